@@ -5,10 +5,20 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/notlelouch/Distributed-Cache/pkg/cache"
+	"github.com/notlelouch/Distributed-Cache/pkg/diskstore"
 	"github.com/notlelouch/Distributed-Cache/pkg/distributed"
+	"github.com/notlelouch/Distributed-Cache/pkg/memcached"
+	"github.com/notlelouch/Distributed-Cache/pkg/persist"
+	"github.com/notlelouch/Distributed-Cache/pkg/proxy"
+	"github.com/notlelouch/Distributed-Cache/pkg/resp"
+	"github.com/notlelouch/Distributed-Cache/pkg/rpc"
 )
 
 func main() {
@@ -25,14 +35,161 @@ func main() {
 
 	peer := os.Getenv("PEER")
 
+	// Storage engine: in memory by default, on disk with STORE=disk, or hot
+	// items in memory and the rest on disk with STORE=tiered
+	var store cache.Store[string, interface{}]
+	switch engine := os.Getenv("STORE"); engine {
+	case "", "memory":
+		store = cache.NewOrderedMemoryStore[string, interface{}]()
+	case "disk", "tiered":
+		storePath := os.Getenv("STORE_PATH")
+		if storePath == "" {
+			log.Fatalf("STORE_PATH is required with STORE=%s", engine)
+		}
+		disk, err := diskstore.Open(storePath, diskstore.Options{NoSync: os.Getenv("STORE_NOSYNC") == "true"})
+		if err != nil {
+			log.Fatalf("Failed to open disk store: %v", err)
+		}
+		store = disk
+		if engine == "tiered" {
+			hotItems := 100000
+			if n := os.Getenv("HOT_ITEMS"); n != "" {
+				if hotItems, err = strconv.Atoi(n); err != nil {
+					log.Fatalf("Invalid HOT_ITEMS: %v", err)
+				}
+			}
+			store = cache.NewTieredStore[string, interface{}](disk, hotItems)
+		}
+	default:
+		log.Fatalf("Unknown STORE %q", engine)
+	}
+
 	// dc, err := distributed.NewDistributedCache(port, node_name)
-	dc, err := distributed.NewDistributedCache(memberlistPort, httpPort, node_name)
+	dc, err := distributed.NewDistributedCacheWithStore(memberlistPort, httpPort, node_name, store)
 	if err != nil {
 		log.Fatalf("Failed to create distributed cache: %v", err)
 	}
 
 	// log.Printf("the peer is %v", peer)
 
+	// Store every key only on its owner instead of on every node
+	dc.Sharded = os.Getenv("SHARDED") == "true"
+
+	// Optional limit on the number of items held by this node. The tiered
+	// store already bounds its memory tier with HOT_ITEMS, and evicting on
+	// top of it would drop recently used items instead of cold ones.
+	if maxItems := os.Getenv("MAX_ITEMS"); maxItems != "" {
+		if os.Getenv("STORE") == "tiered" {
+			log.Fatalf("MAX_ITEMS cannot be used with STORE=tiered; use HOT_ITEMS to size the memory tier")
+		}
+		n, err := strconv.Atoi(maxItems)
+		if err != nil {
+			log.Fatalf("Invalid MAX_ITEMS: %v", err)
+		}
+		dc.Cache.SetMaxItems(n)
+	}
+
+	// Persistence and the store are flushed and closed on shutdown
+	var closers []func() error
+
+	// Optional append-only file, replayed before the node joins the cluster
+	aofPath := os.Getenv("AOF_PATH")
+	if aofPath != "" {
+		policy, err := persist.ParseFsyncPolicy(os.Getenv("AOF_FSYNC"))
+		if err != nil {
+			log.Fatalf("Invalid AOF_FSYNC: %v", err)
+		}
+		aof, err := persist.OpenAOF(aofPath, dc.Cache, persist.AOFOptions{Fsync: policy})
+		if err != nil {
+			log.Fatalf("Failed to open append-only file: %v", err)
+		}
+		closers = append(closers, aof.Close)
+	}
+
+	// Optional snapshots, served under /admin/snapshots
+	if snapshotDir := os.Getenv("SNAPSHOT_DIR"); snapshotDir != "" {
+		var opts persist.SnapshotOptions
+		if interval := os.Getenv("SNAPSHOT_INTERVAL"); interval != "" {
+			if opts.Interval, err = time.ParseDuration(interval); err != nil {
+				log.Fatalf("Invalid SNAPSHOT_INTERVAL: %v", err)
+			}
+		}
+		if retain := os.Getenv("SNAPSHOT_RETAIN"); retain != "" {
+			if opts.Retain, err = strconv.Atoi(retain); err != nil {
+				log.Fatalf("Invalid SNAPSHOT_RETAIN: %v", err)
+			}
+		}
+		snapshots, err := persist.NewSnapshotter(snapshotDir, dc.Cache, opts)
+		if err != nil {
+			log.Fatalf("Failed to open snapshot directory: %v", err)
+		}
+		dc.Snapshots = snapshots
+		closers = append(closers, snapshots.Close)
+
+		// Without an append-only file the latest snapshot is the newest data
+		if aofPath == "" {
+			if latest, err := snapshots.Latest(); err == nil {
+				if _, err := snapshots.Restore(latest.Name, persist.RestoreOptions{}); err != nil {
+					log.Fatalf("Failed to restore snapshot: %v", err)
+				}
+			}
+		}
+	}
+
+	// Optional backing store: misses are loaded from ORIGIN_URL, and writes are
+	// forwarded to it with ORIGIN_WRITE=through or ORIGIN_WRITE=behind
+	if originURL := os.Getenv("ORIGIN_URL"); originURL != "" {
+		httpOrigin := &distributed.HTTPOrigin{BaseURL: originURL}
+		opts := distributed.OriginOptions{Loader: httpOrigin}
+		if ttl := os.Getenv("ORIGIN_TTL"); ttl != "" {
+			if opts.TTL, err = time.ParseDuration(ttl); err != nil {
+				log.Fatalf("Invalid ORIGIN_TTL: %v", err)
+			}
+		}
+		// Serve stale values while refreshing them after ORIGIN_SOFT_TTL, and
+		// refresh early at random with ORIGIN_BETA
+		if softTTL := os.Getenv("ORIGIN_SOFT_TTL"); softTTL != "" {
+			if opts.SoftTTL, err = time.ParseDuration(softTTL); err != nil {
+				log.Fatalf("Invalid ORIGIN_SOFT_TTL: %v", err)
+			}
+		}
+		if beta := os.Getenv("ORIGIN_BETA"); beta != "" {
+			if opts.Beta, err = strconv.ParseFloat(beta, 64); err != nil {
+				log.Fatalf("Invalid ORIGIN_BETA: %v", err)
+			}
+		}
+		// Remember keys the origin doesn't have for ORIGIN_NEGATIVE_TTL
+		if negativeTTL := os.Getenv("ORIGIN_NEGATIVE_TTL"); negativeTTL != "" {
+			if opts.NegativeTTL, err = time.ParseDuration(negativeTTL); err != nil {
+				log.Fatalf("Invalid ORIGIN_NEGATIVE_TTL: %v", err)
+			}
+		}
+		switch mode := os.Getenv("ORIGIN_WRITE"); mode {
+		case "":
+		case "through":
+			opts.Writer = httpOrigin
+		case "behind":
+			opts.Writer, opts.Mode = httpOrigin, distributed.WriteBehind
+		default:
+			log.Fatalf("Unknown ORIGIN_WRITE %q", mode)
+		}
+		dc.SetOrigin(opts)
+		closers = append(closers, dc.CloseOrigin)
+	}
+
+	closers = append(closers, dc.Cache.Close)
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		for _, close := range closers {
+			if err := close(); err != nil {
+				log.Printf("Failed to shut down cleanly: %v", err)
+			}
+		}
+		os.Exit(0)
+	}()
+
 	if peer != "" {
 		err = dc.JoinCluster(peer)
 		if err != nil {
@@ -46,10 +203,52 @@ func main() {
 	// log.Print(dc.Config.Name)
 	// log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
 
+	// Optional binary transport for node-to-node replication
+	if replicationPort := os.Getenv("REPLICATION_PORT"); replicationPort != "" {
+		port, err := strconv.Atoi(replicationPort)
+		if err != nil {
+			log.Fatalf("Invalid REPLICATION_PORT: %v", err)
+		}
+		if err := dc.StartReplication(port); err != nil {
+			log.Fatalf("Failed to start replication listener: %v", err)
+		}
+	}
+
+	// Optional Redis-compatible listener
+	if respPort := os.Getenv("RESP_PORT"); respPort != "" {
+		go func() {
+			log.Fatal(resp.NewServer(dc).ListenAndServe(fmt.Sprintf(":%s", respPort)))
+		}()
+	}
+
+	// Optional memcached-compatible listener
+	if memcachedPort := os.Getenv("MEMCACHED_PORT"); memcachedPort != "" {
+		go func() {
+			log.Fatal(memcached.NewServer(dc).ListenAndServe(fmt.Sprintf(":%s", memcachedPort)))
+		}()
+	}
+
+	// Optional gRPC API
+	if grpcPort := os.Getenv("GRPC_PORT"); grpcPort != "" {
+		go func() {
+			log.Fatal(rpc.NewServer(dc).ListenAndServe(fmt.Sprintf(":%s", grpcPort)))
+		}()
+	}
+
+	// Optional caching reverse proxy in front of PROXY_UPSTREAM
+	if proxyPort := os.Getenv("PROXY_PORT"); proxyPort != "" {
+		p, err := proxy.NewServer(dc, os.Getenv("PROXY_UPSTREAM"), proxy.Options{})
+		if err != nil {
+			log.Fatalf("Failed to start caching proxy: %v", err)
+		}
+		go func() {
+			log.Fatal(p.ListenAndServe(fmt.Sprintf(":%s", proxyPort)))
+		}()
+	}
+
 	// Fiber Handler
 	app := fiber.New()
-	app.Get("/cache/members", dc.HandleGetMembers)
-	app.All("/cache/:key", dc.FiberHandler)
+	dc.RegisterRoutes(app)
 
 	log.Printf("Server is running on port: %d", httpPort)
 	log.Print(dc.Config.Name)
@@ -0,0 +1,245 @@
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// ByteCache is a GC-friendly cache for serialized values, in the style of
+// bigcache/freecache. Entries are packed into large preallocated ring buffers
+// and located through a map[uint64]uint32 index, which holds no pointers and
+// so is never scanned by the garbage collector. When a shard's buffer is full
+// its oldest entries are evicted to make room.
+type ByteCache struct {
+	shards    []*byteShard
+	shardMask uint64
+}
+
+// Entry layout inside a shard buffer:
+//
+//	[4 entry length][8 expiration][8 key hash][2 key length][key][value]
+//
+// An entry length of zero marks the point where the writer wrapped around to
+// the start of the buffer.
+const (
+	entryLenSize    = 4
+	entryHeaderSize = entryLenSize + 8 + 8 + 2
+	maxKeyLen       = 1<<16 - 1
+	neverExpires    = math.MaxInt64 // Expiration of entries without a duration
+)
+
+var (
+	ErrEntryTooLarge = errors.New("cache: entry does not fit in shard buffer")
+	ErrKeyTooLong    = errors.New("cache: key is longer than 65535 bytes")
+)
+
+type byteShard struct {
+	mu    sync.RWMutex
+	index map[uint64]uint32
+	buf   []byte
+	head  int // offset of the oldest entry
+	tail  int // offset where the next entry is written
+	count int // number of entries (live or stale) between head and tail
+}
+
+// NewByteCache creates a ByteCache holding at most maxBytes of entries spread
+// over the given number of shards. The shard count is rounded up to a power
+// of two.
+func NewByteCache(shards int, maxBytes int) *ByteCache {
+	n := 1
+	for n < shards {
+		n <<= 1
+	}
+	shardSize := maxBytes / n
+	if shardSize < entryHeaderSize {
+		shardSize = entryHeaderSize
+	}
+
+	bc := &ByteCache{
+		shards:    make([]*byteShard, n),
+		shardMask: uint64(n - 1),
+	}
+	for i := range bc.shards {
+		bc.shards[i] = &byteShard{
+			index: make(map[uint64]uint32),
+			buf:   make([]byte, shardSize),
+		}
+	}
+	return bc
+}
+
+func (bc *ByteCache) shardFor(hash uint64) *byteShard {
+	return bc.shards[hash&bc.shardMask]
+}
+
+// Set stores a copy of value under key for the given duration. A duration
+// of zero or less means the entry never expires, as with TypedCache.
+func (bc *ByteCache) Set(key string, value []byte, duration time.Duration) error {
+	if len(key) > maxKeyLen {
+		return ErrKeyTooLong
+	}
+	hash := hashKey(key)
+	expiration := int64(neverExpires)
+	if duration > 0 {
+		expiration = time.Now().Add(duration).UnixNano()
+	}
+	return bc.shardFor(hash).set(key, hash, value, expiration)
+}
+
+// Get returns a copy of the value stored under key.
+func (bc *ByteCache) Get(key string) ([]byte, bool) {
+	hash := hashKey(key)
+	return bc.shardFor(hash).get(key, hash, time.Now().UnixNano())
+}
+
+// Delete removes key from the cache. The bytes it occupied are reclaimed when
+// the ring buffer wraps past them.
+func (bc *ByteCache) Delete(key string) {
+	hash := hashKey(key)
+	bc.shardFor(hash).delete(key, hash)
+}
+
+// Len returns the number of indexed entries, including expired ones that have
+// not been evicted yet.
+func (bc *ByteCache) Len() int {
+	n := 0
+	for _, s := range bc.shards {
+		s.mu.RLock()
+		n += len(s.index)
+		s.mu.RUnlock()
+	}
+	return n
+}
+
+func (s *byteShard) set(key string, hash uint64, value []byte, expiration int64) error {
+	size := entryHeaderSize + len(key) + len(value)
+	if size > len(s.buf) {
+		return ErrEntryTooLarge
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	offset := s.reserve(size)
+	entry := s.buf[offset : offset+size]
+	binary.LittleEndian.PutUint32(entry[0:], uint32(size))
+	binary.LittleEndian.PutUint64(entry[4:], uint64(expiration))
+	binary.LittleEndian.PutUint64(entry[12:], hash)
+	binary.LittleEndian.PutUint16(entry[20:], uint16(len(key)))
+	copy(entry[entryHeaderSize:], key)
+	copy(entry[entryHeaderSize+len(key):], value)
+
+	s.index[hash] = uint32(offset)
+	return nil
+}
+
+// reserve makes room for an entry of the given size and returns its offset,
+// evicting the oldest entries as needed. Callers must hold s.mu.
+func (s *byteShard) reserve(size int) int {
+	for {
+		if s.count == 0 {
+			s.head, s.tail = 0, 0
+		}
+		if s.tail >= s.head && (s.count == 0 || s.tail != s.head) {
+			// Free space runs from tail to the end of the buffer.
+			if len(s.buf)-s.tail >= size {
+				break
+			}
+			if s.head == 0 {
+				// Cannot wrap onto the oldest entry; evict it first.
+				s.evictHead()
+				continue
+			}
+			if len(s.buf)-s.tail >= entryLenSize {
+				binary.LittleEndian.PutUint32(s.buf[s.tail:], 0)
+			}
+			s.tail = 0
+			continue
+		}
+		// Wrapped: free space runs from tail up to head.
+		if s.head-s.tail >= size {
+			break
+		}
+		s.evictHead()
+	}
+
+	offset := s.tail
+	s.tail += size
+	s.count++
+	return offset
+}
+
+// evictHead drops the oldest entry in the buffer. Callers must hold s.mu.
+func (s *byteShard) evictHead() {
+	if len(s.buf)-s.head < entryLenSize || binary.LittleEndian.Uint32(s.buf[s.head:]) == 0 {
+		s.head = 0
+	}
+	size := int(binary.LittleEndian.Uint32(s.buf[s.head:]))
+	hash := binary.LittleEndian.Uint64(s.buf[s.head+12:])
+	if offset, ok := s.index[hash]; ok && int(offset) == s.head {
+		delete(s.index, hash)
+	}
+	s.head += size
+	s.count--
+}
+
+func (s *byteShard) get(key string, hash uint64, now int64) ([]byte, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.lookup(key, hash)
+	if !ok {
+		return nil, false
+	}
+	if int64(binary.LittleEndian.Uint64(entry[4:])) <= now {
+		return nil, false
+	}
+
+	value := entry[entryHeaderSize+len(key):]
+	out := make([]byte, len(value))
+	copy(out, value)
+	return out, true
+}
+
+func (s *byteShard) delete(key string, hash uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.lookup(key, hash); ok {
+		delete(s.index, hash)
+	}
+}
+
+// lookup returns the entry indexed under hash if its key matches. Callers must
+// hold s.mu.
+func (s *byteShard) lookup(key string, hash uint64) ([]byte, bool) {
+	offset, ok := s.index[hash]
+	if !ok {
+		return nil, false
+	}
+	size := int(binary.LittleEndian.Uint32(s.buf[offset:]))
+	entry := s.buf[int(offset) : int(offset)+size]
+	keyLen := int(binary.LittleEndian.Uint16(entry[20:]))
+	if string(entry[entryHeaderSize:entryHeaderSize+keyLen]) != key {
+		// Hash collision with a different key.
+		return nil, false
+	}
+	return entry, true
+}
+
+// hashKey is FNV-1a, inlined to avoid allocating a hash.Hash per call.
+func hashKey(key string) uint64 {
+	const (
+		offset64 = 14695981039346656037
+		prime64  = 1099511628211
+	)
+	h := uint64(offset64)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= prime64
+	}
+	return h
+}
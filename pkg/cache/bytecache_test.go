@@ -0,0 +1,178 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"testing"
+	"time"
+)
+
+func TestByteCacheSetGet(t *testing.T) {
+	bc := NewByteCache(4, 1<<20)
+	if err := bc.Set("key1", []byte("value1"), 2*time.Second); err != nil {
+		t.Fatalf("Failed to set key1: %v", err)
+	}
+
+	value, found := bc.Get("key1")
+	if !found || !bytes.Equal(value, []byte("value1")) {
+		t.Errorf("Expected to find key1 with value1, got %s, found: %v", value, found)
+	}
+
+	bc.Set("key1", []byte("value2"), 2*time.Second)
+	value, _ = bc.Get("key1")
+	if !bytes.Equal(value, []byte("value2")) {
+		t.Errorf("Expected overwritten key1 to be value2, got %s", value)
+	}
+
+	bc.Delete("key1")
+	if _, found := bc.Get("key1"); found {
+		t.Errorf("Expected key1 to be deleted")
+	}
+}
+
+func TestByteCacheExpiration(t *testing.T) {
+	bc := NewByteCache(1, 1<<10)
+	bc.Set("key2", []byte("value2"), 50*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+
+	if _, found := bc.Get("key2"); found {
+		t.Errorf("Expected key2 to be expired")
+	}
+
+	// Like TypedCache, no duration means no expiration
+	for _, duration := range []time.Duration{0, -time.Second} {
+		bc.Set("forever", []byte("v"), duration)
+		if _, found := bc.Get("forever"); !found {
+			t.Errorf("Expected an entry set with duration %v to be kept", duration)
+		}
+	}
+}
+
+func TestByteCacheEvictsOldest(t *testing.T) {
+	// A single 1KiB shard holds roughly 20 of these entries.
+	bc := NewByteCache(1, 1<<10)
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("key-%03d", i)
+		if err := bc.Set(key, bytes.Repeat([]byte{'x'}, 20), time.Minute); err != nil {
+			t.Fatalf("Failed to set %s: %v", key, err)
+		}
+	}
+
+	if _, found := bc.Get("key-000"); found {
+		t.Errorf("Expected key-000 to have been evicted")
+	}
+	value, found := bc.Get("key-199")
+	if !found || len(value) != 20 {
+		t.Errorf("Expected newest key to be present, got %q, found: %v", value, found)
+	}
+	if bc.Len() == 0 || bc.Len() > 40 {
+		t.Errorf("Unexpected number of live entries after wrapping: %d", bc.Len())
+	}
+}
+
+func TestByteCacheEntryTooLarge(t *testing.T) {
+	bc := NewByteCache(1, 64)
+	if err := bc.Set("big", make([]byte, 128), time.Minute); err != ErrEntryTooLarge {
+		t.Errorf("Expected ErrEntryTooLarge, got %v", err)
+	}
+}
+
+// ####################################################   Benchmarks   ##############################################
+
+const benchEntries = 1_000_000
+
+func BenchmarkByteCacheSet(b *testing.B) {
+	bc := NewByteCache(256, 256<<20)
+	value := make([]byte, 64)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bc.Set(fmt.Sprintf("key-%d", i%benchEntries), value, time.Hour)
+	}
+}
+
+func BenchmarkMapCacheSet(b *testing.B) {
+	c := NewCache()
+	value := make([]byte, 64)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Set(fmt.Sprintf("key-%d", i%benchEntries), value, time.Hour)
+	}
+}
+
+// BenchmarkGCPauseByteCache and BenchmarkGCPauseMapCache fill each store with
+// benchEntries values and then measure how long a forced collection pauses
+// the program. The ns/gc-pause metric is the one to compare.
+func BenchmarkGCPauseByteCache(b *testing.B) {
+	bc := NewByteCache(256, 256<<20)
+	value := make([]byte, 64)
+	for i := 0; i < benchEntries; i++ {
+		bc.Set(fmt.Sprintf("key-%d", i), value, time.Hour)
+	}
+	benchmarkGCPause(b)
+	runtime.KeepAlive(bc)
+}
+
+func BenchmarkGCPauseMapCache(b *testing.B) {
+	c := NewCache()
+	for i := 0; i < benchEntries; i++ {
+		c.Set(fmt.Sprintf("key-%d", i), make([]byte, 64), time.Hour)
+	}
+	benchmarkGCPause(b)
+	runtime.KeepAlive(c)
+}
+
+func benchmarkGCPause(b *testing.B) {
+	runtime.GC()
+	var before debug.GCStats
+	debug.ReadGCStats(&before)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+	}
+	b.StopTimer()
+
+	var after debug.GCStats
+	debug.ReadGCStats(&after)
+	gcs := after.NumGC - before.NumGC
+	if gcs > 0 {
+		b.ReportMetric(float64(after.PauseTotal-before.PauseTotal)/float64(gcs), "ns/gc-pause")
+	}
+}
+
+func TestByteStore(t *testing.T) {
+	c, err := NewTypedCacheWithStore[string, []byte](NewByteStore(4, 1<<20))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+
+	item, err := c.SetIf("greeting", []byte("hello"), time.Minute, SetOptions{Tags: []string{"t"}, Flags: 7}, Precondition{})
+	if err != nil {
+		t.Fatalf("SetIf failed: %v", err)
+	}
+	got, found := c.GetItem("greeting")
+	if !found || string(got.Value) != "hello" || got.Version != item.Version || got.Flags != 7 || got.Tags[0] != "t" {
+		t.Errorf("Expected the stored item back, got %+v, %v", got, found)
+	}
+
+	// Expired items are removed by the cache with an event
+	c.Set("short", []byte("x"), 10*time.Millisecond)
+	w := c.Watch(10)
+	defer w.Close()
+	time.Sleep(20 * time.Millisecond)
+	if n := c.DeleteExpired(); n != 1 {
+		t.Errorf("Expected one expired item, got %d", n)
+	}
+	if ev := <-w.C; ev.Type != EventExpire || ev.Key != "short" {
+		t.Errorf("Expected an expire event for short, got %s %s", ev.Type, ev.Key)
+	}
+
+	if n := c.InvalidateTag("t"); n != 1 {
+		t.Errorf("Expected the tagged item to be invalidated, got %d", n)
+	}
+	if stats := c.Stats(); stats.Engine != "bytes" || stats.Items != 0 {
+		t.Errorf("Expected an empty byte store, got %+v", stats)
+	}
+}
@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"encoding/binary"
+)
+
+// ByteStore is a Store for byte values kept in a ByteCache, so a
+// TypedCache[string, []byte] gets versions, tags, events and expiry on top
+// of the ring buffers. Items are stored in their MarshalItem encoding.
+//
+// The store is unordered, and when a shard's buffer is full the ring
+// buffer drops its oldest items without the cache knowing: they are not
+// published as events.
+type ByteStore struct {
+	bc *ByteCache
+}
+
+var _ Store[string, []byte] = (*ByteStore)(nil)
+
+// NewByteStore creates a ByteStore over a new ByteCache with the given
+// number of shards and total size.
+func NewByteStore(shards int, maxBytes int) *ByteStore {
+	return &ByteStore{bc: NewByteCache(shards, maxBytes)}
+}
+
+// Get returns the item stored under key. Unlike ByteCache.Get it also
+// returns expired items, which the cache removes itself.
+func (s *ByteStore) Get(key string) (Item[string, []byte], bool) {
+	hash := hashKey(key)
+	shard := s.bc.shardFor(hash)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	entry, ok := shard.lookup(key, hash)
+	if !ok {
+		return Item[string, []byte]{}, false
+	}
+	item, err := unmarshalByteItem(entry[entryHeaderSize+len(key):])
+	return item, err == nil
+}
+
+func (s *ByteStore) Set(item Item[string, []byte]) error {
+	if len(item.Key) > maxKeyLen {
+		return ErrKeyTooLong
+	}
+	data, err := MarshalItem(CacheItem{
+		Key:         item.Key,
+		Value:       item.Value,
+		Expiration:  item.Expiration,
+		Stale:       item.Stale,
+		ContentType: item.ContentType,
+		Version:     item.Version,
+		Modified:    item.Modified,
+		Flags:       item.Flags,
+		Tags:        item.Tags,
+	})
+	if err != nil {
+		return err
+	}
+	// The ring buffer drops entries at their deadline, and zero means never
+	expiration := item.Expiration
+	if expiration == 0 {
+		expiration = neverExpires
+	}
+	hash := hashKey(item.Key)
+	return s.bc.shardFor(hash).set(item.Key, hash, data, expiration)
+}
+
+func (s *ByteStore) Delete(key string) error {
+	s.bc.Delete(key)
+	return nil
+}
+
+// Scan visits every item, shard by shard, in no particular order: from is
+// ignored.
+func (s *ByteStore) Scan(from *string, fn func(Item[string, []byte]) bool) error {
+	for _, shard := range s.bc.shards {
+		more, err := shard.scan(fn)
+		if err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ByteStore) Len() int { return s.bc.Len() }
+
+func (s *ByteStore) Stats() StoreStats {
+	return StoreStats{Engine: "bytes", Items: s.bc.Len()}
+}
+
+func (s *ByteStore) Close() error { return nil }
+
+// scan decodes every indexed entry of the shard and calls fn for it. It
+// reports whether fn asked for more.
+func (s *byteShard) scan(fn func(Item[string, []byte]) bool) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, offset := range s.index {
+		size := int(binary.LittleEndian.Uint32(s.buf[offset:]))
+		entry := s.buf[int(offset) : int(offset)+size]
+		keyLen := int(binary.LittleEndian.Uint16(entry[20:]))
+		item, err := unmarshalByteItem(entry[entryHeaderSize+keyLen:])
+		if err != nil {
+			return false, err
+		}
+		if !fn(item) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// unmarshalByteItem decodes an item written by ByteStore.Set. The value is
+// copied out of the ring buffer.
+func unmarshalByteItem(data []byte) (Item[string, []byte], error) {
+	item, err := UnmarshalItem(data)
+	if err != nil {
+		return Item[string, []byte]{}, err
+	}
+	value, _ := item.Value.([]byte)
+	return Item[string, []byte]{
+		Key:         item.Key,
+		Value:       value,
+		Expiration:  item.Expiration,
+		Stale:       item.Stale,
+		ContentType: item.ContentType,
+		Version:     item.Version,
+		Modified:    item.Modified,
+		Flags:       item.Flags,
+		Tags:        item.Tags,
+	}, nil
+}
@@ -1,58 +1,32 @@
 package cache
 
-import (
-	"log"
-	"sync"
-	"time"
-)
+// CacheItem is an entry in the untyped Cache.
+type CacheItem = Item[string, interface{}]
 
-type CacheItem struct {
-	Key        string
-	Value      interface{}
-	Expiration int64
-}
+// CacheEvent is a change to the untyped Cache.
+type CacheEvent = Event[string, interface{}]
 
+// Cache is the untyped cache used by the distributed HTTP layer. It is a thin
+// wrapper around TypedCache[string, interface{}]; new code that knows its
+// value type should use TypedCache directly.
 type Cache struct {
-	items map[string]CacheItem
-	mu    sync.RWMutex
+	*TypedCache[string, interface{}]
+
+	applied map[string]*opWindow // Replicated collection ops seen, by origin node
 }
 
 func NewCache() *Cache {
 	return &Cache{
-		items: make(map[string]CacheItem),
-	}
-}
-
-func (c *Cache) Set(key string, value interface{}, duration time.Duration) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	expiration := time.Now().Add(duration).Unix()
-	c.items[key] = CacheItem{
-		Key:        key,
-		Value:      value,
-		Expiration: expiration,
+		TypedCache: NewOrderedTypedCache[string, interface{}](),
 	}
 }
 
-func (c *Cache) Get(key string) (interface{}, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-
-	item, found := c.items[key]
-	if !found || item.Expiration <= time.Now().Unix() {
-		return nil, false
-	}
-	if found {
-		log.Printf("Cache item found: Key=%s, Value=%v, Expiration=%d", item.Key, item.Value, item.Expiration)
+// NewCacheWithStore creates a Cache backed by store, such as a disk engine.
+// The store must be ordered: Scan relies on visiting keys in order.
+func NewCacheWithStore(store Store[string, interface{}]) (*Cache, error) {
+	c, err := NewTypedCacheWithStore(store)
+	if err != nil {
+		return nil, err
 	}
-
-	return item.Value, true
-}
-
-func (c *Cache) Delete(key string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	delete(c.items, key)
+	return &Cache{TypedCache: c}, nil
 }
@@ -25,3 +25,27 @@ func TestCacheExpiration(t *testing.T) {
 		t.Errorf("Expected key2 to be expired")
 	}
 }
+
+func TestCacheExpireAndTTL(t *testing.T) {
+	c := NewCache()
+	c.Set("key3", "value3", time.Hour)
+	item, _ := c.GetItem("key3")
+
+	if !c.Expire("key3", 50*time.Millisecond) {
+		t.Fatalf("Expected Expire to find key3")
+	}
+	if ttl, found := c.TTL("key3"); !found || ttl <= 0 || ttl > 50*time.Millisecond {
+		t.Errorf("Expected TTL of at most 50ms, got %v, found: %v", ttl, found)
+	}
+	if updated, _ := c.GetItem("key3"); updated.Version != item.Version {
+		t.Errorf("Expected Expire to keep version %d, got %d", item.Version, updated.Version)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if _, found := c.Get("key3"); found {
+		t.Errorf("Expected key3 to expire with millisecond precision")
+	}
+	if c.Expire("key3", time.Hour) {
+		t.Errorf("Expected Expire on an expired key to report false")
+	}
+}
@@ -0,0 +1,53 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Codec converts cache values to and from bytes when they cross the network.
+type Codec[V any] interface {
+	Marshal(value V) ([]byte, error)
+	Unmarshal(data []byte) (V, error)
+}
+
+// StringCodec stores strings as their raw bytes.
+type StringCodec struct{}
+
+func (StringCodec) Marshal(value string) ([]byte, error)  { return []byte(value), nil }
+func (StringCodec) Unmarshal(data []byte) (string, error) { return string(data), nil }
+
+// BytesCodec passes byte slices through unchanged.
+type BytesCodec struct{}
+
+func (BytesCodec) Marshal(value []byte) ([]byte, error)  { return value, nil }
+func (BytesCodec) Unmarshal(data []byte) ([]byte, error) { return data, nil }
+
+// JSONCodec encodes values of any type as JSON.
+type JSONCodec[V any] struct{}
+
+func (JSONCodec[V]) Marshal(value V) ([]byte, error) { return json.Marshal(value) }
+
+func (JSONCodec[V]) Unmarshal(data []byte) (V, error) {
+	var value V
+	err := json.Unmarshal(data, &value)
+	return value, err
+}
+
+// ValueCodec is the default codec for the untyped Cache. Strings and byte
+// slices are written raw, anything else is formatted with %v. Unmarshal
+// always yields a string, which is what the HTTP API stores.
+type ValueCodec struct{}
+
+func (ValueCodec) Marshal(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case string:
+		return []byte(v), nil
+	case []byte:
+		return v, nil
+	default:
+		return []byte(fmt.Sprintf("%v", v)), nil
+	}
+}
+
+func (ValueCodec) Unmarshal(data []byte) (interface{}, error) { return string(data), nil }
@@ -0,0 +1,571 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrWrongType is returned when a collection operation targets a key
+	// holding a different kind of value.
+	ErrWrongType = errors.New("cache: operation against a key holding the wrong kind of value")
+	// ErrInvalidOp is returned for unknown operations and malformed arguments.
+	ErrInvalidOp = errors.New("cache: invalid collection operation")
+)
+
+// Hash, List, Set and SortedSet are the collection types a Cache can hold.
+// Like PNCounter they are never mutated in place: every write stores a
+// modified copy, so a value returned by Get can be read without the cache
+// lock. A write therefore costs time proportional to the collection's size.
+type (
+	Hash map[string]string
+	List []string
+	Set  map[string]struct{}
+)
+
+// SortedSet holds unique members ordered by score, then by member.
+type SortedSet struct {
+	scores  map[string]float64
+	entries []ScoredMember
+}
+
+// ScoredMember is one member of a SortedSet.
+type ScoredMember struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+// The String methods format collections as JSON, which is how they are
+// returned by a plain GET.
+func (h Hash) String() string { return toJSON(map[string]string(h)) }
+func (l List) String() string { return toJSON([]string(l)) }
+func (s Set) String() string  { return toJSON(s.Members()) }
+
+func (z *SortedSet) String() string { return toJSON(z.entries) }
+
+func toJSON(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// Members returns the members of s in order.
+func (s Set) Members() []string {
+	members := make([]string, 0, len(s))
+	for m := range s {
+		members = append(members, m)
+	}
+	sort.Strings(members)
+	return members
+}
+
+// Len returns the number of members in z.
+func (z *SortedSet) Len() int {
+	return len(z.entries)
+}
+
+// withScores returns a copy of z with the given scores applied, removing the
+// members whose score is nil.
+func (z *SortedSet) withScores(updates map[string]*float64) *SortedSet {
+	next := &SortedSet{scores: make(map[string]float64, len(z.scores)+len(updates))}
+	maps.Copy(next.scores, z.scores)
+	for member, score := range updates {
+		if score == nil {
+			delete(next.scores, member)
+		} else {
+			next.scores[member] = *score
+		}
+	}
+	next.entries = make([]ScoredMember, 0, len(next.scores))
+	for member, score := range next.scores {
+		next.entries = append(next.entries, ScoredMember{Member: member, Score: score})
+	}
+	sort.Slice(next.entries, func(i, j int) bool {
+		a, b := next.entries[i], next.entries[j]
+		return a.Score < b.Score || (a.Score == b.Score && a.Member < b.Member)
+	})
+	return next
+}
+
+// ####################################################   Writes   ##############################################
+
+// Op is a single write to a collection, named after the Redis command it
+// mirrors and taking the same arguments, e.g. {"hset", ["field", "value"]}.
+// Replicas apply the same Op instead of receiving the whole collection.
+type Op struct {
+	Name string   `json:"op"`
+	Args []string `json:"args"`
+}
+
+// OpResult is what a collection write returns: the number of elements added
+// or removed (the new length for pushes), and the removed elements for pops.
+type OpResult struct {
+	Count  int      `json:"count"`
+	Values []string `json:"values,omitempty"`
+}
+
+// An opFunc applies args to the current value, which is nil for a missing
+// key, and returns the new value, or nil if the collection is now empty.
+type opFunc func(current interface{}, args []string) (interface{}, OpResult, error)
+
+var collectionOps = map[string]opFunc{
+	"hset":  hset,
+	"hdel":  hdel,
+	"lpush": lpush,
+	"rpush": rpush,
+	"lpop":  lpop,
+	"rpop":  rpop,
+	"sadd":  sadd,
+	"srem":  srem,
+	"zadd":  zadd,
+	"zrem":  zrem,
+}
+
+// IsCollectionOp reports whether name is an operation Apply understands.
+func IsCollectionOp(name string) bool {
+	_, ok := collectionOps[name]
+	return ok
+}
+
+// OpOrigin identifies a replicated collection op, so each replica applies
+// it exactly once. Every node numbers the ops it originates in increasing
+// order; Version is the version that node gave the collection.
+type OpOrigin struct {
+	Node    string
+	Seq     uint64
+	Version uint64
+}
+
+// opWindowSize is how many ops of each origin node are remembered. An op
+// delayed by more than that many later ops from its node is taken for a
+// duplicate and dropped.
+const opWindowSize = 4096
+
+// opWindow holds the sequence numbers seen from one node: all those in
+// seen, and any at or below floor.
+type opWindow struct {
+	floor uint64
+	seen  map[uint64]struct{}
+	order []uint64 // seen in arrival order, oldest first
+}
+
+// record reports whether seq is new, remembering it if so.
+func (w *opWindow) record(seq uint64) bool {
+	if _, dup := w.seen[seq]; dup || seq <= w.floor {
+		return false
+	}
+	w.seen[seq] = struct{}{}
+	w.order = append(w.order, seq)
+	if len(w.order) > opWindowSize {
+		oldest := w.order[0]
+		w.order = w.order[1:]
+		delete(w.seen, oldest)
+		w.floor = max(w.floor, oldest)
+	}
+	return true
+}
+
+// Apply atomically performs op on the collection under key and returns its
+// result along with the stored item. A missing key is created with the given
+// duration, and a collection left empty is deleted. Local ops pass a zero
+// origin. Replicated ops pass the one their originating node gave them and
+// are skipped only if this cache has already applied that op, so concurrent
+// ops from different nodes all apply everywhere.
+func (c *Cache) Apply(key string, op Op, duration time.Duration, origin OpOrigin) (OpResult, CacheItem, error) {
+	apply, ok := collectionOps[op.Name]
+	if !ok {
+		return OpResult{}, CacheItem{}, fmt.Errorf("%w: unknown operation %q", ErrInvalidOp, op.Name)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	item, exists := c.peek(key)
+	if !exists || item.expired(now.UnixNano()) {
+		exists = false
+		item = CacheItem{Key: key, Expiration: expiresAt(now, duration), ContentType: "application/json"}
+	}
+	if origin.Node != "" {
+		if !c.recordOp(origin) {
+			return OpResult{}, item, nil
+		}
+		// Keep the origin's version unless a concurrent op has already
+		// moved the collection past it
+		if origin.Version <= item.Version {
+			origin.Version = 0
+		}
+	}
+
+	value, result, err := apply(item.Value, op.Args)
+	if err != nil {
+		return OpResult{}, CacheItem{}, err
+	}
+	if value == nil {
+		if exists {
+			c.remove(key)
+			c.publish(CacheEvent{Type: EventDelete, Key: key, Item: item})
+		}
+		return result, CacheItem{}, nil
+	}
+
+	item.Value = value
+	item.Version = c.nextVersion(origin.Version, now)
+	item.Modified = now.UnixNano()
+	c.store(item)
+	c.publish(CacheEvent{Type: EventSet, Key: key, Item: item})
+	return result, item, nil
+}
+
+// recordOp reports whether the op is new to this cache, remembering it if
+// so. The caller holds c.mu.
+func (c *Cache) recordOp(origin OpOrigin) bool {
+	if c.applied == nil {
+		c.applied = make(map[string]*opWindow)
+	}
+	w, ok := c.applied[origin.Node]
+	if !ok {
+		w = &opWindow{seen: make(map[uint64]struct{})}
+		c.applied[origin.Node] = w
+	}
+	return w.record(origin.Seq)
+}
+
+func hset(current interface{}, args []string) (interface{}, OpResult, error) {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return nil, OpResult{}, fmt.Errorf("%w: hset takes field value pairs", ErrInvalidOp)
+	}
+	h, err := asHash(current)
+	if err != nil {
+		return nil, OpResult{}, err
+	}
+	next := make(Hash, len(h)+len(args)/2)
+	maps.Copy(next, h)
+	var result OpResult
+	for i := 0; i < len(args); i += 2 {
+		if _, ok := next[args[i]]; !ok {
+			result.Count++
+		}
+		next[args[i]] = args[i+1]
+	}
+	return next, result, nil
+}
+
+func hdel(current interface{}, args []string) (interface{}, OpResult, error) {
+	if len(args) == 0 {
+		return nil, OpResult{}, fmt.Errorf("%w: hdel takes at least one field", ErrInvalidOp)
+	}
+	h, err := asHash(current)
+	if err != nil {
+		return nil, OpResult{}, err
+	}
+	next := maps.Clone(h)
+	var result OpResult
+	for _, field := range args {
+		if _, ok := next[field]; ok {
+			delete(next, field)
+			result.Count++
+		}
+	}
+	if len(next) == 0 {
+		return nil, result, nil
+	}
+	return next, result, nil
+}
+
+func lpush(current interface{}, args []string) (interface{}, OpResult, error) {
+	if len(args) == 0 {
+		return nil, OpResult{}, fmt.Errorf("%w: lpush takes at least one value", ErrInvalidOp)
+	}
+	l, err := asList(current)
+	if err != nil {
+		return nil, OpResult{}, err
+	}
+	// Each value is pushed in turn, so the last one ends up first
+	next := make(List, 0, len(l)+len(args))
+	for i := len(args) - 1; i >= 0; i-- {
+		next = append(next, args[i])
+	}
+	next = append(next, l...)
+	return next, OpResult{Count: len(next)}, nil
+}
+
+func rpush(current interface{}, args []string) (interface{}, OpResult, error) {
+	if len(args) == 0 {
+		return nil, OpResult{}, fmt.Errorf("%w: rpush takes at least one value", ErrInvalidOp)
+	}
+	l, err := asList(current)
+	if err != nil {
+		return nil, OpResult{}, err
+	}
+	next := make(List, 0, len(l)+len(args))
+	next = append(append(next, l...), args...)
+	return next, OpResult{Count: len(next)}, nil
+}
+
+func lpop(current interface{}, args []string) (interface{}, OpResult, error) {
+	return pop(current, args, true)
+}
+
+func rpop(current interface{}, args []string) (interface{}, OpResult, error) {
+	return pop(current, args, false)
+}
+
+// pop removes up to count (default 1) values from one end of a list.
+func pop(current interface{}, args []string, left bool) (interface{}, OpResult, error) {
+	count := 1
+	if len(args) > 1 {
+		return nil, OpResult{}, fmt.Errorf("%w: pop takes an optional count", ErrInvalidOp)
+	}
+	if len(args) == 1 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			return nil, OpResult{}, fmt.Errorf("%w: count must be a non-negative integer", ErrInvalidOp)
+		}
+		count = n
+	}
+	l, err := asList(current)
+	if err != nil {
+		return nil, OpResult{}, err
+	}
+	count = min(count, len(l))
+
+	var popped, rest []string
+	if left {
+		popped, rest = l[:count], l[count:]
+	} else {
+		popped, rest = slices.Clone(l[len(l)-count:]), l[:len(l)-count]
+		slices.Reverse(popped)
+	}
+	result := OpResult{Count: len(popped), Values: slices.Clone(popped)}
+	if len(rest) == 0 {
+		return nil, result, nil
+	}
+	// rest shares the old backing array, which is never written again
+	return List(rest), result, nil
+}
+
+func sadd(current interface{}, args []string) (interface{}, OpResult, error) {
+	if len(args) == 0 {
+		return nil, OpResult{}, fmt.Errorf("%w: sadd takes at least one member", ErrInvalidOp)
+	}
+	s, err := asSet(current)
+	if err != nil {
+		return nil, OpResult{}, err
+	}
+	next := make(Set, len(s)+len(args))
+	maps.Copy(next, s)
+	var result OpResult
+	for _, member := range args {
+		if _, ok := next[member]; !ok {
+			next[member] = struct{}{}
+			result.Count++
+		}
+	}
+	return next, result, nil
+}
+
+func srem(current interface{}, args []string) (interface{}, OpResult, error) {
+	if len(args) == 0 {
+		return nil, OpResult{}, fmt.Errorf("%w: srem takes at least one member", ErrInvalidOp)
+	}
+	s, err := asSet(current)
+	if err != nil {
+		return nil, OpResult{}, err
+	}
+	next := maps.Clone(s)
+	var result OpResult
+	for _, member := range args {
+		if _, ok := next[member]; ok {
+			delete(next, member)
+			result.Count++
+		}
+	}
+	if len(next) == 0 {
+		return nil, result, nil
+	}
+	return next, result, nil
+}
+
+func zadd(current interface{}, args []string) (interface{}, OpResult, error) {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return nil, OpResult{}, fmt.Errorf("%w: zadd takes score member pairs", ErrInvalidOp)
+	}
+	z, err := asSortedSet(current)
+	if err != nil {
+		return nil, OpResult{}, err
+	}
+	updates := make(map[string]*float64, len(args)/2)
+	var result OpResult
+	for i := 0; i < len(args); i += 2 {
+		score, err := strconv.ParseFloat(args[i], 64)
+		if err != nil {
+			return nil, OpResult{}, fmt.Errorf("%w: score %q is not a number", ErrInvalidOp, args[i])
+		}
+		member := args[i+1]
+		if _, ok := z.scores[member]; !ok && updates[member] == nil {
+			result.Count++
+		}
+		updates[member] = &score
+	}
+	return z.withScores(updates), result, nil
+}
+
+func zrem(current interface{}, args []string) (interface{}, OpResult, error) {
+	if len(args) == 0 {
+		return nil, OpResult{}, fmt.Errorf("%w: zrem takes at least one member", ErrInvalidOp)
+	}
+	z, err := asSortedSet(current)
+	if err != nil {
+		return nil, OpResult{}, err
+	}
+	updates := make(map[string]*float64, len(args))
+	var result OpResult
+	for _, member := range args {
+		if _, ok := z.scores[member]; ok {
+			if _, seen := updates[member]; !seen {
+				result.Count++
+			}
+			updates[member] = nil
+		}
+	}
+	next := z.withScores(updates)
+	if next.Len() == 0 {
+		return nil, result, nil
+	}
+	return next, result, nil
+}
+
+// The as* helpers return the collection held in v, an empty one for a
+// missing key, or ErrWrongType.
+
+func asHash(v interface{}) (Hash, error) {
+	switch h := v.(type) {
+	case nil:
+		return Hash{}, nil
+	case Hash:
+		return h, nil
+	}
+	return nil, ErrWrongType
+}
+
+func asList(v interface{}) (List, error) {
+	switch l := v.(type) {
+	case nil:
+		return List{}, nil
+	case List:
+		return l, nil
+	}
+	return nil, ErrWrongType
+}
+
+func asSet(v interface{}) (Set, error) {
+	switch s := v.(type) {
+	case nil:
+		return Set{}, nil
+	case Set:
+		return s, nil
+	}
+	return nil, ErrWrongType
+}
+
+func asSortedSet(v interface{}) (*SortedSet, error) {
+	switch z := v.(type) {
+	case nil:
+		return &SortedSet{}, nil
+	case *SortedSet:
+		return z, nil
+	}
+	return nil, ErrWrongType
+}
+
+// ####################################################   Reads   ##############################################
+
+// HGet returns the value of field in the hash under key.
+func (c *Cache) HGet(key, field string) (string, bool, error) {
+	h, err := c.getHash(key)
+	value, found := h[field]
+	return value, found, err
+}
+
+// HGetAll returns a copy of the hash under key.
+func (c *Cache) HGetAll(key string) (Hash, error) {
+	h, err := c.getHash(key)
+	return maps.Clone(h), err
+}
+
+// LRange returns the elements of the list under key between start and stop
+// inclusive. Negative indexes count from the end, so 0, -1 is the whole list.
+func (c *Cache) LRange(key string, start, stop int) ([]string, error) {
+	value, _ := c.Get(key)
+	l, err := asList(value)
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 {
+		start = max(len(l)+start, 0)
+	}
+	if stop < 0 {
+		stop = len(l) + stop
+	}
+	stop = min(stop, len(l)-1)
+	if start > stop {
+		return []string{}, nil
+	}
+	return slices.Clone(l[start : stop+1]), nil
+}
+
+// SMembers returns the members of the set under key in order.
+func (c *Cache) SMembers(key string) ([]string, error) {
+	value, _ := c.Get(key)
+	s, err := asSet(value)
+	if err != nil {
+		return nil, err
+	}
+	return s.Members(), nil
+}
+
+// SIsMember reports whether member is in the set under key.
+func (c *Cache) SIsMember(key, member string) (bool, error) {
+	value, _ := c.Get(key)
+	s, err := asSet(value)
+	_, found := s[member]
+	return found, err
+}
+
+// ZRangeByScore returns the members of the sorted set under key whose score
+// is between minScore and maxScore inclusive, in order.
+func (c *Cache) ZRangeByScore(key string, minScore, maxScore float64) ([]ScoredMember, error) {
+	value, _ := c.Get(key)
+	z, err := asSortedSet(value)
+	if err != nil {
+		return nil, err
+	}
+	from := sort.Search(len(z.entries), func(i int) bool { return z.entries[i].Score >= minScore })
+	to := sort.Search(len(z.entries), func(i int) bool { return z.entries[i].Score > maxScore })
+	if from >= to {
+		return []ScoredMember{}, nil
+	}
+	return slices.Clone(z.entries[from:to]), nil
+}
+
+// ZScore returns the score of member in the sorted set under key.
+func (c *Cache) ZScore(key, member string) (float64, bool, error) {
+	value, _ := c.Get(key)
+	z, err := asSortedSet(value)
+	if err != nil {
+		return 0, false, err
+	}
+	score, found := z.scores[member]
+	return score, found, nil
+}
+
+func (c *Cache) getHash(key string) (Hash, error) {
+	value, _ := c.Get(key)
+	return asHash(value)
+}
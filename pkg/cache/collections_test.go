@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func apply(t *testing.T, c *Cache, key, name string, args ...string) OpResult {
+	t.Helper()
+	result, _, err := c.Apply(key, Op{Name: name, Args: args}, time.Minute, OpOrigin{})
+	if err != nil {
+		t.Fatalf("%s %v failed: %v", name, args, err)
+	}
+	return result
+}
+
+func TestHashOps(t *testing.T) {
+	c := NewCache()
+	if r := apply(t, c, "user", "hset", "name", "alice", "age", "30"); r.Count != 2 {
+		t.Errorf("Expected 2 new fields, got %d", r.Count)
+	}
+	if r := apply(t, c, "user", "hset", "age", "31"); r.Count != 0 {
+		t.Errorf("Expected updating a field to add none, got %d", r.Count)
+	}
+	if value, found, _ := c.HGet("user", "age"); !found || value != "31" {
+		t.Errorf("Expected age 31, got %q, found: %v", value, found)
+	}
+	if v, _ := c.Get("user"); fmt.Sprint(v) != `{"age":"31","name":"alice"}` {
+		t.Errorf("Unexpected hash formatting %v", v)
+	}
+	apply(t, c, "user", "hdel", "name", "age", "missing")
+	if _, found := c.Get("user"); found {
+		t.Errorf("Expected emptied hash to be deleted")
+	}
+}
+
+func TestListOps(t *testing.T) {
+	c := NewCache()
+	apply(t, c, "q", "rpush", "b", "c")
+	if r := apply(t, c, "q", "lpush", "a", "z"); r.Count != 4 {
+		t.Errorf("Expected length 4, got %d", r.Count)
+	}
+	if got, _ := c.LRange("q", 0, -1); fmt.Sprint(got) != "[z a b c]" {
+		t.Errorf("Unexpected list %v", got)
+	}
+	if got, _ := c.LRange("q", -2, 10); fmt.Sprint(got) != "[b c]" {
+		t.Errorf("Unexpected tail %v", got)
+	}
+	if r := apply(t, c, "q", "rpop", "2"); fmt.Sprint(r.Values) != "[c b]" {
+		t.Errorf("Unexpected rpop %v", r.Values)
+	}
+	if r := apply(t, c, "q", "lpop"); fmt.Sprint(r.Values) != "[z]" {
+		t.Errorf("Unexpected lpop %v", r.Values)
+	}
+	if got, _ := c.LRange("q", 0, -1); fmt.Sprint(got) != "[a]" {
+		t.Errorf("Unexpected remaining list %v", got)
+	}
+}
+
+func TestSetAndSortedSetOps(t *testing.T) {
+	c := NewCache()
+	if r := apply(t, c, "tags", "sadd", "go", "cache", "go"); r.Count != 2 {
+		t.Errorf("Expected 2 new members, got %d", r.Count)
+	}
+	if members, _ := c.SMembers("tags"); fmt.Sprint(members) != "[cache go]" {
+		t.Errorf("Unexpected members %v", members)
+	}
+	if ok, _ := c.SIsMember("tags", "go"); !ok {
+		t.Errorf("Expected go to be a member")
+	}
+
+	apply(t, c, "scores", "zadd", "3", "carol", "1", "alice", "2", "bob")
+	apply(t, c, "scores", "zadd", "5", "alice")
+	got, _ := c.ZRangeByScore("scores", 2, 5)
+	if fmt.Sprint(got) != "[{bob 2} {carol 3} {alice 5}]" {
+		t.Errorf("Unexpected range %v", got)
+	}
+	if score, found, _ := c.ZScore("scores", "alice"); !found || score != 5 {
+		t.Errorf("Expected alice to score 5, got %v", score)
+	}
+	apply(t, c, "scores", "zrem", "bob")
+	if got, _ := c.ZRangeByScore("scores", 0, 10); len(got) != 2 {
+		t.Errorf("Expected 2 members after zrem, got %v", got)
+	}
+}
+
+func TestCollectionErrors(t *testing.T) {
+	c := NewCache()
+	c.Set("name", "alice", time.Minute)
+	if _, _, err := c.Apply("name", Op{Name: "lpush", Args: []string{"x"}}, 0, OpOrigin{}); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType, got %v", err)
+	}
+	if _, err := c.SMembers("name"); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType from a read, got %v", err)
+	}
+	for _, op := range []Op{{Name: "flush"}, {Name: "hset", Args: []string{"odd"}}, {Name: "zadd", Args: []string{"x", "m"}}} {
+		if _, _, err := c.Apply("k", op, 0, OpOrigin{}); !errors.Is(err, ErrInvalidOp) {
+			t.Errorf("%v: expected ErrInvalidOp, got %v", op, err)
+		}
+	}
+}
+
+func TestCollectionOpsAreAtomic(t *testing.T) {
+	c := NewCache()
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c.Apply("list", Op{Name: "rpush", Args: []string{fmt.Sprint(i)}}, 0, OpOrigin{})
+			c.Apply("hash", Op{Name: "hset", Args: []string{fmt.Sprint(i), "x"}}, 0, OpOrigin{})
+		}(i)
+	}
+	wg.Wait()
+
+	if items, _ := c.LRange("list", 0, -1); len(items) != 100 {
+		t.Errorf("Expected 100 list items, got %d", len(items))
+	}
+	if h, _ := c.HGetAll("hash"); len(h) != 100 {
+		t.Errorf("Expected 100 hash fields, got %d", len(h))
+	}
+}
+
+func TestReplicatedOpAppliedOnce(t *testing.T) {
+	c := NewCache()
+	add := func(member string, origin OpOrigin) CacheItem {
+		t.Helper()
+		_, item, err := c.Apply("set", Op{Name: "sadd", Args: []string{member}}, 0, origin)
+		if err != nil {
+			t.Fatalf("sadd %s failed: %v", member, err)
+		}
+		return item
+	}
+
+	if item := add("a", OpOrigin{Node: "n1", Seq: 2, Version: 10}); item.Version != 10 {
+		t.Fatalf("Expected the replicated version to be kept, got %d", item.Version)
+	}
+	// Concurrent ops from other nodes, or an earlier op delivered late,
+	// apply even though their versions are older
+	if item := add("b", OpOrigin{Node: "n2", Seq: 1, Version: 5}); item.Version <= 10 {
+		t.Errorf("Expected the version to keep increasing, got %d", item.Version)
+	}
+	add("c", OpOrigin{Node: "n1", Seq: 1, Version: 8})
+	// A redelivered op is not applied a second time
+	c.Apply("set", Op{Name: "srem", Args: []string{"a"}}, 0, OpOrigin{Node: "n3", Seq: 1})
+	add("a", OpOrigin{Node: "n1", Seq: 2, Version: 10})
+
+	if members, _ := c.SMembers("set"); fmt.Sprint(members) != "[b c]" {
+		t.Errorf("Expected members [b c], got %v", members)
+	}
+}
+
+func TestOpWindowForgetsOldOps(t *testing.T) {
+	w := &opWindow{seen: make(map[uint64]struct{})}
+	for seq := uint64(1); seq <= opWindowSize+1; seq++ {
+		if !w.record(seq + 1) {
+			t.Fatalf("Expected op %d to be new", seq+1)
+		}
+	}
+	if w.record(2) || w.record(opWindowSize+2) {
+		t.Errorf("Expected seen ops to be duplicates")
+	}
+	// Op 1 fell behind the window, so it can no longer be told apart
+	if w.record(1) {
+		t.Errorf("Expected an op older than the window to be dropped")
+	}
+	if len(w.seen) != opWindowSize {
+		t.Errorf("Expected %d remembered ops, got %d", opWindowSize, len(w.seen))
+	}
+}
@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrPreconditionFailed is returned by conditional writes whose Precondition
+// does not hold for the item currently stored under the key.
+var ErrPreconditionFailed = errors.New("cache: precondition failed")
+
+// Precondition restricts a write to a particular state of the current item.
+// The zero value places no restriction.
+type Precondition struct {
+	IfMatch           []uint64  // Current version must be one of these
+	IfMatchAny        bool      // An item must exist
+	IfNoneMatch       bool      // No item may exist (set-if-absent)
+	IfUnmodifiedSince time.Time // Item must not have changed after this time
+}
+
+func (p Precondition) check(modified int64, version uint64, exists bool) error {
+	if p.IfNoneMatch && exists {
+		return ErrPreconditionFailed
+	}
+	if (p.IfMatchAny || len(p.IfMatch) > 0) && !exists {
+		return ErrPreconditionFailed
+	}
+	if len(p.IfMatch) > 0 {
+		matched := false
+		for _, v := range p.IfMatch {
+			if v == version {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return ErrPreconditionFailed
+		}
+	}
+	// HTTP dates have second precision, so compare at that resolution.
+	if !p.IfUnmodifiedSince.IsZero() && exists &&
+		time.Unix(0, modified).Truncate(time.Second).After(p.IfUnmodifiedSince) {
+		return ErrPreconditionFailed
+	}
+	return nil
+}
+
+// SetIf stores value under key if cond holds for the current item and returns
+// the stored item. When opts.Version is set the write comes from a replica:
+// it keeps that version and is skipped if a newer one is already stored.
+func (c *TypedCache[K, V]) SetIf(key K, value V, duration time.Duration, opts SetOptions, cond Precondition) (Item[K, V], error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	current, exists := c.peek(key)
+	exists = exists && !current.expired(now.UnixNano())
+	if err := cond.check(current.Modified, current.Version, exists); err != nil {
+		return current, err
+	}
+	if opts.Version != 0 && exists && opts.Version <= current.Version {
+		return current, nil
+	}
+
+	expiration := expiresAt(now, duration)
+	item := Item[K, V]{
+		Key:         key,
+		Value:       value,
+		Expiration:  expiration,
+		Stale:       staleAt(now, opts.SoftTTL, expiration),
+		ContentType: opts.ContentType,
+		Version:     c.nextVersion(opts.Version, now),
+		Modified:    now.UnixNano(),
+		Flags:       opts.Flags,
+		Tags:        opts.Tags,
+	}
+	c.store(item)
+	c.publish(Event[K, V]{Type: EventSet, Key: key, Item: item})
+	return item, nil
+}
+
+// CompareAndSwap replaces the value under key only if its current version is
+// version.
+func (c *TypedCache[K, V]) CompareAndSwap(key K, version uint64, value V, duration time.Duration) (Item[K, V], error) {
+	return c.SetIf(key, value, duration, SetOptions{}, Precondition{IfMatch: []uint64{version}})
+}
+
+// DeleteIf removes key if cond holds for the current item.
+func (c *TypedCache[K, V]) DeleteIf(key K, cond Precondition) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	current, exists := c.peek(key)
+	exists = exists && !current.expired(time.Now().UnixNano())
+	if err := cond.check(current.Modified, current.Version, exists); err != nil {
+		return err
+	}
+	c.remove(key)
+	if exists {
+		c.publish(Event[K, V]{Type: EventDelete, Key: key, Item: current})
+	}
+	return nil
+}
+
+// nextVersion returns a version greater than any this cache has issued or
+// accepted. Versions follow the wall clock in nanoseconds so that writes made
+// on different nodes order roughly by time. Callers must hold c.mu.
+func (c *TypedCache[K, V]) nextVersion(replicated uint64, now time.Time) uint64 {
+	if replicated != 0 {
+		if replicated > c.clock {
+			c.clock = replicated
+		}
+		return replicated
+	}
+	c.clock++
+	if ts := uint64(now.UnixNano()); ts > c.clock {
+		c.clock = ts
+	}
+	return c.clock
+}
@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCompareAndSwap(t *testing.T) {
+	c := NewCache()
+	c.Set("counter", "1", time.Minute)
+	item, _ := c.GetItem("counter")
+
+	updated, err := c.CompareAndSwap("counter", item.Version, "2", time.Minute)
+	if err != nil {
+		t.Fatalf("Expected CAS with current version to succeed, got %v", err)
+	}
+	if updated.Version <= item.Version {
+		t.Errorf("Expected version to increase, got %d after %d", updated.Version, item.Version)
+	}
+
+	if _, err := c.CompareAndSwap("counter", item.Version, "3", time.Minute); err != ErrPreconditionFailed {
+		t.Errorf("Expected stale CAS to fail, got %v", err)
+	}
+	if value, _ := c.Get("counter"); value != "2" {
+		t.Errorf("Expected value to stay 2, got %v", value)
+	}
+}
+
+func TestSetIfNoneMatch(t *testing.T) {
+	c := NewCache()
+	absent := Precondition{IfNoneMatch: true}
+
+	if _, err := c.SetIf("lock", "a", time.Minute, SetOptions{}, absent); err != nil {
+		t.Fatalf("Expected set-if-absent on a new key to succeed, got %v", err)
+	}
+	if _, err := c.SetIf("lock", "b", time.Minute, SetOptions{}, absent); err != ErrPreconditionFailed {
+		t.Errorf("Expected set-if-absent on an existing key to fail, got %v", err)
+	}
+	if _, err := c.SetIf("missing", "x", time.Minute, SetOptions{}, Precondition{IfMatchAny: true}); err != ErrPreconditionFailed {
+		t.Errorf("Expected If-Match: * on a missing key to fail, got %v", err)
+	}
+}
+
+func TestSetIfUnmodifiedSince(t *testing.T) {
+	c := NewCache()
+	c.Set("doc", "v1", time.Minute)
+
+	before := time.Now().Add(-time.Hour)
+	if _, err := c.SetIf("doc", "v2", time.Minute, SetOptions{}, Precondition{IfUnmodifiedSince: before}); err != ErrPreconditionFailed {
+		t.Errorf("Expected write to fail for an item modified since %v, got %v", before, err)
+	}
+	after := time.Now().Add(time.Hour)
+	if _, err := c.SetIf("doc", "v2", time.Minute, SetOptions{}, Precondition{IfUnmodifiedSince: after}); err != nil {
+		t.Errorf("Expected write to succeed, got %v", err)
+	}
+}
+
+func TestDeleteIf(t *testing.T) {
+	c := NewCache()
+	c.Set("key", "value", time.Minute)
+	item, _ := c.GetItem("key")
+
+	if err := c.DeleteIf("key", Precondition{IfMatch: []uint64{item.Version + 1}}); err != ErrPreconditionFailed {
+		t.Errorf("Expected delete with wrong version to fail, got %v", err)
+	}
+	if err := c.DeleteIf("key", Precondition{IfMatch: []uint64{item.Version}}); err != nil {
+		t.Errorf("Expected delete with current version to succeed, got %v", err)
+	}
+	if _, found := c.Get("key"); found {
+		t.Errorf("Expected key to be deleted")
+	}
+}
+
+func TestReplicatedVersionsAreLastWriterWins(t *testing.T) {
+	c := NewCache()
+	c.SetWithOptions("key", "newer", time.Minute, SetOptions{Version: 200})
+	c.SetWithOptions("key", "older", time.Minute, SetOptions{Version: 100})
+
+	item, _ := c.GetItem("key")
+	if item.Value != "newer" || item.Version != 200 {
+		t.Errorf("Expected the newer replicated write to win, got %+v", item)
+	}
+
+	c.Set("key", "local", time.Minute)
+	if item, _ := c.GetItem("key"); item.Version <= 200 {
+		t.Errorf("Expected local version to exceed replicated ones, got %d", item.Version)
+	}
+}
+
+func TestSoftTTL(t *testing.T) {
+	c := NewCache()
+	item, _ := c.SetIf("k", "v", time.Minute, SetOptions{SoftTTL: 10 * time.Millisecond}, Precondition{})
+	if item.Stale == 0 || item.Stale >= item.Expiration {
+		t.Fatalf("Expected the item to go stale before it expires, got %+v", item)
+	}
+	now := time.Now().UnixNano()
+	if item.IsStale(now) {
+		t.Error("Expected a new item to be fresh")
+	}
+	if !item.IsStale(now + int64(20*time.Millisecond)) {
+		t.Error("Expected the item to be stale after its soft TTL")
+	}
+
+	// A soft TTL that doesn't end before the hard one is ignored
+	item, _ = c.SetIf("k", "v", time.Second, SetOptions{SoftTTL: time.Minute}, Precondition{})
+	if item.Stale != 0 {
+		t.Errorf("Expected no soft TTL past the expiration, got %d", item.Stale)
+	}
+	c.SetIf("k", "v", time.Minute, SetOptions{SoftTTL: 30 * time.Second}, Precondition{})
+	c.Expire("k", time.Second)
+	if item, _ := c.GetItem("k"); item.Stale != 0 {
+		t.Errorf("Expected shortening the TTL to drop the soft TTL, got %d", item.Stale)
+	}
+}
@@ -0,0 +1,230 @@
+package cache
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrNotCounter is returned when incrementing a key whose value is
+	// neither a counter nor an integer.
+	ErrNotCounter = errors.New("cache: value is not an integer or counter")
+	// ErrDeltaRange is returned for an increment of math.MinInt64, which
+	// has no positive counterpart to record as a decrement.
+	ErrDeltaRange = errors.New("cache: increment is out of range")
+)
+
+// baseNode is the counter entry holding the value an integer string had
+// when it was converted into a counter. Every node converts the same string
+// into the same entry, so the base is only counted once when their states
+// are merged. Node names never start with a NUL byte.
+const baseNode = "\x00base"
+
+// PNCounter is a state-based CRDT counter. Each node only ever grows its own
+// entries in P (increments) and N (decrements), so replicas that exchange
+// states and Merge them converge on the same value regardless of the order in
+// which increments arrive.
+//
+// A PNCounter stored in a Cache is never mutated in place; updates replace it
+// with a modified copy so readers can use it without holding the cache lock.
+type PNCounter struct {
+	P map[string]uint64 `json:"p"`
+	N map[string]uint64 `json:"n"`
+}
+
+func NewPNCounter() *PNCounter {
+	return &PNCounter{
+		P: make(map[string]uint64),
+		N: make(map[string]uint64),
+	}
+}
+
+// Add records delta as performed by node.
+func (pn *PNCounter) Add(node string, delta int64) {
+	if delta >= 0 {
+		pn.P[node] += uint64(delta)
+	} else {
+		// -(delta+1) cannot overflow, even for math.MinInt64
+		pn.N[node] += uint64(-(delta + 1)) + 1
+	}
+}
+
+// Value returns the current count.
+func (pn *PNCounter) Value() int64 {
+	var total int64
+	for _, v := range pn.P {
+		total += int64(v)
+	}
+	for _, v := range pn.N {
+		total -= int64(v)
+	}
+	return total
+}
+
+// Merge folds other into pn by taking the per-node maximum of each entry.
+func (pn *PNCounter) Merge(other *PNCounter) {
+	for node, v := range other.P {
+		if v > pn.P[node] {
+			pn.P[node] = v
+		}
+	}
+	for node, v := range other.N {
+		if v > pn.N[node] {
+			pn.N[node] = v
+		}
+	}
+}
+
+// Clone returns a deep copy of pn.
+func (pn *PNCounter) Clone() *PNCounter {
+	clone := NewPNCounter()
+	clone.Merge(pn)
+	return clone
+}
+
+// String formats the counter as its value, which is how it is returned over
+// the HTTP API.
+func (pn *PNCounter) String() string {
+	return strconv.FormatInt(pn.Value(), 10)
+}
+
+// Incr atomically adds delta to the counter under key and returns the new
+// value. A missing key starts at zero with the given duration; integer strings
+// are converted into counters.
+func (c *Cache) Incr(key string, delta int64, duration time.Duration) (int64, error) {
+	counter, err := c.IncrBy(key, "", delta, duration)
+	if err != nil {
+		return 0, err
+	}
+	return counter.Value(), nil
+}
+
+// IncrBy is Incr for a node of a cluster: the increment is attributed to node
+// in the counter's CRDT state, which is returned so it can be replicated.
+func (c *Cache) IncrBy(key string, node string, delta int64, duration time.Duration) (*PNCounter, error) {
+	if delta == math.MinInt64 {
+		return nil, ErrDeltaRange
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	item, exists := c.peek(key)
+	exists = exists && !item.expired(now.UnixNano())
+
+	var counter *PNCounter
+	if !exists {
+		counter = NewPNCounter()
+		item = Item[string, interface{}]{Key: key, Expiration: expiresAt(now, duration)}
+	} else if v, ok := item.Value.(*PNCounter); ok {
+		counter = v.Clone()
+	} else if n, ok := parseInteger(item.Value); ok {
+		counter = NewPNCounter()
+		counter.Add(baseNode, n)
+	} else {
+		return nil, ErrNotCounter
+	}
+	counter.Add(node, delta)
+	c.storeCounter(item, counter, now)
+	return counter, nil
+}
+
+// IncrUnsigned is IncrBy for memcached's unsigned 64-bit counters: an
+// increment wraps around at 2^64 and a decrement stops at zero. Both are
+// decided under the cache lock against the current value, which a counter
+// holds modulo 2^64, and recorded in the CRDT state like any increment.
+// Decrements made concurrently on different nodes can still take the merged
+// value below zero. A missing key is not created, and found is false.
+func (c *Cache) IncrUnsigned(key string, node string, delta uint64, decr bool) (counter *PNCounter, found bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	item, exists := c.peek(key)
+	if !exists || item.expired(now.UnixNano()) {
+		return nil, false, nil
+	}
+
+	var current uint64
+	if v, ok := item.Value.(*PNCounter); ok {
+		counter = v.Clone()
+		current = uint64(counter.Value())
+	} else if n, ok := parseUnsigned(item.Value); ok {
+		counter = NewPNCounter()
+		counter.Add(baseNode, int64(n))
+		current = n
+	} else {
+		return nil, true, ErrNotCounter
+	}
+	// Conversions to int64 wrap, which is exact modulo 2^64
+	if decr {
+		counter.Add(node, -int64(min(delta, current)))
+	} else {
+		counter.Add(node, int64(delta))
+	}
+	c.storeCounter(item, counter, now)
+	return counter, true, nil
+}
+
+// storeCounter stores counter as the new value of item. Callers must hold
+// c.mu.
+func (c *Cache) storeCounter(item CacheItem, counter *PNCounter, now time.Time) {
+	item.Value = counter
+	item.Version = c.nextVersion(0, now)
+	item.Modified = now.UnixNano()
+	c.store(item)
+	c.publish(CacheEvent{Type: EventSet, Key: item.Key, Item: item})
+}
+
+// MergeCounter merges a counter state received from another node into the
+// counter under key and returns the merged value. Non-counter values are
+// replaced.
+func (c *Cache) MergeCounter(key string, state *PNCounter, duration time.Duration) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	item, exists := c.peek(key)
+	current, isCounter := item.Value.(*PNCounter)
+	if !exists || item.expired(now.UnixNano()) || !isCounter {
+		current = NewPNCounter()
+		item = Item[string, interface{}]{Key: key, Expiration: expiresAt(now, duration)}
+	}
+	merged := current.Clone()
+	merged.Merge(state)
+	c.storeCounter(item, merged, now)
+	return merged.Value()
+}
+
+// parseInteger converts string and []byte values holding a base-10 integer.
+func parseInteger(v interface{}) (int64, bool) {
+	s, ok := integerText(v)
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n, err == nil
+}
+
+// parseUnsigned is parseInteger for unsigned 64-bit integers.
+func parseUnsigned(v interface{}) (uint64, bool) {
+	s, ok := integerText(v)
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	return n, err == nil
+}
+
+func integerText(v interface{}) (string, bool) {
+	switch b := v.(type) {
+	case string:
+		return b, true
+	case []byte:
+		return string(b), true
+	}
+	return "", false
+}
@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCacheIncrConcurrent(t *testing.T) {
+	c := NewCache()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Incr("hits", 1, time.Minute)
+		}()
+	}
+	wg.Wait()
+
+	value, err := c.Incr("hits", -10, time.Minute)
+	if err != nil || value != 90 {
+		t.Errorf("Expected 90 after 100 increments and one decrement of 10, got %d (%v)", value, err)
+	}
+}
+
+func TestCacheIncrExistingValues(t *testing.T) {
+	c := NewCache()
+	c.Set("n", "41", time.Minute)
+	if value, err := c.Incr("n", 1, time.Minute); err != nil || value != 42 {
+		t.Errorf("Expected integer string to be incremented to 42, got %d (%v)", value, err)
+	}
+
+	c.Set("name", "alice", time.Minute)
+	if _, err := c.Incr("name", 1, time.Minute); err != ErrNotCounter {
+		t.Errorf("Expected ErrNotCounter for a non-integer value, got %v", err)
+	}
+}
+
+func TestPNCounterConvergence(t *testing.T) {
+	// Two replicas increment concurrently and then exchange states in
+	// different orders, including a duplicate delivery.
+	a, b := NewCache(), NewCache()
+	stateA, _ := a.IncrBy("rate", "node-a", 5, time.Minute)
+	stateB, _ := b.IncrBy("rate", "node-b", 3, time.Minute)
+	stateB2, _ := b.IncrBy("rate", "node-b", -1, time.Minute)
+
+	a.MergeCounter("rate", stateB, time.Minute)
+	a.MergeCounter("rate", stateB2, time.Minute)
+	a.MergeCounter("rate", stateB, time.Minute)
+	b.MergeCounter("rate", stateA, time.Minute)
+
+	va, _ := a.Get("rate")
+	vb, _ := b.Get("rate")
+	if va.(*PNCounter).Value() != 7 || vb.(*PNCounter).Value() != 7 {
+		t.Errorf("Expected both replicas to converge on 7, got %v and %v", va, vb)
+	}
+}
+
+func TestConvertedCounterConverges(t *testing.T) {
+	// Both replicas hold the integer string and increment it concurrently
+	a, b := NewCache(), NewCache()
+	a.Set("n", "5", time.Minute)
+	b.Set("n", "5", time.Minute)
+	stateA, _ := a.IncrBy("n", "node-a", 1, time.Minute)
+	stateB, _ := b.IncrBy("n", "node-b", 1, time.Minute)
+
+	if va, vb := a.MergeCounter("n", stateB, time.Minute), b.MergeCounter("n", stateA, time.Minute); va != 7 || vb != 7 {
+		t.Errorf("Expected both replicas to converge on 7, got %d and %d", va, vb)
+	}
+}
+
+func TestIncrRejectsMinInt64(t *testing.T) {
+	c := NewCache()
+	if _, err := c.Incr("n", math.MinInt64, 0); err != ErrDeltaRange {
+		t.Errorf("Expected ErrDeltaRange, got %v", err)
+	}
+
+	pn := NewPNCounter()
+	pn.Add("node", math.MinInt64)
+	if pn.N["node"] != 1<<63 {
+		t.Errorf("Expected a decrement of 2^63, got %d", pn.N["node"])
+	}
+}
+
+func TestSetWithoutDurationNeverExpires(t *testing.T) {
+	c := NewCache()
+	c.Set("forever", "value", 0)
+	if _, found := c.Get("forever"); !found {
+		t.Errorf("Expected item with zero duration not to expire")
+	}
+}
@@ -0,0 +1,310 @@
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Items are encoded for persistence as a format byte followed by the key,
+// a tagged value and the metadata fields in declaration order. Strings are
+// uvarint length prefixed, and integers are varints or uvarints. Format 1
+// lacks the Stale field and is still read.
+const itemFormat = 2
+
+// Value tags.
+const (
+	valueString byte = iota
+	valueBytes
+	valueCounter
+	valueHash
+	valueList
+	valueSet
+	valueSortedSet
+)
+
+var (
+	// ErrUnsupportedValue is returned when encoding a value of a type the
+	// cache does not know how to persist.
+	ErrUnsupportedValue = errors.New("cache: value type cannot be persisted")
+	errShortItem        = errors.New("cache: truncated encoding")
+)
+
+// MarshalItem encodes item in a compact binary form. Strings, byte slices,
+// counters and collections are supported.
+func MarshalItem(item CacheItem) ([]byte, error) {
+	buf := make([]byte, 0, 64+len(item.Key))
+	buf = append(buf, itemFormat)
+	buf = AppendString(buf, item.Key)
+
+	var err error
+	if buf, err = appendValue(buf, item.Value); err != nil {
+		return nil, err
+	}
+	buf = binary.AppendVarint(buf, item.Expiration)
+	buf = AppendString(buf, item.ContentType)
+	buf = binary.AppendUvarint(buf, item.Version)
+	buf = binary.AppendVarint(buf, item.Modified)
+	buf = binary.AppendUvarint(buf, uint64(item.Flags))
+	buf = AppendStrings(buf, item.Tags)
+	buf = binary.AppendVarint(buf, item.Stale)
+	return buf, nil
+}
+
+// UnmarshalItem decodes an item written by MarshalItem.
+func UnmarshalItem(data []byte) (CacheItem, error) {
+	d := NewDecoder(data)
+	format := d.Byte()
+	if d.err == nil && format != itemFormat && format != 1 {
+		return CacheItem{}, fmt.Errorf("cache: unknown item format %d", format)
+	}
+
+	var item CacheItem
+	item.Key = d.Str()
+	item.Value = d.value()
+	item.Expiration = d.Varint()
+	item.ContentType = d.Str()
+	item.Version = d.Uvarint()
+	item.Modified = d.Varint()
+	item.Flags = uint32(d.Uvarint())
+	item.Tags = d.Strings()
+	if format >= 2 {
+		item.Stale = d.Varint()
+	}
+	return item, d.err
+}
+
+func appendValue(buf []byte, value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case string:
+		buf = append(buf, valueString)
+		return AppendString(buf, v), nil
+	case []byte:
+		buf = append(buf, valueBytes)
+		return AppendString(buf, string(v)), nil
+	case *PNCounter:
+		buf = append(buf, valueCounter)
+		buf = AppendCounts(buf, v.P)
+		return AppendCounts(buf, v.N), nil
+	case Hash:
+		buf = append(buf, valueHash)
+		buf = binary.AppendUvarint(buf, uint64(len(v)))
+		for field, value := range v {
+			buf = AppendString(buf, field)
+			buf = AppendString(buf, value)
+		}
+		return buf, nil
+	case List:
+		buf = append(buf, valueList)
+		return AppendStrings(buf, v), nil
+	case Set:
+		buf = append(buf, valueSet)
+		return AppendStrings(buf, v.Members()), nil
+	case *SortedSet:
+		buf = append(buf, valueSortedSet)
+		buf = binary.AppendUvarint(buf, uint64(len(v.entries)))
+		for _, e := range v.entries {
+			buf = AppendString(buf, e.Member)
+			buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(e.Score))
+		}
+		return buf, nil
+	}
+	return nil, fmt.Errorf("%w: %T", ErrUnsupportedValue, value)
+}
+
+// AppendString appends s with a uvarint length prefix. It and the other
+// Append helpers are shared with the replication payload encoding.
+func AppendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// AppendStrings appends a uvarint count followed by the strings.
+func AppendStrings(buf []byte, list []string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(list)))
+	for _, s := range list {
+		buf = AppendString(buf, s)
+	}
+	return buf
+}
+
+// AppendCounts appends a counter map as a count followed by node and count
+// pairs.
+func AppendCounts(buf []byte, counts map[string]uint64) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(counts)))
+	for node, n := range counts {
+		buf = AppendString(buf, node)
+		buf = binary.AppendUvarint(buf, n)
+	}
+	return buf
+}
+
+// Decoder reads fields written by the Append helpers in order, remembering
+// the first error so callers only need to check once at the end.
+type Decoder struct {
+	buf []byte
+	err error
+}
+
+func NewDecoder(buf []byte) *Decoder {
+	return &Decoder{buf: buf}
+}
+
+// Err returns the first error met while decoding.
+func (d *Decoder) Err() error {
+	return d.err
+}
+
+func (d *Decoder) Byte() byte {
+	if d.err != nil || len(d.buf) == 0 {
+		d.fail()
+		return 0
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b
+}
+
+func (d *Decoder) Uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *Decoder) Varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *Decoder) Float() float64 {
+	if d.err != nil || len(d.buf) < 8 {
+		d.fail()
+		return 0
+	}
+	v := math.Float64frombits(binary.LittleEndian.Uint64(d.buf))
+	d.buf = d.buf[8:]
+	return v
+}
+
+// Bytes returns a copy of the next length-prefixed field.
+func (d *Decoder) Bytes() []byte {
+	return append([]byte(nil), d.next()...)
+}
+
+// Str returns the next length-prefixed field as a string.
+func (d *Decoder) Str() string {
+	return string(d.next())
+}
+
+// next returns the next length-prefixed field, aliasing the input.
+func (d *Decoder) next() []byte {
+	n := d.Uvarint()
+	if d.err != nil || n > uint64(len(d.buf)) {
+		d.fail()
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+// Count reads a length prefix, rejecting lengths that cannot fit in the rest
+// of the input so corrupt data cannot force a huge allocation.
+func (d *Decoder) Count() int {
+	n := d.Uvarint()
+	if n > uint64(len(d.buf)) {
+		d.fail()
+		return 0
+	}
+	return int(n)
+}
+
+func (d *Decoder) Strings() []string {
+	n := d.Count()
+	if n == 0 {
+		return nil
+	}
+	list := make([]string, n)
+	for i := range list {
+		list[i] = d.Str()
+	}
+	return list
+}
+
+func (d *Decoder) Counts() map[string]uint64 {
+	n := d.Count()
+	counts := make(map[string]uint64, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		node := d.Str()
+		counts[node] = d.Uvarint()
+	}
+	return counts
+}
+
+func (d *Decoder) value() interface{} {
+	switch tag := d.Byte(); tag {
+	case valueString:
+		return d.Str()
+	case valueBytes:
+		return []byte(d.Str())
+	case valueCounter:
+		return &PNCounter{P: d.Counts(), N: d.Counts()}
+	case valueHash:
+		n := d.Count()
+		h := make(Hash, n)
+		for i := 0; i < n && d.err == nil; i++ {
+			field := d.Str()
+			h[field] = d.Str()
+		}
+		return h
+	case valueList:
+		l := List(d.Strings())
+		if l == nil {
+			l = List{}
+		}
+		return l
+	case valueSet:
+		members := d.Strings()
+		s := make(Set, len(members))
+		for _, m := range members {
+			s[m] = struct{}{}
+		}
+		return s
+	case valueSortedSet:
+		n := d.Count()
+		updates := make(map[string]*float64, n)
+		for i := 0; i < n && d.err == nil; i++ {
+			member := d.Str()
+			score := d.Float()
+			updates[member] = &score
+		}
+		return (&SortedSet{}).withScores(updates)
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("cache: unknown value tag %d", tag)
+		}
+		return nil
+	}
+}
+
+func (d *Decoder) fail() {
+	if d.err == nil {
+		d.err = errShortItem
+	}
+}
@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestItemEncodingRoundTrip(t *testing.T) {
+	c := NewCache()
+	c.Apply("z", Op{Name: "zadd", Args: []string{"2", "b", "1.5", "a"}}, 0, OpOrigin{})
+	zset, _ := c.Get("z")
+	counter := NewPNCounter()
+	counter.Add("n1", 4)
+	counter.Add("n2", -1)
+
+	values := []interface{}{
+		"text",
+		[]byte{0, 1, 2},
+		counter,
+		Hash{"f": "v"},
+		List{"a", "b"},
+		Set{"x": {}},
+		zset,
+	}
+	for _, value := range values {
+		item := CacheItem{
+			Key:         "k",
+			Value:       value,
+			Expiration:  time.Now().Add(time.Minute).UnixNano(),
+			ContentType: "text/plain",
+			Version:     42,
+			Modified:    time.Now().UnixNano(),
+			Flags:       7,
+			Tags:        []string{"t1", "t2"},
+			Stale:       time.Now().Add(time.Second).UnixNano(),
+		}
+		data, err := MarshalItem(item)
+		if err != nil {
+			t.Fatalf("Failed to marshal %T: %v", value, err)
+		}
+		got, err := UnmarshalItem(data)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal %T: %v", value, err)
+		}
+		if !reflect.DeepEqual(got, item) {
+			t.Errorf("Round trip mismatch for %T:\nwant %+v\n got %+v", value, item, got)
+		}
+		if _, err := UnmarshalItem(data[:len(data)-2]); err == nil {
+			t.Errorf("Expected truncated %T to fail to decode", value)
+		}
+	}
+
+	// Items persisted before soft TTLs existed still decode
+	old := []byte{1, 1, 'k', valueString, 1, 'v', 0, 0, 1, 0, 0, 0}
+	if item, err := UnmarshalItem(old); err != nil || item.Value != "v" || item.Version != 1 {
+		t.Errorf("Expected a format 1 item to decode, got %+v, %v", item, err)
+	}
+
+	if _, err := MarshalItem(CacheItem{Key: "k", Value: 3.5}); !errors.Is(err, ErrUnsupportedValue) {
+		t.Errorf("Expected ErrUnsupportedValue, got %v", err)
+	}
+}
+
+// recordingJournal collects the changes a cache reports.
+type recordingJournal struct {
+	changes []string
+}
+
+func (j *recordingJournal) Stored(item CacheItem) { j.changes = append(j.changes, "set "+item.Key) }
+func (j *recordingJournal) Removed(key string)    { j.changes = append(j.changes, "del "+key) }
+
+func TestJournalSeesEveryChange(t *testing.T) {
+	c := NewCache()
+	j := &recordingJournal{}
+	c.SetJournal(j)
+
+	c.Set("a", "1", time.Minute)
+	c.Expire("a", time.Hour)
+	c.Incr("n", 1, 0)
+	c.Delete("a")
+	c.Set("short", "x", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	c.DeleteExpired()
+	c.Load(CacheItem{Key: "loaded", Value: "x"})
+
+	want := "[set a set a set n del a set short del short]"
+	if got := fmt.Sprint(j.changes); got != want {
+		t.Errorf("Unexpected journal %s, want %s", got, want)
+	}
+	if _, found := c.Get("loaded"); !found {
+		t.Errorf("Expected loaded item to be stored")
+	}
+}
@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// evictionSamples is how many items are looked at to pick an eviction victim.
+const evictionSamples = 5
+
+// SetMaxItems limits the cache to n items; zero removes the limit. Once the
+// limit is reached, every write that adds a key first evicts another one.
+func (c *TypedCache[K, V]) SetMaxItems(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxItems = n
+	for n > 0 && c.items.Len() > n {
+		c.evictOne()
+	}
+}
+
+// evictOne removes one item to make room. Like Redis' approximated LRU it
+// samples a few items rather than tracking all of them: an expired one is
+// dropped if found, otherwise the least recently written. Callers must hold
+// c.mu for writing.
+func (c *TypedCache[K, V]) evictOne() {
+	now := time.Now().UnixNano()
+
+	var victim Item[K, V]
+	expired := false
+	sampled := 0
+	err := c.items.Scan(nil, func(item Item[K, V]) bool {
+		if item.expired(now) {
+			victim, expired = item, true
+			return false
+		}
+		if sampled == 0 || item.Modified < victim.Modified {
+			victim = item
+		}
+		sampled++
+		return sampled < evictionSamples
+	})
+	if err != nil {
+		log.Printf("Failed to pick an item to evict: %v", err)
+		return
+	}
+	switch {
+	case expired:
+		c.remove(victim.Key)
+		c.publish(Event[K, V]{Type: EventExpire, Key: victim.Key, Item: victim})
+	case sampled > 0:
+		c.remove(victim.Key)
+		c.publish(Event[K, V]{Type: EventEvict, Key: victim.Key, Item: victim})
+	}
+}
+
+// DeleteExpired removes every expired item and returns how many there were.
+// Expired items are otherwise only hidden from reads, so this is what makes
+// their EventExpire visible to watchers.
+func (c *TypedCache[K, V]) DeleteExpired() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	var expired []Item[K, V]
+	collect := func(item Item[K, V]) bool {
+		if item.expired(now) {
+			expired = append(expired, item)
+		}
+		return true
+	}
+	var err error
+	if scanner, ok := c.items.(ExpiryScanner[K, V]); ok {
+		err = scanner.ScanExpired(now, collect)
+	} else {
+		err = c.items.Scan(nil, collect)
+	}
+	if err != nil {
+		log.Printf("Failed to find expired items: %v", err)
+	}
+
+	// Removed after scanning, as stores can't be modified during a scan
+	for _, item := range expired {
+		c.remove(item.Key)
+		c.publish(Event[K, V]{Type: EventExpire, Key: item.Key, Item: item})
+	}
+	return len(expired)
+}
+
+// StartJanitor runs DeleteExpired every interval until the returned function
+// is called.
+func (c *TypedCache[K, V]) StartJanitor(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				c.DeleteExpired()
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"log"
+	"time"
+)
+
+// A Journal is told about every change to a cache, in order, so it can be
+// persisted. Expiry, eviction and TTL changes are reported like any other
+// write. Its methods are called with the cache lock held, so they must be
+// quick and must not call back into the cache.
+type Journal[K comparable, V any] interface {
+	Stored(item Item[K, V])
+	Removed(key K)
+}
+
+// SetJournal starts reporting changes to j, or stops if j is nil.
+func (c *TypedCache[K, V]) SetJournal(j Journal[K, V]) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.journal = j
+}
+
+// Load stores item exactly as given, keeping its version, expiration and
+// modification time. It is meant for restoring persisted items: no event is
+// published and the journal is not told. Expired items are skipped.
+func (c *TypedCache[K, V]) Load(item Item[K, V]) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if item.expired(time.Now().UnixNano()) {
+		return false
+	}
+	if item.Version > c.clock {
+		c.clock = item.Version
+	}
+	journal := c.journal
+	c.journal = nil
+	c.store(item)
+	c.journal = journal
+	return true
+}
+
+// Unload removes key without publishing an event or telling the journal.
+func (c *TypedCache[K, V]) Unload(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	journal := c.journal
+	c.journal = nil
+	c.remove(key)
+	c.journal = journal
+}
+
+// Items returns a copy of every unexpired item. The values themselves are
+// shared with the cache, not copied.
+func (c *TypedCache[K, V]) Items() []Item[K, V] {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := time.Now().UnixNano()
+	items := make([]Item[K, V], 0, c.items.Len())
+	err := c.items.Scan(nil, func(item Item[K, V]) bool {
+		if !item.expired(now) {
+			items = append(items, item)
+		}
+		return true
+	})
+	if err != nil {
+		log.Printf("Failed to list items: %v", err)
+	}
+	return items
+}
+
+// Replace swaps the whole contents of the cache for items, as when restoring
+// a snapshot. Unlike Load, every key it removes or stores is published as an
+// event and reported to the journal. Expired items are skipped. It returns
+// the number of items stored.
+func (c *TypedCache[K, V]) Replace(items []Item[K, V]) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	keep := make(map[K]struct{}, len(items))
+	for _, item := range items {
+		if !item.expired(now) {
+			keep[item.Key] = struct{}{}
+		}
+	}
+	var removed []Item[K, V]
+	err := c.items.Scan(nil, func(item Item[K, V]) bool {
+		if _, ok := keep[item.Key]; !ok {
+			removed = append(removed, item)
+		}
+		return true
+	})
+	if err != nil {
+		log.Printf("Failed to list items to replace: %v", err)
+	}
+	for _, item := range removed {
+		c.remove(item.Key)
+		c.publish(Event[K, V]{Type: EventDelete, Key: item.Key, Item: item})
+	}
+
+	n := 0
+	for _, item := range items {
+		if item.expired(now) {
+			continue
+		}
+		if item.Version > c.clock {
+			c.clock = item.Version
+		}
+		c.store(item)
+		c.publish(Event[K, V]{Type: EventSet, Key: item.Key, Item: item})
+		n++
+	}
+	return n
+}
@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+// DefaultScanLimit is the page size Scan uses when given a limit of zero.
+const DefaultScanLimit = 10
+
+// Scan lists live keys starting with prefix, in order, a page at a time. It
+// returns up to limit keys that sort after cursor and the cursor for the next
+// page. Pass an empty cursor to start; an empty cursor is returned once the
+// scan is complete.
+//
+// Each call holds the read lock for a single page only, so writers are never
+// blocked for the length of a scan. Because pages resume after the last key
+// returned, a key present for the whole scan is returned exactly once. Keys
+// written or deleted while the scan runs may or may not be returned.
+func (c *Cache) Scan(cursor, prefix string, limit int) ([]string, string) {
+	if limit <= 0 {
+		limit = DefaultScanLimit
+	}
+	start := prefix
+	if cursor >= start {
+		// The smallest key sorting after the cursor
+		start = cursor + "\x00"
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := time.Now().UnixNano()
+	keys := make([]string, 0, limit)
+	more := false
+	err := c.items.Scan(&start, func(item CacheItem) bool {
+		if !strings.HasPrefix(item.Key, prefix) {
+			return false
+		}
+		if item.expired(now) {
+			return true
+		}
+		if len(keys) == limit {
+			more = true
+			return false
+		}
+		keys = append(keys, item.Key)
+		return true
+	})
+	if err != nil {
+		log.Printf("Failed to scan keys: %v", err)
+	}
+
+	if !more {
+		return keys, ""
+	}
+	return keys, keys[len(keys)-1]
+}
+
+// ScanItems is Scan for whole items regardless of their key: it returns up
+// to limit live items with keys sorting after cursor, in order, and the
+// cursor for the next page. Like Scan, it holds the read lock for a single
+// page only.
+func (c *Cache) ScanItems(cursor string, limit int) ([]CacheItem, string) {
+	if limit <= 0 {
+		limit = DefaultScanLimit
+	}
+	// Scan only visits keys in order when given a key to start from
+	start := cursor
+	if cursor != "" {
+		start += "\x00"
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := time.Now().UnixNano()
+	items := make([]CacheItem, 0, limit)
+	more := false
+	err := c.items.Scan(&start, func(item CacheItem) bool {
+		if item.expired(now) {
+			return true
+		}
+		if len(items) == limit {
+			more = true
+			return false
+		}
+		items = append(items, item)
+		return true
+	})
+	if err != nil {
+		log.Printf("Failed to scan items: %v", err)
+	}
+
+	if !more {
+		return items, ""
+	}
+	return items, items[len(items)-1].Key
+}
@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestScanPages(t *testing.T) {
+	c := NewCache()
+	for i := 0; i < 25; i++ {
+		c.Set(fmt.Sprintf("user:%02d", i), i, time.Minute)
+	}
+	c.Set("order:1", "x", time.Minute)
+	c.Set("user:expired", "x", time.Nanosecond)
+	c.Delete("user:13")
+	time.Sleep(time.Millisecond)
+
+	var all []string
+	cursor, pages := "", 0
+	for {
+		var keys []string
+		keys, cursor = c.Scan(cursor, "user:", 10)
+		all = append(all, keys...)
+		pages++
+		if cursor == "" {
+			break
+		}
+	}
+
+	var want []string
+	for i := 0; i < 25; i++ {
+		if i != 13 {
+			want = append(want, fmt.Sprintf("user:%02d", i))
+		}
+	}
+	if !reflect.DeepEqual(all, want) {
+		t.Errorf("Expected %v, got %v", want, all)
+	}
+	if pages != 3 {
+		t.Errorf("Expected 3 pages, got %d", pages)
+	}
+
+	keys, cursor := c.Scan("", "", 0)
+	if len(keys) != DefaultScanLimit || keys[0] != "order:1" || cursor != keys[len(keys)-1] {
+		t.Errorf("Unexpected first page of full scan: %v, cursor %q", keys, cursor)
+	}
+}
+
+func TestScanDuringWrites(t *testing.T) {
+	c := NewCache()
+	for i := 0; i < 1000; i++ {
+		c.Set(fmt.Sprintf("stable:%04d", i), i, time.Minute)
+	}
+
+	// Churn other keys while scanning; every stable key must be seen once
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			key := fmt.Sprintf("stable:%04d-churn", i%1000)
+			c.Set(key, i, time.Minute)
+			c.Delete(key)
+		}
+	}()
+
+	seen := map[string]int{}
+	cursor := ""
+	for {
+		var keys []string
+		keys, cursor = c.Scan(cursor, "stable:", 7)
+		for _, key := range keys {
+			seen[key]++
+		}
+		if cursor == "" {
+			break
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	for i := 0; i < 1000; i++ {
+		if n := seen[fmt.Sprintf("stable:%04d", i)]; n != 1 {
+			t.Fatalf("Expected stable:%04d once, saw it %d times", i, n)
+		}
+	}
+}
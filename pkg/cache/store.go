@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"cmp"
+
+	"github.com/google/btree"
+)
+
+// Store is the storage engine behind a TypedCache. It only keeps items by
+// key: versions, expiry, events, tags and eviction are all handled by the
+// cache on top of it.
+//
+// The cache calls Get, Scan, Len and Stats with its read lock held, possibly
+// from several goroutines at once, and Set and Delete with its write lock
+// held. Values handed to Set are never modified afterwards, and values
+// returned by Get must not be modified by the store.
+type Store[K comparable, V any] interface {
+	Get(key K) (Item[K, V], bool)
+	Set(item Item[K, V]) error
+	Delete(key K) error
+	// Scan calls fn for stored items until it returns false. Given a from
+	// key, an ordered store visits the items whose keys sort at or after it,
+	// in order. With a nil from every item is visited in whatever order is
+	// cheapest. fn must not modify the store.
+	Scan(from *K, fn func(Item[K, V]) bool) error
+	Len() int
+	Stats() StoreStats
+	Close() error
+}
+
+// ExpiryScanner is implemented by stores that can find expired items without
+// visiting every item. DeleteExpired uses it when available.
+type ExpiryScanner[K comparable, V any] interface {
+	// ScanExpired calls fn for items that expired at or before now, in
+	// order of expiration, until it returns false.
+	ScanExpired(now int64, fn func(Item[K, V]) bool) error
+}
+
+// Peeker is implemented by stores whose Get has side effects, such as
+// counting hits or promoting items between tiers. The cache uses Peek for
+// lookups that don't read the value on behalf of a client: keeping its
+// indexes up to date, checking preconditions and applying replicated writes.
+type Peeker[K comparable, V any] interface {
+	Peek(key K) (Item[K, V], bool)
+}
+
+// StoreStats describes the contents of a Store.
+type StoreStats struct {
+	Engine string      `json:"engine"`
+	Items  int         `json:"items"`
+	Size   int64       `json:"size"`            // Bytes on disk; zero for in-memory stores
+	Tiers  []TierStats `json:"tiers,omitempty"` // Per tier, for tiered stores
+}
+
+// TierStats describes one tier of a tiered store.
+type TierStats struct {
+	Engine  string  `json:"engine"`
+	Items   int     `json:"items"`
+	Size    int64   `json:"size"`
+	Hits    uint64  `json:"hits"`
+	HitRate float64 `json:"hit_rate"` // Share of all lookups served by this tier
+}
+
+// MemoryStore keeps items in a map. It is the default Store.
+type MemoryStore[K comparable, V any] struct {
+	items map[K]Item[K, V]
+	index *btree.BTreeG[K] // Keys in order, if the store was created ordered
+}
+
+func NewMemoryStore[K comparable, V any]() *MemoryStore[K, V] {
+	return &MemoryStore[K, V]{items: make(map[K]Item[K, V])}
+}
+
+// NewOrderedMemoryStore creates a MemoryStore that also keeps its keys in an
+// ordered index, so Scan can start from a key. Maintaining the index adds a
+// small cost to writes that create or remove a key.
+func NewOrderedMemoryStore[K cmp.Ordered, V any]() *MemoryStore[K, V] {
+	s := NewMemoryStore[K, V]()
+	s.index = btree.NewG[K](32, cmp.Less[K])
+	return s
+}
+
+func (s *MemoryStore[K, V]) Get(key K) (Item[K, V], bool) {
+	item, found := s.items[key]
+	return item, found
+}
+
+func (s *MemoryStore[K, V]) Set(item Item[K, V]) error {
+	if _, exists := s.items[item.Key]; !exists && s.index != nil {
+		s.index.ReplaceOrInsert(item.Key)
+	}
+	s.items[item.Key] = item
+	return nil
+}
+
+func (s *MemoryStore[K, V]) Delete(key K) error {
+	if _, exists := s.items[key]; exists && s.index != nil {
+		s.index.Delete(key)
+	}
+	delete(s.items, key)
+	return nil
+}
+
+// Scan visits items in key order when given a from key and the store is
+// ordered, and in map order otherwise.
+func (s *MemoryStore[K, V]) Scan(from *K, fn func(Item[K, V]) bool) error {
+	if from == nil || s.index == nil {
+		for _, item := range s.items {
+			if !fn(item) {
+				break
+			}
+		}
+		return nil
+	}
+	s.index.AscendGreaterOrEqual(*from, func(key K) bool {
+		return fn(s.items[key])
+	})
+	return nil
+}
+
+func (s *MemoryStore[K, V]) Len() int { return len(s.items) }
+
+func (s *MemoryStore[K, V]) Stats() StoreStats {
+	return StoreStats{Engine: "memory", Items: len(s.items)}
+}
+
+func (s *MemoryStore[K, V]) Close() error { return nil }
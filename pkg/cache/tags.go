@@ -0,0 +1,55 @@
+package cache
+
+// tag adds key to the index of each tag. Callers must hold c.mu.
+func (c *TypedCache[K, V]) tag(key K, tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+	if c.tags == nil {
+		c.tags = make(map[string]map[K]struct{})
+	}
+	for _, t := range tags {
+		if c.tags[t] == nil {
+			c.tags[t] = make(map[K]struct{})
+		}
+		c.tags[t][key] = struct{}{}
+	}
+}
+
+// untag removes key from the index of each tag. Callers must hold c.mu.
+func (c *TypedCache[K, V]) untag(key K, tags []string) {
+	for _, t := range tags {
+		delete(c.tags[t], key)
+		if len(c.tags[t]) == 0 {
+			delete(c.tags, t)
+		}
+	}
+}
+
+// Tagged returns the keys currently carrying tag.
+func (c *TypedCache[K, V]) Tagged(tag string) []K {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	keys := make([]K, 0, len(c.tags[tag]))
+	for key := range c.tags[tag] {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// InvalidateTag deletes every item carrying tag and returns how many were
+// deleted.
+func (c *TypedCache[K, V]) InvalidateTag(tag string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for key := range c.tags[tag] {
+		item, _ := c.peek(key)
+		c.remove(key)
+		c.publish(Event[K, V]{Type: EventDelete, Key: key, Item: item})
+		n++
+	}
+	return n
+}
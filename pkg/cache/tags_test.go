@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func sortedTagged(c *Cache, tag string) []string {
+	keys := c.Tagged(tag)
+	sort.Strings(keys)
+	return keys
+}
+
+func TestInvalidateTag(t *testing.T) {
+	c := NewCache()
+	c.SetWithOptions("product:1", "a", time.Minute, SetOptions{Tags: []string{"product:1", "catalog"}})
+	c.SetWithOptions("product:1:price", "9", time.Minute, SetOptions{Tags: []string{"product:1"}})
+	c.SetWithOptions("product:2", "b", time.Minute, SetOptions{Tags: []string{"catalog"}})
+
+	w := c.Watch(8)
+	defer w.Close()
+
+	if n := c.InvalidateTag("product:1"); n != 2 {
+		t.Errorf("Expected 2 keys invalidated, got %d", n)
+	}
+	if _, found := c.Get("product:1:price"); found {
+		t.Errorf("Expected tagged key to be deleted")
+	}
+	if ev := <-w.C; ev.Type != EventDelete {
+		t.Errorf("Expected a delete event, got %v", ev.Type)
+	}
+	if keys := sortedTagged(c, "catalog"); len(keys) != 1 || keys[0] != "product:2" {
+		t.Errorf("Expected invalidated key to leave its other tags, got %v", keys)
+	}
+	if n := c.InvalidateTag("product:1"); n != 0 {
+		t.Errorf("Expected nothing left to invalidate, got %d", n)
+	}
+}
+
+func TestTagIndexFollowsWrites(t *testing.T) {
+	c := NewCache()
+	c.SetWithOptions("k", "v1", time.Minute, SetOptions{Tags: []string{"old"}})
+	c.SetWithOptions("k", "v2", time.Minute, SetOptions{Tags: []string{"new"}})
+	if len(c.Tagged("old")) != 0 || len(c.Tagged("new")) != 1 {
+		t.Errorf("Expected overwrite to move tags, got old=%v new=%v", c.Tagged("old"), c.Tagged("new"))
+	}
+	c.Expire("k", time.Hour)
+	if len(c.Tagged("new")) != 1 {
+		t.Errorf("Expected Expire to keep tags")
+	}
+
+	c.SetWithOptions("short", "x", time.Millisecond, SetOptions{Tags: []string{"new"}})
+	time.Sleep(5 * time.Millisecond)
+	c.DeleteExpired()
+	if keys := c.Tagged("new"); len(keys) != 1 || keys[0] != "k" {
+		t.Errorf("Expected expired key to leave the tag index, got %v", keys)
+	}
+
+	c.SetMaxItems(1)
+	c.SetWithOptions("other", "y", time.Minute, SetOptions{})
+	if keys := c.Tagged("new"); len(keys) != 0 {
+		t.Errorf("Expected evicted key to leave the tag index, got %v", keys)
+	}
+	c.Delete("other")
+	if len(c.tags) != 0 {
+		t.Errorf("Expected an empty tag index, got %v", c.tags)
+	}
+}
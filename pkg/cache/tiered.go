@@ -0,0 +1,257 @@
+package cache
+
+import (
+	"cmp"
+	"container/list"
+	"iter"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/btree"
+)
+
+// TieredStore keeps the most recently used items in memory and the rest in
+// a cold store, usually on disk. When the memory tier is full, its least
+// recently used item is demoted to the cold tier instead of being dropped;
+// expired items are dropped. A cold item that is read is promoted back to
+// memory. Each key lives in exactly one tier.
+type TieredStore[K cmp.Ordered, V any] struct {
+	mu       sync.Mutex // Get promotes, so even reads need it
+	hot      map[K]*list.Element
+	lru      *list.List // Hot items, most recently used first
+	index    *btree.BTreeG[K]
+	hotItems int
+	cold     Store[K, V]
+
+	lookups  uint64
+	hotHits  uint64
+	coldHits uint64
+}
+
+var _ Peeker[string, interface{}] = (*TieredStore[string, interface{}])(nil)
+
+// NewTieredStore creates a TieredStore that holds up to hotItems items in
+// memory in front of cold.
+func NewTieredStore[K cmp.Ordered, V any](cold Store[K, V], hotItems int) *TieredStore[K, V] {
+	return &TieredStore[K, V]{
+		hot:      make(map[K]*list.Element),
+		lru:      list.New(),
+		index:    btree.NewG[K](32, cmp.Less[K]),
+		hotItems: max(hotItems, 1),
+		cold:     cold,
+	}
+}
+
+func (t *TieredStore[K, V]) Get(key K) (Item[K, V], bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.lookups++
+	if e, found := t.hot[key]; found {
+		t.hotHits++
+		t.lru.MoveToFront(e)
+		return e.Value.(Item[K, V]), true
+	}
+	item, found := t.cold.Get(key)
+	if !found {
+		return Item[K, V]{}, false
+	}
+	t.coldHits++
+	if !item.expired(time.Now().UnixNano()) {
+		if err := t.cold.Delete(key); err != nil {
+			log.Printf("Failed to promote %v: %v", key, err)
+			return item, true
+		}
+		t.insertHot(item)
+	}
+	return item, true
+}
+
+// Peek implements Peeker: it neither counts the lookup nor promotes.
+func (t *TieredStore[K, V]) Peek(key K) (Item[K, V], bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if e, found := t.hot[key]; found {
+		return e.Value.(Item[K, V]), true
+	}
+	return t.peekCold(key)
+}
+
+// Set writes item to the memory tier, demoting another item if it is full.
+func (t *TieredStore[K, V]) Set(item Item[K, V]) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if e, found := t.hot[item.Key]; found {
+		e.Value = item
+		t.lru.MoveToFront(e)
+		return nil
+	}
+	if _, found := t.peekCold(item.Key); found {
+		if err := t.cold.Delete(item.Key); err != nil {
+			return err
+		}
+	}
+	t.insertHot(item)
+	return nil
+}
+
+// peekCold looks key up in the cold tier. Callers must hold t.mu.
+func (t *TieredStore[K, V]) peekCold(key K) (Item[K, V], bool) {
+	if p, ok := t.cold.(Peeker[K, V]); ok {
+		return p.Peek(key)
+	}
+	return t.cold.Get(key)
+}
+
+// insertHot adds item to the memory tier and demotes the least recently
+// used items that no longer fit. Callers must hold t.mu.
+func (t *TieredStore[K, V]) insertHot(item Item[K, V]) {
+	t.hot[item.Key] = t.lru.PushFront(item)
+	t.index.ReplaceOrInsert(item.Key)
+
+	now := time.Now().UnixNano()
+	for len(t.hot) > t.hotItems {
+		e := t.lru.Back()
+		victim := e.Value.(Item[K, V])
+		t.lru.Remove(e)
+		delete(t.hot, victim.Key)
+		t.index.Delete(victim.Key)
+		if victim.expired(now) {
+			continue
+		}
+		if err := t.cold.Set(victim); err != nil {
+			log.Printf("Failed to demote %v, dropping it: %v", victim.Key, err)
+		}
+	}
+}
+
+func (t *TieredStore[K, V]) Delete(key K) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if e, found := t.hot[key]; found {
+		t.lru.Remove(e)
+		delete(t.hot, key)
+		t.index.Delete(key)
+		return nil
+	}
+	return t.cold.Delete(key)
+}
+
+// Scan merges the two tiers. Given a from key, items are visited in key
+// order; otherwise the memory tier is visited first.
+func (t *TieredStore[K, V]) Scan(from *K, fn func(Item[K, V]) bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if from == nil {
+		for e := t.lru.Front(); e != nil; e = e.Next() {
+			if !fn(e.Value.(Item[K, V])) {
+				return nil
+			}
+		}
+		return t.cold.Scan(nil, fn)
+	}
+
+	var coldErr error
+	next, stop := iter.Pull(func(yield func(Item[K, V]) bool) {
+		coldErr = t.cold.Scan(from, yield)
+	})
+	defer stop()
+
+	pending, more := next()
+	done := false
+	t.index.AscendGreaterOrEqual(*from, func(key K) bool {
+		for more && pending.Key < key {
+			if !fn(pending) {
+				done = true
+				return false
+			}
+			pending, more = next()
+		}
+		if !fn(t.hot[key].Value.(Item[K, V])) {
+			done = true
+			return false
+		}
+		return true
+	})
+	for !done && more {
+		if !fn(pending) {
+			break
+		}
+		pending, more = next()
+	}
+	stop() // Ends the cold scan so coldErr is set
+	return coldErr
+}
+
+// ScanExpired implements ExpiryScanner, using the cold store's expiry index
+// if it has one.
+func (t *TieredStore[K, V]) ScanExpired(now int64, fn func(Item[K, V]) bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for e := t.lru.Front(); e != nil; e = e.Next() {
+		if item := e.Value.(Item[K, V]); item.expired(now) && !fn(item) {
+			return nil
+		}
+	}
+	if scanner, ok := t.cold.(ExpiryScanner[K, V]); ok {
+		return scanner.ScanExpired(now, fn)
+	}
+	return t.cold.Scan(nil, func(item Item[K, V]) bool {
+		return !item.expired(now) || fn(item)
+	})
+}
+
+func (t *TieredStore[K, V]) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.hot) + t.cold.Len()
+}
+
+// Stats reports each tier separately, with the share of lookups it served.
+func (t *TieredStore[K, V]) Stats() StoreStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rate := func(hits uint64) float64 {
+		if t.lookups == 0 {
+			return 0
+		}
+		return float64(hits) / float64(t.lookups)
+	}
+	cold := t.cold.Stats()
+	return StoreStats{
+		Engine: "tiered",
+		Items:  len(t.hot) + cold.Items,
+		Size:   cold.Size,
+		Tiers: []TierStats{
+			{Engine: "memory", Items: len(t.hot), Hits: t.hotHits, HitRate: rate(t.hotHits)},
+			{Engine: cold.Engine, Items: cold.Items, Size: cold.Size, Hits: t.coldHits, HitRate: rate(t.coldHits)},
+		},
+	}
+}
+
+// Close demotes every unexpired item to the cold tier, so a persistent cold
+// store keeps them across restarts, and then closes it.
+func (t *TieredStore[K, V]) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	for e := t.lru.Back(); e != nil; e = e.Prev() {
+		if item := e.Value.(Item[K, V]); !item.expired(now) {
+			if err := t.cold.Set(item); err != nil {
+				log.Printf("Failed to demote %v on close: %v", item.Key, err)
+			}
+		}
+	}
+	clear(t.hot)
+	t.lru.Init()
+	t.index.Clear(false)
+	return t.cold.Close()
+}
@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTieredStore(t *testing.T) {
+	cold := NewOrderedMemoryStore[string, interface{}]()
+	store := NewTieredStore[string, interface{}](cold, 2)
+	c, err := NewCacheWithStore(store)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+
+	c.Set("a", "1", 0)
+	c.Set("b", "2", 0)
+	c.Set("c", "3", 0)
+	c.Set("d", "4", 0)
+	stats := c.Stats()
+	if stats.Items != 4 || stats.Tiers[0].Items != 2 || stats.Tiers[1].Items != 2 {
+		t.Fatalf("Expected 2 items in each tier, got %+v", stats)
+	}
+	if _, found := cold.Get("a"); !found {
+		t.Error("Expected the least recently used item to be demoted")
+	}
+
+	// Reading a cold item promotes it and demotes the coldest hot one
+	if v, _ := c.Get("a"); v != "1" {
+		t.Errorf("Expected a from the cold tier, got %v", v)
+	}
+	if _, found := cold.Get("a"); found {
+		t.Error("Expected a to be promoted out of the cold tier")
+	}
+	if _, found := cold.Get("c"); !found {
+		t.Error("Expected c to be demoted to make room")
+	}
+	c.Get("a")
+	c.Get("missing")
+
+	stats = c.Stats()
+	if stats.Tiers[0].Hits != 1 || stats.Tiers[1].Hits != 1 || stats.Tiers[0].HitRate != 1.0/3 {
+		t.Errorf("Expected one hit per tier out of 3 lookups, got %+v", stats.Tiers)
+	}
+
+	// Keys are listed in order across both tiers
+	keys, _ := c.Scan("", "", 10)
+	if fmt.Sprint(keys) != "[a b c d]" {
+		t.Errorf("Expected keys from both tiers in order, got %v", keys)
+	}
+	keys, next := c.Scan("b", "", 1)
+	if fmt.Sprint(keys) != "[c]" || next != "c" {
+		t.Errorf("Expected the page after b, got %v next %q", keys, next)
+	}
+
+	// Writes don't count as lookups
+	c.IncrBy("hits", "node", 1, 0)
+	c.IncrBy("hits", "node", 1, 0)
+	if after := c.Stats(); after.Tiers[0].Hits != 1 || after.Tiers[0].HitRate != 1.0/3 {
+		t.Errorf("Expected increments not to count as lookups, got %+v", after.Tiers)
+	}
+
+	// Expired items are dropped rather than demoted
+	c.Set("short", "x", 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	c.Set("e", "5", 0)
+	c.Set("f", "6", 0)
+	if _, found := cold.Get("short"); found {
+		t.Error("Expected an expired item not to be demoted")
+	}
+
+	// Closing moves the memory tier down so nothing is lost
+	c.Delete("b")
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if cold.Len() != 6 {
+		t.Errorf("Expected all 6 live items in the cold store after close, got %d", cold.Len())
+	}
+}
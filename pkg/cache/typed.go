@@ -0,0 +1,260 @@
+package cache
+
+import (
+	"cmp"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Item is a single entry in a TypedCache.
+type Item[K comparable, V any] struct {
+	Key         K
+	Value       V
+	Expiration  int64    // Unix nanoseconds; zero means the item never expires
+	Stale       int64    // Unix nanoseconds after which the value should be refreshed; zero means never
+	ContentType string   // Media type of the value, if known
+	Version     uint64   // Changes on every write; used as CAS token and ETag
+	Modified    int64    // Time of the last write in Unix nanoseconds
+	Flags       uint32   // Opaque client flags, as used by memcached clients
+	Tags        []string // Groups the item can be invalidated with
+}
+
+func (item Item[K, V]) expired(now int64) bool {
+	return item.Expiration != 0 && item.Expiration <= now
+}
+
+// IsStale reports whether item has passed its soft TTL at now, in Unix
+// nanoseconds. A stale item is still served until it expires.
+func (item Item[K, V]) IsStale(now int64) bool {
+	return item.Stale != 0 && item.Stale <= now
+}
+
+// expiresAt converts a duration into an Expiration. A duration of zero or less
+// means the item never expires.
+func expiresAt(now time.Time, duration time.Duration) int64 {
+	if duration <= 0 {
+		return 0
+	}
+	return now.Add(duration).UnixNano()
+}
+
+// staleAt converts a soft TTL into a Stale time. A soft TTL that is zero or
+// less, or that would not end before the item expires, is ignored.
+func staleAt(now time.Time, softTTL time.Duration, expiration int64) int64 {
+	stale := expiresAt(now, softTTL)
+	if expiration != 0 && stale >= expiration {
+		return 0
+	}
+	return stale
+}
+
+// SetOptions carries optional per-item metadata for SetWithOptions.
+type SetOptions struct {
+	ContentType string
+	Version     uint64 // Version assigned by the originating node, for replicated writes
+	Flags       uint32
+	Tags        []string
+	SoftTTL     time.Duration // Time until the item goes stale; zero means it never does
+}
+
+// TypedCache is a type-safe cache. Embedding Go services can use it directly
+// to avoid type assertions on every Get. Items are kept in memory unless the
+// cache is created with another Store.
+type TypedCache[K comparable, V any] struct {
+	items Store[K, V]
+	mu    sync.RWMutex
+	clock uint64 // Highest version issued or accepted
+
+	watchers    map[*Watcher[K, V]]struct{}
+	seq         uint64        // Sequence number of the last event
+	history     []Event[K, V] // Ring of recent events for WatchFrom
+	historyHead int           // Index of the oldest event once history is full
+
+	maxItems int // Zero means unlimited
+
+	tags map[string]map[K]struct{} // Keys carrying each tag
+
+	journal Journal[K, V]
+}
+
+func NewTypedCache[K comparable, V any]() *TypedCache[K, V] {
+	return &TypedCache[K, V]{
+		items: NewMemoryStore[K, V](),
+	}
+}
+
+// NewOrderedTypedCache creates a TypedCache that also keeps its keys in an
+// ordered index, so they can be listed page by page. Maintaining the index
+// adds a small cost to writes that create or remove a key.
+func NewOrderedTypedCache[K cmp.Ordered, V any]() *TypedCache[K, V] {
+	return &TypedCache[K, V]{
+		items: NewOrderedMemoryStore[K, V](),
+	}
+}
+
+// NewTypedCacheWithStore creates a TypedCache backed by store, which may
+// already hold items. Their tags are indexed and the version clock starts
+// after the highest version found.
+func NewTypedCacheWithStore[K comparable, V any](store Store[K, V]) (*TypedCache[K, V], error) {
+	c := &TypedCache[K, V]{items: store}
+	err := store.Scan(nil, func(item Item[K, V]) bool {
+		c.tag(item.Key, item.Tags)
+		if item.Version > c.clock {
+			c.clock = item.Version
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// store writes item, evicting another item if a new key would exceed the
+// item limit, and keeps the tag index in sync. Callers must hold c.mu.
+func (c *TypedCache[K, V]) store(item Item[K, V]) {
+	old, exists := c.peek(item.Key)
+	if !exists && c.maxItems > 0 && c.items.Len() >= c.maxItems {
+		c.evictOne()
+	}
+	if err := c.items.Set(item); err != nil {
+		log.Printf("Failed to store %v: %v", item.Key, err)
+		return
+	}
+	if !slices.Equal(old.Tags, item.Tags) {
+		c.untag(item.Key, old.Tags)
+		c.tag(item.Key, item.Tags)
+	}
+	if c.journal != nil {
+		c.journal.Stored(item)
+	}
+}
+
+// remove deletes key and keeps the tag index in sync. Callers must hold c.mu.
+func (c *TypedCache[K, V]) remove(key K) {
+	old, exists := c.peek(key)
+	if !exists {
+		return
+	}
+	if err := c.items.Delete(key); err != nil {
+		log.Printf("Failed to delete %v: %v", key, err)
+		return
+	}
+	c.untag(key, old.Tags)
+	if c.journal != nil {
+		c.journal.Removed(key)
+	}
+}
+
+// peek looks key up without it counting as a read of the store.
+func (c *TypedCache[K, V]) peek(key K) (Item[K, V], bool) {
+	if p, ok := c.items.(Peeker[K, V]); ok {
+		return p.Peek(key)
+	}
+	return c.items.Get(key)
+}
+
+// Stats describes the cache's store.
+func (c *TypedCache[K, V]) Stats() StoreStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.items.Stats()
+}
+
+// Close closes the cache's store. The cache must not be used afterwards.
+func (c *TypedCache[K, V]) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.items.Close()
+}
+
+func (c *TypedCache[K, V]) Set(key K, value V, duration time.Duration) {
+	c.SetWithOptions(key, value, duration, SetOptions{})
+}
+
+// SetWithOptions stores value under key along with the metadata in opts.
+func (c *TypedCache[K, V]) SetWithOptions(key K, value V, duration time.Duration, opts SetOptions) {
+	c.SetIf(key, value, duration, opts, Precondition{})
+}
+
+func (c *TypedCache[K, V]) Get(key K) (V, bool) {
+	item, found := c.GetItem(key)
+	return item.Value, found
+}
+
+// GetItem returns the full item stored under key, including its metadata.
+func (c *TypedCache[K, V]) GetItem(key K) (Item[K, V], bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	item, found := c.items.Get(key)
+	if !found || item.expired(time.Now().UnixNano()) {
+		return Item[K, V]{}, false
+	}
+	log.Printf("Cache item found: Key=%v, Value=%v, Expiration=%d", item.Key, item.Value, item.Expiration)
+
+	return item, true
+}
+
+func (c *TypedCache[K, V]) Delete(key K) {
+	c.DeleteIf(key, Precondition{})
+}
+
+// GetEncoded returns the value stored under key serialized with codec, ready
+// to be written to the network.
+func (c *TypedCache[K, V]) GetEncoded(key K, codec Codec[V]) ([]byte, bool, error) {
+	value, found := c.Get(key)
+	if !found {
+		return nil, false, nil
+	}
+	data, err := codec.Marshal(value)
+	if err != nil {
+		return nil, true, err
+	}
+	return data, true, nil
+}
+
+// SetEncoded decodes data with codec and stores the result under key.
+func (c *TypedCache[K, V]) SetEncoded(key K, data []byte, duration time.Duration, codec Codec[V]) error {
+	value, err := codec.Unmarshal(data)
+	if err != nil {
+		return err
+	}
+	c.Set(key, value, duration)
+	return nil
+}
+
+// Expire changes when key expires without touching its value or version. A
+// duration of zero or less removes the expiration. A soft TTL that would no
+// longer end first is dropped. It reports whether the key exists.
+func (c *TypedCache[K, V]) Expire(key K, duration time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	item, found := c.peek(key)
+	if !found || item.expired(now.UnixNano()) {
+		return false
+	}
+	item.Expiration = expiresAt(now, duration)
+	if item.Expiration != 0 && item.Stale >= item.Expiration {
+		item.Stale = 0
+	}
+	c.store(item)
+	return true
+}
+
+// TTL returns how long key has left to live. The duration is zero for items
+// that never expire.
+func (c *TypedCache[K, V]) TTL(key K) (time.Duration, bool) {
+	item, found := c.GetItem(key)
+	if !found {
+		return 0, false
+	}
+	if item.Expiration == 0 {
+		return 0, true
+	}
+	return time.Until(time.Unix(0, item.Expiration)), true
+}
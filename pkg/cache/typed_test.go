@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+type user struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+func TestTypedCacheSetGet(t *testing.T) {
+	c := NewTypedCache[int, user]()
+	c.Set(1, user{Name: "alice", Age: 30}, 2*time.Second)
+
+	u, found := c.Get(1)
+	if !found || u.Name != "alice" || u.Age != 30 {
+		t.Errorf("Expected to find alice, got %+v, found: %v", u, found)
+	}
+
+	c.Delete(1)
+	if u, found := c.Get(1); found || u != (user{}) {
+		t.Errorf("Expected zero value after delete, got %+v, found: %v", u, found)
+	}
+}
+
+func TestTypedCacheCodecRoundTrip(t *testing.T) {
+	c := NewTypedCache[string, user]()
+	codec := JSONCodec[user]{}
+
+	if err := c.SetEncoded("bob", []byte(`{"name":"bob","age":41}`), 2*time.Second, codec); err != nil {
+		t.Fatalf("Failed to set encoded value: %v", err)
+	}
+
+	u, found := c.Get("bob")
+	if !found || u.Age != 41 {
+		t.Errorf("Expected bob aged 41, got %+v, found: %v", u, found)
+	}
+
+	data, found, err := c.GetEncoded("bob", codec)
+	if err != nil || !found || string(data) != `{"name":"bob","age":41}` {
+		t.Errorf("Unexpected encoded value %s, found: %v, err: %v", data, found, err)
+	}
+
+	if err := c.SetEncoded("bad", []byte("not json"), time.Second, codec); err == nil {
+		t.Errorf("Expected error decoding invalid JSON")
+	}
+}
+
+func TestValueCodec(t *testing.T) {
+	codec := ValueCodec{}
+	for _, tc := range []struct {
+		value interface{}
+		want  string
+	}{
+		{"hello", "hello"},
+		{[]byte("raw"), "raw"},
+		{42, "42"},
+	} {
+		data, err := codec.Marshal(tc.value)
+		if err != nil || string(data) != tc.want {
+			t.Errorf("Marshal(%v) = %q, %v; want %q", tc.value, data, err, tc.want)
+		}
+	}
+}
+
+func TestTypedCacheSetWithOptions(t *testing.T) {
+	c := NewTypedCache[string, []byte]()
+	c.SetWithOptions("img", []byte{0x89, 'P', 'N', 'G'}, 2*time.Second, SetOptions{ContentType: "image/png"})
+
+	item, found := c.GetItem("img")
+	if !found || item.ContentType != "image/png" || len(item.Value) != 4 {
+		t.Errorf("Expected image/png item, got %+v, found: %v", item, found)
+	}
+
+	c.Set("img", []byte("plain"), 2*time.Second)
+	if item, _ := c.GetItem("img"); item.ContentType != "" {
+		t.Errorf("Expected Set to clear the content type, got %q", item.ContentType)
+	}
+}
@@ -0,0 +1,159 @@
+package cache
+
+import "errors"
+
+// EventType identifies the kind of change an Event describes.
+type EventType int
+
+const (
+	EventSet    EventType = iota + 1
+	EventDelete           // Removed by a client
+	EventExpire           // Removed by the janitor after its TTL ran out
+	EventEvict            // Removed to stay within the item limit
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventSet:
+		return "set"
+	case EventDelete:
+		return "delete"
+	case EventExpire:
+		return "expire"
+	case EventEvict:
+		return "evict"
+	default:
+		return "unknown"
+	}
+}
+
+// EventHistory is how many recent events a cache keeps so that watchers can
+// resume with WatchFrom after reconnecting.
+const EventHistory = 1024
+
+// ErrEventsGone is returned by WatchFrom when events after the requested
+// sequence number are no longer in the history, or when the number is ahead
+// of this cache's, as after a restart. The watcher must resync.
+var ErrEventsGone = errors.New("cache: requested events are no longer available")
+
+// Event describes one change to a TypedCache.
+type Event[K comparable, V any] struct {
+	Seq  uint64 // Increases by one with every event of this cache
+	Type EventType
+	Key  K
+	Item Item[K, V] // The stored item for EventSet, the removed one otherwise
+}
+
+// Watcher receives every change made to a TypedCache, whether the write was
+// made locally or replicated from another node. Events are delivered on C.
+// A watcher that falls more than its buffer behind is dropped: C is closed
+// and Lagged reports true.
+type Watcher[K comparable, V any] struct {
+	C <-chan Event[K, V]
+
+	c      chan Event[K, V]
+	cache  *TypedCache[K, V]
+	lagged bool
+	closed bool
+}
+
+// Watch registers a new Watcher whose channel holds up to buffer events.
+func (c *TypedCache[K, V]) Watch(buffer int) *Watcher[K, V] {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.addWatcher(buffer, nil)
+}
+
+// WatchFrom is Watch for a watcher resuming after event since: it first
+// receives the events after since that are still in the history.
+func (c *TypedCache[K, V]) WatchFrom(since uint64, buffer int) (*Watcher[K, V], error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if since > c.seq {
+		return nil, ErrEventsGone
+	}
+	missed := int(c.seq - since)
+	if missed > len(c.history) {
+		return nil, ErrEventsGone
+	}
+	replay := make([]Event[K, V], missed)
+	for i := range replay {
+		replay[i] = c.historyAt(len(c.history) - missed + i)
+	}
+	return c.addWatcher(buffer, replay), nil
+}
+
+// historyAt returns the i-th oldest event still in the history. Callers must
+// hold c.mu.
+func (c *TypedCache[K, V]) historyAt(i int) Event[K, V] {
+	return c.history[(c.historyHead+i)%len(c.history)]
+}
+
+// addWatcher must be called with c.mu held for writing.
+func (c *TypedCache[K, V]) addWatcher(buffer int, replay []Event[K, V]) *Watcher[K, V] {
+	ch := make(chan Event[K, V], buffer+len(replay))
+	for _, ev := range replay {
+		ch <- ev
+	}
+	w := &Watcher[K, V]{C: ch, c: ch, cache: c}
+	if c.watchers == nil {
+		c.watchers = make(map[*Watcher[K, V]]struct{})
+	}
+	c.watchers[w] = struct{}{}
+	return w
+}
+
+// Close unregisters w and closes its channel.
+func (w *Watcher[K, V]) Close() {
+	w.cache.mu.Lock()
+	defer w.cache.mu.Unlock()
+	w.close()
+}
+
+// Lagged reports whether w was dropped for not keeping up.
+func (w *Watcher[K, V]) Lagged() bool {
+	w.cache.mu.RLock()
+	defer w.cache.mu.RUnlock()
+	return w.lagged
+}
+
+// close must be called with the cache lock held.
+func (w *Watcher[K, V]) close() {
+	if w.closed {
+		return
+	}
+	w.closed = true
+	delete(w.cache.watchers, w)
+	close(w.c)
+}
+
+// LastSeq returns the sequence number of the most recent event.
+func (c *TypedCache[K, V]) LastSeq() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.seq
+}
+
+// publish numbers ev, records it in the history and delivers it to every
+// watcher without blocking. Callers must hold c.mu for writing.
+func (c *TypedCache[K, V]) publish(ev Event[K, V]) {
+	c.seq++
+	ev.Seq = c.seq
+	if len(c.history) < EventHistory {
+		c.history = append(c.history, ev)
+	} else {
+		// Full: overwrite the oldest event
+		c.history[c.historyHead] = ev
+		c.historyHead = (c.historyHead + 1) % EventHistory
+	}
+
+	for w := range c.watchers {
+		select {
+		case w.c <- ev:
+		default:
+			w.lagged = true
+			w.close()
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWatchReceivesChanges(t *testing.T) {
+	c := NewCache()
+	w := c.Watch(10)
+	defer w.Close()
+
+	c.Set("key1", "value1", time.Minute)
+	c.Incr("hits", 1, 0)
+	c.Delete("key1")
+	c.Delete("missing")
+
+	want := []struct {
+		typ EventType
+		key string
+	}{
+		{EventSet, "key1"},
+		{EventSet, "hits"},
+		{EventDelete, "key1"},
+	}
+	for _, e := range want {
+		select {
+		case ev := <-w.C:
+			if ev.Type != e.typ || ev.Key != e.key {
+				t.Errorf("Expected %s %s, got %s %s", e.typ, e.key, ev.Type, ev.Key)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for %s %s", e.typ, e.key)
+		}
+	}
+	select {
+	case ev := <-w.C:
+		t.Errorf("Unexpected event %s %s", ev.Type, ev.Key)
+	default:
+	}
+}
+
+func TestWatchDropsLaggingWatcher(t *testing.T) {
+	c := NewCache()
+	w := c.Watch(1)
+
+	c.Set("a", "1", time.Minute)
+	c.Set("b", "2", time.Minute)
+
+	<-w.C
+	if _, ok := <-w.C; ok {
+		t.Errorf("Expected channel of lagging watcher to be closed")
+	}
+	if !w.Lagged() {
+		t.Errorf("Expected watcher to report that it lagged")
+	}
+	w.Close() // Closing twice is safe
+}
+
+func TestWatchExpireAndEvict(t *testing.T) {
+	c := NewCache()
+	w := c.Watch(10)
+	defer w.Close()
+
+	c.Set("short", "x", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if n := c.DeleteExpired(); n != 1 {
+		t.Errorf("Expected 1 expired item to be removed, got %d", n)
+	}
+
+	c.SetMaxItems(2)
+	c.Set("a", "1", time.Minute)
+	time.Sleep(time.Millisecond)
+	c.Set("b", "2", time.Minute)
+	c.Set("c", "3", time.Minute)
+
+	var got []string
+	for len(got) < 6 {
+		select {
+		case ev := <-w.C:
+			got = append(got, ev.Type.String()+" "+ev.Key)
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out after events %v", got)
+		}
+	}
+	want := "[set short expire short set a set b evict a set c]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("Expected %s, got %v", want, got)
+	}
+	if _, found := c.Get("a"); found {
+		t.Errorf("Expected the least recently written key to be evicted")
+	}
+}
+
+func TestWatchFromResumes(t *testing.T) {
+	c := NewCache()
+	c.Set("a", "1", time.Minute)
+	c.Set("b", "2", time.Minute)
+	c.Delete("a")
+	if seq := c.LastSeq(); seq != 3 {
+		t.Fatalf("Expected last sequence number 3, got %d", seq)
+	}
+
+	w, err := c.WatchFrom(1, 10)
+	if err != nil {
+		t.Fatalf("WatchFrom failed: %v", err)
+	}
+	defer w.Close()
+	c.Set("c", "3", time.Minute)
+
+	for _, want := range []uint64{2, 3, 4} {
+		ev := <-w.C
+		if ev.Seq != want {
+			t.Errorf("Expected event %d, got %d (%s %s)", want, ev.Seq, ev.Type, ev.Key)
+		}
+	}
+
+	for i := 0; i < EventHistory; i++ {
+		c.Set("churn", i, time.Minute)
+	}
+	if _, err := c.WatchFrom(1, 10); err != ErrEventsGone {
+		t.Errorf("Expected ErrEventsGone for a sequence number out of the history, got %v", err)
+	}
+	// An id from before a restart is ahead of the new sequence numbers
+	if _, err := c.WatchFrom(c.LastSeq()+1, 10); err != ErrEventsGone {
+		t.Errorf("Expected ErrEventsGone for a sequence number ahead of the cache, got %v", err)
+	}
+	w2, err := c.WatchFrom(c.LastSeq()-EventHistory, 0)
+	if err != nil {
+		t.Fatalf("Expected the full history to be replayable, got %v", err)
+	}
+	if ev := <-w2.C; ev.Seq != c.LastSeq()-EventHistory+1 {
+		t.Errorf("Expected replay to start with the oldest event, got %d", ev.Seq)
+	}
+	w2.Close()
+}
@@ -0,0 +1,447 @@
+// Package client is a Go client for a Disperse cluster. It sends each
+// request straight to the node that owns the key, and can keep a near cache:
+// a small local copy of recently read values in front of the cluster. The
+// near cache subscribes to the change events of the nodes owning its keys
+// and drops a value as soon as it changes anywhere in the cluster, so
+// repeated reads don't cross the network but don't stay stale either.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/notlelouch/Distributed-Cache/pkg/cache"
+	"github.com/notlelouch/Distributed-Cache/pkg/distributed"
+)
+
+// ErrNotFound is returned by Get for a key the cluster doesn't have.
+var ErrNotFound = errors.New("client: key not found")
+
+// errLagged ends an event stream the node gave up on because it fell behind.
+var errLagged = errors.New("event stream fell behind")
+
+// Defaults for Options fields left at zero.
+const (
+	DefaultNearTTL         = 30 * time.Second
+	DefaultMembersInterval = 10 * time.Second
+)
+
+// reconnectDelay is how long a broken event stream waits before
+// reconnecting.
+var reconnectDelay = time.Second
+
+// Options tunes a Client.
+type Options struct {
+	// NearCacheSize is how many values are kept locally. Zero disables the
+	// near cache, so every read goes to the cluster.
+	NearCacheSize int
+	// NearTTL is the longest a value is served locally. It bounds how stale
+	// a value can get even if its invalidation is lost.
+	NearTTL time.Duration
+	// Prefix limits the near cache to keys under it. The event streams are
+	// filtered by it too, so other keys cost nothing.
+	Prefix string
+	// MembersInterval is how often the member list is refreshed.
+	MembersInterval time.Duration
+	HTTPClient      *http.Client // Defaults to http.DefaultClient
+}
+
+// Client talks to a Disperse cluster over its HTTP API. It is safe for
+// concurrent use.
+type Client struct {
+	seed   string // Base URL of the node the member list is read from
+	opts   Options
+	near   *cache.TypedCache[string, []byte] // Nil without a near cache
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	stopJanitor func()
+
+	mu       sync.Mutex
+	members  []distributed.Member
+	streams  map[string]*stream // Event subscriptions by node name
+	fills    map[string]uint64  // Reads whose value may still be kept locally, by key
+	nextFill uint64
+}
+
+// stream is the subscription to one node's change events.
+type stream struct {
+	member distributed.Member
+	// Set while the subscription is up, so the node's keys may be kept
+	// locally
+	ready  atomic.Bool
+	cancel context.CancelFunc
+}
+
+// New creates a client for the cluster the node at seed, an HTTP base URL
+// such as http://127.0.0.1:8000, belongs to.
+func New(seed string, opts Options) (*Client, error) {
+	if opts.NearTTL <= 0 {
+		opts.NearTTL = DefaultNearTTL
+	}
+	if opts.MembersInterval <= 0 {
+		opts.MembersInterval = DefaultMembersInterval
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = http.DefaultClient
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Client{
+		seed:    strings.TrimSuffix(seed, "/"),
+		opts:    opts,
+		ctx:     ctx,
+		cancel:  cancel,
+		streams: make(map[string]*stream),
+		fills:   make(map[string]uint64),
+	}
+	if err := c.refreshMembers(); err != nil {
+		cancel()
+		return nil, err
+	}
+	if opts.NearCacheSize > 0 {
+		c.near = cache.NewTypedCache[string, []byte]()
+		c.near.SetMaxItems(opts.NearCacheSize)
+		c.stopJanitor = c.near.StartJanitor(opts.NearTTL)
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(opts.MembersInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := c.refreshMembers(); err != nil {
+					log.Printf("Failed to refresh cluster members: %v", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return c, nil
+}
+
+// Close stops the event subscriptions and drops the near cache.
+func (c *Client) Close() error {
+	c.cancel()
+	c.wg.Wait()
+	if c.near != nil {
+		c.stopJanitor()
+		return c.near.Close()
+	}
+	return nil
+}
+
+// Get returns the value stored under key, from the near cache if it holds
+// it.
+func (c *Client) Get(ctx context.Context, key string) ([]byte, error) {
+	if c.near != nil {
+		if value, found := c.near.Get(key); found {
+			return value, nil
+		}
+	}
+	owner, err := c.owner(key)
+	if err != nil {
+		return nil, err
+	}
+	token, keep := c.beginFill(key, owner)
+	value, err := c.do(ctx, http.MethodGet, owner, key, nil, nil)
+	if keep {
+		c.endFill(key, token, owner, value, err == nil)
+	}
+	return value, err
+}
+
+// Set stores value under key for ttl; zero means it never expires.
+func (c *Client) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	owner, err := c.owner(key)
+	if err != nil {
+		return err
+	}
+	header := http.Header{distributed.TTLHeader: {ttl.String()}}
+	_, err = c.do(ctx, http.MethodPut, owner, key, value, header)
+	// The change event would drop it too, but the caller should read its
+	// own write right away
+	c.invalidate(key)
+	return err
+}
+
+// Delete removes key from the cluster. Deleting a missing key is not an
+// error.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	owner, err := c.owner(key)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(ctx, http.MethodDelete, owner, key, nil, nil)
+	c.invalidate(key)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// owner returns the node that owns key.
+func (c *Client) owner(key string) (distributed.Member, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	owner, ok := distributed.OwnerOf(key, c.members)
+	if !ok {
+		return owner, errors.New("client: no cluster members known")
+	}
+	return owner, nil
+}
+
+// do sends a request for key to member and returns the response body.
+func (c *Client) do(ctx context.Context, method string, member distributed.Member, key string, body []byte, header http.Header) ([]byte, error) {
+	uri := fmt.Sprintf("http://%s:%d/cache/%s", member.Addr, member.HTTPPort, url.PathEscape(key))
+	req, err := http.NewRequestWithContext(ctx, method, uri, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, ErrNotFound
+	case resp.StatusCode >= 300:
+		return nil, fmt.Errorf("client: %s %s answered %s: %s", method, key, resp.Status, data)
+	}
+	return data, nil
+}
+
+// ####################################### Near cache #######################################
+
+// beginFill records a read of key from owner whose value may be kept
+// locally. It reports false if the value must not be kept, because there is
+// no near cache, key is outside the prefix, or owner's events aren't
+// subscribed to yet.
+func (c *Client) beginFill(key string, owner distributed.Member) (uint64, bool) {
+	if c.near == nil || !strings.HasPrefix(key, c.opts.Prefix) {
+		return 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.subscribe(owner)
+	if !s.ready.Load() {
+		return 0, false
+	}
+	c.nextFill++
+	c.fills[key] = c.nextFill
+	return c.nextFill, true
+}
+
+// endFill keeps value locally unless key changed while it was being read,
+// in which case the value may already be stale.
+func (c *Client) endFill(key string, token uint64, owner distributed.Member, value []byte, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fills[key] != token {
+		return
+	}
+	delete(c.fills, key)
+	if ok {
+		// Tagged with the owner, so its values can be dropped together
+		c.near.SetWithOptions(key, value, c.opts.NearTTL, cache.SetOptions{Tags: []string{owner.Name}})
+	}
+}
+
+// invalidate drops the local copy of key and any read of it in flight.
+func (c *Client) invalidate(key string) {
+	if c.near == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.fills, key)
+	c.near.Delete(key)
+}
+
+// invalidateNode drops every value owned by the named node, and every read
+// in flight, for when its changes may have been missed. Callers must hold
+// c.mu.
+func (c *Client) invalidateNode(name string) {
+	if c.near == nil {
+		return
+	}
+	for key := range c.fills {
+		delete(c.fills, key)
+	}
+	c.near.InvalidateTag(name)
+}
+
+// refreshMembers reads the member list from the seed node. When it changes,
+// keys may have moved to other owners, so the whole near cache is dropped.
+func (c *Client) refreshMembers() error {
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, c.seed+"/cache/members", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("client: listing members answered %s", resp.Status)
+	}
+	// addr holds the memberlist port too
+	var listed []distributed.Member
+	if err := json.NewDecoder(resp.Body).Decode(&listed); err != nil {
+		return err
+	}
+	members := make([]distributed.Member, 0, len(listed))
+	for _, m := range listed {
+		if host, _, err := net.SplitHostPort(m.Addr); err == nil {
+			m.Addr = host
+		}
+		members = append(members, m)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if sameMembers(c.members, members) {
+		return nil
+	}
+	for _, m := range c.members {
+		c.invalidateNode(m.Name)
+	}
+	current := make(map[string]distributed.Member, len(members))
+	for _, m := range members {
+		current[m.Name] = m
+	}
+	for name, s := range c.streams {
+		if m, ok := current[name]; !ok || m != s.member {
+			s.cancel()
+			delete(c.streams, name)
+		}
+	}
+	c.members = members
+	return nil
+}
+
+func sameMembers(a, b []distributed.Member) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// subscribe returns the subscription to member's change events, starting
+// it if needed. Callers must hold c.mu.
+func (c *Client) subscribe(member distributed.Member) *stream {
+	if s, ok := c.streams[member.Name]; ok {
+		return s
+	}
+	ctx, cancel := context.WithCancel(c.ctx)
+	s := &stream{member: member, cancel: cancel}
+	c.streams[member.Name] = s
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		for {
+			err := c.follow(ctx, s)
+			// Changes may be missed until the stream is back, so nothing
+			// the node owns can be trusted locally
+			s.ready.Store(false)
+			c.mu.Lock()
+			c.invalidateNode(member.Name)
+			c.mu.Unlock()
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Event stream from %s ended, reconnecting: %v", member.Name, err)
+			select {
+			case <-time.After(reconnectDelay):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// follow reads s's event stream, dropping the local copy of every key that
+// changes, until the stream ends.
+func (c *Client) follow(ctx context.Context, s *stream) error {
+	uri := fmt.Sprintf("http://%s:%d/cache/_events?prefix=%s", s.member.Addr, s.member.HTTPPort, url.QueryEscape(c.opts.Prefix))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("subscribing answered %s", resp.Status)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	var event, data string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, ": connected"):
+			// The node registers its watcher before saying so, so every
+			// change from here on is seen
+			s.ready.Store(true)
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "":
+			if event == "lagged" {
+				return errLagged
+			}
+			if data != "" {
+				var ev distributed.ChangeEvent
+				if err := json.Unmarshal([]byte(data), &ev); err == nil {
+					c.invalidate(ev.Key)
+				}
+			}
+			event, data = "", ""
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
@@ -0,0 +1,162 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/notlelouch/Distributed-Cache/pkg/cache"
+	"github.com/notlelouch/Distributed-Cache/pkg/distributed"
+)
+
+func startNode(t *testing.T, name string, memberlistPort int) *distributed.DistributedCache {
+	t.Helper()
+
+	httpPort := memberlistPort + 1000
+	dc, err := distributed.NewDistributedCache(memberlistPort, httpPort, name)
+	if err != nil {
+		t.Fatalf("Failed to create %s: %v", name, err)
+	}
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	dc.RegisterRoutes(app)
+	go app.Listen(fmt.Sprintf("127.0.0.1:%d", httpPort))
+	t.Cleanup(func() {
+		app.Shutdown()
+		dc.List.Shutdown()
+	})
+
+	// Wait for the listener to come up
+	for i := 0; i < 50; i++ {
+		if conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", httpPort)); err == nil {
+			conn.Close()
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return dc
+}
+
+// countingTransport counts the key reads that reach the cluster.
+type countingTransport struct {
+	reads atomic.Int32
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodGet && strings.HasPrefix(req.URL.Path, "/cache/") &&
+		req.URL.Path != "/cache/_events" && req.URL.Path != "/cache/members" {
+		t.reads.Add(1)
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// eventually retries check until it passes or a few seconds go by.
+func eventually(t *testing.T, what string, check func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if check() {
+			return
+		}
+	}
+	t.Fatalf("Timed out waiting for %s", what)
+}
+
+func TestNearCacheInvalidation(t *testing.T) {
+	// Let the nodes notice the closed event streams quickly on shutdown
+	distributed.EventKeepAlive = 50 * time.Millisecond
+	dc1 := startNode(t, "client1", 7904)
+	dc2 := startNode(t, "client2", 7905)
+	if err := dc2.JoinCluster("127.0.0.1:7904"); err != nil {
+		t.Fatalf("Failed to join cluster: %v", err)
+	}
+
+	transport := &countingTransport{}
+	c, err := New("http://127.0.0.1:8904", Options{
+		NearCacheSize: 100,
+		Prefix:        "user:",
+		HTTPClient:    &http.Client{Transport: transport},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	if err := c.Set(ctx, "user:1", []byte("alice"), time.Minute); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	// Reads are kept locally once the owner's events are subscribed to
+	eventually(t, "a read served locally", func() bool {
+		before := transport.reads.Load()
+		value, err := c.Get(ctx, "user:1")
+		return err == nil && string(value) == "alice" && transport.reads.Load() == before
+	})
+
+	// A write through either node reaches the near cache as an invalidation
+	for i, dc := range []*distributed.DistributedCache{dc1, dc2} {
+		want := fmt.Sprintf("bob%d", i)
+		if _, err := dc.Set("user:1", want, time.Minute, cache.SetOptions{}, cache.Precondition{}); err != nil {
+			t.Fatalf("Set on node failed: %v", err)
+		}
+		eventually(t, "the new value", func() bool {
+			value, err := c.Get(ctx, "user:1")
+			return err == nil && string(value) == want
+		})
+	}
+
+	if err := c.Delete(ctx, "user:1"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := c.Get(ctx, "user:1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete = %v, want ErrNotFound", err)
+	}
+
+	// Keys outside the prefix always go to the cluster
+	if err := c.Set(ctx, "session:1", []byte("x"), time.Minute); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		before := transport.reads.Load()
+		if _, err := c.Get(ctx, "session:1"); err != nil || transport.reads.Load() == before {
+			t.Errorf("Get outside the prefix = %v, served locally: %v", err, transport.reads.Load() == before)
+		}
+	}
+}
+
+func TestNearCacheDropsRacingRead(t *testing.T) {
+	c := &Client{
+		near:  cache.NewTypedCache[string, []byte](),
+		fills: make(map[string]uint64),
+	}
+	owner := distributed.Member{Name: "node"}
+
+	// The key changes while its old value is being read
+	c.nextFill++
+	c.fills["k"] = c.nextFill
+	c.invalidate("k")
+	c.endFill("k", c.nextFill, owner, []byte("old"), true)
+	if _, found := c.near.Get("k"); found {
+		t.Errorf("A value read before an invalidation was kept")
+	}
+
+	c.nextFill++
+	c.fills["k"] = c.nextFill
+	c.endFill("k", c.nextFill, owner, []byte("new"), true)
+	if value, found := c.near.Get("k"); !found || string(value) != "new" {
+		t.Errorf("Near cache = %q, %v, want the value read", value, found)
+	}
+
+	// Losing the owner's event stream drops its values
+	c.mu.Lock()
+	c.invalidateNode("node")
+	c.mu.Unlock()
+	if _, found := c.near.Get("k"); found {
+		t.Errorf("A value survived its owner's stream ending")
+	}
+}
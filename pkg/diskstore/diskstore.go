@@ -0,0 +1,217 @@
+// Package diskstore is a disk-backed cache.Store for datasets bigger than
+// RAM. It keeps items in a bbolt B+tree file; only the pages being read are
+// held in memory, by the OS page cache.
+package diskstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/notlelouch/Distributed-Cache/pkg/cache"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Options configures a Store.
+type Options struct {
+	// NoSync skips the fsync after each write. Writes are much faster, but a
+	// machine crash can lose recent writes or corrupt the file.
+	NoSync bool
+}
+
+var ErrEmptyKey = errors.New("diskstore: empty key")
+
+// The items bucket maps each key to its expiration, as 8 big-endian bytes,
+// followed by the item encoded with cache.MarshalItem. The expiry bucket
+// indexes items that expire by [8 big-endian expiration][key], so expired
+// items are found without reading the others.
+var (
+	itemsBucket  = []byte("items")
+	expiryBucket = []byte("expiry")
+)
+
+const expirationSize = 8
+
+// Store is a cache.Store kept in a single file. Keys are visited in order.
+type Store struct {
+	db    *bolt.DB
+	count atomic.Int64
+}
+
+var (
+	_ cache.Store[string, interface{}]         = (*Store)(nil)
+	_ cache.ExpiryScanner[string, interface{}] = (*Store)(nil)
+)
+
+// Open opens the store file at path, creating it if needed. Items already in
+// the file, including expired ones, are kept.
+func Open(path string, opts Options) (*Store, error) {
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: time.Second, NoSync: opts.NoSync})
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{db: db}
+	err = db.Update(func(tx *bolt.Tx) error {
+		items, err := tx.CreateBucketIfNotExists(itemsBucket)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(expiryBucket); err != nil {
+			return err
+		}
+		s.count.Store(int64(items.Stats().KeyN))
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) Get(key string) (cache.CacheItem, bool) {
+	var item cache.CacheItem
+	found := false
+	s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(itemsBucket).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		var err error
+		item, err = decode(data)
+		found = err == nil
+		return err
+	})
+	return item, found
+}
+
+func (s *Store) Set(item cache.CacheItem) error {
+	if item.Key == "" {
+		return ErrEmptyKey
+	}
+	data, err := cache.MarshalItem(item)
+	if err != nil {
+		return err
+	}
+	value := make([]byte, expirationSize, expirationSize+len(data))
+	binary.BigEndian.PutUint64(value, uint64(item.Expiration))
+	value = append(value, data...)
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		items, expiry := tx.Bucket(itemsBucket), tx.Bucket(expiryBucket)
+		key := []byte(item.Key)
+		old := items.Get(key)
+		if old != nil {
+			if err := expiry.Delete(expiryKey(old, key)); err != nil {
+				return err
+			}
+		}
+		if err := items.Put(key, value); err != nil {
+			return err
+		}
+		if item.Expiration != 0 {
+			if err := expiry.Put(expiryKey(value, key), nil); err != nil {
+				return err
+			}
+		}
+		if old == nil {
+			s.count.Add(1)
+		}
+		return nil
+	})
+}
+
+func (s *Store) Delete(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		items := tx.Bucket(itemsBucket)
+		k := []byte(key)
+		old := items.Get(k)
+		if old == nil {
+			return nil
+		}
+		if err := tx.Bucket(expiryBucket).Delete(expiryKey(old, k)); err != nil {
+			return err
+		}
+		if err := items.Delete(k); err != nil {
+			return err
+		}
+		s.count.Add(-1)
+		return nil
+	})
+}
+
+// expiryKey builds the expiry index key of a stored value.
+func expiryKey(value, key []byte) []byte {
+	return append(append(make([]byte, 0, expirationSize+len(key)), value[:expirationSize]...), key...)
+}
+
+func decode(value []byte) (cache.CacheItem, error) {
+	if len(value) < expirationSize {
+		return cache.CacheItem{}, errors.New("diskstore: truncated value")
+	}
+	return cache.UnmarshalItem(value[expirationSize:])
+}
+
+// Scan visits items in key order, starting at from if given.
+func (s *Store) Scan(from *string, fn func(cache.CacheItem) bool) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(itemsBucket).Cursor()
+		var k, v []byte
+		if from != nil {
+			k, v = c.Seek([]byte(*from))
+		} else {
+			k, v = c.First()
+		}
+		for ; k != nil; k, v = c.Next() {
+			item, err := decode(v)
+			if err != nil {
+				return err
+			}
+			if !fn(item) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// ScanExpired implements cache.ExpiryScanner.
+func (s *Store) ScanExpired(now int64, fn func(cache.CacheItem) bool) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		items := tx.Bucket(itemsBucket)
+		c := tx.Bucket(expiryBucket).Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if int64(binary.BigEndian.Uint64(k)) > now {
+				return nil
+			}
+			v := items.Get(k[expirationSize:])
+			if v == nil {
+				continue
+			}
+			item, err := decode(v)
+			if err != nil {
+				return err
+			}
+			if !fn(item) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Store) Len() int { return int(s.count.Load()) }
+
+func (s *Store) Stats() cache.StoreStats {
+	stats := cache.StoreStats{Engine: "disk", Items: s.Len()}
+	s.db.View(func(tx *bolt.Tx) error {
+		stats.Size = tx.Size()
+		return nil
+	})
+	return stats
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
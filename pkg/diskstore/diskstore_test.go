@@ -0,0 +1,151 @@
+package diskstore
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/notlelouch/Distributed-Cache/pkg/cache"
+)
+
+func openCache(t *testing.T, path string) *cache.Cache {
+	t.Helper()
+	store, err := Open(path, Options{NoSync: true})
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", path, err)
+	}
+	c, err := cache.NewCacheWithStore(store)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	return c
+}
+
+func TestDiskStoreCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	c := openCache(t, path)
+
+	item, _ := c.SetIf("greeting", "hello", time.Hour, cache.SetOptions{Tags: []string{"t"}}, cache.Precondition{})
+	c.Set("gone", "x", 0)
+	c.Delete("gone")
+	c.Incr("hits", 3, 0)
+	c.Apply("list", cache.Op{Name: "rpush", Args: []string{"a", "b"}}, 0, cache.OpOrigin{})
+	for i := 0; i < 5; i++ {
+		c.Set(fmt.Sprintf("user:%d", i), "x", 0)
+	}
+
+	got, found := c.GetItem("greeting")
+	if !found || got.Value != "hello" || got.Version != item.Version || got.Expiration != item.Expiration {
+		t.Errorf("Expected greeting to round trip through the store, got %+v", got)
+	}
+	if _, found := c.Get("gone"); found {
+		t.Error("Expected gone to be deleted")
+	}
+	keys, next := c.Scan("", "user:", 3)
+	if fmt.Sprint(keys) != "[user:0 user:1 user:2]" || next != "user:2" {
+		t.Errorf("Expected the first page of users, got %v next %q", keys, next)
+	}
+	if stats := c.Stats(); stats.Engine != "disk" || stats.Items != 8 || stats.Size == 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	// Items, tags and versions survive a restart
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	c = openCache(t, path)
+	defer c.Close()
+
+	if v, _ := c.Get("greeting"); v != "hello" {
+		t.Errorf("Expected greeting after reopening, got %v", v)
+	}
+	if n, _ := c.Incr("hits", 1, 0); n != 4 {
+		t.Errorf("Expected counter 4, got %d", n)
+	}
+	if l, _ := c.LRange("list", 0, -1); fmt.Sprint(l) != "[a b]" {
+		t.Errorf("Expected list to survive, got %v", l)
+	}
+	if tagged := c.Tagged("t"); len(tagged) != 1 {
+		t.Errorf("Expected the tag index to be rebuilt, got %v", tagged)
+	}
+	next2, _ := c.SetIf("greeting", "again", 0, cache.SetOptions{}, cache.Precondition{})
+	if next2.Version <= item.Version {
+		t.Errorf("Expected version after %d, got %d", item.Version, next2.Version)
+	}
+	if n := c.InvalidateTag("t"); n != 0 {
+		t.Errorf("Expected the overwrite to drop the tag, invalidated %d", n)
+	}
+}
+
+func TestDiskStoreExpiry(t *testing.T) {
+	c := openCache(t, filepath.Join(t.TempDir(), "cache.db"))
+	defer c.Close()
+
+	c.Set("short", "x", 10*time.Millisecond)
+	c.Set("long", "x", time.Hour)
+	c.Set("forever", "x", 0)
+	c.Set("extended", "x", 10*time.Millisecond)
+	c.Expire("extended", time.Hour)
+	time.Sleep(20 * time.Millisecond)
+
+	if _, found := c.Get("short"); found {
+		t.Error("Expected short to be hidden once expired")
+	}
+	if n := c.DeleteExpired(); n != 1 {
+		t.Errorf("Expected 1 expired item, got %d", n)
+	}
+	if stats := c.Stats(); stats.Items != 3 {
+		t.Errorf("Expected 3 items left, got %d", stats.Items)
+	}
+}
+
+func TestDiskStoreEviction(t *testing.T) {
+	c := openCache(t, filepath.Join(t.TempDir(), "cache.db"))
+	defer c.Close()
+
+	c.SetMaxItems(3)
+	for i := 0; i < 10; i++ {
+		c.Set(fmt.Sprintf("k%d", i), "x", 0)
+	}
+	if stats := c.Stats(); stats.Items != 3 {
+		t.Errorf("Expected 3 items, got %d", stats.Items)
+	}
+	if _, found := c.Get("k9"); !found {
+		t.Error("Expected the newest key to be kept")
+	}
+}
+
+func TestTieredStoreOnDisk(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	open := func() (*cache.Cache, *cache.TieredStore[string, interface{}]) {
+		disk, err := Open(path, Options{NoSync: true})
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", path, err)
+		}
+		tiered := cache.NewTieredStore[string, interface{}](disk, 2)
+		c, err := cache.NewCacheWithStore(tiered)
+		if err != nil {
+			t.Fatalf("Failed to create cache: %v", err)
+		}
+		return c, tiered
+	}
+
+	c, _ := open()
+	for i := 0; i < 5; i++ {
+		c.Set(fmt.Sprintf("k%d", i), fmt.Sprint(i), 0)
+	}
+	c.Close()
+
+	c, tiered := open()
+	defer c.Close()
+	if stats := tiered.Stats(); stats.Items != 5 || stats.Tiers[0].Items != 0 || stats.Tiers[1].Engine != "disk" {
+		t.Errorf("Expected every item on disk after a restart, got %+v", stats)
+	}
+	if v, _ := c.Get("k4"); v != "4" {
+		t.Errorf("Expected k4 after a restart, got %v", v)
+	}
+	if stats := tiered.Stats(); stats.Tiers[0].Items != 1 || stats.Tiers[1].Hits != 1 {
+		t.Errorf("Expected k4 to be promoted on read, got %+v", stats)
+	}
+}
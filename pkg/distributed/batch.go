@@ -0,0 +1,375 @@
+package distributed
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/notlelouch/Distributed-Cache/pkg/cache"
+)
+
+// BatchRequest is the body of the _mget, _mput and _mdelete endpoints.
+type BatchRequest struct {
+	Keys  []string    `json:"keys,omitempty"`  // _mget and _mdelete
+	Items []BatchItem `json:"items,omitempty"` // _mput
+}
+
+// BatchItem is one write of a _mput request.
+type BatchItem struct {
+	Key         string   `json:"key"`
+	Value       string   `json:"value"`
+	TTL         string   `json:"ttl,omitempty"`      // Seconds or a Go duration; empty never expires
+	IfMatch     uint64   `json:"if_match,omitempty"` // Only write over this version
+	IfNoneMatch bool     `json:"if_none_match,omitempty"`
+	Version     uint64   `json:"version,omitempty"` // Set by the origin on replicated batches
+	Tags        []string `json:"tags,omitempty"`
+}
+
+// BatchResult is the outcome for one key of a batch. Status is the HTTP
+// status the single-key endpoint would have answered with.
+type BatchResult struct {
+	Key         string `json:"key"`
+	Status      int    `json:"status"`
+	Value       string `json:"value,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Version     uint64 `json:"version,omitempty"`
+	Error       string `json:"error,omitempty"`
+	Negative    bool   `json:"negative,omitempty"` // The origin recently reported the key missing
+}
+
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+// HandleMGet reads many keys at once. Results are in request order.
+func (dc *DistributedCache) HandleMGet(c *fiber.Ctx) error {
+	req, err := parseBatch(c)
+	if err != nil {
+		return err
+	}
+
+	results := dc.runBatch(req.Keys, c.Get("X-Is-Sync") == "true", "/cache/_mget",
+		func(idx []int) BatchRequest { return BatchRequest{Keys: pick(req.Keys, idx)} },
+		func(i int) BatchResult { return dc.getResult(req.Keys[i]) },
+	)
+	return c.JSON(BatchResponse{Results: results})
+}
+
+// HandleMPut writes many keys at once. Each item may carry its own TTL and
+// precondition; one failing item does not stop the others.
+func (dc *DistributedCache) HandleMPut(c *fiber.Ctx) error {
+	req, err := parseBatch(c)
+	if err != nil {
+		return err
+	}
+	isSync := c.Get("X-Is-Sync") == "true"
+
+	keys := make([]string, len(req.Items))
+	for i, item := range req.Items {
+		keys[i] = item.Key
+	}
+	results := dc.runBatch(keys, isSync, "/cache/_mput",
+		func(idx []int) BatchRequest { return BatchRequest{Items: pick(req.Items, idx)} },
+		func(i int) BatchResult { return dc.putResult(req.Items[i]) },
+	)
+	if !isSync {
+		dc.forwardPuts(req.Items, results)
+	}
+
+	if !isSync && !dc.Sharded {
+		var written []BatchItem
+		var payloads []SyncPayload
+		for i, result := range results {
+			if result.Status != fiber.StatusOK {
+				continue
+			}
+			item := req.Items[i]
+			// The TTL was validated when the item was stored
+			var duration time.Duration
+			if item.TTL != "" {
+				duration, _ = ParseTTL(item.TTL)
+			}
+			written = append(written, BatchItem{Key: item.Key, Value: item.Value, TTL: item.TTL, Tags: item.Tags, Version: result.Version})
+			payloads = append(payloads, SyncPayload{
+				Method:   fiber.MethodPut,
+				Key:      item.Key,
+				Value:    item.Value,
+				Duration: strconv.FormatInt(int64(duration), 10),
+				IsSync:   true,
+				Version:  result.Version,
+				Tags:     item.Tags,
+			})
+		}
+		dc.replicateBatch("/cache/_mput", BatchRequest{Items: written}, payloads)
+	}
+	return c.JSON(BatchResponse{Results: results})
+}
+
+// HandleMDelete deletes many keys at once.
+func (dc *DistributedCache) HandleMDelete(c *fiber.Ctx) error {
+	req, err := parseBatch(c)
+	if err != nil {
+		return err
+	}
+	isSync := c.Get("X-Is-Sync") == "true"
+
+	results := dc.deleteBatch(req.Keys, isSync)
+	if !isSync {
+		writes := make([]OriginWrite, len(req.Keys))
+		for i, key := range req.Keys {
+			writes[i] = OriginWrite{Key: key, Delete: true}
+		}
+		if err := dc.forwardWrites(writes); err != nil {
+			for i := range results {
+				results[i].Status, results[i].Error = fiber.StatusBadGateway, err.Error()
+			}
+		}
+	}
+	return c.JSON(BatchResponse{Results: results})
+}
+
+// deleteBatch deletes keys wherever they live and, unless isSync, replicates
+// the deletes.
+func (dc *DistributedCache) deleteBatch(keys []string, isSync bool) []BatchResult {
+	results := dc.runBatch(keys, isSync, "/cache/_mdelete",
+		func(idx []int) BatchRequest { return BatchRequest{Keys: pick(keys, idx)} },
+		func(i int) BatchResult {
+			dc.Cache.Delete(keys[i])
+			return BatchResult{Key: keys[i], Status: fiber.StatusOK}
+		},
+	)
+
+	if !isSync && !dc.Sharded {
+		payloads := make([]SyncPayload, len(keys))
+		for i, key := range keys {
+			payloads[i] = SyncPayload{Method: fiber.MethodDelete, Key: key, IsSync: true}
+		}
+		dc.replicateBatch("/cache/_mdelete", BatchRequest{Keys: keys}, payloads)
+	}
+	return results
+}
+
+// forwardPuts sends the stored items of a _mput to the origin as one batch.
+// If a write-through batch fails, its keys are deleted again and their
+// results marked as failed.
+func (dc *DistributedCache) forwardPuts(items []BatchItem, results []BatchResult) {
+	var writes []OriginWrite
+	var idx []int
+	for i, result := range results {
+		if result.Status == fiber.StatusOK {
+			writes = append(writes, OriginWrite{Key: items[i].Key, Value: []byte(items[i].Value), ContentType: fiber.MIMETextPlainCharsetUTF8})
+			idx = append(idx, i)
+		}
+	}
+	if err := dc.forwardWrites(writes); err != nil {
+		keys := make([]string, len(idx))
+		for n, i := range idx {
+			keys[n] = items[i].Key
+			results[i].Status, results[i].Error, results[i].Version = fiber.StatusBadGateway, err.Error(), 0
+		}
+		dc.deleteBatch(keys, false)
+	}
+}
+
+func parseBatch(c *fiber.Ctx) (BatchRequest, error) {
+	var req BatchRequest
+	// Unmarshal copies every string, so nothing aliases fiber's buffers
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return req, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid JSON format",
+		})
+	}
+	return req, nil
+}
+
+func pick[T any](all []T, idx []int) []T {
+	out := make([]T, len(idx))
+	for i, j := range idx {
+		out[i] = all[j]
+	}
+	return out
+}
+
+// runBatch groups keys by owning node and runs the sub-batches in parallel:
+// local ones through handle, remote ones by posting sub(idx) to path on the
+// owner. Unless the cluster is sharded every key is handled locally.
+func (dc *DistributedCache) runBatch(keys []string, isSync bool, path string, sub func(idx []int) BatchRequest, handle func(i int) BatchResult) []BatchResult {
+	results := make([]BatchResult, len(keys))
+
+	groups := make(map[Member][]int)
+	var local []int
+	members := dc.members()
+	for i, key := range keys {
+		owner, ok := OwnerOf(key, members)
+		if !dc.Sharded || isSync || !ok || dc.isLocal(owner) {
+			local = append(local, i)
+			continue
+		}
+		groups[owner] = append(groups[owner], i)
+	}
+
+	var wg sync.WaitGroup
+	for owner, idx := range groups {
+		wg.Add(1)
+		go func(owner Member, idx []int) {
+			defer wg.Done()
+			var resp BatchResponse
+			err := dc.postSync(owner, path, sub(idx), &resp)
+			if err == nil && len(resp.Results) != len(idx) {
+				err = fmt.Errorf("expected %d results, got %d", len(idx), len(resp.Results))
+			}
+			for n, i := range idx {
+				if err != nil {
+					results[i] = BatchResult{Key: keys[i], Status: fiber.StatusBadGateway, Error: err.Error()}
+				} else {
+					results[i] = resp.Results[n]
+				}
+			}
+		}(owner, idx)
+	}
+	for _, i := range local {
+		results[i] = handle(i)
+	}
+	wg.Wait()
+	return results
+}
+
+func (dc *DistributedCache) getResult(key string) BatchResult {
+	item, found, err := dc.Fetch(context.Background(), key)
+	if errors.Is(err, ErrKnownMissing) {
+		return BatchResult{Key: key, Status: fiber.StatusNotFound, Negative: true}
+	}
+	if err != nil {
+		return BatchResult{Key: key, Status: fiber.StatusBadGateway, Error: "Failed to load from origin"}
+	}
+	if !found {
+		return BatchResult{Key: key, Status: fiber.StatusNotFound}
+	}
+	data, err := dc.Codec.Marshal(item.Value)
+	if err != nil {
+		return BatchResult{Key: key, Status: fiber.StatusInternalServerError, Error: "Failed to encode value"}
+	}
+	return BatchResult{
+		Key:         key,
+		Status:      fiber.StatusOK,
+		Value:       string(data),
+		ContentType: item.ContentType,
+		Version:     item.Version,
+	}
+}
+
+// putResult applies one item of a _mput on this node only.
+func (dc *DistributedCache) putResult(item BatchItem) BatchResult {
+	result := BatchResult{Key: item.Key}
+	if item.Key == "" {
+		result.Status, result.Error = fiber.StatusBadRequest, "Missing key"
+		return result
+	}
+
+	var duration time.Duration
+	if item.TTL != "" {
+		var err error
+		if duration, err = ParseTTL(item.TTL); err != nil {
+			result.Status, result.Error = fiber.StatusBadRequest, "Invalid TTL"
+			return result
+		}
+	}
+	cond := cache.Precondition{IfNoneMatch: item.IfNoneMatch}
+	if item.IfMatch != 0 {
+		cond.IfMatch = []uint64{item.IfMatch}
+	}
+
+	stored, err := dc.store(item.Key, item.Value, duration, cache.SetOptions{Version: item.Version, Tags: item.Tags}, cond)
+	if err == cache.ErrPreconditionFailed {
+		result.Status, result.Error = fiber.StatusPreconditionFailed, "Precondition failed"
+		return result
+	}
+	result.Status, result.Version = fiber.StatusOK, stored.Version
+	return result
+}
+
+// replicateBatch sends a whole batch of writes to every peer in one go: as a
+// single pipelined burst over the binary transport, or as one sync request
+// to path for peers reached over HTTP.
+func (dc *DistributedCache) replicateBatch(path string, req BatchRequest, payloads []SyncPayload) {
+	if len(payloads) == 0 {
+		return
+	}
+	bodies := make([][]byte, len(payloads))
+	for i, payload := range payloads {
+		bodies[i] = encodeSyncPayload(payload)
+	}
+	pool := dc.pool()
+
+	var wg sync.WaitGroup
+	for _, member := range dc.peers() {
+		wg.Add(1)
+		go func(member Member) {
+			defer wg.Done()
+			if pool != nil && member.ReplicationPort != 0 {
+				ctx, cancel := context.WithTimeout(context.Background(), ReplicationTimeout)
+				defer cancel()
+				addr := net.JoinHostPort(member.Addr, strconv.Itoa(member.ReplicationPort))
+				_, errs := pool.CallBatch(ctx, addr, bodies)
+				for _, err := range errs {
+					if err != nil {
+						log.Printf("Failed to sync batch with %s over binary transport: %v", member.Name, err)
+						return
+					}
+				}
+				return
+			}
+			var resp BatchResponse
+			if err := dc.postSync(member, path, req, &resp); err != nil {
+				log.Printf("Failed to sync batch with %s: %v", member.Name, err)
+			}
+		}(member)
+	}
+	wg.Wait()
+}
+
+// postSync posts body as JSON to path on member, marked as a sync request so
+// the member handles it locally, and decodes the JSON response into out.
+func (dc *DistributedCache) postSync(member Member, path string, body interface{}, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return dc.syncRequest(member, fiber.MethodPost, path, data, out)
+}
+
+// syncRequest sends a sync request to path on member and decodes the JSON
+// response into out.
+func (dc *DistributedCache) syncRequest(member Member, method, path string, body []byte, out interface{}) error {
+	// Bytes() hands the agent back to the pool
+	agent := fiber.AcquireAgent()
+	req := agent.Request()
+	req.Header.SetMethod(method)
+	req.Header.Set("X-Is-Sync", "true")
+	req.SetRequestURI(fmt.Sprintf("http://%s:%d%s", member.Addr, member.HTTPPort, path))
+	if body != nil {
+		req.Header.SetContentType(fiber.MIMEApplicationJSON)
+		req.SetBody(body)
+	}
+	agent.Timeout(ReplicationTimeout)
+	if err := agent.Parse(); err != nil {
+		fiber.ReleaseAgent(agent)
+		return err
+	}
+
+	status, resp, errs := agent.Bytes()
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	if status != fiber.StatusOK {
+		return fmt.Errorf("%s answered with status %d", member.Name, status)
+	}
+	return json.Unmarshal(resp, out)
+}
@@ -0,0 +1,144 @@
+package distributed
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func postBatch(t *testing.T, httpPort int, endpoint string, req BatchRequest) []BatchResult {
+	t.Helper()
+
+	body, _ := json.Marshal(req)
+	resp, err := http.Post(fmt.Sprintf("http://127.0.0.1:%d/cache/%s", httpPort, endpoint), "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to post %s: %v", endpoint, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 from %s, got %d: %s", endpoint, resp.StatusCode, data)
+	}
+
+	var out BatchResponse
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("Invalid %s response %s: %v", endpoint, data, err)
+	}
+	return out.Results
+}
+
+func TestBatchEndpointsReplicated(t *testing.T) {
+	dc1 := startTestNode(t, "batch1", 7967, 8967)
+	dc2 := startTestNode(t, "batch2", 7968, 8968)
+	if err := dc2.JoinCluster("127.0.0.1:7967"); err != nil {
+		t.Fatalf("Failed to join cluster: %v", err)
+	}
+	dc1.Cache.Set("taken", "already", time.Minute)
+
+	results := postBatch(t, 8967, "_mput", BatchRequest{Items: []BatchItem{
+		{Key: "a", Value: "1", TTL: "60"},
+		{Key: "b", Value: "2"},
+		{Key: "taken", Value: "3", IfNoneMatch: true},
+		{Key: "c", Value: "4", TTL: "soon"},
+	}})
+	wantStatus := []int{200, 200, 412, 400}
+	for i, result := range results {
+		if result.Status != wantStatus[i] {
+			t.Errorf("Expected %s to end with %d, got %+v", result.Key, wantStatus[i], result)
+		}
+	}
+
+	// The whole batch reaches the peer, with the versions of the origin
+	replica, found := dc2.Cache.GetItem("a")
+	if !found || replica.Value != "1" || replica.Version != results[0].Version || replica.Expiration == 0 {
+		t.Errorf("Expected a to replicate with its version and TTL, got %+v", replica)
+	}
+	if _, found := dc2.Cache.Get("taken"); found {
+		t.Errorf("Expected the failed write not to be replicated")
+	}
+
+	results = postBatch(t, 8968, "_mget", BatchRequest{Keys: []string{"b", "missing", "a"}})
+	if results[0].Value != "2" || results[1].Status != 404 || results[2].Value != "1" {
+		t.Errorf("Unexpected _mget results: %+v", results)
+	}
+
+	postBatch(t, 8967, "_mdelete", BatchRequest{Keys: []string{"a", "b"}})
+	if _, found := dc2.Cache.Get("a"); found {
+		t.Errorf("Expected delete to replicate")
+	}
+}
+
+func TestBatchEndpointsSharded(t *testing.T) {
+	nodes := []*DistributedCache{
+		startTestNode(t, "shard1", 7964, 8964),
+		startTestNode(t, "shard2", 7965, 8965),
+		startTestNode(t, "shard3", 7966, 8966),
+	}
+	for _, dc := range nodes {
+		dc.Sharded = true
+	}
+	for _, dc := range nodes[1:] {
+		if err := dc.JoinCluster("127.0.0.1:7964"); err != nil {
+			t.Fatalf("Failed to join cluster: %v", err)
+		}
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	var items []BatchItem
+	var keys []string
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		items = append(items, BatchItem{Key: key, Value: fmt.Sprint(i)})
+		keys = append(keys, key)
+	}
+	for _, result := range postBatch(t, 8964, "_mput", BatchRequest{Items: items}) {
+		if result.Status != 200 {
+			t.Errorf("Expected %s to be stored, got %+v", result.Key, result)
+		}
+	}
+
+	// Every key lives on its owner only, and the keys are spread out
+	perNode := map[string]int{}
+	for _, key := range keys {
+		owner, _ := nodes[0].Owner(key)
+		for _, dc := range nodes {
+			_, found := dc.Cache.Get(key)
+			if found != (dc.Config.Name == owner.Name) {
+				t.Errorf("Expected %s only on %s, found on %s: %v", key, owner.Name, dc.Config.Name, found)
+			}
+		}
+		perNode[owner.Name]++
+	}
+	if len(perNode) != 3 {
+		t.Errorf("Expected keys on all three nodes, got %v", perNode)
+	}
+
+	results := postBatch(t, 8965, "_mget", BatchRequest{Keys: keys})
+	for i, result := range results {
+		if result.Status != 200 || result.Value != fmt.Sprint(i) {
+			t.Errorf("Expected %s to be %d, got %+v", keys[i], i, result)
+		}
+	}
+
+	// Single-key requests are routed to the owner too
+	resp, err := http.Get("http://127.0.0.1:8966/cache/key-7")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || string(body) != "7" {
+		t.Errorf("Expected routed GET to return 7, got %d %q", resp.StatusCode, body)
+	}
+
+	postBatch(t, 8966, "_mdelete", BatchRequest{Keys: keys})
+	for _, result := range postBatch(t, 8964, "_mget", BatchRequest{Keys: keys}) {
+		if result.Status != 404 {
+			t.Errorf("Expected %s to be deleted, got %+v", result.Key, result)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package distributed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/notlelouch/Distributed-Cache/pkg/cache"
+)
+
+// FillTimeout bounds how long a node waits for a key's owner to load it.
+var FillTimeout = 10 * time.Second
+
+// fetched is the outcome of loading a key.
+type fetched struct {
+	item  cache.CacheItem
+	found bool
+}
+
+// flightGroup runs at most one call per key at a time. Callers that arrive
+// while a call for their key is in flight wait for it and share its result.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+}
+
+type flight struct {
+	done   chan struct{}
+	result fetched
+	err    error
+}
+
+// do runs fn for key unless a call for key is already in flight, in which
+// case it waits for that call instead. shared reports whether the result
+// came from another caller's call.
+func (g *flightGroup) do(key string, fn func() (fetched, error)) (result fetched, err error, shared bool) {
+	f, started := g.begin(key)
+	if !started {
+		<-f.done
+		return f.result, f.err, true
+	}
+	g.run(key, f, fn)
+	return f.result, f.err, false
+}
+
+// start runs fn for key in the background unless a call for key is already
+// in flight. It reports whether it started one.
+func (g *flightGroup) start(key string, fn func() (fetched, error)) bool {
+	f, started := g.begin(key)
+	if started {
+		go g.run(key, f, fn)
+	}
+	return started
+}
+
+// begin returns the call in flight for key, or registers a new one.
+func (g *flightGroup) begin(key string) (*flight, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if f, ok := g.calls[key]; ok {
+		return f, false
+	}
+	if g.calls == nil {
+		g.calls = make(map[string]*flight)
+	}
+	f := &flight{done: make(chan struct{})}
+	g.calls[key] = f
+	return f, true
+}
+
+func (g *flightGroup) run(key string, f *flight, fn func() (fetched, error)) {
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(f.done)
+	}()
+	f.result, f.err = fn()
+}
+
+// fetch loads a missing key once across the cluster. Concurrent misses on
+// this node share one call, which asks the key's owner to load it, so the
+// owner is the only node that calls the origin. If the owner can't be
+// reached the key is loaded here instead.
+func (dc *DistributedCache) fetch(ctx context.Context, key string) (fetched, error) {
+	result, err, shared := dc.origin.fetches.do(key, func() (fetched, error) {
+		return dc.loadAtOwner(ctx, key, 0)
+	})
+	if shared {
+		log.Printf("Coalesced miss on %s", key)
+	}
+	return result, err
+}
+
+// refresh reloads the item at version of key in the background, the same
+// way as a miss, unless a load of key is already running on this node. The
+// old item is served until the new one is stored.
+func (dc *DistributedCache) refresh(key string, version uint64) {
+	dc.origin.fetches.start(key, func() (fetched, error) {
+		log.Printf("Refreshing %s", key)
+		result, err := dc.loadAtOwner(context.Background(), key, version)
+		if err != nil {
+			log.Printf("Failed to refresh %s, still serving the old value: %v", key, err)
+		}
+		return result, err
+	})
+}
+
+// loadAtOwner asks the owner of key to load it, or loads it here if this
+// node is the owner or the owner can't be reached.
+func (dc *DistributedCache) loadAtOwner(ctx context.Context, key string, replaces uint64) (fetched, error) {
+	if owner, ok := dc.Owner(key); ok && !dc.isLocal(owner) {
+		result, err := dc.fill(owner, key, replaces)
+		if err == nil || errors.Is(err, ErrKnownMissing) {
+			if !result.found {
+				dc.origin.missing(key)
+			}
+			return result, err
+		}
+		log.Printf("Failed to fill %s from %s, loading it here: %v", key, owner.Name, err)
+	}
+	return dc.load(ctx, key, replaces)
+}
+
+// load calls the origin for key and stores the result. replaces is the
+// version of the item being refreshed, or zero on a miss. Concurrent loads
+// of the same key, including fills requested by other nodes, share one call.
+func (dc *DistributedCache) load(ctx context.Context, key string, replaces uint64) (fetched, error) {
+	// Waiting callers share the result, so one client going away must not
+	// cancel it for the others
+	ctx = context.WithoutCancel(ctx)
+	result, err, _ := dc.origin.loads.do(key, func() (fetched, error) {
+		// The key may have been filled or refreshed while this call waited
+		// to start
+		if item, found := dc.Get(key); found && item.Version != replaces {
+			return fetched{item, true}, nil
+		}
+		start := time.Now()
+		loaded, err := dc.origin.Loader.Load(ctx, key)
+		dc.origin.recordLoad(time.Since(start))
+		if errors.Is(err, ErrNotFound) {
+			dc.origin.missing(key)
+			return fetched{}, nil
+		}
+		if err != nil {
+			return fetched{}, err
+		}
+		ttl := loaded.TTL
+		if ttl == 0 {
+			ttl = dc.origin.TTL
+		}
+		opts := cache.SetOptions{ContentType: loaded.ContentType, SoftTTL: loaded.SoftTTL}
+		if opts.SoftTTL == 0 {
+			opts.SoftTTL = dc.origin.SoftTTL
+		}
+		log.Printf("Loaded %s from origin (%d bytes)", key, len(loaded.Value))
+		// The value came from the origin, so it is not written back
+		item, err := dc.set(key, loaded.Value, ttl, opts, cache.Precondition{}, false)
+		return fetched{item, err == nil}, err
+	})
+	return result, err
+}
+
+// fill asks owner to load key, as groupcache's peers do. Unless the cluster
+// is sharded the owner replicates what it loads, but the item is stored here
+// too in case that write has not arrived yet. It returns ErrKnownMissing if
+// the owner answered from its negative cache.
+func (dc *DistributedCache) fill(owner Member, key string, replaces uint64) (fetched, error) {
+	agent := fiber.AcquireAgent()
+	req := agent.Request()
+	req.Header.SetMethod(fiber.MethodGet)
+	req.Header.Set("X-Is-Sync", "true")
+	req.SetRequestURI(fmt.Sprintf("http://%s:%d/cache/_fill/%s?replaces=%d", owner.Addr, owner.HTTPPort, url.PathEscape(key), replaces))
+	agent.Timeout(FillTimeout)
+	if err := agent.Parse(); err != nil {
+		fiber.ReleaseAgent(agent)
+		return fetched{}, err
+	}
+	// Keep the response for its headers
+	resp := fiber.AcquireResponse()
+	defer fiber.ReleaseResponse(resp)
+	agent.SetResponse(resp)
+
+	// Bytes() hands the agent back to the pool
+	status, body, errs := agent.Bytes()
+	switch {
+	case len(errs) > 0:
+		return fetched{}, errs[0]
+	case status == fiber.StatusNotFound && string(resp.Header.Peek(CacheStatusHeader)) == "negative":
+		return fetched{}, ErrKnownMissing
+	case status == fiber.StatusNotFound:
+		return fetched{}, nil
+	case status != fiber.StatusOK:
+		return fetched{}, fmt.Errorf("%s answered with status %d", owner.Name, status)
+	}
+	item, err := cache.UnmarshalItem(body)
+	if err != nil {
+		return fetched{}, err
+	}
+
+	if !dc.Sharded {
+		var ttl time.Duration
+		if item.Expiration != 0 {
+			if ttl = time.Until(time.Unix(0, item.Expiration)); ttl <= 0 {
+				return fetched{}, nil
+			}
+		}
+		opts := cache.SetOptions{ContentType: item.ContentType, Version: item.Version, Flags: item.Flags, Tags: item.Tags}
+		if item.Stale != 0 {
+			opts.SoftTTL = max(time.Until(time.Unix(0, item.Stale)), time.Nanosecond)
+		}
+		if _, err := dc.Cache.SetIf(key, item.Value, ttl, opts, cache.Precondition{}); err != nil {
+			log.Printf("Failed to store %s filled from %s: %v", key, owner.Name, err)
+		}
+	}
+	return fetched{item, true}, nil
+}
+
+// HandleFill loads :key on behalf of another node: GET /cache/_fill/:key.
+// With ?replaces= set to a version, an item at that version is reloaded
+// rather than returned. The item is sent in the binary persistence
+// encoding, so its expiration and version survive the trip.
+func (dc *DistributedCache) HandleFill(c *fiber.Ctx) error {
+	key := strings.Clone(c.Params("key"))
+	replaces, _ := strconv.ParseUint(c.Query("replaces"), 10, 64)
+
+	result := fetched{}
+	result.item, result.found = dc.Get(key)
+	if !result.found && dc.origin != nil && dc.origin.knownMissing(key) {
+		c.Set(CacheStatusHeader, "negative")
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	if (!result.found || result.item.Version == replaces) && dc.origin != nil && dc.origin.Loader != nil {
+		var err error
+		if result, err = dc.load(c.UserContext(), key, replaces); err != nil {
+			log.Printf("Failed to load %s from origin: %v", key, err)
+			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+				"error": "Failed to load from origin",
+			})
+		}
+	}
+	if !result.found {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	data, err := cache.MarshalItem(result.item)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMEOctetStream)
+	return c.Send(data)
+}
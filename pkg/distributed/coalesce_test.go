@@ -0,0 +1,126 @@
+package distributed
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMissesCoalesceAcrossCluster(t *testing.T) {
+	stub := &stubOrigin{values: map[string]string{"hot": "value"}, delay: 100 * time.Millisecond}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	httpPorts := []int{8978, 8979, 8981}
+	nodes := []*DistributedCache{
+		startTestNode(t, "coalesce1", 7978, 8978),
+		startTestNode(t, "coalesce2", 7979, 8979),
+		startTestNode(t, "coalesce3", 7981, 8981),
+	}
+	for _, dc := range nodes {
+		dc.SetOrigin(OriginOptions{Loader: &HTTPOrigin{BaseURL: server.URL}})
+	}
+	for _, dc := range nodes[1:] {
+		if err := dc.JoinCluster("127.0.0.1:7978"); err != nil {
+			t.Fatalf("Failed to join cluster: %v", err)
+		}
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	// Many clients miss on every node at once
+	var wg sync.WaitGroup
+	errs := make(chan error, 30)
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func(port int) {
+			defer wg.Done()
+			resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/cache/hot", port))
+			if err != nil {
+				errs <- err
+				return
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || string(body) != "value" {
+				errs <- fmt.Errorf("got %d %q", resp.StatusCode, body)
+			}
+		}(httpPorts[i%len(httpPorts)])
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Expected every client to get the value: %v", err)
+	}
+
+	if n := stub.count("GET hot"); n != 1 {
+		t.Errorf("Expected one origin load for the whole cluster, got %d", n)
+	}
+	owner, _ := nodes[0].Owner("hot")
+	for _, dc := range nodes {
+		item, found := dc.Get("hot")
+		if !found {
+			t.Errorf("Expected %s to hold the loaded key", dc.Config.Name)
+			continue
+		}
+		if dc.Config.Name == owner.Name && item.Expiration == 0 {
+			t.Error("Expected the owner to apply the origin's max-age")
+		}
+	}
+
+	// A key the origin doesn't have is a miss on every node
+	for _, port := range httpPorts {
+		resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/cache/cold", port))
+		if err != nil {
+			t.Fatalf("GET failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected 404 for a key the origin doesn't have, got %d", resp.StatusCode)
+		}
+	}
+}
+
+func TestFlightGroupSharesResult(t *testing.T) {
+	var g flightGroup
+	var calls int
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	shared := make(chan bool, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, _, s := g.do("k", func() (fetched, error) {
+				calls++
+				<-release
+				return fetched{found: true}, nil
+			})
+			if !result.found {
+				t.Error("Expected every caller to get the result")
+			}
+			shared <- s
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(shared)
+
+	if calls != 1 {
+		t.Errorf("Expected one call, got %d", calls)
+	}
+	n := 0
+	for s := range shared {
+		if s {
+			n++
+		}
+	}
+	if n != 9 {
+		t.Errorf("Expected 9 callers to share the call, got %d", n)
+	}
+}
@@ -0,0 +1,190 @@
+package distributed
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/notlelouch/Distributed-Cache/pkg/cache"
+)
+
+// CollectionRequest is the body of a collection write. Args are those of
+// the Redis command of the same name, e.g. ["field", "value"] for hset.
+type CollectionRequest struct {
+	Args []string `json:"args"`
+}
+
+// HandleCollectionWrite performs a hash, list, set or sorted-set operation
+// on :key: POST /cache/:key/:op, where :op is one of hset, hdel, lpush,
+// rpush, lpop, rpop, sadd, srem, zadd or zrem.
+func (dc *DistributedCache) HandleCollectionWrite(c *fiber.Ctx) error {
+	key := strings.Clone(c.Params("key"))
+	name := strings.ToLower(c.Params("op"))
+	if !cache.IsCollectionOp(name) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unknown operation",
+		})
+	}
+
+	// Pops may omit the body altogether
+	var req CollectionRequest
+	if body := c.Body(); len(body) > 0 && json.Unmarshal(body, &req) != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	// New collections never expire unless a TTL is given
+	var duration time.Duration
+	if ttl := c.Get(TTLHeader, c.Query("ttl")); ttl != "" {
+		var err error
+		if duration, err = ParseTTL(ttl); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid TTL",
+			})
+		}
+	}
+
+	op := cache.Op{Name: name, Args: req.Args}
+	var result cache.OpResult
+	var err error
+	if c.Get("X-Is-Sync") == "true" {
+		origin := cache.OpOrigin{Node: strings.Clone(c.Get(OpNodeHeader))}
+		origin.Seq, _ = strconv.ParseUint(c.Get(OpSeqHeader), 10, 64)
+		origin.Version, _ = strconv.ParseUint(c.Get(VersionHeader), 10, 64)
+		result, _, err = dc.Cache.Apply(key, op, duration, origin)
+	} else {
+		result, err = dc.Apply(key, op, duration)
+	}
+	if err != nil {
+		return collectionError(c, err)
+	}
+
+	response := fiber.Map{"key": key, "count": result.Count}
+	if result.Values != nil {
+		response["values"] = result.Values
+	}
+	return c.JSON(response)
+}
+
+// HandleCollectionRead reads from the collection under :key:
+//
+//	GET /cache/:key/hget?field=f
+//	GET /cache/:key/hgetall
+//	GET /cache/:key/lrange?start=0&stop=-1
+//	GET /cache/:key/smembers
+//	GET /cache/:key/sismember?member=m
+//	GET /cache/:key/zrangebyscore?min=-inf&max=+inf
+//	GET /cache/:key/zscore?member=m
+func (dc *DistributedCache) HandleCollectionRead(c *fiber.Ctx) error {
+	key := c.Params("key")
+
+	var value interface{}
+	var err error
+	switch strings.ToLower(c.Params("op")) {
+	case "hget":
+		var found bool
+		value, found, err = dc.Cache.HGet(key, c.Query("field"))
+		if err == nil && !found {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Field not found",
+			})
+		}
+	case "hgetall":
+		value, err = dc.Cache.HGetAll(key)
+	case "lrange":
+		start, startErr := strconv.Atoi(c.Query("start", "0"))
+		stop, stopErr := strconv.Atoi(c.Query("stop", "-1"))
+		if startErr != nil || stopErr != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "start and stop must be integers",
+			})
+		}
+		value, err = dc.Cache.LRange(key, start, stop)
+	case "smembers":
+		value, err = dc.Cache.SMembers(key)
+	case "sismember":
+		value, err = dc.Cache.SIsMember(key, c.Query("member"))
+	case "zrangebyscore":
+		minScore, minErr := strconv.ParseFloat(c.Query("min", "-inf"), 64)
+		maxScore, maxErr := strconv.ParseFloat(c.Query("max", "+inf"), 64)
+		if minErr != nil || maxErr != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "min and max must be numbers",
+			})
+		}
+		value, err = dc.Cache.ZRangeByScore(key, minScore, maxScore)
+	case "zscore":
+		var found bool
+		value, found, err = dc.Cache.ZScore(key, c.Query("member"))
+		if err == nil && !found {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Member not found",
+			})
+		}
+	default:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unknown operation",
+		})
+	}
+	if err != nil {
+		return collectionError(c, err)
+	}
+	return c.JSON(fiber.Map{"key": key, "value": value})
+}
+
+func collectionError(c *fiber.Ctx, err error) error {
+	status := fiber.StatusBadRequest
+	if err == cache.ErrWrongType {
+		status = fiber.StatusConflict
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
+
+// Apply performs a collection operation on this node and replicates the
+// operation itself, so peers never receive the whole collection. Each op
+// carries this node's name and a number of its own, by which peers apply it
+// exactly once, however it interleaves with ops from other nodes.
+func (dc *DistributedCache) Apply(key string, op cache.Op, duration time.Duration) (cache.OpResult, error) {
+	result, item, err := dc.Cache.Apply(key, op, duration, cache.OpOrigin{})
+	if err != nil {
+		return result, err
+	}
+
+	payload := SyncPayload{
+		Method:  fiber.MethodPost,
+		Key:     key,
+		IsSync:  true,
+		Op:      op.Name,
+		Args:    op.Args,
+		Version: item.Version,
+		OpNode:  dc.Config.Name,
+		OpSeq:   dc.nextOpSeq(),
+	}
+	if duration > 0 {
+		payload.TTL = duration.String()
+	}
+	if err := dc.broadcastToOtherNodes(payload); err != nil {
+		log.Printf("Failed to broadcast: %v", err)
+	}
+	return result, nil
+}
+
+// nextOpSeq numbers a collection op this node originates. Numbers follow
+// the clock, so a restarted node's ops are not taken for ones its peers
+// have already applied.
+func (dc *DistributedCache) nextOpSeq() uint64 {
+	now := uint64(time.Now().UnixNano())
+	for {
+		last := dc.opSeq.Load()
+		next := max(last+1, now)
+		if dc.opSeq.CompareAndSwap(last, next) {
+			return next
+		}
+	}
+}
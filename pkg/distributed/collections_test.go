@@ -0,0 +1,144 @@
+package distributed
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/notlelouch/Distributed-Cache/pkg/cache"
+)
+
+// collectionCall sends one collection request and returns the status and the
+// decoded response.
+func collectionCall(t *testing.T, method string, httpPort int, path, body string) (int, map[string]interface{}) {
+	t.Helper()
+
+	req, _ := http.NewRequest(method, fmt.Sprintf("http://127.0.0.1:%d/cache/%s", httpPort, path), strings.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	var out map[string]interface{}
+	json.Unmarshal(data, &out)
+	return resp.StatusCode, out
+}
+
+func TestCollectionEndpoints(t *testing.T) {
+	startTestNode(t, "coll1", 7924, 8924)
+	dc2 := startTestNode(t, "coll2", 7925, 8925)
+	if err := dc2.JoinCluster("127.0.0.1:7924"); err != nil {
+		t.Fatalf("Failed to join cluster: %v", err)
+	}
+
+	writes := []struct {
+		path, body string
+		want       string
+	}{
+		{"user/hset", `{"args": ["name", "alice", "age", "30"]}`, `map[count:2 key:user]`},
+		{"queue/rpush", `{"args": ["a", "b", "c"]}`, `map[count:3 key:queue]`},
+		{"queue/lpop", ``, `map[count:1 key:queue values:[a]]`},
+		{"tags/sadd", `{"args": ["go", "cache"]}`, `map[count:2 key:tags]`},
+		{"board/zadd", `{"args": ["10", "bob", "20", "alice", "5", "carol"]}`, `map[count:3 key:board]`},
+	}
+	for _, w := range writes {
+		status, out := collectionCall(t, "POST", 8924, w.path, w.body)
+		if status != http.StatusOK || fmt.Sprint(out) != w.want {
+			t.Errorf("POST %s: got %d %v, want %s", w.path, status, out, w.want)
+		}
+	}
+
+	// Every write was replayed on the second node
+	reads := []struct{ path, want string }{
+		{"user/hget?field=name", "alice"},
+		{"user/hgetall", "map[age:30 name:alice]"},
+		{"queue/lrange?start=0&stop=-1", "[b c]"},
+		{"tags/smembers", "[cache go]"},
+		{"tags/sismember?member=go", "true"},
+		{"board/zrangebyscore?min=6&max=20", "[map[member:bob score:10] map[member:alice score:20]]"},
+	}
+	for _, r := range reads {
+		status, out := collectionCall(t, "GET", 8925, r.path, "")
+		if status != http.StatusOK || fmt.Sprint(out["value"]) != r.want {
+			t.Errorf("GET %s: got %d %v, want %s", r.path, status, out, r.want)
+		}
+	}
+	item1, _ := dc2.Cache.GetItem("user")
+	if item1.Version == 0 {
+		t.Errorf("Expected replicated collection to carry a version")
+	}
+
+	errorCases := []struct {
+		method, path, body string
+		want               int
+	}{
+		{"POST", "user/lpush", `{"args": ["x"]}`, http.StatusConflict},
+		{"POST", "user/hset", `{"args": ["odd"]}`, http.StatusBadRequest},
+		{"POST", "user/flush", `{}`, http.StatusNotFound},
+		{"GET", "user/hget?field=missing", "", http.StatusNotFound},
+		{"GET", "queue/lrange?start=x", "", http.StatusBadRequest},
+	}
+	for _, e := range errorCases {
+		if status, out := collectionCall(t, e.method, 8924, e.path, e.body); status != e.want {
+			t.Errorf("%s %s: got %d %v, want %d", e.method, e.path, status, out, e.want)
+		}
+	}
+}
+
+func TestCollectionOpsReplicateOverBinaryTransport(t *testing.T) {
+	dc1, dc2 := startReplicatedPair(t, 7976)
+
+	dc1.Apply("list", cache.Op{Name: "rpush", Args: []string{"a", "b"}}, time.Minute)
+	dc1.Apply("list", cache.Op{Name: "lpush", Args: []string{"z"}}, time.Minute)
+	dc1.Apply("list", cache.Op{Name: "rpop"}, time.Minute)
+
+	local, _ := dc1.Cache.GetItem("list")
+	replica, found := dc2.Cache.GetItem("list")
+	if !found || fmt.Sprint(replica.Value) != `["z","a"]` || replica.Version != local.Version {
+		t.Errorf("Expected replica %v at version %d, got %+v", local.Value, local.Version, replica)
+	}
+	if ttl, _ := dc2.Cache.TTL("list"); ttl <= 0 {
+		t.Errorf("Expected replicated list to keep its TTL")
+	}
+}
+
+func TestConcurrentCollectionOpsConverge(t *testing.T) {
+	dc1, dc2 := startReplicatedPair(t, 7918)
+
+	// Each node adds its own members while replicated adds from the other
+	// arrive, so the set's versions interleave
+	var wg sync.WaitGroup
+	for n, dc := range []*DistributedCache{dc1, dc2} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				member := fmt.Sprintf("%d-%d", n, i)
+				if _, err := dc.Apply("set", cache.Op{Name: "sadd", Args: []string{member}}, 0); err != nil {
+					t.Errorf("sadd %s failed: %v", member, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		members1, _ := dc1.Cache.SMembers("set")
+		members2, _ := dc2.Cache.SMembers("set")
+		if len(members1) == 100 && slices.Equal(members1, members2) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected both nodes to hold all 100 members, got %d and %d", len(members1), len(members2))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,94 @@
+package distributed
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/notlelouch/Distributed-Cache/pkg/cache"
+)
+
+// formatETag renders an item version as a strong entity tag.
+func formatETag(version uint64) string {
+	return fmt.Sprintf("%q", strconv.FormatUint(version, 10))
+}
+
+// parseETags parses a comma-separated If-Match/If-None-Match list. Weak tags
+// are accepted and compared as if they were strong.
+func parseETags(header string) ([]uint64, error) {
+	var versions []uint64
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		version, err := strconv.ParseUint(strings.Trim(tag, `"`), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid entity tag %s", tag)
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// etagMatches reports whether an If-None-Match header names version.
+func etagMatches(header string, version uint64) bool {
+	if header == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	versions, err := parseETags(header)
+	if err != nil {
+		return false
+	}
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePrecondition reads If-Match, If-None-Match and If-Unmodified-Since
+// from a write request. Writes only support If-None-Match: *.
+func parsePrecondition(c *fiber.Ctx) (cache.Precondition, error) {
+	var cond cache.Precondition
+
+	if ifMatch := strings.TrimSpace(c.Get(fiber.HeaderIfMatch)); ifMatch == "*" {
+		cond.IfMatchAny = true
+	} else if ifMatch != "" {
+		versions, err := parseETags(ifMatch)
+		if err != nil {
+			return cond, err
+		}
+		cond.IfMatch = versions
+	}
+
+	if ifNoneMatch := strings.TrimSpace(c.Get(fiber.HeaderIfNoneMatch)); ifNoneMatch == "*" {
+		cond.IfNoneMatch = true
+	} else if ifNoneMatch != "" {
+		return cond, fmt.Errorf("only If-None-Match: * is supported on writes")
+	}
+
+	// If-Unmodified-Since is ignored when If-Match is present (RFC 9110 13.1.4).
+	if since := c.Get(fiber.HeaderIfUnmodifiedSince); since != "" && !cond.IfMatchAny && cond.IfMatch == nil {
+		t, err := http.ParseTime(since)
+		if err != nil {
+			return cond, fmt.Errorf("invalid If-Unmodified-Since date")
+		}
+		cond.IfUnmodifiedSince = t
+	}
+
+	return cond, nil
+}
+
+// setVersionHeaders exposes an item's version as ETag and Last-Modified.
+func setVersionHeaders(c *fiber.Ctx, item cache.CacheItem) {
+	if item.Version == 0 {
+		return
+	}
+	c.Set(fiber.HeaderETag, formatETag(item.Version))
+	c.Set(fiber.HeaderLastModified, time.Unix(0, item.Modified).UTC().Format(http.TimeFormat))
+}
@@ -0,0 +1,123 @@
+package distributed
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/notlelouch/Distributed-Cache/pkg/cache"
+)
+
+// HandleIncr atomically adds the "by" query parameter (default 1) to the
+// counter under :key and returns the new value.
+func (dc *DistributedCache) HandleIncr(c *fiber.Ctx) error {
+	return dc.handleCounter(c, 1)
+}
+
+// HandleDecr atomically subtracts the "by" query parameter (default 1) from
+// the counter under :key and returns the new value.
+func (dc *DistributedCache) HandleDecr(c *fiber.Ctx) error {
+	return dc.handleCounter(c, -1)
+}
+
+func (dc *DistributedCache) handleCounter(c *fiber.Ctx, sign int64) error {
+	key := strings.Clone(c.Params("key"))
+	isSync := c.Get("X-Is-Sync") == "true"
+
+	// New counters never expire unless a TTL is given
+	var duration time.Duration
+	if ttl := c.Get(TTLHeader, c.Query("ttl")); ttl != "" {
+		var err error
+		if duration, err = ParseTTL(ttl); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid TTL",
+			})
+		}
+	}
+
+	// Replicated counter state from a peer
+	if isSync && len(c.Body()) > 0 {
+		var state cache.PNCounter
+		if err := json.Unmarshal(c.Body(), &state); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid counter state",
+			})
+		}
+		value := dc.Cache.MergeCounter(key, &state, duration)
+		return c.JSON(fiber.Map{"key": key, "value": value})
+	}
+
+	by, err := strconv.ParseInt(c.Query("by", "1"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "by must be an integer",
+		})
+	}
+
+	var value int64
+	if isSync {
+		var counter *cache.PNCounter
+		if counter, err = dc.Cache.IncrBy(key, dc.Config.Name, sign*by, duration); err == nil {
+			value = counter.Value()
+		}
+	} else {
+		value, err = dc.Incr(key, sign*by, duration)
+	}
+	if err == cache.ErrNotCounter {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err == cache.ErrDeltaRange {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{"key": key, "value": value})
+}
+
+// Incr atomically adds delta to the counter under key. Instead of the new
+// value, the node's PN-counter state is replicated, and peers merge it with
+// their own so concurrent increments on different nodes are never lost.
+func (dc *DistributedCache) Incr(key string, delta int64, duration time.Duration) (int64, error) {
+	counter, err := dc.Cache.IncrBy(key, dc.Config.Name, delta, duration)
+	if err != nil {
+		return 0, err
+	}
+	dc.replicateCounter(key, counter, duration)
+	return counter.Value(), nil
+}
+
+// IncrUnsigned is Incr for memcached's unsigned counters, with the
+// semantics of cache.Cache.IncrUnsigned. found is false for a missing key.
+func (dc *DistributedCache) IncrUnsigned(key string, delta uint64, decr bool) (value uint64, found bool, err error) {
+	counter, found, err := dc.Cache.IncrUnsigned(key, dc.Config.Name, delta, decr)
+	if !found || err != nil {
+		return 0, found, err
+	}
+	dc.replicateCounter(key, counter, 0)
+	return uint64(counter.Value()), true, nil
+}
+
+// replicateCounter sends the node's state of the counter under key to the
+// rest of the cluster. duration only applies to peers that don't hold the
+// key yet.
+func (dc *DistributedCache) replicateCounter(key string, counter *cache.PNCounter, duration time.Duration) {
+	payload := SyncPayload{
+		Method:  fiber.MethodPost,
+		Key:     key,
+		IsSync:  true,
+		Op:      "incr",
+		Counter: counter,
+	}
+	if duration > 0 {
+		payload.TTL = duration.String()
+	}
+	if err := dc.broadcastToOtherNodes(payload); err != nil {
+		log.Printf("Failed to broadcast: %v", err)
+	}
+}
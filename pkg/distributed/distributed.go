@@ -2,24 +2,47 @@ package distributed
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/hashicorp/memberlist"
 	"github.com/notlelouch/Distributed-Cache/pkg/cache"
+	"github.com/notlelouch/Distributed-Cache/pkg/persist"
+	"github.com/notlelouch/Distributed-Cache/pkg/pubsub"
+	"github.com/notlelouch/Distributed-Cache/pkg/transport"
 )
 
+// JanitorInterval is how often each node removes expired items.
+var JanitorInterval = time.Second
+
 type DistributedCache struct {
 	Cache    *cache.Cache
+	Codec    cache.Codec[interface{}] // Serializes values sent to clients and peers
+	PubSub   *pubsub.Broker           // Local subscribers of cluster-wide channels
 	List     *memberlist.Memberlist
 	Config   *memberlist.Config
 	mu       sync.RWMutex
 	Meta     []byte
 	HTTPPort int
+	// Sharded stores every key only on its owner (see Owner) instead of on
+	// every node. It must be set the same way on all nodes before serving.
+	Sharded bool
+	// Snapshots serves the /admin/snapshots endpoints when set
+	Snapshots *persist.Snapshotter
+
+	origin *origin // Backing store, set with SetOrigin
+
+	opSeq atomic.Uint64 // Number of the last collection op this node originated
+
+	replicationServer *transport.Server
+	replicationPool   *transport.Pool
 }
 
 type Member struct {
@@ -27,6 +50,8 @@ type Member struct {
 	Addr     string `json:"addr"`
 	Port     int    `json:"port"`
 	HTTPPort int    `json:"http_port"`
+	// Binary transport port, or 0 if the node is only reachable over HTTP
+	ReplicationPort int `json:"replication_port,omitempty"`
 }
 
 var UpdatedMembersList []*memberlist.Node
@@ -37,17 +62,25 @@ var UpdatedMembersList []*memberlist.Node
 // Memberlist uses the delegate to share metadata (including HTTP port) with other nodes
 
 type cacheDelegate struct {
-	httpPort int
+	httpPort        int
+	replicationPort atomic.Int64
+	dc              *DistributedCache // Receives messages sent by other nodes
 }
 type NodeMetadata struct {
-	HTTPPort int `json:"http_port"`
+	HTTPPort        int `json:"http_port"`
+	ReplicationPort int `json:"replication_port,omitempty"`
+}
+
+func (d *cacheDelegate) setReplicationPort(port int) {
+	d.replicationPort.Store(int64(port))
 }
 
 // NodeMeta is required by the Delegate interface
 func (d *cacheDelegate) NodeMeta(limit int) []byte {
 	// Create metadata with HTTP port
 	meta := NodeMetadata{
-		HTTPPort: d.httpPort,
+		HTTPPort:        d.httpPort,
+		ReplicationPort: int(d.replicationPort.Load()),
 	}
 
 	metaBytes, _ := json.Marshal(meta)
@@ -57,15 +90,32 @@ func (d *cacheDelegate) NodeMeta(limit int) []byte {
 	return metaBytes
 }
 
+// NotifyMsg receives the messages other nodes send with SendReliable
+func (d *cacheDelegate) NotifyMsg(msg []byte) {
+	if d.dc != nil && len(msg) > 0 {
+		// memberlist reuses msg once we return
+		d.dc.handleClusterMessage(append([]byte(nil), msg...))
+	}
+}
+
 // These methods are required by the Delegate interface but we won't use them
-func (d *cacheDelegate) NotifyMsg([]byte)                           {}
 func (d *cacheDelegate) GetBroadcasts(overhead, limit int) [][]byte { return nil }
 func (d *cacheDelegate) LocalState(join bool) []byte                { return nil }
 func (d *cacheDelegate) MergeRemoteState(buf []byte, join bool)     {}
 
 func NewDistributedCache(memberlistPort int, httpPort int, node_name string) (*DistributedCache, error) {
+	return NewDistributedCacheWithStore(memberlistPort, httpPort, node_name, cache.NewOrderedMemoryStore[string, interface{}]())
+}
+
+// NewDistributedCacheWithStore is NewDistributedCache with the node's items
+// kept in store, such as a disk engine, instead of in memory. The store must
+// be ordered.
+func NewDistributedCacheWithStore(memberlistPort int, httpPort int, node_name string, store cache.Store[string, interface{}]) (*DistributedCache, error) {
 	// Initialize the local cache
-	cacheInstance := cache.NewCache()
+	cacheInstance, err := cache.NewCacheWithStore(store)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store: %v", err)
+	}
 	config := memberlist.DefaultLocalConfig()
 	config.Name = node_name
 	config.BindAddr = "127.0.0.1"
@@ -91,26 +141,33 @@ func NewDistributedCache(memberlistPort int, httpPort int, node_name string) (*D
 		return nil, fmt.Errorf("failed to marshal metadata: %v", err)
 	}
 
+	// Create the DistributedCache instance
+	dc := &DistributedCache{
+		Cache:    cacheInstance,
+		Codec:    cache.ValueCodec{},
+		PubSub:   pubsub.NewBroker(),
+		Config:   config,
+		HTTPPort: httpPort,
+		Meta:     metaBytes,
+	}
+
 	// Create and set the delegate
 	delegate := &cacheDelegate{
 		httpPort: httpPort,
+		dc:       dc,
 	}
 	config.Delegate = delegate
+	config.Events = peerEvents{dc: dc}
 
 	// Create a memberlist instance
 	list, err := memberlist.Create(config)
 	if err != nil {
 		return nil, err
 	}
+	dc.List = list
 
-	// Create the DistributedCache instance
-	dc := &DistributedCache{
-		Cache:    cacheInstance,
-		List:     list,
-		Config:   config,
-		HTTPPort: httpPort,
-		Meta:     metaBytes,
-	}
+	// Remove expired items in the background so watchers see them expire
+	cacheInstance.StartJanitor(JanitorInterval)
 
 	return dc, nil
 }
@@ -123,9 +180,13 @@ func NewDistributedCacheWithConfig(config *memberlist.Config) (*DistributedCache
 	if err != nil {
 		return nil, err
 	}
+	// Remove expired items in the background so watchers see them expire
+	cacheInstance.StartJanitor(JanitorInterval)
 	// Create the DistributedCache instance
 	dc := &DistributedCache{
 		Cache:  cacheInstance,
+		Codec:  cache.ValueCodec{},
+		PubSub: pubsub.NewBroker(),
 		List:   list,
 		Config: config,
 	}
@@ -133,6 +194,30 @@ func NewDistributedCacheWithConfig(config *memberlist.Config) (*DistributedCache
 	return dc, nil
 }
 
+// RegisterRoutes mounts the cache HTTP API on app.
+func (dc *DistributedCache) RegisterRoutes(app *fiber.App) {
+	app.Get("/admin/stats", dc.HandleStats)
+	app.Get("/admin/snapshots", dc.HandleListSnapshots)
+	app.Post("/admin/snapshots", dc.HandleSnapshot)
+	app.Post("/admin/snapshots/:name/restore", dc.HandleRestoreSnapshot)
+	app.Get("/pubsub", dc.HandleSubscribe)
+	app.Post("/pubsub/:channel", dc.HandlePublish)
+	app.Delete("/tags/:tag", dc.HandleInvalidateTag)
+	app.Get("/cache", dc.HandleScan)
+	app.Get("/cache/members", dc.HandleGetMembers)
+	app.Get("/cache/_events", dc.HandleEvents)
+	app.Post("/cache/_mget", dc.HandleMGet)
+	app.Post("/cache/_mput", dc.HandleMPut)
+	app.Post("/cache/_mdelete", dc.HandleMDelete)
+	app.Get("/cache/_fill/:key", dc.HandleFill)
+	app.Post("/cache/:key/incr", dc.routeToOwner, dc.HandleIncr)
+	app.Post("/cache/:key/decr", dc.routeToOwner, dc.HandleDecr)
+	app.Post("/cache/:key/expire", dc.routeToOwner, dc.HandleExpire)
+	app.Post("/cache/:key/:op", dc.routeToOwner, dc.HandleCollectionWrite)
+	app.Get("/cache/:key/:op", dc.routeToOwner, dc.HandleCollectionRead)
+	app.All("/cache/:key", dc.routeToOwner, dc.FiberHandler)
+}
+
 // JoinCluster allows the current node to join an existing cluster using a peer address.
 func (dc *DistributedCache) JoinCluster(peer string) error {
 	// Log initial members
@@ -192,18 +277,61 @@ func (dc *DistributedCache) JoinCluster(peer string) error {
 
 // SyncPayload represents the structure for synchronization requests
 type SyncPayload struct {
-	Method   string `json:"method"`
-	Key      string `json:"key"`
-	Value    string `json:"value"`
-	Duration string `json:"duration"`
-	IsSync   bool   `json:"is_sync"` // Flag to prevent infinite loops
+	Method      string           `json:"method"`
+	Key         string           `json:"key"`
+	Value       string           `json:"value"`
+	Duration    string           `json:"duration"`
+	IsSync      bool             `json:"is_sync"`                // Flag to prevent infinite loops
+	Data        []byte           `json:"data,omitempty"`         // Raw value for binary-safe PUTs
+	ContentType string           `json:"content_type,omitempty"` // Content-Type stored with Data
+	TTL         string           `json:"ttl,omitempty"`          // TTL as given to a raw PUT
+	Version     uint64           `json:"version,omitempty"`      // Version assigned by the originating node
+	Flags       uint32           `json:"flags,omitempty"`        // Opaque client flags stored with the value
+	Op          string           `json:"op,omitempty"`           // Sub-resource of the key, such as "incr"
+	Counter     *cache.PNCounter `json:"counter,omitempty"`      // Counter state for peers to merge
+	Args        []string         `json:"args,omitempty"`         // Arguments of a collection op
+	Tags        []string         `json:"tags,omitempty"`         // Tags stored with a PUT
+	SoftTTL     string           `json:"soft_ttl,omitempty"`     // Soft TTL stored with a PUT
+	OpNode      string           `json:"op_node,omitempty"`      // Node a collection op originated on
+	OpSeq       uint64           `json:"op_seq,omitempty"`       // That node's number for the op
 }
 
+// TTLHeader and the ttl query parameter select the raw-body PUT mode. The TTL
+// is either a whole number of seconds or a Go duration string such as "90s".
+const TTLHeader = "X-Cache-TTL"
+
+// SoftTTLHeader and the soft_ttl query parameter give a PUT a soft TTL, in
+// the same formats as TTLHeader. Once it passes the item is stale: it is
+// still served, but reading it triggers a refresh from the origin.
+const SoftTTLHeader = "X-Cache-Soft-TTL"
+
+// FlagsHeader carries opaque client flags (as used by memcached clients) on
+// raw PUTs and GETs.
+const FlagsHeader = "X-Cache-Flags"
+
+// TagsHeader lists the comma-separated tags of a PUT, which DELETE
+// /tags/:tag can later invalidate as a group. GETs return it too.
+const TagsHeader = "X-Cache-Tags"
+
+// VersionHeader carries the item version on replicated writes so that every
+// node reports the same ETag for the same write.
+const VersionHeader = "X-Cache-Version"
+
+// OpNodeHeader and OpSeqHeader identify a replicated collection op by the
+// node it originated on and that node's number for it, so every replica
+// applies it once.
+const (
+	OpNodeHeader = "X-Cache-Op-Node"
+	OpSeqHeader  = "X-Cache-Op-Seq"
+)
+
 // FiberHandler handles the main cache operations
 func (dc *DistributedCache) FiberHandler(c *fiber.Ctx) error {
 	fmt.Println("################   FiberHandler   ##################")
 
-	key := c.Params("key")
+	// Fiber reuses its buffers once the handler returns, so anything kept
+	// in the cache must be copied out of the request first.
+	key := strings.Clone(c.Params("key"))
 	// Check if this is a sync request by looking at the headers
 	isSync := c.Get("X-Is-Sync") == "true"
 
@@ -211,165 +339,493 @@ func (dc *DistributedCache) FiberHandler(c *fiber.Ctx) error {
 	case "PUT":
 		log.Println("METHODEPUT#####")
 
-		var requestBody struct {
-			Value    string `json:"value"`
-			Duration string `json:"duration"`
+		if ttl := c.Get(TTLHeader, c.Query("ttl")); ttl != "" {
+			return dc.handleRawPut(c, key, ttl, isSync)
+		}
+		return dc.handleJSONPut(c, key, isSync)
+
+	case "GET":
+		log.Printf("METHODGET#####")
+
+		if !isSync {
+			payload := SyncPayload{
+				Method: c.Method(),
+				Key:    key,
+				IsSync: true,
+			}
+
+			if err := dc.broadcastToOtherNodes(payload); err != nil {
+				log.Printf("Failed to broadcast: %v", err)
+				// Continue with local operation even if broadcast fails
+			}
+		}
+		log.Printf("##### broadcastToOtherNodes called #####")
+
+		// Replicated GETs only read this node's copy; the node the client
+		// asked loads a missing key for the whole cluster. Sharded GETs are
+		// routed to the owner as sync requests, so it loads them.
+		var item cache.CacheItem
+		var found bool
+		var err error
+		if isSync && !dc.Sharded {
+			item, found = dc.Get(key)
+		} else {
+			item, found, err = dc.Fetch(c.UserContext(), key)
+		}
+		if errors.Is(err, ErrKnownMissing) {
+			c.Set(CacheStatusHeader, "negative")
+			return c.SendStatus(fiber.StatusNotFound)
+		}
+		if err != nil {
+			log.Printf("Failed to load %s from origin: %v", key, err)
+			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+				"error": "Failed to load from origin",
+			})
+		}
+		if !found {
+			return c.SendStatus(fiber.StatusNotFound)
+		}
+		setVersionHeaders(c, item)
+		if etagMatches(c.Get(fiber.HeaderIfNoneMatch), item.Version) {
+			return c.SendStatus(fiber.StatusNotModified)
+		}
+		data, err := dc.Codec.Marshal(item.Value)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to encode value",
+			})
+		}
+		if item.ContentType != "" {
+			c.Set(fiber.HeaderContentType, item.ContentType)
+		}
+		if item.Flags != 0 {
+			c.Set(FlagsHeader, strconv.FormatUint(uint64(item.Flags), 10))
+		}
+		if len(item.Tags) > 0 {
+			c.Set(TagsHeader, strings.Join(item.Tags, ","))
 		}
+		log.Printf("value of %s is %d bytes", key, len(data))
+		return c.Send(data)
 
-		if !c.Is("json") {
+	case "DELETE":
+		log.Printf("METHODEDELETE####")
+
+		cond, err := parsePrecondition(c)
+		if err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Content-Type must be application/json",
+				"error": err.Error(),
 			})
 		}
 
+		// Only broadcast to other nodes if this is not a sync request
+		if isSync {
+			err = dc.Cache.DeleteIf(key, cond)
+			if err == nil && dc.Sharded {
+				err = dc.forwardDelete(key)
+			}
+		} else {
+			err = dc.Delete(key, cond)
+		}
+		if err == cache.ErrPreconditionFailed {
+			return c.Status(fiber.StatusPreconditionFailed).JSON(fiber.Map{
+				"error": "Precondition failed",
+			})
+		}
+		if errors.Is(err, ErrOriginWrite) {
+			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		log.Printf("Successfully deleted %s", key)
+		return c.SendStatus(fiber.StatusOK)
+
+	default:
+		return c.Status(fiber.StatusMethodNotAllowed).SendString("Method not allowed")
+	}
+}
+
+// handleJSONPut implements the original PUT mode, where the body carries a
+// string value and a duration in nanoseconds, either as JSON or as a form.
+func (dc *DistributedCache) handleJSONPut(c *fiber.Ctx, key string, isSync bool) error {
+	var requestBody struct {
+		Value    string   `json:"value"`
+		Duration string   `json:"duration"`
+		Tags     []string `json:"tags"`
+		SoftTTL  string   `json:"soft_ttl"`
+	}
+
+	switch {
+	case c.Is("json"):
 		if err := json.Unmarshal(c.Body(), &requestBody); err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"error": "Invalid JSON format",
 			})
 		}
+	case strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEApplicationForm):
+		requestBody.Value = strings.Clone(c.FormValue("value"))
+		requestBody.Duration = c.FormValue("duration")
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Content-Type must be application/json, or a TTL must be given for raw values",
+		})
+	}
+
+	value := requestBody.Value
+	durationStr := requestBody.Duration
+
+	if value == "" || durationStr == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Missing required fields",
+		})
+	}
 
-		value := requestBody.Value
-		durationStr := requestBody.Duration
+	duration, err := strconv.ParseInt(durationStr, 10, 64)
+	if err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
 
-		if value == "" || durationStr == "" {
+	opts := cache.SetOptions{Tags: requestBody.Tags}
+	if requestBody.SoftTTL != "" {
+		if opts.SoftTTL, err = ParseTTL(requestBody.SoftTTL); err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Missing required fields",
+				"error": "Invalid soft TTL",
 			})
 		}
+	}
 
-		// Only broadcast to other nodes if this is not a sync request
-		if !isSync {
-			payload := SyncPayload{
-				Method:   c.Method(),
-				Key:      key,
-				Value:    value,
-				Duration: durationStr,
-				IsSync:   true,
-			}
+	log.Printf("value: %s, duration: %s", value, durationStr)
+	return dc.handlePut(c, key, value, time.Duration(duration), opts, isSync)
+}
 
-			if err := dc.broadcastToOtherNodes(payload); err != nil {
-				log.Printf("Failed to broadcast: %v", err)
-				// Continue with local operation even if broadcast fails
-			}
-		}
-		log.Printf("##### broadcastToOtherNodes called #####")
+// handleRawPut stores the request body verbatim together with its
+// Content-Type, so any bytes (images, protobufs, ...) can be cached.
+func (dc *DistributedCache) handleRawPut(c *fiber.Ctx, key string, ttl string, isSync bool) error {
+	duration, err := ParseTTL(ttl)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid TTL",
+		})
+	}
 
-		log.Printf("value: %s, duration: %s", value, durationStr)
+	contentType := strings.Clone(c.Get(fiber.HeaderContentType, fiber.MIMEOctetStream))
+	// Fiber reuses the request buffer, so keep our own copy of the body.
+	data := append([]byte{}, c.Body()...)
 
-		duration, err := strconv.ParseInt(durationStr, 10, 64)
+	opts := cache.SetOptions{ContentType: contentType}
+	if softTTL := c.Get(SoftTTLHeader, c.Query("soft_ttl")); softTTL != "" {
+		if opts.SoftTTL, err = ParseTTL(softTTL); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid soft TTL",
+			})
+		}
+	}
+	if flags := c.Get(FlagsHeader); flags != "" {
+		f, err := strconv.ParseUint(flags, 10, 32)
 		if err != nil {
-			return c.SendStatus(fiber.StatusBadRequest)
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid flags",
+			})
 		}
+		opts.Flags = uint32(f)
+	}
 
-		log.Printf("##### Preparing to set value in cahce #####")
-		dc.Cache.Set(key, value, time.Duration(duration))
-		log.Printf("##### Successfully set value in cahce #####")
+	log.Printf("Storing %d bytes of %s under %s", len(data), contentType, key)
+	return dc.handlePut(c, key, data, duration, opts, isSync)
+}
 
-		return c.SendStatus(fiber.StatusOK)
+// handlePut applies a parsed PUT, honouring any conditional request headers,
+// and answers with the new item's ETag.
+func (dc *DistributedCache) handlePut(c *fiber.Ctx, key string, value interface{}, duration time.Duration, opts cache.SetOptions, isSync bool) error {
+	cond, err := parsePrecondition(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
 
-	case "GET":
-		log.Printf("METHODGET#####")
+	if tags := parseTags(c.Get(TagsHeader)); tags != nil {
+		opts.Tags = tags
+	}
 
-		if !isSync {
-			payload := SyncPayload{
-				Method: c.Method(),
-				Key:    key,
-				IsSync: true,
+	var item cache.CacheItem
+	// Only broadcast to other nodes if this is not a sync request
+	if isSync {
+		opts.Version, _ = strconv.ParseUint(c.Get(VersionHeader), 10, 64)
+		item, err = dc.store(key, value, duration, opts, cond)
+		// Sharded writes reach the owner as sync requests, but came from a client
+		if err == nil && dc.Sharded {
+			if err = dc.forwardWrite(key, value, opts.ContentType); err != nil {
+				dc.Cache.Delete(key)
 			}
+		}
+	} else {
+		item, err = dc.Set(key, value, duration, opts, cond)
+	}
+	if err == cache.ErrPreconditionFailed {
+		return c.Status(fiber.StatusPreconditionFailed).JSON(fiber.Map{
+			"error": "Precondition failed",
+		})
+	}
+	if errors.Is(err, ErrOriginWrite) {
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
 
-			if err := dc.broadcastToOtherNodes(payload); err != nil {
-				log.Printf("Failed to broadcast: %v", err)
-				// Continue with local operation even if broadcast fails
-			}
+	setVersionHeaders(c, item)
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// Get returns the item stored under key on this node. The HTTP and gRPC APIs
+// both read through it.
+func (dc *DistributedCache) Get(key string) (cache.CacheItem, bool) {
+	return dc.Cache.GetItem(key)
+}
+
+// Set stores value under key on this node if cond holds, then replicates the
+// write, with its version, to the rest of the cluster. Raw []byte values
+// without a content type are stored as octet streams.
+//
+// With a write-through origin the write is forwarded before it is
+// replicated; if that fails the key is removed and ErrOriginWrite returned.
+func (dc *DistributedCache) Set(key string, value interface{}, duration time.Duration, opts cache.SetOptions, cond cache.Precondition) (cache.CacheItem, error) {
+	return dc.set(key, value, duration, opts, cond, true)
+}
+
+// store writes value to this node's cache only, and forgets that the origin
+// reported key missing. Local writes and replicated ones, whether they arrive
+// over HTTP, the binary transport, memberlist or in a batch, all go through
+// it.
+func (dc *DistributedCache) store(key string, value interface{}, duration time.Duration, opts cache.SetOptions, cond cache.Precondition) (cache.CacheItem, error) {
+	item, err := dc.Cache.SetIf(key, value, duration, opts, cond)
+	if err == nil && dc.origin != nil {
+		dc.origin.negatives.remove(key)
+	}
+	return item, err
+}
+
+// set is Set, forwarding the write to the origin only if forward is true.
+func (dc *DistributedCache) set(key string, value interface{}, duration time.Duration, opts cache.SetOptions, cond cache.Precondition, forward bool) (cache.CacheItem, error) {
+	if _, ok := value.([]byte); ok && opts.ContentType == "" {
+		opts.ContentType = fiber.MIMEOctetStream
+	}
+	item, err := dc.store(key, value, duration, opts, cond)
+	if err != nil {
+		return item, err
+	}
+	if forward {
+		if err := dc.forwardWrite(key, value, opts.ContentType); err != nil {
+			// Don't serve a value the origin doesn't have
+			dc.Cache.Delete(key)
+			return cache.CacheItem{}, err
 		}
-		log.Printf("##### broadcastToOtherNodes called #####")
+	}
 
-		value, found := dc.Cache.Get(key)
-		if !found {
-			return c.SendStatus(fiber.StatusNotFound)
+	payload := SyncPayload{
+		Method:  fiber.MethodPut,
+		Key:     key,
+		IsSync:  true,
+		Version: item.Version,
+		Tags:    opts.Tags,
+	}
+	if opts.SoftTTL > 0 {
+		payload.SoftTTL = opts.SoftTTL.String()
+	}
+	if data, ok := value.([]byte); ok {
+		payload.Data = data
+		payload.ContentType = opts.ContentType
+		payload.TTL = duration.String()
+		payload.Flags = opts.Flags
+	} else {
+		encoded, err := dc.Codec.Marshal(value)
+		if err != nil {
+			return item, fmt.Errorf("failed to encode value: %v", err)
 		}
-		log.Printf("value of %s is %s", key, value)
-		return c.SendString(fmt.Sprintf("%v", value))
+		payload.Value = string(encoded)
+		payload.Duration = strconv.FormatInt(int64(duration), 10)
+	}
 
-	case "DELETE":
-		log.Printf("METHODEDELETE####")
-		if !isSync {
-			payload := SyncPayload{
-				Method: c.Method(),
-				Key:    key,
-				IsSync: true,
-			}
+	if err := dc.broadcastToOtherNodes(payload); err != nil {
+		log.Printf("Failed to broadcast: %v", err)
+		// The local write stands even if broadcast fails
+	}
+	return item, nil
+}
 
-			if err := dc.broadcastToOtherNodes(payload); err != nil {
-				log.Printf("Failed to broadcast: %v", err)
-				// Continue with local operation even if broadcast fails
-			}
-		}
-		log.Printf("##### broadcastToOtherNodes called #####")
+// Delete removes key on this node if cond holds, then replicates the delete
+// to the rest of the cluster.
+func (dc *DistributedCache) Delete(key string, cond cache.Precondition) error {
+	if err := dc.Cache.DeleteIf(key, cond); err != nil {
+		return err
+	}
+	originErr := dc.forwardDelete(key)
 
-		dc.Cache.Delete(key)
+	payload := SyncPayload{
+		Method: fiber.MethodDelete,
+		Key:    key,
+		IsSync: true,
+	}
+	if err := dc.broadcastToOtherNodes(payload); err != nil {
+		log.Printf("Failed to broadcast: %v", err)
+	}
+	return originErr
+}
 
-		log.Printf("Successfully deleted %s", key)
-		return c.SendStatus(fiber.StatusOK)
+// ParseTTL accepts either a whole number of seconds or a Go duration string.
+// A TTL of zero means the item never expires.
+func ParseTTL(ttl string) (time.Duration, error) {
+	if seconds, err := strconv.ParseInt(ttl, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, fmt.Errorf("ttl must not be negative: %d", seconds)
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	duration, err := time.ParseDuration(ttl)
+	if err != nil {
+		return 0, err
+	}
+	if duration < 0 {
+		return 0, fmt.Errorf("ttl must not be negative: %s", ttl)
+	}
+	return duration, nil
+}
 
-	default:
-		return c.Status(fiber.StatusMethodNotAllowed).SendString("Method not allowed")
+// peers returns every other member of the cluster along with its HTTP port,
+// which is advertised through the memberlist node metadata.
+func (dc *DistributedCache) peers() []Member {
+	var peers []Member
+	for _, member := range dc.members() {
+		if member.Name != dc.Config.Name {
+			peers = append(peers, member)
+		}
 	}
+	return peers
+}
+
+// members lists all cluster members, including this node. Members whose
+// metadata cannot be parsed are skipped.
+func (dc *DistributedCache) members() []Member {
+	nodes := dc.List.Members()
+	members := make([]Member, 0, len(nodes))
+	for _, node := range nodes {
+		var meta NodeMetadata
+		if err := json.Unmarshal(node.Meta, &meta); err != nil {
+			log.Printf("Skipping member %s with invalid metadata: %v", node.Name, err)
+			continue
+		}
+		members = append(members, Member{
+			Name:            node.Name,
+			Addr:            node.Addr.String(),
+			Port:            int(node.Port),
+			HTTPPort:        meta.HTTPPort,
+			ReplicationPort: meta.ReplicationPort,
+		})
+	}
+	return members
 }
 
 // broadcastToOtherNodes broadcasts the incoming cache request(to a single node) to all the other nodes in the cluster
+// unless the cluster is sharded. Peers that advertise a replication port are sent the payload over the
+// binary transport; the rest get an HTTP request.
 func (dc *DistributedCache) broadcastToOtherNodes(payload SyncPayload) error {
 	log.Print("Inside the broadcastToOtherNodes function")
 
-	agent := fiber.AcquireAgent()
-	defer fiber.ReleaseAgent(agent)
-
-	httpPort := "8001"
-
-	// ##### Request for getting httpPorts of all the nodes in the cluster #####
-	req2 := agent.Request()
-	req2.Header.SetMethod(fiber.MethodGet)
-	req2.Header.SetContentType("application/json")
-	req2.SetRequestURI(fmt.Sprintf("http://127.0.0.1:%s/cache/members", httpPort))
-
-	if err := agent.Parse(); err != nil {
-		log.Printf("Failed to parse request for member %s", err)
+	// In sharded mode the owner holds the only copy of a key
+	if dc.Sharded {
+		return nil
 	}
 
-	statusCode, body, errs := agent.Bytes()
-	if len(errs) > 0 || statusCode != fiber.StatusOK {
-		log.Printf("Failed to sync with 8001 :", errs)
+	var httpPeers, binaryPeers []Member
+	pool := dc.pool()
+	for _, member := range dc.peers() {
+		if pool != nil && member.ReplicationPort != 0 {
+			binaryPeers = append(binaryPeers, member)
+		} else {
+			httpPeers = append(httpPeers, member)
+		}
 	}
-	var members []Member
-
-	if err := json.Unmarshal(body, &members); err != nil {
-		log.Fatalf("Failed to parse JSON: %v", err)
+	if len(binaryPeers) > 0 {
+		dc.replicateBinary(pool, binaryPeers, payload)
+	}
+	if len(httpPeers) == 0 {
+		return nil
 	}
 
 	jsonPayload, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("failed to marshal payload: %v", err)
 	}
-	log.Print(members)
-	for _, member := range members {
+	counterState, err := json.Marshal(payload.Counter)
+	if err != nil {
+		return fmt.Errorf("failed to marshal counter: %v", err)
+	}
+	for _, member := range httpPeers {
 		// ##### Request for broadcasting a Cache request to a specific httpPort in the cluster #####
 
+		// Bytes() hands the agent back to the pool, so every request needs its own
+		agent := fiber.AcquireAgent()
+
 		// Setup request
 		req := agent.Request()
 		req.Header.SetMethod(payload.Method)
-		req.Header.SetContentType("application/json")
 		req.Header.Set("X-Is-Sync", "true") // Mark this as a sync request
-		req.SetRequestURI(fmt.Sprintf("http://127.0.0.1:%s/cache/%s", strconv.Itoa(member.HTTPPort), payload.Key))
-		req.SetBody(jsonPayload)
+		uri := fmt.Sprintf("http://%s:%d/cache/%s", member.Addr, member.HTTPPort, payload.Key)
+		if payload.Op != "" {
+			uri += "/" + payload.Op
+		}
+		req.SetRequestURI(uri)
+		if payload.Version != 0 {
+			req.Header.Set(VersionHeader, strconv.FormatUint(payload.Version, 10))
+		}
+		if len(payload.Tags) > 0 {
+			req.Header.Set(TagsHeader, strings.Join(payload.Tags, ","))
+		}
+		switch {
+		case payload.Op == "expire":
+			req.Header.Set(TTLHeader, payload.TTL)
+		case cache.IsCollectionOp(payload.Op):
+			// Peers replay the operation rather than receiving the collection
+			body, _ := json.Marshal(CollectionRequest{Args: payload.Args})
+			req.Header.SetContentType("application/json")
+			req.Header.Set(TTLHeader, payload.TTL)
+			req.Header.Set(OpNodeHeader, payload.OpNode)
+			req.Header.Set(OpSeqHeader, strconv.FormatUint(payload.OpSeq, 10))
+			req.SetBody(body)
+		case payload.Counter != nil:
+			// Peers merge our counter state rather than replaying the increment
+			req.Header.SetContentType("application/json")
+			req.Header.Set(TTLHeader, payload.TTL)
+			req.SetBody(counterState)
+		case payload.TTL != "":
+			// Raw values are replayed exactly as the client sent them
+			req.Header.SetContentType(payload.ContentType)
+			req.Header.Set(TTLHeader, payload.TTL)
+			if payload.Flags != 0 {
+				req.Header.Set(FlagsHeader, strconv.FormatUint(uint64(payload.Flags), 10))
+			}
+			if payload.SoftTTL != "" {
+				req.Header.Set(SoftTTLHeader, payload.SoftTTL)
+			}
+			req.SetBody(payload.Data)
+		default:
+			req.Header.SetContentType("application/json")
+			req.SetBody(jsonPayload)
+		}
 
 		if err := agent.Parse(); err != nil {
 			log.Printf("Failed to parse request for member %s", err)
 		}
 
 		log.Printf("########## port is : %s", strconv.Itoa(member.HTTPPort))
-		statusCode, _, errs = agent.Bytes()
+		statusCode, _, errs := agent.Bytes()
 		if len(errs) > 0 || statusCode != fiber.StatusOK {
-			log.Printf("Failed to sync with 8001 :", err)
+			log.Printf("Failed to sync with %s (status %d): %v", member.Name, statusCode, errs)
 		}
 
 	}
@@ -409,10 +865,11 @@ func (dc *DistributedCache) HandleGetMembers(c *fiber.Ctx) error {
 		}
 
 		response[i] = fiber.Map{
-			"name":      member.Name,
-			"addr":      member.Address(),
-			"port":      member.Port,
-			"http_port": meta.HTTPPort,
+			"name":             member.Name,
+			"addr":             member.Address(),
+			"port":             member.Port,
+			"http_port":        meta.HTTPPort,
+			"replication_port": meta.ReplicationPort,
 		}
 	}
 	// log.Printf("response is %s", response)
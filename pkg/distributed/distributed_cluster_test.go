@@ -1,10 +1,15 @@
 package distributed
 
 import (
+	"fmt"
+	"net"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/gofiber/fiber/v2"
 	"github.com/hashicorp/memberlist"
+	"github.com/notlelouch/Distributed-Cache/pkg/cache"
 )
 
 func TestNewDistributedCache(t *testing.T) {
@@ -168,3 +173,95 @@ func TestJoinCluster(t *testing.T) {
 // 		t.Errorf("Expected status 200 OK after concurrent writes, got %v", w.Code)
 // 	}
 // }
+
+// startTestNode creates a node serving the cache API on httpPort.
+func startTestNode(t testing.TB, name string, memberlistPort, httpPort int) *DistributedCache {
+	t.Helper()
+
+	dc, err := NewDistributedCache(memberlistPort, httpPort, name)
+	if err != nil {
+		t.Fatalf("Failed to create %s: %v", name, err)
+	}
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	dc.RegisterRoutes(app)
+	go app.Listen(fmt.Sprintf("127.0.0.1:%d", httpPort))
+	t.Cleanup(func() {
+		app.Shutdown()
+		dc.List.Shutdown()
+	})
+
+	// Wait for the listener to come up
+	for i := 0; i < 50; i++ {
+		if conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", httpPort)); err == nil {
+			conn.Close()
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return dc
+}
+
+func TestReplicatedConditionalWrites(t *testing.T) {
+	dc1 := startTestNode(t, "cas1", 7960, 8960)
+	dc2 := startTestNode(t, "cas2", 7961, 8961)
+	if err := dc2.JoinCluster("127.0.0.1:7960"); err != nil {
+		t.Fatalf("Failed to join cluster: %v", err)
+	}
+
+	item, err := dc1.Set("lock", "owner-a", time.Minute, cache.SetOptions{}, cache.Precondition{IfNoneMatch: true})
+	if err != nil {
+		t.Fatalf("Expected set-if-absent to succeed, got %v", err)
+	}
+
+	replica, found := dc2.Cache.GetItem("lock")
+	if !found || replica.Value != "owner-a" {
+		t.Fatalf("Expected lock to be replicated to cas2, got %+v, found: %v", replica, found)
+	}
+	if replica.Version != item.Version {
+		t.Errorf("Expected replica version %d, got %d", item.Version, replica.Version)
+	}
+
+	// A CAS on the second node using the version from the first succeeds once
+	if _, err := dc2.Set("lock", "owner-b", time.Minute, cache.SetOptions{}, cache.Precondition{IfMatch: []uint64{item.Version}}); err != nil {
+		t.Fatalf("Expected CAS on replica to succeed, got %v", err)
+	}
+	if _, err := dc1.Set("lock", "owner-c", time.Minute, cache.SetOptions{}, cache.Precondition{IfMatch: []uint64{item.Version}}); err != cache.ErrPreconditionFailed {
+		t.Errorf("Expected stale CAS on cas1 to fail, got %v", err)
+	}
+	if value, _ := dc1.Cache.Get("lock"); value != "owner-b" {
+		t.Errorf("Expected cas1 to hold owner-b, got %v", value)
+	}
+}
+
+func TestReplicatedCountersConverge(t *testing.T) {
+	dc1 := startTestNode(t, "ctr1", 7962, 8962)
+	dc2 := startTestNode(t, "ctr2", 7963, 8963)
+	if err := dc2.JoinCluster("127.0.0.1:7962"); err != nil {
+		t.Fatalf("Failed to join cluster: %v", err)
+	}
+
+	// Both nodes increment the same key concurrently
+	var wg sync.WaitGroup
+	for _, dc := range []*DistributedCache{dc1, dc2} {
+		wg.Add(1)
+		go func(dc *DistributedCache) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				if _, err := dc.Incr("requests", 1, time.Minute); err != nil {
+					t.Errorf("Incr failed: %v", err)
+				}
+			}
+		}(dc)
+	}
+	wg.Wait()
+	dc1.Incr("requests", -5, time.Minute)
+
+	for _, dc := range []*DistributedCache{dc1, dc2} {
+		value, _ := dc.Cache.Get("requests")
+		counter, ok := value.(*cache.PNCounter)
+		if !ok || counter.Value() != 35 {
+			t.Errorf("Expected %s to converge on 35, got %v", dc.Config.Name, value)
+		}
+	}
+}
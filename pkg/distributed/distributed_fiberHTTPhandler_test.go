@@ -1,7 +1,9 @@
 package distributed
 
 import (
+	"bytes"
 	"io"
+	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
@@ -118,3 +120,172 @@ func TestFiberHandlerInvalidMethod(t *testing.T) {
 		t.Errorf("Expected status 405 Method Not Allowed for POST, got %v", resp.StatusCode)
 	}
 }
+
+func TestFiberHandlerRawPut(t *testing.T) {
+	dc, _ := NewDistributedCache(7952, 8000, "node1")
+
+	app := fiber.New()
+	app.Put("/cache/:key", dc.FiberHandler)
+	app.Get("/cache/:key", dc.FiberHandler)
+
+	png := []byte{0x89, 'P', 'N', 'G', 0x00, 0xff}
+
+	// TTL given as a header
+	req := httptest.NewRequest(fiber.MethodPut, "/cache/logo", bytes.NewReader(png))
+	req.Header.Set("Content-Type", "image/png")
+	req.Header.Set(TTLHeader, "60")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Failed to test raw PUT request: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("Expected status 200 OK for raw PUT, got %v", resp.StatusCode)
+	}
+
+	req = httptest.NewRequest(fiber.MethodGet, "/cache/logo", nil)
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatalf("Failed to test GET request: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if !bytes.Equal(body, png) {
+		t.Errorf("Expected raw bytes %v, got %v", png, body)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "image/png" {
+		t.Errorf("Expected Content-Type image/png, got %q", ct)
+	}
+
+	// TTL given as a query parameter; a JSON body is stored verbatim
+	req = httptest.NewRequest(fiber.MethodPut, "/cache/doc?ttl=1m", strings.NewReader(`{"value":"not legacy"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, _ = app.Test(req)
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("Expected status 200 OK for raw JSON PUT, got %v", resp.StatusCode)
+	}
+	resp, _ = app.Test(httptest.NewRequest(fiber.MethodGet, "/cache/doc", nil))
+	body, _ = io.ReadAll(resp.Body)
+	if string(body) != `{"value":"not legacy"}` {
+		t.Errorf("Expected JSON document to round-trip, got %s", body)
+	}
+
+	// Invalid TTL
+	req = httptest.NewRequest(fiber.MethodPut, "/cache/bad?ttl=soon", strings.NewReader("x"))
+	resp, _ = app.Test(req)
+	if resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid TTL, got %v", resp.StatusCode)
+	}
+}
+
+func TestFiberHandlerJSONPut(t *testing.T) {
+	dc, _ := NewDistributedCache(7953, 8000, "node1")
+
+	app := fiber.New()
+	app.Put("/cache/:key", dc.FiberHandler)
+
+	req := httptest.NewRequest(fiber.MethodPut, "/cache/John10", strings.NewReader(`{"value": "test", "duration": "9000000000"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Failed to test PUT request: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("Expected status 200 OK for JSON PUT, got %v", resp.StatusCode)
+	}
+	if value, found := dc.Cache.Get("John10"); !found || value != "test" {
+		t.Errorf("Expected John10 to be test, got %v, found: %v", value, found)
+	}
+}
+
+func TestFiberHandlerConditionalWrites(t *testing.T) {
+	dc, _ := NewDistributedCache(7954, 8000, "node1")
+
+	app := fiber.New()
+	app.All("/cache/:key", dc.FiberHandler)
+
+	put := func(value string, headers map[string]string) *http.Response {
+		req := httptest.NewRequest(fiber.MethodPut, "/cache/lock?ttl=60", strings.NewReader(value))
+		req.Header.Set("Content-Type", "text/plain")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to test PUT request: %v", err)
+		}
+		return resp
+	}
+
+	// Set-if-absent
+	resp := put("owner-a", map[string]string{"If-None-Match": "*"})
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("Expected first If-None-Match: * PUT to succeed, got %v", resp.StatusCode)
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatalf("Expected PUT to return an ETag")
+	}
+	if resp := put("owner-b", map[string]string{"If-None-Match": "*"}); resp.StatusCode != fiber.StatusPreconditionFailed {
+		t.Errorf("Expected second If-None-Match: * PUT to fail with 412, got %v", resp.StatusCode)
+	}
+
+	// GET returns the same ETag, and 304 when it matches If-None-Match
+	resp, _ = app.Test(httptest.NewRequest(fiber.MethodGet, "/cache/lock", nil))
+	if got := resp.Header.Get("ETag"); got != etag {
+		t.Errorf("Expected GET ETag %s, got %s", etag, got)
+	}
+	req := httptest.NewRequest(fiber.MethodGet, "/cache/lock", nil)
+	req.Header.Set("If-None-Match", etag)
+	if resp, _ := app.Test(req); resp.StatusCode != fiber.StatusNotModified {
+		t.Errorf("Expected 304 for matching If-None-Match, got %v", resp.StatusCode)
+	}
+
+	// Compare-and-swap
+	resp = put("owner-c", map[string]string{"If-Match": etag})
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("Expected If-Match PUT with current ETag to succeed, got %v", resp.StatusCode)
+	}
+	if resp := put("owner-d", map[string]string{"If-Match": etag}); resp.StatusCode != fiber.StatusPreconditionFailed {
+		t.Errorf("Expected If-Match PUT with stale ETag to fail with 412, got %v", resp.StatusCode)
+	}
+	if resp := put("owner-e", map[string]string{"If-Unmodified-Since": "Mon, 01 Jan 2001 00:00:00 GMT"}); resp.StatusCode != fiber.StatusPreconditionFailed {
+		t.Errorf("Expected If-Unmodified-Since in the past to fail with 412, got %v", resp.StatusCode)
+	}
+
+	// Conditional delete
+	req = httptest.NewRequest(fiber.MethodDelete, "/cache/lock", nil)
+	req.Header.Set("If-Match", etag)
+	if resp, _ := app.Test(req); resp.StatusCode != fiber.StatusPreconditionFailed {
+		t.Errorf("Expected DELETE with stale ETag to fail with 412, got %v", resp.StatusCode)
+	}
+	if value, _ := dc.Cache.Get("lock"); string(value.([]byte)) != "owner-c" {
+		t.Errorf("Expected lock to be held by owner-c, got %s", value)
+	}
+}
+
+func TestFiberHandlerIncrDecr(t *testing.T) {
+	dc, _ := NewDistributedCache(7955, 8000, "node1")
+
+	app := fiber.New()
+	app.Post("/cache/:key/incr", dc.HandleIncr)
+	app.Post("/cache/:key/decr", dc.HandleDecr)
+	app.All("/cache/:key", dc.FiberHandler)
+
+	for _, path := range []string{"/cache/hits/incr", "/cache/hits/incr?by=10", "/cache/hits/decr?by=3"} {
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodPost, path, nil))
+		if err != nil || resp.StatusCode != fiber.StatusOK {
+			t.Fatalf("Expected POST %s to succeed, got %v (%v)", path, resp.StatusCode, err)
+		}
+	}
+
+	resp, _ := app.Test(httptest.NewRequest(fiber.MethodGet, "/cache/hits", nil))
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "8" {
+		t.Errorf("Expected counter value 8, got %s", body)
+	}
+
+	dc.Cache.Set("name", "alice", time.Minute)
+	resp, _ = app.Test(httptest.NewRequest(fiber.MethodPost, "/cache/name/incr", nil))
+	if resp.StatusCode != fiber.StatusConflict {
+		t.Errorf("Expected 409 incrementing a non-integer, got %v", resp.StatusCode)
+	}
+}
@@ -0,0 +1,108 @@
+package distributed
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/notlelouch/Distributed-Cache/pkg/cache"
+)
+
+// EventBuffer is how many events an event stream may fall behind before it
+// is disconnected.
+const EventBuffer = 1024
+
+// EventKeepAlive is how often an idle event stream sends a comment line, so
+// proxies keep it open and closed clients are noticed.
+var EventKeepAlive = 15 * time.Second
+
+// ChangeEvent is the data of one Server-Sent Event.
+type ChangeEvent struct {
+	Seq       uint64 `json:"seq"`
+	Type      string `json:"type"` // set, delete, expire or evict
+	Key       string `json:"key"`
+	Version   uint64 `json:"version,omitempty"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+}
+
+// HandleEvents streams changes to keys under the prefix query parameter as
+// Server-Sent Events. Every node applies every replicated write, so a stream
+// from any node carries changes made anywhere in the cluster.
+//
+// In sharded mode that is not the case: a node only holds, and so only
+// streams, the keys it owns, and the subscription is not forwarded to other
+// nodes. A subscriber that needs every change must open a stream to each
+// member listed by GET /cache/members, as pkg/client does, and open one to
+// new members as they join.
+//
+// Each event's id is its sequence number on this node. A client reconnecting
+// to the same node resumes without gaps by sending it back as Last-Event-ID
+// (or the since query parameter). If the events are no longer retained the
+// request fails with 410 Gone and the client has to resync.
+func (dc *DistributedCache) HandleEvents(c *fiber.Ctx) error {
+	prefix := strings.Clone(c.Query("prefix"))
+
+	var watcher *cache.Watcher[string, interface{}]
+	if since := c.Get("Last-Event-ID", c.Query("since")); since != "" {
+		seq, err := strconv.ParseUint(since, 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid event id",
+			})
+		}
+		if watcher, err = dc.Cache.WatchFrom(seq, EventBuffer); err == cache.ErrEventsGone {
+			return c.Status(fiber.StatusGone).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+	} else {
+		watcher = dc.Cache.Watch(EventBuffer)
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer watcher.Close()
+		keepAlive := time.NewTicker(EventKeepAlive)
+		defer keepAlive.Stop()
+
+		// Let the client know the stream is open before the first change
+		fmt.Fprint(w, ": connected\n\n")
+		if err := w.Flush(); err != nil {
+			return
+		}
+		for {
+			select {
+			case ev, ok := <-watcher.C:
+				if !ok {
+					fmt.Fprint(w, "event: lagged\ndata: {}\n\n")
+					w.Flush()
+					return
+				}
+				if !strings.HasPrefix(ev.Key, prefix) {
+					continue
+				}
+				data, _ := json.Marshal(ChangeEvent{
+					Seq:       ev.Seq,
+					Type:      ev.Type.String(),
+					Key:       ev.Key,
+					Version:   ev.Item.Version,
+					ExpiresAt: ev.Item.Expiration,
+				})
+				fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.Seq, ev.Type, data)
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keepalive\n\n")
+			}
+			if err := w.Flush(); err != nil {
+				// The client went away
+				return
+			}
+		}
+	})
+	return nil
+}
@@ -0,0 +1,86 @@
+package distributed
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/notlelouch/Distributed-Cache/pkg/cache"
+)
+
+// readEvents reads Server-Sent Events from r until n events have arrived.
+func readEvents(t *testing.T, r *bufio.Reader, n int) []ChangeEvent {
+	t.Helper()
+
+	var events []ChangeEvent
+	for len(events) < n {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read event stream after %v: %v", events, err)
+		}
+		if data, ok := strings.CutPrefix(strings.TrimSpace(line), "data: "); ok {
+			var ev ChangeEvent
+			if err := json.Unmarshal([]byte(data), &ev); err != nil {
+				t.Fatalf("Invalid event data %q: %v", data, err)
+			}
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
+func TestEventStream(t *testing.T) {
+	EventKeepAlive = 50 * time.Millisecond
+	dc1 := startTestNode(t, "events1", 7939, 8939)
+	dc2 := startTestNode(t, "events2", 7940, 8940)
+	if err := dc2.JoinCluster("127.0.0.1:7939"); err != nil {
+		t.Fatalf("Failed to join cluster: %v", err)
+	}
+
+	resp, err := http.Get("http://127.0.0.1:8940/cache/_events?prefix=user:")
+	if err != nil {
+		t.Fatalf("Failed to open event stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %q", ct)
+	}
+	r := bufio.NewReader(resp.Body)
+	r.ReadString('\n') // ": connected"
+
+	// Writes made on the other node arrive through replication
+	dc1.Set("other", "x", time.Minute, cache.SetOptions{}, cache.Precondition{})
+	item, _ := dc1.Set("user:1", "alice", time.Minute, cache.SetOptions{}, cache.Precondition{})
+	dc1.Delete("user:1", cache.Precondition{})
+	dc2.Set("user:2", "bob", time.Millisecond, cache.SetOptions{}, cache.Precondition{})
+	time.Sleep(5 * time.Millisecond)
+	dc2.Cache.DeleteExpired()
+
+	events := readEvents(t, r, 4)
+	got := []string{}
+	for _, ev := range events {
+		got = append(got, ev.Type+" "+ev.Key)
+	}
+	if strings.Join(got, ",") != "set user:1,delete user:1,set user:2,expire user:2" {
+		t.Errorf("Unexpected events: %v", got)
+	}
+	if events[0].Version != item.Version {
+		t.Errorf("Expected set event to carry version %d, got %d", item.Version, events[0].Version)
+	}
+
+	// Resuming after the first event replays the rest
+	req, _ := http.NewRequest("GET", "http://127.0.0.1:8940/cache/_events?prefix=user:", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resumed, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to resume event stream: %v", err)
+	}
+	defer resumed.Body.Close()
+	replayed := readEvents(t, bufio.NewReader(resumed.Body), 4)
+	if replayed[0].Seq != events[0].Seq || replayed[3].Seq != events[3].Seq {
+		t.Errorf("Expected replay of %v, got %v", events, replayed)
+	}
+}
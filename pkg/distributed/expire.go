@@ -0,0 +1,53 @@
+package distributed
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandleExpire changes the TTL of :key to the ttl query parameter or
+// X-Cache-TTL header. A TTL of zero makes the key persistent.
+func (dc *DistributedCache) HandleExpire(c *fiber.Ctx) error {
+	key := strings.Clone(c.Params("key"))
+
+	duration, err := ParseTTL(c.Get(TTLHeader, c.Query("ttl")))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid TTL",
+		})
+	}
+
+	var found bool
+	if c.Get("X-Is-Sync") == "true" {
+		found = dc.Cache.Expire(key, duration)
+	} else {
+		found = dc.Expire(key, duration)
+	}
+	if !found {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// Expire changes the TTL of key on this node and replicates the change. It
+// reports whether the key exists here.
+func (dc *DistributedCache) Expire(key string, duration time.Duration) bool {
+	if !dc.Cache.Expire(key, duration) {
+		return false
+	}
+
+	payload := SyncPayload{
+		Method: fiber.MethodPost,
+		Key:    key,
+		IsSync: true,
+		Op:     "expire",
+		TTL:    duration.String(),
+	}
+	if err := dc.broadcastToOtherNodes(payload); err != nil {
+		log.Printf("Failed to broadcast: %v", err)
+	}
+	return true
+}
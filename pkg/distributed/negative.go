@@ -0,0 +1,74 @@
+package distributed
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrKnownMissing is returned by Fetch for a key the origin recently
+// reported missing, while that answer is cached.
+var ErrKnownMissing = errors.New("known missing at origin")
+
+// CacheStatusHeader tells how a response was answered. It is set to
+// "negative" on a 404 answered from the negative cache, telling it apart
+// from a miss that just asked the origin, and the caching proxy sets it on
+// every response it sends.
+const CacheStatusHeader = "X-Cache-Status"
+
+// minNegativePrune is the smallest size at which a negativeCache prunes.
+const minNegativePrune = 1024
+
+// negativeCache remembers keys the origin reported missing, so repeated
+// misses don't reach it until the entry expires. A key that is stored in
+// the cache takes precedence, since the cache is always read first.
+type negativeCache struct {
+	mu    sync.Mutex
+	keys  map[string]int64 // Expiry of each entry in Unix nanoseconds
+	prune int              // Size at which expired entries are next dropped
+}
+
+func (n *negativeCache) add(key string, ttl time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	if n.keys == nil {
+		n.keys = make(map[string]int64)
+	}
+	// Dropping expired entries whenever the map doubles keeps adds cheap
+	if len(n.keys) >= n.prune {
+		for k, expiry := range n.keys {
+			if expiry <= now {
+				delete(n.keys, k)
+			}
+		}
+		n.prune = max(2*len(n.keys), minNegativePrune)
+	}
+	n.keys[key] = now + int64(ttl)
+}
+
+// has reports whether key has an unexpired entry.
+func (n *negativeCache) has(key string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	expiry, ok := n.keys[key]
+	if ok && expiry <= time.Now().UnixNano() {
+		delete(n.keys, key)
+		return false
+	}
+	return ok
+}
+
+func (n *negativeCache) remove(key string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.keys, key)
+}
+
+func (n *negativeCache) len() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.keys)
+}
@@ -0,0 +1,103 @@
+package distributed
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNegativeCaching(t *testing.T) {
+	stub := &stubOrigin{values: map[string]string{}}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	httpPorts := []int{8986, 8987}
+	nodes := []*DistributedCache{
+		startTestNode(t, "negative1", 7986, 8986),
+		startTestNode(t, "negative2", 7987, 8987),
+	}
+	for _, dc := range nodes {
+		dc.SetOrigin(OriginOptions{Loader: &HTTPOrigin{BaseURL: server.URL}, NegativeTTL: 200 * time.Millisecond})
+	}
+	if err := nodes[1].JoinCluster("127.0.0.1:7986"); err != nil {
+		t.Fatalf("Failed to join cluster: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	get := func(port int, key string) (int, string) {
+		resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/cache/%s", port, key))
+		if err != nil {
+			t.Fatalf("GET failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode, resp.Header.Get(CacheStatusHeader)
+	}
+
+	// The first miss asks the origin; later ones on any node are answered
+	// from the negative cache
+	if status, cached := get(8986, "ghost"); status != http.StatusNotFound || cached != "" {
+		t.Errorf("Expected an ordinary 404 first, got %d %q", status, cached)
+	}
+	for _, port := range httpPorts {
+		if status, cached := get(port, "ghost"); status != http.StatusNotFound || cached != "negative" {
+			t.Errorf("Expected a cached 404 on %d, got %d %q", port, status, cached)
+		}
+	}
+	if n := stub.count("GET ghost"); n != 1 {
+		t.Errorf("Expected one origin load, got %d", n)
+	}
+	results := postBatch(t, 8987, "_mget", BatchRequest{Keys: []string{"ghost"}})
+	if len(results) != 1 || results[0].Status != http.StatusNotFound || !results[0].Negative {
+		t.Errorf("Expected _mget to report the cached miss, got %+v", results)
+	}
+
+	// Writing the key replaces the negative entry
+	req, _ := http.NewRequest("PUT", "http://127.0.0.1:8986/cache/ghost?ttl=60", strings.NewReader("boo"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PUT failed: %v", err)
+	}
+	resp.Body.Close()
+	for _, port := range httpPorts {
+		if status, _ := get(port, "ghost"); status != http.StatusOK {
+			t.Errorf("Expected the written key on %d, got %d", port, status)
+		}
+	}
+
+	// So does a write replicated from another node
+	get(8987, "phantom")
+	req, _ = http.NewRequest("PUT", "http://127.0.0.1:8987/cache/phantom?ttl=60", strings.NewReader("boo"))
+	req.Header.Set("X-Is-Sync", "true")
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatalf("Sync PUT failed: %v", err)
+	}
+	resp.Body.Close()
+	if nodes[1].origin.knownMissing("phantom") {
+		t.Error("Expected a replicated write to clear the negative entry")
+	}
+
+	// Entries expire, and then the origin is asked again
+	get(8987, "later")
+	time.Sleep(250 * time.Millisecond)
+	if status, cached := get(8987, "later"); status != http.StatusNotFound || cached != "" {
+		t.Errorf("Expected an ordinary 404 once the entry expired, got %d %q", status, cached)
+	}
+	if n := stub.count("GET later"); n != 2 {
+		t.Errorf("Expected the origin to be asked again, got %d loads", n)
+	}
+}
+
+func TestNegativeCachePrunes(t *testing.T) {
+	var n negativeCache
+	for i := 0; i < minNegativePrune; i++ {
+		n.add(fmt.Sprint(i), time.Nanosecond)
+	}
+	time.Sleep(time.Millisecond)
+	n.add("fresh", time.Minute)
+	if n.len() != 1 || !n.has("fresh") || n.has("0") {
+		t.Errorf("Expected only the fresh entry after pruning, have %d", n.len())
+	}
+}
@@ -0,0 +1,459 @@
+package distributed
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/notlelouch/Distributed-Cache/pkg/cache"
+)
+
+// ErrNotFound is returned by a Loader when the origin has no value for a key.
+var ErrNotFound = errors.New("not found at origin")
+
+// ErrOriginWrite is returned by Set and Delete when a write-through write
+// could not be forwarded to the origin. Either way the key is left out of the
+// cache, so the next read loads whatever the origin has.
+var ErrOriginWrite = errors.New("origin write failed")
+
+// Loader fetches values from the backing store behind the cache on a miss.
+type Loader interface {
+	Load(ctx context.Context, key string) (Loaded, error)
+}
+
+// Loaded is a value fetched by a Loader.
+type Loaded struct {
+	Value       []byte
+	ContentType string
+	TTL         time.Duration // Zero uses OriginOptions.TTL
+	SoftTTL     time.Duration // Zero uses OriginOptions.SoftTTL
+}
+
+// OriginWrite is one write forwarded to the backing store.
+type OriginWrite struct {
+	Key         string
+	Value       []byte
+	ContentType string
+	Delete      bool
+}
+
+// Writer forwards writes to the backing store. Write-through hands it the
+// writes of one client request; write-behind hands it a batch holding the
+// latest write of each key.
+type Writer interface {
+	Write(ctx context.Context, writes []OriginWrite) error
+}
+
+// WriteMode chooses when writes reach the Writer.
+type WriteMode int
+
+const (
+	// WriteThrough forwards each write before it is acknowledged.
+	WriteThrough WriteMode = iota
+	// WriteBehind acknowledges writes at once and forwards them in batches.
+	WriteBehind
+)
+
+// OriginOptions connects a DistributedCache to a backing store.
+type OriginOptions struct {
+	Loader Loader // Read-through on a miss; nil disables it
+	Writer Writer // Nil leaves writes to the application
+	Mode   WriteMode
+	TTL    time.Duration // TTL of loaded values; zero means they never expire
+
+	// SoftTTL makes loaded values stale before they expire. A stale value is
+	// still served, and the first read after it goes stale starts a single
+	// background refresh. Zero disables it.
+	SoftTTL time.Duration
+	// Beta enables XFetch-style probabilistic early refresh: any read may
+	// refresh a value shortly before it goes stale or expires, more likely
+	// the closer the deadline and the slower loads are. Higher values refresh
+	// earlier; 1 is the usual choice and zero disables it.
+	Beta float64
+	// NegativeTTL caches the origin's "not found" answers for this long, so
+	// repeated misses on a missing key don't reach it. Zero disables it.
+	NegativeTTL time.Duration
+
+	// Retries is how many times a failed write is retried, waiting Backoff
+	// and then twice as long each time. Zero uses the default; -1 disables
+	// retries.
+	Retries int
+	Backoff time.Duration
+
+	// Write-behind flushes once BatchSize distinct keys are pending or
+	// every FlushInterval, whichever comes first.
+	BatchSize     int
+	FlushInterval time.Duration
+}
+
+// Defaults for OriginOptions fields left at zero.
+const (
+	DefaultOriginRetries = 3
+	DefaultOriginBackoff = 100 * time.Millisecond
+	DefaultBatchSize     = 100
+	DefaultFlushInterval = time.Second
+)
+
+// origin is the backing store of a DistributedCache.
+type origin struct {
+	OriginOptions
+
+	fetches   flightGroup   // Misses and refreshes on this node
+	loads     flightGroup   // Origin loads, including fills for other nodes
+	loadTime  atomic.Int64  // Moving average of load durations in nanoseconds
+	negatives negativeCache // Keys the origin reported missing
+
+	mu      sync.Mutex
+	pending map[string]OriginWrite // Write-behind writes, latest per key
+	order   []string               // Keys of pending in arrival order
+	flush   chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+// SetOrigin puts the cache in front of a backing store: misses are loaded
+// through opts.Loader and writes are forwarded to opts.Writer. It must be
+// called before serving. In write-behind mode call CloseOrigin on shutdown
+// to flush pending writes.
+func (dc *DistributedCache) SetOrigin(opts OriginOptions) {
+	if opts.Retries == 0 {
+		opts.Retries = DefaultOriginRetries
+	}
+	opts.Retries = max(opts.Retries, 0)
+	if opts.Backoff == 0 {
+		opts.Backoff = DefaultOriginBackoff
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultFlushInterval
+	}
+	o := &origin{OriginOptions: opts}
+	if opts.Writer != nil && opts.Mode == WriteBehind {
+		o.pending = make(map[string]OriginWrite)
+		o.flush = make(chan struct{}, 1)
+		o.stop = make(chan struct{})
+		o.done = make(chan struct{})
+		go o.flushLoop()
+	}
+	dc.origin = o
+}
+
+// CloseOrigin flushes any pending write-behind writes.
+func (dc *DistributedCache) CloseOrigin() error {
+	if dc.origin == nil || dc.origin.stop == nil {
+		return nil
+	}
+	dc.origin.once.Do(func() { close(dc.origin.stop) })
+	<-dc.origin.done
+	return nil
+}
+
+// Fetch returns the item stored under key on this node. On a miss it is
+// loaded from the origin, if there is one, and stored across the cluster.
+// Each miss reaches the origin once, however many clients and nodes ask for
+// the key at the same time. A stale item is returned as is while it is
+// refreshed in the background.
+func (dc *DistributedCache) Fetch(ctx context.Context, key string) (cache.CacheItem, bool, error) {
+	item, found := dc.Get(key)
+	if dc.origin == nil || dc.origin.Loader == nil {
+		return item, found, nil
+	}
+	if !found {
+		if dc.origin.knownMissing(key) {
+			return cache.CacheItem{}, false, ErrKnownMissing
+		}
+		result, err := dc.fetch(ctx, key)
+		return result.item, result.found, err
+	}
+	if dc.origin.due(item, time.Now().UnixNano()) {
+		dc.refresh(key, item.Version)
+	}
+	return item, true, nil
+}
+
+// due reports whether item should be refreshed at now: once it is stale, or
+// earlier at random with XFetch. XFetch refreshes when
+//
+//	now - delta * beta * ln(rand()) >= deadline
+//
+// where delta is how long a load takes, so slow loads start early enough to
+// finish before the deadline and different nodes and keys spread out.
+func (o *origin) due(item cache.CacheItem, now int64) bool {
+	if item.IsStale(now) {
+		return true
+	}
+	deadline := item.Stale
+	if deadline == 0 {
+		deadline = item.Expiration
+	}
+	if o.Beta <= 0 || deadline == 0 {
+		return false
+	}
+	delta := float64(o.loadTime.Load())
+	return float64(now)-delta*o.Beta*math.Log(rand.Float64()) >= float64(deadline)
+}
+
+// knownMissing reports whether the origin recently reported key missing.
+func (o *origin) knownMissing(key string) bool {
+	return o.NegativeTTL > 0 && o.negatives.has(key)
+}
+
+// missing records that the origin has no value for key.
+func (o *origin) missing(key string) {
+	if o.NegativeTTL > 0 {
+		o.negatives.add(key, o.NegativeTTL)
+	}
+}
+
+// recordLoad folds the duration of a load into the moving average used by
+// XFetch.
+func (o *origin) recordLoad(d time.Duration) {
+	avg := o.loadTime.Load()
+	if avg == 0 {
+		avg = int64(d)
+	} else {
+		avg += (int64(d) - avg) / 8
+	}
+	o.loadTime.Store(avg)
+}
+
+// write forwards writes according to the write mode: at once, as a single
+// batch, or queued for write-behind. Callers must check that the origin has
+// a Writer.
+func (o *origin) write(writes ...OriginWrite) error {
+	if o.Mode == WriteBehind {
+		for _, w := range writes {
+			o.enqueue(w)
+		}
+		return nil
+	}
+	if err := o.send(writes); err != nil {
+		return fmt.Errorf("%w: %v", ErrOriginWrite, err)
+	}
+	return nil
+}
+
+// send writes a batch, retrying with exponential backoff.
+func (o *origin) send(writes []OriginWrite) error {
+	backoff := o.Backoff
+	var err error
+	for attempt := 0; attempt <= o.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err = o.Writer.Write(context.Background(), writes); err == nil {
+			return nil
+		}
+		log.Printf("Origin write of %d keys failed (attempt %d): %v", len(writes), attempt+1, err)
+	}
+	return err
+}
+
+func (o *origin) enqueue(w OriginWrite) {
+	o.mu.Lock()
+	if _, queued := o.pending[w.Key]; !queued {
+		o.order = append(o.order, w.Key)
+	}
+	o.pending[w.Key] = w
+	full := len(o.order) >= o.BatchSize
+	o.mu.Unlock()
+
+	if full {
+		select {
+		case o.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (o *origin) flushLoop() {
+	defer close(o.done)
+	ticker := time.NewTicker(o.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-o.stop:
+			o.flushPending()
+			return
+		case <-ticker.C:
+		case <-o.flush:
+		}
+		o.flushPending()
+	}
+}
+
+// flushPending sends everything queued so far in batches of BatchSize.
+func (o *origin) flushPending() {
+	for {
+		o.mu.Lock()
+		n := min(len(o.order), o.BatchSize)
+		batch := make([]OriginWrite, n)
+		for i, key := range o.order[:n] {
+			batch[i] = o.pending[key]
+			delete(o.pending, key)
+		}
+		o.order = o.order[n:]
+		o.mu.Unlock()
+
+		if n == 0 {
+			return
+		}
+		if err := o.send(batch); err != nil {
+			log.Printf("Dropping %d write-behind writes after %d retries: %v", n, o.Retries, err)
+		}
+	}
+}
+
+// forwardWrite passes a client write of key to the origin, if it has a
+// Writer. Only raw and string values are forwarded.
+func (dc *DistributedCache) forwardWrite(key string, value interface{}, contentType string) error {
+	if dc.origin == nil || dc.origin.Writer == nil {
+		return nil
+	}
+	w := OriginWrite{Key: key, ContentType: contentType}
+	switch v := value.(type) {
+	case []byte:
+		w.Value = v
+	case string:
+		w.Value = []byte(v)
+		if w.ContentType == "" {
+			w.ContentType = fiber.MIMETextPlainCharsetUTF8
+		}
+	default:
+		return nil
+	}
+	return dc.forwardWrites([]OriginWrite{w})
+}
+
+func (dc *DistributedCache) forwardDelete(key string) error {
+	return dc.forwardWrites([]OriginWrite{{Key: key, Delete: true}})
+}
+
+// forwardWrites passes client writes to the origin, if it has a Writer.
+func (dc *DistributedCache) forwardWrites(writes []OriginWrite) error {
+	if dc.origin == nil || dc.origin.Writer == nil || len(writes) == 0 {
+		return nil
+	}
+	return dc.origin.write(writes...)
+}
+
+// ####################################################################################################
+// ####################################################################################################
+// #####################################   HTTP origin   ##############################################
+// ####################################################################################################
+// ####################################################################################################
+
+// HTTPOrigin is a Loader and Writer for a backing store reached over HTTP.
+// Keys map to URLs under BaseURL: a miss is a GET, and writes are PUTs and
+// DELETEs. A Cache-Control max-age on a GET response sets the TTL. With a
+// stale-while-revalidate as well, max-age sets the soft TTL and the value
+// expires once both have passed.
+type HTTPOrigin struct {
+	BaseURL string
+	Client  *http.Client // Defaults to a client with a 10 second timeout
+}
+
+var defaultOriginClient = &http.Client{Timeout: 10 * time.Second}
+
+func (o *HTTPOrigin) client() *http.Client {
+	if o.Client != nil {
+		return o.Client
+	}
+	return defaultOriginClient
+}
+
+func (o *HTTPOrigin) url(key string) string {
+	return strings.TrimSuffix(o.BaseURL, "/") + "/" + url.PathEscape(key)
+}
+
+func (o *HTTPOrigin) Load(ctx context.Context, key string) (Loaded, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.url(key), nil)
+	if err != nil {
+		return Loaded{}, err
+	}
+	resp, err := o.client().Do(req)
+	if err != nil {
+		return Loaded{}, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return Loaded{}, ErrNotFound
+	case resp.StatusCode != http.StatusOK:
+		return Loaded{}, fmt.Errorf("origin answered %s", resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Loaded{}, err
+	}
+	loaded := Loaded{
+		Value:       data,
+		ContentType: resp.Header.Get(fiber.HeaderContentType),
+	}
+	directives := cacheControl(resp.Header.Get(fiber.HeaderCacheControl))
+	loaded.TTL = directives["max-age"]
+	if swr := directives["stale-while-revalidate"]; swr > 0 && loaded.TTL > 0 {
+		loaded.SoftTTL = loaded.TTL
+		loaded.TTL += swr
+	}
+	return loaded, nil
+}
+
+// Write sends each write as its own request, stopping at the first failure.
+func (o *HTTPOrigin) Write(ctx context.Context, writes []OriginWrite) error {
+	for _, w := range writes {
+		method := http.MethodPut
+		var body io.Reader = bytes.NewReader(w.Value)
+		if w.Delete {
+			method, body = http.MethodDelete, nil
+		}
+		req, err := http.NewRequestWithContext(ctx, method, o.url(w.Key), body)
+		if err != nil {
+			return err
+		}
+		if w.ContentType != "" {
+			req.Header.Set(fiber.HeaderContentType, w.ContentType)
+		}
+		resp, err := o.client().Do(req)
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode >= 300 && !(w.Delete && resp.StatusCode == http.StatusNotFound) {
+			return fmt.Errorf("%s %s: origin answered %s", method, w.Key, resp.Status)
+		}
+	}
+	return nil
+}
+
+// cacheControl returns the positive durations in a Cache-Control header, such
+// as max-age, by lower-case directive name.
+func cacheControl(header string) map[string]time.Duration {
+	directives := make(map[string]time.Duration)
+	for _, directive := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			directives[strings.ToLower(name)] = time.Duration(seconds) * time.Second
+		}
+	}
+	return directives
+}
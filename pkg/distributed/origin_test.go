@@ -0,0 +1,245 @@
+package distributed
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/notlelouch/Distributed-Cache/pkg/cache"
+)
+
+// stubOrigin is an HTTP backing store that records the requests it gets.
+type stubOrigin struct {
+	mu       sync.Mutex
+	values   map[string]string
+	requests []string
+	fail     bool
+	delay    time.Duration // How long each request takes
+	control  string        // Cache-Control of GET responses
+}
+
+func (o *stubOrigin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	time.Sleep(o.delay)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	o.requests = append(o.requests, r.Method+" "+key)
+	if o.fail {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		value, ok := o.values[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		control := o.control
+		if control == "" {
+			control = "public, max-age=60"
+		}
+		w.Header().Set("Cache-Control", control)
+		io.WriteString(w, value)
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		o.values[key] = string(body)
+	case http.MethodDelete:
+		delete(o.values, key)
+	}
+}
+
+func (o *stubOrigin) count(request string) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n := 0
+	for _, r := range o.requests {
+		if r == request {
+			n++
+		}
+	}
+	return n
+}
+
+func TestReadThroughAndWriteThrough(t *testing.T) {
+	stub := &stubOrigin{values: map[string]string{"user:1": "alice"}}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	dc := startTestNode(t, "origin", 7958, 8958)
+	httpOrigin := &HTTPOrigin{BaseURL: server.URL}
+	dc.SetOrigin(OriginOptions{Loader: httpOrigin, Writer: httpOrigin, Retries: 1, Backoff: time.Millisecond})
+
+	// A miss is loaded from the origin once and then served from the cache
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get("http://127.0.0.1:8958/cache/user:1")
+		if err != nil {
+			t.Fatalf("GET failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != "alice" || resp.Header.Get("Content-Type") != "text/plain" {
+			t.Errorf("Expected alice from the origin, got %d %q", resp.StatusCode, body)
+		}
+	}
+	if n := stub.count("GET user:1"); n != 1 {
+		t.Errorf("Expected one origin load, got %d", n)
+	}
+	if ttl, _ := dc.Cache.TTL("user:1"); ttl <= 50*time.Second || ttl > time.Minute {
+		t.Errorf("Expected the max-age to set the TTL, got %v", ttl)
+	}
+	resp, _ := http.Get("http://127.0.0.1:8958/cache/user:2")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for a key the origin doesn't have, got %d", resp.StatusCode)
+	}
+
+	// Writes reach the origin before they are acknowledged
+	put := func(key, value string) int {
+		req, _ := http.NewRequest("PUT", "http://127.0.0.1:8958/cache/"+key+"?ttl=60", strings.NewReader(value))
+		req.Header.Set("Content-Type", "text/plain")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("PUT failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := put("user:3", "carol"); status != http.StatusOK {
+		t.Fatalf("Expected PUT to succeed, got %d", status)
+	}
+	stub.mu.Lock()
+	stored := stub.values["user:3"]
+	stub.mu.Unlock()
+	if stored != "carol" {
+		t.Errorf("Expected the write to reach the origin, got %q", stored)
+	}
+
+	req, _ := http.NewRequest("DELETE", "http://127.0.0.1:8958/cache/user:3", nil)
+	resp, _ = http.DefaultClient.Do(req)
+	resp.Body.Close()
+	if stub.count("DELETE user:3") != 1 {
+		t.Error("Expected the delete to reach the origin")
+	}
+
+	// A failed write is retried, reported and not cached
+	stub.mu.Lock()
+	stub.fail = true
+	stub.mu.Unlock()
+	if status := put("user:4", "dave"); status != http.StatusBadGateway {
+		t.Errorf("Expected 502 when the origin fails, got %d", status)
+	}
+	if n := stub.count("PUT user:4"); n != 2 {
+		t.Errorf("Expected the write to be tried twice, got %d", n)
+	}
+	if _, found := dc.Cache.Get("user:4"); found {
+		t.Error("Expected a write the origin rejected not to be cached")
+	}
+}
+
+// recordingWriter collects the batches it is given.
+type recordingWriter struct {
+	mu      sync.Mutex
+	batches [][]OriginWrite
+}
+
+func (w *recordingWriter) Write(ctx context.Context, writes []OriginWrite) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.batches = append(w.batches, writes)
+	return nil
+}
+
+func (w *recordingWriter) snapshot() [][]OriginWrite {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([][]OriginWrite(nil), w.batches...)
+}
+
+func TestWriteBehind(t *testing.T) {
+	dc := startTestNode(t, "writebehind", 7959, 8959)
+	writer := &recordingWriter{}
+	dc.SetOrigin(OriginOptions{Writer: writer, Mode: WriteBehind, BatchSize: 3, FlushInterval: time.Hour})
+
+	dc.Set("a", "1", 0, cache.SetOptions{}, cache.Precondition{})
+	dc.Set("b", "2", 0, cache.SetOptions{}, cache.Precondition{})
+	dc.Set("a", "3", 0, cache.SetOptions{}, cache.Precondition{})
+	if len(writer.snapshot()) != 0 {
+		t.Fatal("Expected writes to be held until the batch is full")
+	}
+	dc.Delete("c", cache.Precondition{})
+
+	deadline := time.Now().Add(time.Second)
+	for len(writer.snapshot()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	batches := writer.snapshot()
+	if len(batches) != 1 || len(batches[0]) != 3 {
+		t.Fatalf("Expected one batch of 3 keys, got %+v", batches)
+	}
+	if a := batches[0][0]; a.Key != "a" || string(a.Value) != "3" {
+		t.Errorf("Expected only the latest write of a, got %+v", a)
+	}
+	if c := batches[0][2]; c.Key != "c" || !c.Delete {
+		t.Errorf("Expected the delete of c, got %+v", c)
+	}
+
+	// Closing flushes what is left
+	dc.Set("d", "4", 0, cache.SetOptions{}, cache.Precondition{})
+	dc.CloseOrigin()
+	if batches := writer.snapshot(); len(batches) != 2 || batches[1][0].Key != "d" {
+		t.Errorf("Expected the last write to be flushed on close, got %+v", batches)
+	}
+}
+
+func TestShardedWritesReachOrigin(t *testing.T) {
+	stub := &stubOrigin{values: map[string]string{}}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	nodes := []*DistributedCache{
+		startTestNode(t, "originshard1", 7974, 8974),
+		startTestNode(t, "originshard2", 7975, 8975),
+	}
+	for _, dc := range nodes {
+		dc.Sharded = true
+		httpOrigin := &HTTPOrigin{BaseURL: server.URL}
+		dc.SetOrigin(OriginOptions{Loader: httpOrigin, Writer: httpOrigin})
+	}
+	if err := nodes[1].JoinCluster("127.0.0.1:7974"); err != nil {
+		t.Fatalf("Failed to join cluster: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	// Find a key the first node doesn't own, so its writes are routed
+	key := ""
+	for i := 0; key == ""; i++ {
+		if owner, _ := nodes[0].Owner(fmt.Sprintf("k%d", i)); owner.Name == "originshard2" {
+			key = fmt.Sprintf("k%d", i)
+		}
+	}
+	req, _ := http.NewRequest("PUT", "http://127.0.0.1:8974/cache/"+key+"?ttl=60", strings.NewReader("v"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PUT failed: %v", err)
+	}
+	resp.Body.Close()
+	req, _ = http.NewRequest("DELETE", "http://127.0.0.1:8974/cache/"+key, nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("DELETE failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if stub.count("PUT "+key) != 1 || stub.count("DELETE "+key) != 1 {
+		t.Errorf("Expected the owner to forward each write once, got %v", stub.requests)
+	}
+}
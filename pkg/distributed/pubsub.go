@@ -0,0 +1,158 @@
+package distributed
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/hashicorp/memberlist"
+)
+
+// SubscriberBuffer is how many messages a subscriber may fall behind before
+// it is disconnected.
+const SubscriberBuffer = 1024
+
+// PubSubMessage is the data of one Server-Sent Event on a subscription.
+type PubSubMessage struct {
+	Channel string `json:"channel"`
+	Pattern string `json:"pattern,omitempty"`
+	Payload string `json:"payload"`
+}
+
+// Publish delivers payload to the subscribers of channel on every node and
+// returns how many subscribers on this node received it. Unlike cache
+// writes, messages go to every node even when the cluster is sharded.
+func (dc *DistributedCache) Publish(channel string, payload []byte) int {
+	receivers := dc.PubSub.Publish(channel, payload)
+	dc.notifyPeers(SyncPayload{
+		Method: fiber.MethodPost,
+		Key:    channel,
+		IsSync: true,
+		Op:     "publish",
+		Data:   payload,
+	})
+	return receivers
+}
+
+// notifyPeers sends payload to every other node, over the binary transport
+// where a peer has one and as a memberlist message otherwise.
+func (dc *DistributedCache) notifyPeers(payload SyncPayload) {
+	body := encodeSyncPayload(payload)
+	pool := dc.pool()
+
+	nodes := make(map[string]*memberlist.Node)
+	for _, node := range dc.List.Members() {
+		nodes[node.Name] = node
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ReplicationTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, member := range dc.peers() {
+		wg.Add(1)
+		go func(member Member) {
+			defer wg.Done()
+			var err error
+			if pool != nil && member.ReplicationPort != 0 {
+				addr := net.JoinHostPort(member.Addr, strconv.Itoa(member.ReplicationPort))
+				_, err = pool.Call(ctx, addr, body)
+			} else if node, ok := nodes[member.Name]; ok {
+				err = dc.List.SendReliable(node, body)
+			}
+			if err != nil {
+				log.Printf("Failed to notify %s: %v", member.Name, err)
+			}
+		}(member)
+	}
+	wg.Wait()
+}
+
+// handleClusterMessage applies a message another node sent through
+// memberlist.
+func (dc *DistributedCache) handleClusterMessage(msg []byte) {
+	payload, err := decodeSyncPayload(msg)
+	if err != nil {
+		log.Printf("Dropping invalid cluster message: %v", err)
+		return
+	}
+	if err := dc.applySync(payload); err != nil {
+		log.Printf("Failed to apply cluster message: %v", err)
+	}
+}
+
+// HandlePublish publishes the request body to :channel on every node:
+// POST /pubsub/:channel. It answers with the number of subscribers on this
+// node that received it.
+func (dc *DistributedCache) HandlePublish(c *fiber.Ctx) error {
+	channel := strings.Clone(c.Params("channel"))
+	payload := append([]byte{}, c.Body()...)
+
+	receivers := dc.Publish(channel, payload)
+	return c.JSON(fiber.Map{"receivers": receivers})
+}
+
+// HandleSubscribe streams the messages published to the channel and pattern
+// query parameters (each may be repeated) as Server-Sent Events:
+// GET /pubsub?channel=news&pattern=alerts.*
+func (dc *DistributedCache) HandleSubscribe(c *fiber.Ctx) error {
+	var channels, patterns []string
+	for _, v := range c.Context().QueryArgs().PeekMulti("channel") {
+		channels = append(channels, string(v))
+	}
+	for _, v := range c.Context().QueryArgs().PeekMulti("pattern") {
+		patterns = append(patterns, string(v))
+	}
+	if len(channels) == 0 && len(patterns) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "At least one channel or pattern is required",
+		})
+	}
+
+	sub := dc.PubSub.Subscribe(SubscriberBuffer)
+	sub.Subscribe(channels...)
+	sub.PSubscribe(patterns...)
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer sub.Close()
+		keepAlive := time.NewTicker(EventKeepAlive)
+		defer keepAlive.Stop()
+
+		fmt.Fprint(w, ": subscribed\n\n")
+		if err := w.Flush(); err != nil {
+			return
+		}
+		for {
+			select {
+			case msg, ok := <-sub.C:
+				if !ok {
+					fmt.Fprint(w, "event: lagged\ndata: {}\n\n")
+					w.Flush()
+					return
+				}
+				data, _ := json.Marshal(PubSubMessage{
+					Channel: msg.Channel,
+					Pattern: msg.Pattern,
+					Payload: string(msg.Payload),
+				})
+				fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keepalive\n\n")
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+	return nil
+}
@@ -0,0 +1,112 @@
+package distributed
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/notlelouch/Distributed-Cache/pkg/pubsub"
+)
+
+func receive(t *testing.T, sub *pubsub.Subscription) pubsub.Message {
+	t.Helper()
+	select {
+	case msg := <-sub.C:
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Timed out waiting for a message")
+		return pubsub.Message{}
+	}
+}
+
+func TestPublishReachesEveryNode(t *testing.T) {
+	// dc1 and dc2 talk over the binary transport, dc3 only through memberlist
+	dc1, dc2 := startReplicatedPair(t, 7941)
+	dc3 := startTestNode(t, "pubsub3", 7943, 8943)
+	if err := dc3.JoinCluster("127.0.0.1:7941"); err != nil {
+		t.Fatalf("Failed to join cluster: %v", err)
+	}
+
+	sub2 := dc2.PubSub.Subscribe(8)
+	sub2.Subscribe("news")
+	sub3 := dc3.PubSub.Subscribe(8)
+	sub3.PSubscribe("news*")
+	local := dc1.PubSub.Subscribe(8)
+	local.Subscribe("news")
+
+	if n := dc1.Publish("news", []byte("hello")); n != 1 {
+		t.Errorf("Expected one local receiver, got %d", n)
+	}
+	if msg := receive(t, local); string(msg.Payload) != "hello" {
+		t.Errorf("Unexpected local message %+v", msg)
+	}
+	if msg := receive(t, sub2); msg.Channel != "news" || string(msg.Payload) != "hello" {
+		t.Errorf("Unexpected message over the binary transport %+v", msg)
+	}
+	if msg := receive(t, sub3); msg.Pattern != "news*" || string(msg.Payload) != "hello" {
+		t.Errorf("Unexpected message over memberlist %+v", msg)
+	}
+
+	// A node without a replication port publishes through memberlist
+	if n := dc3.Publish("news", []byte("from3")); n != 1 {
+		t.Errorf("Expected one local receiver on dc3, got %d", n)
+	}
+	if msg := receive(t, local); string(msg.Payload) != "from3" {
+		t.Errorf("Expected dc1 to receive dc3's message, got %+v", msg)
+	}
+	if msg := receive(t, sub2); string(msg.Payload) != "from3" {
+		t.Errorf("Expected dc2 to receive dc3's message, got %+v", msg)
+	}
+}
+
+func TestSubscribeOverSSE(t *testing.T) {
+	EventKeepAlive = 50 * time.Millisecond
+	startTestNode(t, "sse1", 7944, 8944)
+	dc2 := startTestNode(t, "sse2", 7945, 8945)
+	if err := dc2.JoinCluster("127.0.0.1:7944"); err != nil {
+		t.Fatalf("Failed to join cluster: %v", err)
+	}
+
+	if resp, err := http.Get("http://127.0.0.1:8945/pubsub"); err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 without channels, got %v %v", resp, err)
+	}
+
+	resp, err := http.Get("http://127.0.0.1:8945/pubsub?channel=orders&pattern=alerts.*")
+	if err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+	defer resp.Body.Close()
+	r := bufio.NewReader(resp.Body)
+	r.ReadString('\n') // ": subscribed"
+
+	post, err := http.Post("http://127.0.0.1:8944/pubsub/ignored", "text/plain", strings.NewReader("x"))
+	if err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+	post.Body.Close()
+	http.Post("http://127.0.0.1:8944/pubsub/alerts.disk", "text/plain", strings.NewReader("full"))
+	http.Post("http://127.0.0.1:8944/pubsub/orders", "text/plain", strings.NewReader("42"))
+
+	var got []PubSubMessage
+	for len(got) < 2 {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read stream after %v: %v", got, err)
+		}
+		if data, ok := strings.CutPrefix(strings.TrimSpace(line), "data: "); ok {
+			var msg PubSubMessage
+			json.Unmarshal([]byte(data), &msg)
+			got = append(got, msg)
+		}
+	}
+	// Each memberlist message travels on its own stream, so order may differ
+	sort.Slice(got, func(i, j int) bool { return got[i].Channel < got[j].Channel })
+	if got[0] != (PubSubMessage{Channel: "alerts.disk", Pattern: "alerts.*", Payload: "full"}) ||
+		got[1] != (PubSubMessage{Channel: "orders", Payload: "42"}) {
+		t.Errorf("Unexpected messages %+v", got)
+	}
+}
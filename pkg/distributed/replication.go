@@ -0,0 +1,280 @@
+package distributed
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/hashicorp/memberlist"
+	"github.com/notlelouch/Distributed-Cache/pkg/cache"
+	"github.com/notlelouch/Distributed-Cache/pkg/transport"
+)
+
+// ReplicationTimeout bounds how long a write waits for each peer to
+// acknowledge it over the binary transport.
+var ReplicationTimeout = 2 * time.Second
+
+// replicationConnsPerPeer is how many multiplexed connections are kept open
+// to every peer.
+const replicationConnsPerPeer = 2
+
+// StartReplication serves the binary replication protocol on port and starts
+// sending this node's writes to peers over it. The port is advertised in the
+// node metadata; peers that do not advertise one are still reached over HTTP.
+func (dc *DistributedCache) StartReplication(port int) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", dc.Config.BindAddr, port))
+	if err != nil {
+		return err
+	}
+	server := transport.NewServer(dc.handleReplicated)
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			log.Printf("Replication listener stopped: %v", err)
+		}
+	}()
+
+	dc.mu.Lock()
+	dc.replicationServer = server
+	dc.replicationPool = transport.NewPool(replicationConnsPerPeer)
+	dc.mu.Unlock()
+
+	delegate, ok := dc.Config.Delegate.(*cacheDelegate)
+	if !ok {
+		return nil
+	}
+	delegate.setReplicationPort(port)
+	dc.mu.Lock()
+	dc.Meta = delegate.NodeMeta(memberlist.MetaMaxSize)
+	dc.mu.Unlock()
+	// Gossip the new metadata so peers switch to the binary transport
+	return dc.List.UpdateNode(time.Second)
+}
+
+// StopReplication closes the replication listener and every pooled
+// connection to peers.
+func (dc *DistributedCache) StopReplication() {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	if dc.replicationServer != nil {
+		dc.replicationServer.Close()
+		dc.replicationServer = nil
+	}
+	if dc.replicationPool != nil {
+		dc.replicationPool.Close()
+		dc.replicationPool = nil
+	}
+}
+
+// peerEvents closes the pooled replication connections to members that
+// leave the cluster, which would otherwise stay open until the pool closes.
+type peerEvents struct {
+	dc *DistributedCache
+}
+
+func (e peerEvents) NotifyJoin(node *memberlist.Node)   {}
+func (e peerEvents) NotifyUpdate(node *memberlist.Node) {}
+
+func (e peerEvents) NotifyLeave(node *memberlist.Node) {
+	var meta NodeMetadata
+	if err := json.Unmarshal(node.Meta, &meta); err != nil || meta.ReplicationPort == 0 {
+		return
+	}
+	if pool := e.dc.pool(); pool != nil {
+		pool.Evict(net.JoinHostPort(node.Addr.String(), strconv.Itoa(meta.ReplicationPort)))
+	}
+}
+
+func (dc *DistributedCache) pool() *transport.Pool {
+	dc.mu.RLock()
+	defer dc.mu.RUnlock()
+	return dc.replicationPool
+}
+
+// replicateBinary sends payload to every peer concurrently and waits for
+// their acknowledgements.
+func (dc *DistributedCache) replicateBinary(pool *transport.Pool, peers []Member, payload SyncPayload) {
+	body := encodeSyncPayload(payload)
+	ctx, cancel := context.WithTimeout(context.Background(), ReplicationTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, member := range peers {
+		wg.Add(1)
+		go func(member Member) {
+			defer wg.Done()
+			addr := net.JoinHostPort(member.Addr, strconv.Itoa(member.ReplicationPort))
+			if _, err := pool.Call(ctx, addr, body); err != nil {
+				log.Printf("Failed to sync with %s over binary transport: %v", member.Name, err)
+			}
+		}(member)
+	}
+	wg.Wait()
+}
+
+// handleReplicated applies a write received over the binary transport. It
+// mirrors what the HTTP handlers do for requests marked X-Is-Sync.
+func (dc *DistributedCache) handleReplicated(body []byte) ([]byte, error) {
+	payload, err := decodeSyncPayload(body)
+	if err != nil {
+		return nil, err
+	}
+	return nil, dc.applySync(payload)
+}
+
+func (dc *DistributedCache) applySync(payload SyncPayload) error {
+	switch {
+	case payload.Op == "publish":
+		dc.PubSub.Publish(payload.Key, payload.Data)
+
+	case payload.Op == "invalidate-tag":
+		dc.Cache.InvalidateTag(payload.Key)
+
+	case payload.Op == "expire":
+		duration, err := ParseTTL(payload.TTL)
+		if err != nil {
+			return err
+		}
+		dc.Cache.Expire(payload.Key, duration)
+
+	case cache.IsCollectionOp(payload.Op):
+		var duration time.Duration
+		if payload.TTL != "" {
+			var err error
+			if duration, err = ParseTTL(payload.TTL); err != nil {
+				return err
+			}
+		}
+		op := cache.Op{Name: payload.Op, Args: payload.Args}
+		origin := cache.OpOrigin{Node: payload.OpNode, Seq: payload.OpSeq, Version: payload.Version}
+		_, _, err := dc.Cache.Apply(payload.Key, op, duration, origin)
+		return err
+
+	case payload.Counter != nil:
+		var duration time.Duration
+		if payload.TTL != "" {
+			var err error
+			if duration, err = ParseTTL(payload.TTL); err != nil {
+				return err
+			}
+		}
+		dc.Cache.MergeCounter(payload.Key, payload.Counter, duration)
+
+	case payload.Method == fiber.MethodPut && payload.TTL != "":
+		duration, err := ParseTTL(payload.TTL)
+		if err != nil {
+			return err
+		}
+		opts := cache.SetOptions{
+			ContentType: payload.ContentType,
+			Version:     payload.Version,
+			Flags:       payload.Flags,
+			Tags:        payload.Tags,
+		}
+		if opts.SoftTTL, err = parseSoftTTL(payload.SoftTTL); err != nil {
+			return err
+		}
+		_, err = dc.store(payload.Key, payload.Data, duration, opts, cache.Precondition{})
+		return err
+
+	case payload.Method == fiber.MethodPut:
+		duration, err := strconv.ParseInt(payload.Duration, 10, 64)
+		if err != nil {
+			return err
+		}
+		opts := cache.SetOptions{Version: payload.Version, Tags: payload.Tags}
+		if opts.SoftTTL, err = parseSoftTTL(payload.SoftTTL); err != nil {
+			return err
+		}
+		_, err = dc.store(payload.Key, payload.Value, time.Duration(duration), opts, cache.Precondition{})
+		return err
+
+	case payload.Method == fiber.MethodDelete:
+		dc.Cache.Delete(payload.Key)
+	}
+	return nil
+}
+
+// parseSoftTTL parses the optional soft TTL of a replicated PUT.
+func parseSoftTTL(softTTL string) (time.Duration, error) {
+	if softTTL == "" {
+		return 0, nil
+	}
+	return ParseTTL(softTTL)
+}
+
+// ####################################################   Wire format   ##############################################
+
+// Sync payloads are encoded as a format version byte followed by every field
+// in declaration order. Strings and byte slices are uvarint length prefixed,
+// integers are uvarints, the counter is a presence byte followed by its P and
+// N maps, and collection op arguments and tags are each a count followed by
+// the strings.
+const syncPayloadFormat = 5
+
+func encodeSyncPayload(p SyncPayload) []byte {
+	size := 32 + len(p.Method) + len(p.Key) + len(p.Value) + len(p.Duration) +
+		len(p.Data) + len(p.ContentType) + len(p.TTL) + len(p.Op) + len(p.SoftTTL) + len(p.OpNode)
+	buf := make([]byte, 0, size)
+
+	buf = append(buf, syncPayloadFormat)
+	buf = cache.AppendString(buf, p.Method)
+	buf = cache.AppendString(buf, p.Key)
+	buf = cache.AppendString(buf, p.Value)
+	buf = cache.AppendString(buf, p.Duration)
+	buf = cache.AppendString(buf, string(p.Data))
+	buf = cache.AppendString(buf, p.ContentType)
+	buf = cache.AppendString(buf, p.TTL)
+	buf = binary.AppendUvarint(buf, p.Version)
+	buf = binary.AppendUvarint(buf, uint64(p.Flags))
+	buf = cache.AppendString(buf, p.Op)
+	if p.Counter == nil {
+		buf = append(buf, 0)
+	} else {
+		buf = append(buf, 1)
+		buf = cache.AppendCounts(buf, p.Counter.P)
+		buf = cache.AppendCounts(buf, p.Counter.N)
+	}
+	buf = cache.AppendStrings(buf, p.Args)
+	buf = cache.AppendStrings(buf, p.Tags)
+	buf = cache.AppendString(buf, p.SoftTTL)
+	buf = cache.AppendString(buf, p.OpNode)
+	return binary.AppendUvarint(buf, p.OpSeq)
+}
+
+func decodeSyncPayload(buf []byte) (SyncPayload, error) {
+	d := cache.NewDecoder(buf)
+	if format := d.Byte(); d.Err() == nil && format != syncPayloadFormat {
+		return SyncPayload{}, fmt.Errorf("replication: unknown payload format %d", format)
+	}
+
+	p := SyncPayload{IsSync: true}
+	p.Method = d.Str()
+	p.Key = d.Str()
+	p.Value = d.Str()
+	p.Duration = d.Str()
+	if data := d.Bytes(); len(data) > 0 {
+		p.Data = data
+	}
+	p.ContentType = d.Str()
+	p.TTL = d.Str()
+	p.Version = d.Uvarint()
+	p.Flags = uint32(d.Uvarint())
+	p.Op = d.Str()
+	if d.Byte() == 1 {
+		p.Counter = &cache.PNCounter{P: d.Counts(), N: d.Counts()}
+	}
+	p.Args = d.Strings()
+	p.Tags = d.Strings()
+	p.SoftTTL = d.Str()
+	p.OpNode = d.Str()
+	p.OpSeq = d.Uvarint()
+	return p, d.Err()
+}
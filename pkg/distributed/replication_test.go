@@ -0,0 +1,144 @@
+package distributed
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/notlelouch/Distributed-Cache/pkg/cache"
+)
+
+func TestSyncPayloadRoundTrip(t *testing.T) {
+	counter := cache.NewPNCounter()
+	counter.Add("node1", 5)
+	counter.Add("node2", -2)
+
+	payloads := []SyncPayload{
+		{Method: "PUT", Key: "k", Value: "v", Duration: "1000", IsSync: true, Version: 42},
+		{Method: "PUT", Key: "img", Data: []byte{0, 1, 2}, ContentType: "image/png", TTL: "1m0s", IsSync: true, Version: 7, Flags: 3, Tags: []string{"a", "b"}, SoftTTL: "30s"},
+		{Method: "DELETE", Key: "k", IsSync: true},
+		{Method: "POST", Key: "hits", Op: "incr", Counter: counter, IsSync: true},
+		{Method: "POST", Key: "q", Op: "rpush", Args: []string{"a", ""}, TTL: "1m0s", IsSync: true, Version: 9, OpNode: "node1", OpSeq: 1 << 60},
+	}
+	for _, want := range payloads {
+		got, err := decodeSyncPayload(encodeSyncPayload(want))
+		if err != nil {
+			t.Fatalf("Failed to decode %+v: %v", want, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Round trip mismatch:\nwant %+v\n got %+v", want, got)
+		}
+	}
+
+	encoded := encodeSyncPayload(payloads[1])
+	if _, err := decodeSyncPayload(encoded[:len(encoded)-3]); err == nil {
+		t.Errorf("Expected truncated payload to fail to decode")
+	}
+}
+
+func startReplicatedPair(t testing.TB, basePort int) (*DistributedCache, *DistributedCache) {
+	t.Helper()
+
+	dc1 := startTestNode(t, "bin1", basePort, basePort+1000)
+	dc2 := startTestNode(t, "bin2", basePort+1, basePort+1001)
+	for i, dc := range []*DistributedCache{dc1, dc2} {
+		if err := dc.StartReplication(basePort + 2000 + i); err != nil {
+			t.Fatalf("Failed to start replication: %v", err)
+		}
+		t.Cleanup(dc.StopReplication)
+	}
+	if err := dc2.JoinCluster(dc1.List.LocalNode().Address()); err != nil {
+		t.Fatalf("Failed to join cluster: %v", err)
+	}
+	return dc1, dc2
+}
+
+func TestBinaryReplication(t *testing.T) {
+	dc1, dc2 := startReplicatedPair(t, 7990)
+
+	peers := dc1.peers()
+	if len(peers) != 1 || peers[0].ReplicationPort != 9991 {
+		t.Fatalf("Expected peer to advertise replication port 9991, got %+v", peers)
+	}
+
+	item, err := dc1.Set("greeting", "hello", time.Minute, cache.SetOptions{}, cache.Precondition{})
+	if err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	replica, found := dc2.Cache.GetItem("greeting")
+	if !found || replica.Value != "hello" || replica.Version != item.Version {
+		t.Errorf("Expected replica of version %d, got %+v (found: %v)", item.Version, replica, found)
+	}
+
+	dc2.Set("blob", []byte{0xff, 0x00}, time.Minute, cache.SetOptions{ContentType: "application/x-test", Flags: 9}, cache.Precondition{})
+	replica, found = dc1.Cache.GetItem("blob")
+	if !found || replica.ContentType != "application/x-test" || replica.Flags != 9 {
+		t.Errorf("Expected raw value to replicate with metadata, got %+v", replica)
+	}
+
+	dc1.Incr("hits", 3, 0)
+	dc2.Incr("hits", 4, 0)
+	if v, _ := dc1.Incr("hits", 0, 0); v != 7 {
+		t.Errorf("Expected counters to converge on 7, got %d", v)
+	}
+
+	dc1.Expire("greeting", time.Hour)
+	if ttl, _ := dc2.Cache.TTL("greeting"); ttl < 59*time.Minute {
+		t.Errorf("Expected TTL change to replicate, got %v", ttl)
+	}
+
+	dc2.Delete("greeting", cache.Precondition{})
+	if _, found := dc1.Cache.Get("greeting"); found {
+		t.Errorf("Expected delete to replicate")
+	}
+}
+
+// BenchmarkReplicationHTTP and BenchmarkReplicationBinary measure a replicated
+// Set to one peer over each path.
+func BenchmarkReplicationHTTP(b *testing.B) {
+	dc1 := startTestNode(b, "benchhttp1", 7996, 8996)
+	dc2 := startTestNode(b, "benchhttp2", 7997, 8997)
+	if err := dc2.JoinCluster("127.0.0.1:7996"); err != nil {
+		b.Fatalf("Failed to join cluster: %v", err)
+	}
+	benchmarkReplicatedSet(b, dc1)
+}
+
+func BenchmarkReplicationBinary(b *testing.B) {
+	dc1, _ := startReplicatedPair(b, 7998)
+	benchmarkReplicatedSet(b, dc1)
+}
+
+func benchmarkReplicatedSet(b *testing.B, dc *DistributedCache) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			dc.Set("bench", "value", time.Minute, cache.SetOptions{}, cache.Precondition{})
+		}
+	})
+}
+
+func TestBinaryReplicationClearsNegativeEntries(t *testing.T) {
+	dc1, dc2 := startReplicatedPair(t, 7912)
+	dc2.SetOrigin(OriginOptions{Loader: &HTTPOrigin{BaseURL: "http://127.0.0.1:1"}, NegativeTTL: time.Minute})
+
+	// A write replicated over the binary transport means the key exists now
+	dc2.origin.missing("ghost")
+	if _, err := dc1.Set("ghost", "boo", time.Minute, cache.SetOptions{}, cache.Precondition{}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if dc2.origin.knownMissing("ghost") {
+		t.Error("Expected a binary replicated PUT to clear the negative entry")
+	}
+
+	// So does one replicated as part of a batch
+	dc2.origin.missing("bulk")
+	postBatch(t, 8912, "_mput", BatchRequest{Items: []BatchItem{{Key: "bulk", Value: "x"}}})
+	if _, found := dc2.Cache.Get("bulk"); !found {
+		t.Fatal("Expected the batch to be replicated")
+	}
+	if dc2.origin.knownMissing("bulk") {
+		t.Error("Expected a replicated _mput to clear the negative entry")
+	}
+}
@@ -0,0 +1,111 @@
+package distributed
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// MaxScanLimit caps the page size of a key listing.
+const MaxScanLimit = 1000
+
+// ScanResponse is one page of a key listing. Cursor is empty on the last page.
+type ScanResponse struct {
+	Keys   []string `json:"keys"`
+	Cursor string   `json:"cursor"`
+}
+
+// HandleScan lists keys page by page: GET /cache?prefix=&cursor=&limit=.
+// Pass the returned cursor to fetch the next page until it comes back empty.
+// In sharded mode every node is scanned and the results are merged.
+func (dc *DistributedCache) HandleScan(c *fiber.Ctx) error {
+	prefix := strings.Clone(c.Query("prefix"))
+	cursor, err := decodeCursor(c.Query("cursor"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid cursor",
+		})
+	}
+	limit, err := strconv.Atoi(c.Query("limit", "100"))
+	if err != nil || limit <= 0 || limit > MaxScanLimit {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("limit must be between 1 and %d", MaxScanLimit),
+		})
+	}
+
+	var keys []string
+	var next string
+	if dc.Sharded && c.Get("X-Is-Sync") != "true" {
+		keys, next = dc.scanCluster(cursor, prefix, limit)
+	} else {
+		// Every node holds every key unless the cluster is sharded
+		keys, next = dc.Cache.Scan(cursor, prefix, limit)
+	}
+	return c.JSON(ScanResponse{Keys: keys, Cursor: encodeCursor(next)})
+}
+
+// scanCluster fetches one page from every node and merges them. Each node
+// returns its first limit keys after cursor, so the first limit keys of the
+// union are the first limit keys of the cluster.
+func (dc *DistributedCache) scanCluster(cursor, prefix string, limit int) ([]string, string) {
+	keys, next := dc.Cache.Scan(cursor, prefix, limit)
+	more := next != ""
+
+	query := url.Values{}
+	query.Set("prefix", prefix)
+	query.Set("cursor", encodeCursor(cursor))
+	query.Set("limit", strconv.Itoa(limit))
+	path := "/cache?" + query.Encode()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, member := range dc.peers() {
+		wg.Add(1)
+		go func(member Member) {
+			defer wg.Done()
+			var page ScanResponse
+			if err := dc.syncRequest(member, fiber.MethodGet, path, nil, &page); err != nil {
+				// A missing node's keys are skipped rather than failing the scan
+				log.Printf("Failed to scan %s: %v", member.Name, err)
+				return
+			}
+			mu.Lock()
+			keys = append(keys, page.Keys...)
+			more = more || page.Cursor != ""
+			mu.Unlock()
+		}(member)
+	}
+	wg.Wait()
+
+	sort.Strings(keys)
+	merged := keys[:0]
+	for i, key := range keys {
+		if i == 0 || key != keys[i-1] {
+			merged = append(merged, key)
+		}
+	}
+	if len(merged) > limit {
+		merged, more = merged[:limit], true
+	}
+	if !more || len(merged) == 0 {
+		return merged, ""
+	}
+	return merged, merged[len(merged)-1]
+}
+
+// Cursors are keys, which may hold any bytes, so they are encoded for URLs.
+func encodeCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeCursor(cursor string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(cursor)
+	return string(key), err
+}
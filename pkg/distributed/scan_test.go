@@ -0,0 +1,74 @@
+package distributed
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestScanAcrossShardedNodes(t *testing.T) {
+	nodes := []*DistributedCache{
+		startTestNode(t, "scan1", 7936, 8936),
+		startTestNode(t, "scan2", 7937, 8937),
+		startTestNode(t, "scan3", 7938, 8938),
+	}
+	for _, dc := range nodes {
+		dc.Sharded = true
+	}
+	for _, dc := range nodes[1:] {
+		if err := dc.JoinCluster("127.0.0.1:7936"); err != nil {
+			t.Fatalf("Failed to join cluster: %v", err)
+		}
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	var items []BatchItem
+	var want []string
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("user:%02d", i)
+		items = append(items, BatchItem{Key: key, Value: "x"})
+		want = append(want, key)
+	}
+	items = append(items, BatchItem{Key: "order:1", Value: "x"})
+	postBatch(t, 8936, "_mput", BatchRequest{Items: items})
+	sort.Strings(want)
+
+	var got []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatalf("Scan did not terminate, keys so far: %v", got)
+		}
+		resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:8937/cache?prefix=user:&limit=7&cursor=%s", url.QueryEscape(cursor)))
+		if err != nil {
+			t.Fatalf("Scan request failed: %v", err)
+		}
+		var page ScanResponse
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("Invalid scan response: %v", err)
+		}
+		got = append(got, page.Keys...)
+		if cursor = page.Cursor; cursor == "" {
+			break
+		}
+	}
+
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	resp, err := http.Get("http://127.0.0.1:8937/cache?cursor=!!")
+	if err != nil {
+		t.Fatalf("Scan request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a malformed cursor, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,79 @@
+package distributed
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/proxy"
+)
+
+// ErrShardedFrontend is returned by the Redis, memcached and gRPC listeners
+// when the cluster is sharded. Only the HTTP API routes keys to their owners,
+// so the others would keep keys on whichever node took the connection.
+var ErrShardedFrontend = errors.New("only the HTTP API can serve a sharded cluster")
+
+// Owner returns the member responsible for key when the cluster is sharded.
+// Ownership uses rendezvous hashing, so when a node joins or leaves only the
+// keys it owns move. It reports false if no member is known.
+func (dc *DistributedCache) Owner(key string) (Member, bool) {
+	return OwnerOf(key, dc.members())
+}
+
+// OwnerOf returns the member among members that owns key, as Owner does.
+// Clients that know the member list use it to reach the owner directly.
+func OwnerOf(key string, members []Member) (Member, bool) {
+	var (
+		owner Member
+		best  uint64
+		found bool
+	)
+	for _, member := range members {
+		if score := rendezvousScore(member.Name, key); !found || score > best {
+			owner, best, found = member, score, true
+		}
+	}
+	return owner, found
+}
+
+// rendezvousScore hashes node and key together with FNV-1a.
+func rendezvousScore(node, key string) uint64 {
+	const (
+		offset64 = 14695981039346656037
+		prime64  = 1099511628211
+	)
+	h := uint64(offset64)
+	for _, s := range []string{node, "\x00", key} {
+		for i := 0; i < len(s); i++ {
+			h ^= uint64(s[i])
+			h *= prime64
+		}
+	}
+	// Finalize so that similar inputs spread over the whole range
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	return h
+}
+
+// isLocal reports whether this node should handle key itself.
+func (dc *DistributedCache) isLocal(owner Member) bool {
+	return owner.Name == dc.Config.Name
+}
+
+// routeToOwner proxies a request for :key to the node that owns it when the
+// cluster is sharded. The owner receives it as a sync request, so it handles
+// the key locally and nothing is forwarded twice.
+func (dc *DistributedCache) routeToOwner(c *fiber.Ctx) error {
+	if !dc.Sharded || c.Get("X-Is-Sync") == "true" {
+		return c.Next()
+	}
+	owner, ok := dc.Owner(c.Params("key"))
+	if !ok || dc.isLocal(owner) {
+		return c.Next()
+	}
+
+	c.Request().Header.Set("X-Is-Sync", "true")
+	url := fmt.Sprintf("http://%s:%d%s", owner.Addr, owner.HTTPPort, c.OriginalURL())
+	return proxy.DoTimeout(c, url, ReplicationTimeout)
+}
@@ -0,0 +1,62 @@
+package distributed
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/notlelouch/Distributed-Cache/pkg/persist"
+)
+
+// Snapshots are per node: each endpoint acts on this node's cache only.
+
+// HandleListSnapshots lists this node's snapshots, newest first:
+// GET /admin/snapshots.
+func (dc *DistributedCache) HandleListSnapshots(c *fiber.Ctx) error {
+	if dc.Snapshots == nil {
+		return snapshotsDisabled(c)
+	}
+	infos, err := dc.Snapshots.List()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"snapshots": infos})
+}
+
+// HandleSnapshot takes a snapshot now: POST /admin/snapshots.
+func (dc *DistributedCache) HandleSnapshot(c *fiber.Ctx) error {
+	if dc.Snapshots == nil {
+		return snapshotsDisabled(c)
+	}
+	info, err := dc.Snapshots.Snapshot()
+	if err != nil {
+		log.Printf("Snapshot failed: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusCreated).JSON(info)
+}
+
+// HandleRestoreSnapshot replaces this node's cache with a snapshot:
+// POST /admin/snapshots/:name/restore. With ?rebase=true every item gets the
+// TTL it had left when the snapshot was taken instead of its original
+// deadline.
+func (dc *DistributedCache) HandleRestoreSnapshot(c *fiber.Ctx) error {
+	if dc.Snapshots == nil {
+		return snapshotsDisabled(c)
+	}
+	name := strings.Clone(c.Params("name"))
+	n, err := dc.Snapshots.Restore(name, persist.RestoreOptions{Rebase: c.QueryBool("rebase")})
+	if errors.Is(err, persist.ErrSnapshotNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Snapshot not found"})
+	}
+	if err != nil {
+		log.Printf("Restore of %s failed: %v", name, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"snapshot": name, "restored": n})
+}
+
+func snapshotsDisabled(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Snapshots are not enabled on this node"})
+}
@@ -0,0 +1,80 @@
+package distributed
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/notlelouch/Distributed-Cache/pkg/persist"
+)
+
+func TestSnapshotEndpoints(t *testing.T) {
+	dc := startTestNode(t, "snapshots", 7929, 8929)
+
+	resp, err := http.Post("http://127.0.0.1:8929/admin/snapshots", "", nil)
+	if err != nil {
+		t.Fatalf("POST /admin/snapshots failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 with snapshots disabled, got %d", resp.StatusCode)
+	}
+
+	snapshots, err := persist.NewSnapshotter(t.TempDir(), dc.Cache, persist.SnapshotOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create snapshotter: %v", err)
+	}
+	defer snapshots.Close()
+	dc.Snapshots = snapshots
+
+	dc.Cache.Set("kept", "v1", 0)
+	resp, err = http.Post("http://127.0.0.1:8929/admin/snapshots", "", nil)
+	if err != nil {
+		t.Fatalf("POST /admin/snapshots failed: %v", err)
+	}
+	var info persist.SnapshotInfo
+	json.NewDecoder(resp.Body).Decode(&info)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || info.Items != 1 {
+		t.Fatalf("Expected a snapshot of 1 item, got %d %+v", resp.StatusCode, info)
+	}
+
+	resp, err = http.Get("http://127.0.0.1:8929/admin/snapshots")
+	if err != nil {
+		t.Fatalf("GET /admin/snapshots failed: %v", err)
+	}
+	var list struct {
+		Snapshots []persist.SnapshotInfo `json:"snapshots"`
+	}
+	json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if len(list.Snapshots) != 1 || list.Snapshots[0].Name != info.Name {
+		t.Errorf("Expected the snapshot to be listed, got %+v", list.Snapshots)
+	}
+
+	dc.Cache.Set("kept", "v2", 0)
+	dc.Cache.Set("dropped", "x", 0)
+	resp, err = http.Post("http://127.0.0.1:8929/admin/snapshots/"+info.Name+"/restore", "", nil)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 from restore, got %d", resp.StatusCode)
+	}
+	if v, _ := dc.Cache.Get("kept"); v != "v1" {
+		t.Errorf("Expected kept to be restored to v1, got %v", v)
+	}
+	if _, found := dc.Cache.Get("dropped"); found {
+		t.Error("Expected dropped to be removed by the restore")
+	}
+
+	resp, err = http.Post("http://127.0.0.1:8929/admin/snapshots/snapshot-missing.dsnp/restore", "", nil)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown snapshot, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,144 @@
+package distributed
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/notlelouch/Distributed-Cache/pkg/cache"
+)
+
+func getBody(t *testing.T, url string) string {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	stub := &stubOrigin{values: map[string]string{"k": "v1"}, delay: 200 * time.Millisecond}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	dc := startTestNode(t, "stale", 7983, 8983)
+	dc.SetOrigin(OriginOptions{Loader: &HTTPOrigin{BaseURL: server.URL}, SoftTTL: 100 * time.Millisecond})
+
+	if body := getBody(t, "http://127.0.0.1:8983/cache/k"); body != "v1" {
+		t.Fatalf("Expected v1 from the origin, got %q", body)
+	}
+	time.Sleep(150 * time.Millisecond)
+	stub.mu.Lock()
+	stub.values["k"] = "v2"
+	stub.mu.Unlock()
+
+	// Stale reads are answered at once, and only one of them refreshes
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if body := getBody(t, "http://127.0.0.1:8983/cache/k"); body != "v1" {
+				t.Errorf("Expected the stale value while refreshing, got %q", body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	time.Sleep(300 * time.Millisecond)
+	if n := stub.count("GET k"); n != 2 {
+		t.Errorf("Expected a single refresh, got %d loads", n)
+	}
+	if body := getBody(t, "http://127.0.0.1:8983/cache/k"); body != "v2" {
+		t.Errorf("Expected the refreshed value, got %q", body)
+	}
+}
+
+func TestHTTPOriginStaleWhileRevalidate(t *testing.T) {
+	stub := &stubOrigin{values: map[string]string{"k": "v"}, control: "max-age=60, stale-while-revalidate=30"}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	loaded, err := (&HTTPOrigin{BaseURL: server.URL}).Load(context.Background(), "k")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if loaded.SoftTTL != time.Minute || loaded.TTL != 90*time.Second {
+		t.Errorf("Expected a 60s soft TTL and a 90s TTL, got %v and %v", loaded.SoftTTL, loaded.TTL)
+	}
+}
+
+func TestXFetchRefreshesEarly(t *testing.T) {
+	now := time.Now()
+	item := cache.CacheItem{Key: "k", Expiration: now.Add(time.Second).UnixNano()}
+
+	o := &origin{}
+	o.recordLoad(100 * time.Millisecond)
+	if o.due(item, now.UnixNano()) {
+		t.Error("Expected no early refresh without XFetch")
+	}
+	o.Beta = 1e6
+	if !o.due(item, now.UnixNano()) {
+		t.Error("Expected a large beta to refresh early")
+	}
+
+	// Refreshes get more likely as the deadline nears
+	o.Beta = 1
+	early, late := 0, 0
+	for i := 0; i < 1000; i++ {
+		if o.due(item, now.UnixNano()) {
+			early++
+		}
+		if o.due(item, now.Add(900*time.Millisecond).UnixNano()) {
+			late++
+		}
+	}
+	if early >= late || late == 0 {
+		t.Errorf("Expected more refreshes near the deadline, got %d early and %d late", early, late)
+	}
+
+	item.Stale = now.Add(-time.Millisecond).UnixNano()
+	if o.Beta = 0; !o.due(item, now.UnixNano()) {
+		t.Error("Expected a stale item to be refreshed")
+	}
+}
+
+func TestSoftTTLReplicates(t *testing.T) {
+	dc1 := startTestNode(t, "soft1", 7984, 8984)
+	dc2 := startTestNode(t, "soft2", 7985, 8985)
+	if err := dc2.JoinCluster("127.0.0.1:7984"); err != nil {
+		t.Fatalf("Failed to join cluster: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	req, _ := http.NewRequest("PUT", "http://127.0.0.1:8984/cache/raw?ttl=60&soft_ttl=30", strings.NewReader("v"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PUT failed: %v", err)
+	}
+	resp.Body.Close()
+	req, _ = http.NewRequest("PUT", "http://127.0.0.1:8984/cache/json", strings.NewReader(`{"value": "v", "duration": "60000000000", "soft_ttl": "30s"}`))
+	req.Header.Set("Content-Type", "application/json")
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatalf("PUT failed: %v", err)
+	}
+	resp.Body.Close()
+
+	for _, key := range []string{"raw", "json"} {
+		for _, dc := range []*DistributedCache{dc1, dc2} {
+			item, found := dc.Get(key)
+			stale := time.Until(time.Unix(0, item.Stale))
+			if !found || stale <= 25*time.Second || stale > 30*time.Second {
+				t.Errorf("Expected %s on %s to go stale in 30s, got %v", key, dc.Config.Name, stale)
+			}
+		}
+	}
+}
@@ -0,0 +1,8 @@
+package distributed
+
+import "github.com/gofiber/fiber/v2"
+
+// HandleStats describes this node's storage engine: GET /admin/stats.
+func (dc *DistributedCache) HandleStats(c *fiber.Ctx) error {
+	return c.JSON(dc.Cache.Stats())
+}
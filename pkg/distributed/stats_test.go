@@ -0,0 +1,49 @@
+package distributed
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/notlelouch/Distributed-Cache/pkg/cache"
+	"github.com/notlelouch/Distributed-Cache/pkg/diskstore"
+)
+
+func TestDiskStoreNode(t *testing.T) {
+	store, err := diskstore.Open(filepath.Join(t.TempDir(), "node.db"), diskstore.Options{NoSync: true})
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	dc, err := NewDistributedCacheWithStore(7957, 8957, "disk", store)
+	if err != nil {
+		t.Fatalf("Failed to create node: %v", err)
+	}
+	defer dc.List.Shutdown()
+	defer dc.Cache.Close()
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	dc.RegisterRoutes(app)
+
+	req := httptest.NewRequest("PUT", "/cache/greeting", strings.NewReader(`{"value": "hello", "duration": "0"}`))
+	req.Header.Set("Content-Type", "application/json")
+	if resp, err := app.Test(req); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT failed: %v %v", resp, err)
+	}
+	if v, _ := dc.Cache.Get("greeting"); v != "hello" {
+		t.Errorf("Expected greeting in the disk store, got %v", v)
+	}
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/admin/stats", nil))
+	if err != nil {
+		t.Fatalf("GET /admin/stats failed: %v", err)
+	}
+	var stats cache.StoreStats
+	json.NewDecoder(resp.Body).Decode(&stats)
+	if stats.Engine != "disk" || stats.Items != 1 || stats.Size == 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,49 @@
+package distributed
+
+import (
+	"log"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandleInvalidateTag deletes every key tagged with :tag on every node:
+// DELETE /tags/:tag. It answers with the number of keys deleted on this node.
+func (dc *DistributedCache) HandleInvalidateTag(c *fiber.Ctx) error {
+	tag := strings.Clone(c.Params("tag"))
+
+	var n int
+	if c.Get("X-Is-Sync") == "true" {
+		n = dc.Cache.InvalidateTag(tag)
+	} else {
+		n = dc.InvalidateTag(tag)
+	}
+	log.Printf("Invalidated %d keys tagged %s", n, tag)
+	return c.JSON(fiber.Map{"tag": tag, "invalidated": n})
+}
+
+// InvalidateTag deletes every key tagged with tag on this node and tells
+// every other node to do the same. Tagged keys can live on any node, so the
+// invalidation goes to all of them even when the cluster is sharded.
+func (dc *DistributedCache) InvalidateTag(tag string) int {
+	n := dc.Cache.InvalidateTag(tag)
+	dc.notifyPeers(SyncPayload{
+		Method: fiber.MethodDelete,
+		Key:    tag,
+		IsSync: true,
+		Op:     "invalidate-tag",
+	})
+	return n
+}
+
+// parseTags splits a TagsHeader value, dropping empty tags. It returns nil
+// if there are none. The tags are copied so they can be stored.
+func parseTags(header string) []string {
+	var tags []string
+	for _, t := range strings.Split(header, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, strings.Clone(t))
+		}
+	}
+	return tags
+}
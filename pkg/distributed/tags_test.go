@@ -0,0 +1,98 @@
+package distributed
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTagInvalidationAcrossCluster(t *testing.T) {
+	dc1 := startTestNode(t, "tags1", 7927, 8927)
+	dc2 := startTestNode(t, "tags2", 7928, 8928)
+	if err := dc2.JoinCluster("127.0.0.1:7927"); err != nil {
+		t.Fatalf("Failed to join cluster: %v", err)
+	}
+
+	put := func(key, contentType, body string) {
+		t.Helper()
+		req, _ := http.NewRequest("PUT", "http://127.0.0.1:8927/cache/"+key, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set(TagsHeader, "product:1, catalog")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("PUT %s failed: %v %v", key, resp, err)
+		}
+		resp.Body.Close()
+	}
+	put("page?ttl=60", "text/html", "<h1>Product</h1>")
+	put("price", "application/json", `{"value": "9.99", "duration": "60000000000"}`)
+	req, _ := http.NewRequest("PUT", "http://127.0.0.1:8927/cache/other", strings.NewReader(`{"value": "x", "duration": "60000000000", "tags": ["catalog"]}`))
+	req.Header.Set("Content-Type", "application/json")
+	if resp, err := http.DefaultClient.Do(req); err == nil {
+		resp.Body.Close()
+	}
+
+	resp, err := http.Get("http://127.0.0.1:8928/cache/page")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get(TagsHeader); got != "product:1,catalog" {
+		t.Errorf("Expected replicated item to carry its tags, got %q", got)
+	}
+
+	// Invalidating on the other node removes the keys everywhere
+	req, _ = http.NewRequest("DELETE", "http://127.0.0.1:8928/tags/product:1", nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("DELETE /tags failed: %v", err)
+	}
+	var out struct {
+		Invalidated int `json:"invalidated"`
+	}
+	json.NewDecoder(resp.Body).Decode(&out)
+	resp.Body.Close()
+	if out.Invalidated != 2 {
+		t.Errorf("Expected 2 keys invalidated on the receiving node, got %d", out.Invalidated)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(dc1.Cache.Tagged("product:1")) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, dc := range []*DistributedCache{dc1, dc2} {
+		if _, found := dc.Cache.Get("price"); found {
+			t.Errorf("Expected price to be invalidated on %s", dc.Config.Name)
+		}
+		if _, found := dc.Cache.Get("other"); !found {
+			t.Errorf("Expected key without the tag to survive on %s", dc.Config.Name)
+		}
+	}
+	// Tags written through _mput replicate with the items too
+	body := `{"items": [{"key": "bulk1", "value": "a", "tags": ["bulk"]}, {"key": "bulk2", "value": "b", "tags": ["bulk"]}]}`
+	resp, err = http.Post("http://127.0.0.1:8927/cache/_mput", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("_mput failed: %v", err)
+	}
+	resp.Body.Close()
+	req, _ = http.NewRequest("DELETE", "http://127.0.0.1:8928/tags/bulk", nil)
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatalf("DELETE /tags failed: %v", err)
+	}
+	json.NewDecoder(resp.Body).Decode(&out)
+	resp.Body.Close()
+	if out.Invalidated != 2 {
+		t.Errorf("Expected 2 _mput keys invalidated on the other node, got %d", out.Invalidated)
+	}
+	deadline = time.Now().Add(2 * time.Second)
+	for len(dc1.Cache.Tagged("bulk")) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, dc := range []*DistributedCache{dc1, dc2} {
+		if _, found := dc.Cache.Get("bulk1"); found {
+			t.Errorf("Expected bulk1 to be invalidated on %s", dc.Config.Name)
+		}
+	}
+}
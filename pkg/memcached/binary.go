@@ -0,0 +1,248 @@
+package memcached
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"strconv"
+)
+
+// Binary protocol constants, from the memcached binary protocol spec.
+const (
+	magicRequest  = 0x80
+	magicResponse = 0x81
+	headerLen     = 24
+
+	opGet       = 0x00
+	opSet       = 0x01
+	opAdd       = 0x02
+	opReplace   = 0x03
+	opDelete    = 0x04
+	opIncrement = 0x05
+	opDecrement = 0x06
+	opQuit      = 0x07
+	opGetQ      = 0x09
+	opNoop      = 0x0a
+	opVersion   = 0x0b
+	opGetK      = 0x0c
+	opGetKQ     = 0x0d
+	opSetQ      = 0x11
+	opAddQ      = 0x12
+	opReplaceQ  = 0x13
+	opDeleteQ   = 0x14
+	opIncrQ     = 0x15
+	opDecrQ     = 0x16
+	opQuitQ     = 0x17
+	opTouch     = 0x1c
+
+	statusOK             = 0x00
+	statusKeyNotFound    = 0x01
+	statusKeyExists      = 0x02
+	statusValueTooLarge  = 0x03
+	statusInvalidArgs    = 0x04
+	statusNotStored      = 0x05
+	statusNonNumeric     = 0x06
+	statusUnknownCommand = 0x81
+	statusInternalError  = 0x84
+)
+
+type binaryRequest struct {
+	opcode byte
+	opaque uint32
+	cas    uint64
+	extras []byte
+	key    []byte
+	value  []byte
+}
+
+type binaryResponse struct {
+	status uint16
+	cas    uint64
+	extras []byte
+	key    []byte
+	value  []byte
+}
+
+// serveBinary handles the memcached binary protocol.
+func (s *Server) serveBinary(r *bufio.Reader, w *bufio.Writer) {
+	header := make([]byte, headerLen)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return
+		}
+		if header[0] != magicRequest {
+			return
+		}
+		keyLen := int(binary.BigEndian.Uint16(header[2:]))
+		extrasLen := int(header[4])
+		bodyLen := int(binary.BigEndian.Uint32(header[8:]))
+		if bodyLen < keyLen+extrasLen || bodyLen > maxValueLen+maxKeyLen+64 {
+			return
+		}
+		body := make([]byte, bodyLen)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return
+		}
+
+		req := binaryRequest{
+			opcode: header[1],
+			opaque: binary.BigEndian.Uint32(header[12:]),
+			cas:    binary.BigEndian.Uint64(header[16:]),
+			extras: body[:extrasLen],
+			key:    body[extrasLen : extrasLen+keyLen],
+			value:  body[extrasLen+keyLen:],
+		}
+
+		resp, quiet, quit := s.binaryCommand(req)
+		if !quiet {
+			writeBinary(w, req, resp)
+		}
+		if quit {
+			w.Flush()
+			return
+		}
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// binaryCommand executes req. Quiet opcodes suppress the response when it is
+// uninteresting: misses for GetQ/GetKQ and successes for the write variants.
+func (s *Server) binaryCommand(req binaryRequest) (resp binaryResponse, quiet bool, quit bool) {
+	key := string(req.key)
+	if len(req.key) > 0 && !validKey(req.key) {
+		return binaryResponse{status: statusInvalidArgs}, false, false
+	}
+
+	switch req.opcode {
+	case opGet, opGetQ, opGetK, opGetKQ:
+		data, item, found, err := s.fetch(key)
+		if err != nil {
+			return binaryResponse{status: statusInternalError, value: []byte("Failed to load from origin")}, false, false
+		}
+		quietMiss := req.opcode == opGetQ || req.opcode == opGetKQ
+		if !found {
+			return binaryResponse{status: statusKeyNotFound, value: []byte("Not found")}, quietMiss, false
+		}
+		resp = binaryResponse{cas: item.Version, extras: make([]byte, 4), value: data}
+		binary.BigEndian.PutUint32(resp.extras, item.Flags)
+		if req.opcode == opGetK || req.opcode == opGetKQ {
+			resp.key = req.key
+		}
+		return resp, false, false
+
+	case opSet, opSetQ, opAdd, opAddQ, opReplace, opReplaceQ:
+		if len(req.extras) != 8 || len(req.key) == 0 {
+			return binaryResponse{status: statusInvalidArgs}, false, false
+		}
+		if len(req.value) > maxValueLen {
+			return binaryResponse{status: statusValueTooLarge}, false, false
+		}
+		mode := modeSet
+		switch req.opcode {
+		case opAdd, opAddQ:
+			mode = modeAdd
+		case opReplace, opReplaceQ:
+			mode = modeReplace
+		}
+		// A CAS value on set/replace turns it into a compare-and-swap
+		if req.cas != 0 && mode != modeAdd {
+			mode = modeCAS
+		}
+		flags := binary.BigEndian.Uint32(req.extras[0:])
+		exptime := int64(binary.BigEndian.Uint32(req.extras[4:]))
+
+		switch s.store(mode, key, flags, exptime, req.value, req.cas) {
+		case resultStored:
+			_, item, _ := s.lookup(key)
+			quiet := req.opcode == opSetQ || req.opcode == opAddQ || req.opcode == opReplaceQ
+			return binaryResponse{cas: item.Version}, quiet, false
+		case resultExists:
+			return binaryResponse{status: statusKeyExists}, false, false
+		case resultNotFound:
+			return binaryResponse{status: statusKeyNotFound}, false, false
+		default:
+			if mode == modeAdd {
+				return binaryResponse{status: statusKeyExists}, false, false
+			}
+			return binaryResponse{status: statusKeyNotFound}, false, false
+		}
+
+	case opDelete, opDeleteQ:
+		switch s.remove(key, req.cas) {
+		case resultStored:
+			return binaryResponse{}, req.opcode == opDeleteQ, false
+		case resultExists:
+			return binaryResponse{status: statusKeyExists}, false, false
+		default:
+			return binaryResponse{status: statusKeyNotFound}, false, false
+		}
+
+	case opIncrement, opIncrQ, opDecrement, opDecrQ:
+		if len(req.extras) != 20 {
+			return binaryResponse{status: statusInvalidArgs}, false, false
+		}
+		delta := binary.BigEndian.Uint64(req.extras[0:])
+		initial := binary.BigEndian.Uint64(req.extras[8:])
+		exptime := binary.BigEndian.Uint32(req.extras[16:])
+		decr := req.opcode == opDecrement || req.opcode == opDecrQ
+
+		value, found, err := s.incr(key, delta, decr)
+		if !found {
+			// An expiration of all ones means "don't create"
+			if exptime == 0xffffffff {
+				return binaryResponse{status: statusKeyNotFound}, false, false
+			}
+			s.store(modeAdd, key, 0, int64(exptime), []byte(strconv.FormatUint(initial, 10)), 0)
+			value, err = initial, nil
+		}
+		if err != nil {
+			return binaryResponse{status: statusNonNumeric}, false, false
+		}
+		_, item, _ := s.lookup(key)
+		resp = binaryResponse{cas: item.Version, value: make([]byte, 8)}
+		binary.BigEndian.PutUint64(resp.value, value)
+		return resp, req.opcode == opIncrQ || req.opcode == opDecrQ, false
+
+	case opTouch:
+		if len(req.extras) != 4 {
+			return binaryResponse{status: statusInvalidArgs}, false, false
+		}
+		if !s.touch(key, int64(binary.BigEndian.Uint32(req.extras))) {
+			return binaryResponse{status: statusKeyNotFound}, false, false
+		}
+		return binaryResponse{}, false, false
+
+	case opNoop:
+		return binaryResponse{}, false, false
+
+	case opVersion:
+		return binaryResponse{value: []byte(version)}, false, false
+
+	case opQuit, opQuitQ:
+		return binaryResponse{}, req.opcode == opQuitQ, true
+
+	default:
+		return binaryResponse{status: statusUnknownCommand, value: []byte("Unknown command")}, false, false
+	}
+}
+
+func writeBinary(w *bufio.Writer, req binaryRequest, resp binaryResponse) {
+	header := make([]byte, headerLen)
+	header[0] = magicResponse
+	header[1] = req.opcode
+	binary.BigEndian.PutUint16(header[2:], uint16(len(resp.key)))
+	header[4] = byte(len(resp.extras))
+	binary.BigEndian.PutUint16(header[6:], resp.status)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(resp.extras)+len(resp.key)+len(resp.value)))
+	binary.BigEndian.PutUint32(header[12:], req.opaque)
+	binary.BigEndian.PutUint64(header[16:], resp.cas)
+
+	w.Write(header)
+	w.Write(resp.extras)
+	w.Write(resp.key)
+	w.Write(resp.value)
+}
@@ -0,0 +1,277 @@
+// Package memcached serves the distributed cache over the memcached text and
+// binary protocols, so it can replace a memcached pool. CAS unique values are
+// item versions. Reads load from the origin and writes are replicated like
+// HTTP reads and writes.
+package memcached
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/notlelouch/Distributed-Cache/pkg/cache"
+	"github.com/notlelouch/Distributed-Cache/pkg/distributed"
+)
+
+const (
+	maxKeyLen   = 250
+	maxValueLen = 1 << 20
+
+	// Expiration times beyond 30 days are absolute Unix timestamps.
+	relativeExpiryLimit = 60 * 60 * 24 * 30
+
+	version = "1.6.0-disperse"
+)
+
+// Server accepts memcached connections. The protocol is chosen per connection
+// from its first byte: 0x80 starts a binary request, anything else is text.
+type Server struct {
+	dc *distributed.DistributedCache
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+}
+
+func NewServer(dc *distributed.DistributedCache) *Server {
+	return &Server{
+		dc:    dc,
+		conns: make(map[net.Conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on addr and serves memcached connections until Close.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("Memcached server is running on: %s", l.Addr())
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until Close is called. It refuses to run
+// against a sharded cluster with distributed.ErrShardedFrontend.
+func (s *Server) Serve(l net.Listener) error {
+	if s.dc.Sharded {
+		l.Close()
+		return distributed.ErrShardedFrontend
+	}
+	s.mu.Lock()
+	s.listener = l
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops the listener and closes all client connections.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return err
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	first, err := r.Peek(1)
+	if err != nil {
+		return
+	}
+	if first[0] == magicRequest {
+		s.serveBinary(r, w)
+	} else {
+		s.serveText(r, w)
+	}
+}
+
+// ####################################################   Shared operations   ##############################################
+
+type storeMode int
+
+const (
+	modeSet storeMode = iota
+	modeAdd
+	modeReplace
+	modeCAS
+)
+
+type result int
+
+const (
+	resultStored result = iota
+	resultNotStored
+	resultExists
+	resultNotFound
+)
+
+// expiry converts a memcached expiration time into a duration. Zero means
+// never; expired reports times that are already in the past.
+func expiry(exptime int64) (duration time.Duration, expired bool) {
+	switch {
+	case exptime == 0:
+		return 0, false
+	case exptime < 0:
+		return 0, true
+	case exptime > relativeExpiryLimit:
+		duration = time.Until(time.Unix(exptime, 0))
+		return duration, duration <= 0
+	default:
+		return time.Duration(exptime) * time.Second, false
+	}
+}
+
+func (s *Server) store(mode storeMode, key string, flags uint32, exptime int64, data []byte, casUnique uint64) result {
+	var cond cache.Precondition
+	switch mode {
+	case modeAdd:
+		cond.IfNoneMatch = true
+	case modeReplace:
+		cond.IfMatchAny = true
+	case modeCAS:
+		cond.IfMatch = []uint64{casUnique}
+	}
+
+	duration, expired := expiry(exptime)
+	if expired {
+		// Storing an already-expired item behaves like a conditional delete
+		if err := s.dc.Delete(key, cond); err == cache.ErrPreconditionFailed {
+			return s.failure(mode, key)
+		}
+		return resultStored
+	}
+
+	_, err := s.dc.Set(key, data, duration, cache.SetOptions{Flags: flags}, cond)
+	if err == cache.ErrPreconditionFailed {
+		return s.failure(mode, key)
+	}
+	if err != nil {
+		return resultNotStored
+	}
+	return resultStored
+}
+
+// failure maps a failed precondition to the memcached result for mode.
+func (s *Server) failure(mode storeMode, key string) result {
+	if mode != modeCAS {
+		return resultNotStored
+	}
+	if _, found := s.dc.Cache.Get(key); found {
+		return resultExists
+	}
+	return resultNotFound
+}
+
+// fetch reads key for get and gets like an HTTP GET, loading it from the
+// origin when one is configured. A key the origin is known not to have is
+// reported as missing.
+func (s *Server) fetch(key string) (data []byte, item cache.CacheItem, found bool, err error) {
+	item, found, err = s.dc.Fetch(context.Background(), key)
+	if errors.Is(err, distributed.ErrKnownMissing) {
+		return nil, item, false, nil
+	}
+	if err != nil || !found {
+		return nil, item, false, err
+	}
+	data, found = s.encode(item)
+	return data, item, found, nil
+}
+
+// lookup reads key from this node only, for the version a write left behind.
+func (s *Server) lookup(key string) (data []byte, item cache.CacheItem, found bool) {
+	item, found = s.dc.Cache.GetItem(key)
+	if !found {
+		return nil, item, false
+	}
+	data, found = s.encode(item)
+	return data, item, found
+}
+
+func (s *Server) encode(item cache.CacheItem) ([]byte, bool) {
+	// Counters are unsigned in memcached, and incr wraps them modulo 2^64
+	if counter, ok := item.Value.(*cache.PNCounter); ok {
+		return strconv.AppendUint(nil, uint64(counter.Value()), 10), true
+	}
+	data, err := s.dc.Codec.Marshal(item.Value)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+var errNonNumeric = errors.New("cannot increment or decrement non-numeric value")
+
+// incr applies a memcached incr/decr. Counters in memcached are unsigned:
+// increments wrap around at 2^64 and decrements stop at zero, both in one
+// atomic cache operation.
+func (s *Server) incr(key string, delta uint64, decr bool) (uint64, bool, error) {
+	value, found, err := s.dc.IncrUnsigned(key, delta, decr)
+	if err != nil {
+		return 0, found, errNonNumeric
+	}
+	return value, found, nil
+}
+
+// remove deletes key, only at the given version if casUnique is non-zero.
+func (s *Server) remove(key string, casUnique uint64) result {
+	cond := cache.Precondition{IfMatchAny: true}
+	if casUnique != 0 {
+		cond = cache.Precondition{IfMatch: []uint64{casUnique}}
+	}
+	if err := s.dc.Delete(key, cond); err != nil {
+		return s.failure(modeCAS, key)
+	}
+	return resultStored
+}
+
+func (s *Server) touch(key string, exptime int64) bool {
+	duration, expired := expiry(exptime)
+	if expired {
+		return s.remove(key, 0) == resultStored
+	}
+	return s.dc.Expire(key, duration)
+}
+
+func validKey(key []byte) bool {
+	if len(key) == 0 || len(key) > maxKeyLen {
+		return false
+	}
+	for _, b := range key {
+		if b <= ' ' || b == 0x7f {
+			return false
+		}
+	}
+	return true
+}
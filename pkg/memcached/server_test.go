@@ -0,0 +1,301 @@
+package memcached
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/notlelouch/Distributed-Cache/pkg/distributed"
+)
+
+func startServer(t *testing.T, memberlistPort int) net.Conn {
+	t.Helper()
+
+	dc, err := distributed.NewDistributedCache(memberlistPort, memberlistPort+1000, fmt.Sprintf("mc%d", memberlistPort))
+	if err != nil {
+		t.Fatalf("Failed to create distributed cache: %v", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := NewServer(dc)
+	go s.Serve(l)
+	t.Cleanup(func() {
+		s.Close()
+		dc.List.Shutdown()
+	})
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	return conn
+}
+
+func TestTextProtocol(t *testing.T) {
+	conn := startServer(t, 7980)
+	r := bufio.NewReader(conn)
+
+	// send writes a command and reads lines until one that ends the reply.
+	send := func(cmd string) string {
+		t.Helper()
+		fmt.Fprint(conn, cmd)
+		var lines []string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("Failed to read reply to %q: %v", cmd, err)
+			}
+			line = strings.TrimRight(line, "\r\n")
+			lines = append(lines, line)
+			if !strings.HasPrefix(line, "VALUE") && (len(lines) == 1 || !strings.HasPrefix(lines[len(lines)-2], "VALUE")) {
+				return strings.Join(lines, "|")
+			}
+		}
+	}
+
+	steps := []struct{ cmd, want string }{
+		{"set foo 42 0 3\r\nbar\r\n", "STORED"},
+		{"get foo\r\n", "VALUE foo 42 3|bar|END"},
+		{"add foo 0 0 1\r\nx\r\n", "NOT_STORED"},
+		{"replace missing 0 0 1\r\nx\r\n", "NOT_STORED"},
+		{"replace foo 7 0 3\r\nbaz\r\n", "STORED"},
+		{"get foo missing\r\n", "VALUE foo 7 3|baz|END"},
+		{"cas foo 0 0 1 1\r\nx\r\n", "EXISTS"},
+		{"cas missing 0 0 1 1\r\nx\r\n", "NOT_FOUND"},
+		{"set n 0 0 2\r\n10\r\n", "STORED"},
+		{"incr n 5\r\n", "15"},
+		{"decr n 100\r\n", "0"},
+		{"incr foo 1\r\n", "CLIENT_ERROR cannot increment or decrement non-numeric value"},
+		{"incr missing 1\r\n", "NOT_FOUND"},
+		{"touch foo 100\r\n", "TOUCHED"},
+		{"touch missing 100\r\n", "NOT_FOUND"},
+		{"delete foo\r\n", "DELETED"},
+		{"delete foo\r\n", "NOT_FOUND"},
+		{"set quiet 0 0 1 noreply\r\nq\r\nget quiet\r\n", "VALUE quiet 0 1|q|END"},
+		{"bogus\r\n", "ERROR"},
+	}
+	for _, step := range steps {
+		if got := send(step.cmd); got != step.want {
+			t.Errorf("%q: got %q, want %q", step.cmd, got, step.want)
+		}
+	}
+
+	// gets returns the version as CAS unique, which cas then accepts once
+	reply := send("gets n\r\n")
+	var key string
+	var flags, size int
+	var casUnique uint64
+	fmt.Sscanf(reply, "VALUE %s %d %d %d", &key, &flags, &size, &casUnique)
+	if casUnique == 0 {
+		t.Fatalf("Expected gets to return a CAS unique, got %q", reply)
+	}
+	if got := send(fmt.Sprintf("cas n 0 0 1 %d\r\n9\r\n", casUnique)); got != "STORED" {
+		t.Errorf("Expected cas with current unique to store, got %q", got)
+	}
+	if got := send(fmt.Sprintf("cas n 0 0 1 %d\r\n8\r\n", casUnique)); got != "EXISTS" {
+		t.Errorf("Expected cas with stale unique to fail, got %q", got)
+	}
+}
+
+func binaryRoundTrip(t *testing.T, conn net.Conn, opcode byte, cas uint64, extras, key, value []byte) (status uint16, respCAS uint64, body []byte) {
+	t.Helper()
+
+	header := make([]byte, headerLen)
+	header[0] = magicRequest
+	header[1] = opcode
+	binary.BigEndian.PutUint16(header[2:], uint16(len(key)))
+	header[4] = byte(len(extras))
+	binary.BigEndian.PutUint32(header[8:], uint32(len(extras)+len(key)+len(value)))
+	binary.BigEndian.PutUint32(header[12:], 0xdeadbeef)
+	binary.BigEndian.PutUint64(header[16:], cas)
+	conn.Write(append(append(append(header, extras...), key...), value...))
+
+	resp := make([]byte, headerLen)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		t.Fatalf("Failed to read binary response: %v", err)
+	}
+	if resp[0] != magicResponse || resp[1] != opcode || binary.BigEndian.Uint32(resp[12:]) != 0xdeadbeef {
+		t.Fatalf("Malformed response header %x", resp)
+	}
+	body = make([]byte, binary.BigEndian.Uint32(resp[8:]))
+	io.ReadFull(conn, body)
+	return binary.BigEndian.Uint16(resp[6:]), binary.BigEndian.Uint64(resp[16:]), body
+}
+
+func TestBinaryProtocol(t *testing.T) {
+	conn := startServer(t, 7982)
+
+	setExtras := make([]byte, 8)
+	binary.BigEndian.PutUint32(setExtras, 0xcafe)
+
+	status, cas, _ := binaryRoundTrip(t, conn, opSet, 0, setExtras, []byte("k"), []byte("v1"))
+	if status != statusOK || cas == 0 {
+		t.Fatalf("Expected set to succeed with a CAS, got status %d cas %d", status, cas)
+	}
+
+	status, getCAS, body := binaryRoundTrip(t, conn, opGet, 0, nil, []byte("k"), nil)
+	if status != statusOK || getCAS != cas || binary.BigEndian.Uint32(body) != 0xcafe || string(body[4:]) != "v1" {
+		t.Errorf("Unexpected get response: status %d cas %d body %q", status, getCAS, body)
+	}
+
+	if status, _, _ := binaryRoundTrip(t, conn, opSet, cas+1, setExtras, []byte("k"), []byte("v2")); status != statusKeyExists {
+		t.Errorf("Expected set with stale CAS to fail with KeyExists, got %d", status)
+	}
+	if status, _, _ := binaryRoundTrip(t, conn, opAdd, 0, setExtras, []byte("k"), []byte("v2")); status != statusKeyExists {
+		t.Errorf("Expected add on existing key to fail with KeyExists, got %d", status)
+	}
+
+	incrExtras := make([]byte, 20)
+	binary.BigEndian.PutUint64(incrExtras[0:], 5)
+	binary.BigEndian.PutUint64(incrExtras[8:], 100)
+	status, _, body = binaryRoundTrip(t, conn, opIncrement, 0, incrExtras, []byte("ctr"), nil)
+	if status != statusOK || binary.BigEndian.Uint64(body) != 100 {
+		t.Errorf("Expected incr on a missing key to create it with the initial value, got %d %v", status, body)
+	}
+	status, _, body = binaryRoundTrip(t, conn, opIncrement, 0, incrExtras, []byte("ctr"), nil)
+	if status != statusOK || binary.BigEndian.Uint64(body) != 105 {
+		t.Errorf("Expected incr to return 105, got %d %v", status, body)
+	}
+
+	if status, _, _ := binaryRoundTrip(t, conn, opDelete, 0, nil, []byte("k"), nil); status != statusOK {
+		t.Errorf("Expected delete to succeed, got %d", status)
+	}
+	if status, _, _ := binaryRoundTrip(t, conn, opGet, 0, nil, []byte("k"), nil); status != statusKeyNotFound {
+		t.Errorf("Expected get after delete to miss, got %d", status)
+	}
+	if status, _, body := binaryRoundTrip(t, conn, opVersion, 0, nil, nil, nil); status != statusOK || string(body) != version {
+		t.Errorf("Unexpected version response %d %q", status, body)
+	}
+}
+
+func TestMemcachedRefusesShardedCluster(t *testing.T) {
+	dc, err := distributed.NewDistributedCache(7908, 8908, "mc7908")
+	if err != nil {
+		t.Fatalf("Failed to create distributed cache: %v", err)
+	}
+	defer dc.List.Shutdown()
+	dc.Sharded = true
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer l.Close()
+	if err := NewServer(dc).Serve(l); !errors.Is(err, distributed.ErrShardedFrontend) {
+		t.Errorf("Serve on a sharded cluster = %v, want ErrShardedFrontend", err)
+	}
+}
+
+func TestConcurrentDecrStopsAtZero(t *testing.T) {
+	dc, err := distributed.NewDistributedCache(7914, 8914, "mc-decr")
+	if err != nil {
+		t.Fatalf("Failed to create distributed cache: %v", err)
+	}
+	defer dc.List.Shutdown()
+	s := NewServer(dc)
+
+	s.store(modeSet, "n", 0, 0, []byte("100"), 0)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := s.incr("n", 3, true); err != nil {
+				t.Errorf("decr failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if data, _, _ := s.lookup("n"); string(data) != "0" {
+		t.Errorf("Expected decrements past zero to stop at 0, got %s", data)
+	}
+
+	// Increments wrap around at 2^64, as in memcached
+	s.store(modeSet, "max", 0, 0, []byte("18446744073709551615"), 0)
+	if value, _, err := s.incr("max", 2, false); err != nil || value != 1 {
+		t.Errorf("Expected incr to wrap around to 1, got %d (%v)", value, err)
+	}
+	if data, _, _ := s.lookup("max"); string(data) != "1" {
+		t.Errorf("Expected the wrapped value to be stored, got %s", data)
+	}
+}
+
+func TestGetReadsThroughOrigin(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/loaded":
+			w.Write([]byte("from origin"))
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer origin.Close()
+
+	dc, err := distributed.NewDistributedCache(7915, 8915, "mc-origin")
+	if err != nil {
+		t.Fatalf("Failed to create distributed cache: %v", err)
+	}
+	defer dc.List.Shutdown()
+	dc.SetOrigin(distributed.OriginOptions{Loader: &distributed.HTTPOrigin{BaseURL: origin.URL}, NegativeTTL: time.Minute})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := NewServer(dc)
+	go s.Serve(l)
+	defer s.Close()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	r := bufio.NewReader(conn)
+
+	// Misses are loaded, and a key the origin lacks is simply absent, also
+	// once it is in the negative cache
+	for i := 0; i < 2; i++ {
+		fmt.Fprint(conn, "get loaded ghost\r\n")
+		var lines []string
+		for len(lines) == 0 || lines[len(lines)-1] != "END" {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("Failed to read reply: %v", err)
+			}
+			lines = append(lines, strings.TrimRight(line, "\r\n"))
+		}
+		if got := strings.Join(lines, "|"); got != "VALUE loaded 0 11|from origin|END" {
+			t.Errorf("Unexpected get reply: %q", got)
+		}
+	}
+	fmt.Fprint(conn, "get broken\r\n")
+	if line, _ := r.ReadString('\n'); !strings.HasPrefix(line, "SERVER_ERROR") {
+		t.Errorf("Expected an origin failure to be a server error, got %q", line)
+	}
+
+	// The protocol is chosen per connection, so binary needs its own
+	conn, err = net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	if status, _, body := binaryRoundTrip(t, conn, opGet, 0, nil, []byte("loaded"), nil); status != statusOK || string(body[4:]) != "from origin" {
+		t.Errorf("Unexpected binary get response: status %d body %q", status, body)
+	}
+	if status, _, _ := binaryRoundTrip(t, conn, opGet, 0, nil, []byte("ghost"), nil); status != statusKeyNotFound {
+		t.Errorf("Expected a binary get of a missing key to fail with KeyNotFound, got %d", status)
+	}
+	if status, _, _ := binaryRoundTrip(t, conn, opGet, 0, nil, []byte("broken"), nil); status != statusInternalError {
+		t.Errorf("Expected a binary get to report an origin failure, got %d", status)
+	}
+}
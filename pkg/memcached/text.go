@@ -0,0 +1,191 @@
+package memcached
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/notlelouch/Distributed-Cache/pkg/cache"
+)
+
+// serveText handles the memcached ASCII protocol.
+func (s *Server) serveText(r *bufio.Reader, w *bufio.Writer) {
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		fields := bytes.Fields(line)
+		if len(fields) == 0 {
+			w.WriteString("ERROR\r\n")
+		} else if quit := s.textCommand(r, w, fields); quit {
+			w.Flush()
+			return
+		}
+
+		// Flush once the pipeline has been drained rather than per reply
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) textCommand(r *bufio.Reader, w *bufio.Writer, fields [][]byte) (quit bool) {
+	args := fields[1:]
+	noreply := len(args) > 0 && string(args[len(args)-1]) == "noreply"
+	if noreply {
+		args = args[:len(args)-1]
+	}
+	reply := func(s string) {
+		if !noreply {
+			w.WriteString(s)
+		}
+	}
+
+	switch string(fields[0]) {
+	case "get", "gets":
+		if len(args) == 0 {
+			w.WriteString("ERROR\r\n")
+			return false
+		}
+		withCAS := string(fields[0]) == "gets"
+		// Load every key before replying, so an origin failure does not
+		// leave a partial list of values
+		type hit struct {
+			key  []byte
+			data []byte
+			item cache.CacheItem
+		}
+		var hits []hit
+		for _, key := range args {
+			data, item, found, err := s.fetch(string(key))
+			if err != nil {
+				w.WriteString("SERVER_ERROR failed to load from origin\r\n")
+				return false
+			}
+			if found {
+				hits = append(hits, hit{key, data, item})
+			}
+		}
+		for _, h := range hits {
+			if withCAS {
+				fmt.Fprintf(w, "VALUE %s %d %d %d\r\n", h.key, h.item.Flags, len(h.data), h.item.Version)
+			} else {
+				fmt.Fprintf(w, "VALUE %s %d %d\r\n", h.key, h.item.Flags, len(h.data))
+			}
+			w.Write(h.data)
+			w.WriteString("\r\n")
+		}
+		w.WriteString("END\r\n")
+
+	case "set", "add", "replace", "cas":
+		mode := map[string]storeMode{"set": modeSet, "add": modeAdd, "replace": modeReplace, "cas": modeCAS}[string(fields[0])]
+		want := 4
+		if mode == modeCAS {
+			want = 5
+		}
+		if len(args) != want || !validKey(args[0]) {
+			w.WriteString("CLIENT_ERROR bad command line format\r\n")
+			return false
+		}
+		flags, err1 := strconv.ParseUint(string(args[1]), 10, 32)
+		exptime, err2 := strconv.ParseInt(string(args[2]), 10, 64)
+		size, err3 := strconv.Atoi(string(args[3]))
+		var casUnique uint64
+		var err4 error
+		if mode == modeCAS {
+			casUnique, err4 = strconv.ParseUint(string(args[4]), 10, 64)
+		}
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil || size < 0 {
+			w.WriteString("CLIENT_ERROR bad command line format\r\n")
+			return false
+		}
+		if size > maxValueLen {
+			// Swallow the data block so the connection stays in sync
+			io.CopyN(io.Discard, r, int64(size)+2)
+			w.WriteString("SERVER_ERROR object too large for cache\r\n")
+			return false
+		}
+
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return true
+		}
+		if !bytes.HasSuffix(data, []byte("\r\n")) {
+			w.WriteString("CLIENT_ERROR bad data chunk\r\n")
+			return false
+		}
+
+		switch s.store(mode, string(args[0]), uint32(flags), exptime, data[:size], casUnique) {
+		case resultStored:
+			reply("STORED\r\n")
+		case resultNotStored:
+			reply("NOT_STORED\r\n")
+		case resultExists:
+			reply("EXISTS\r\n")
+		case resultNotFound:
+			reply("NOT_FOUND\r\n")
+		}
+
+	case "delete":
+		if len(args) != 1 {
+			w.WriteString("CLIENT_ERROR bad command line format.  Usage: delete <key> [noreply]\r\n")
+			return false
+		}
+		if s.remove(string(args[0]), 0) == resultStored {
+			reply("DELETED\r\n")
+		} else {
+			reply("NOT_FOUND\r\n")
+		}
+
+	case "incr", "decr":
+		if len(args) != 2 {
+			w.WriteString("ERROR\r\n")
+			return false
+		}
+		delta, err := strconv.ParseUint(string(args[1]), 10, 64)
+		if err != nil {
+			w.WriteString("CLIENT_ERROR invalid numeric delta argument\r\n")
+			return false
+		}
+		value, found, err := s.incr(string(args[0]), delta, string(fields[0]) == "decr")
+		switch {
+		case !found:
+			reply("NOT_FOUND\r\n")
+		case err != nil:
+			w.WriteString("CLIENT_ERROR " + err.Error() + "\r\n")
+		default:
+			reply(strconv.FormatUint(value, 10) + "\r\n")
+		}
+
+	case "touch":
+		if len(args) != 2 {
+			w.WriteString("ERROR\r\n")
+			return false
+		}
+		exptime, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			w.WriteString("CLIENT_ERROR invalid exptime argument\r\n")
+			return false
+		}
+		if s.touch(string(args[0]), exptime) {
+			reply("TOUCHED\r\n")
+		} else {
+			reply("NOT_FOUND\r\n")
+		}
+
+	case "version":
+		w.WriteString("VERSION " + version + "\r\n")
+
+	case "quit":
+		return true
+
+	default:
+		w.WriteString("ERROR\r\n")
+	}
+	return false
+}
@@ -0,0 +1,473 @@
+// Package persist saves the contents of a cache.Cache to disk so a node can
+// restart without losing its data.
+package persist
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/notlelouch/Distributed-Cache/pkg/cache"
+)
+
+// FsyncPolicy controls how often the append-only file is flushed to disk.
+type FsyncPolicy int
+
+const (
+	// FsyncEverySecond loses at most about a second of writes on a crash.
+	FsyncEverySecond FsyncPolicy = iota
+	// FsyncAlways syncs before every write returns. It is the safest and
+	// by far the slowest.
+	FsyncAlways
+	// FsyncNever writes once a second and leaves syncing to the OS.
+	FsyncNever
+)
+
+// ParseFsyncPolicy accepts "always", "everysec" and "no", as in Redis.
+func ParseFsyncPolicy(s string) (FsyncPolicy, error) {
+	switch s {
+	case "always":
+		return FsyncAlways, nil
+	case "everysec", "":
+		return FsyncEverySecond, nil
+	case "no", "never":
+		return FsyncNever, nil
+	}
+	return 0, fmt.Errorf("unknown fsync policy %q", s)
+}
+
+// AOFOptions configures an AOF.
+type AOFOptions struct {
+	Fsync FsyncPolicy
+	// RewriteMinSize is the size the file must reach before it is rewritten
+	// automatically, which happens whenever it has also doubled since the
+	// last rewrite. Zero uses DefaultRewriteMinSize; -1 disables it.
+	RewriteMinSize int64
+}
+
+// DefaultRewriteMinSize is the default AOFOptions.RewriteMinSize.
+const DefaultRewriteMinSize = 64 << 20
+
+var (
+	ErrRewriteInProgress = errors.New("persist: rewrite already in progress")
+	ErrClosed            = errors.New("persist: append-only file is closed")
+)
+
+// The file starts with aofMagic. Each record follows as
+//
+//	[4 payload length][4 CRC-32 of payload][payload]
+//
+// where the payload is a record kind byte followed by an encoded item for
+// recordSet or the key for recordDelete.
+const (
+	aofMagic     = "DAOF1\n"
+	recordHeader = 8
+	recordSet    = 1
+	recordDelete = 2
+
+	// maxRecordSize guards against allocating for a corrupt length.
+	maxRecordSize = 1 << 30
+)
+
+// AOF is an append-only log of every change to a cache. It implements
+// cache.Journal.
+type AOF struct {
+	path  string
+	opts  AOFOptions
+	cache *cache.Cache
+
+	mu       sync.Mutex
+	f        *os.File
+	w        *bufio.Writer
+	size     int64 // Bytes in the file, including unflushed ones
+	baseSize int64 // Size after the last rewrite
+	err      error // First write error, reported by Sync and Close
+
+	rewriting  bool
+	rewriteBuf [][]byte // Records written while a rewrite runs
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// OpenAOF replays the append-only file at path into c, creating the file if
+// needed, and then logs every change to c. A torn or corrupt tail, as left by
+// a crash mid-write, is logged and truncated away.
+func OpenAOF(path string, c *cache.Cache, opts AOFOptions) (*AOF, error) {
+	if opts.RewriteMinSize == 0 {
+		opts.RewriteMinSize = DefaultRewriteMinSize
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	size, replayed, err := replay(f, c)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("persist: replaying %s: %w", path, err)
+	}
+	if size == 0 {
+		if _, err := f.WriteString(aofMagic); err != nil {
+			f.Close()
+			return nil, err
+		}
+		size = int64(len(aofMagic))
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	log.Printf("Replayed %d records from %s", replayed, path)
+
+	a := &AOF{
+		path:     path,
+		opts:     opts,
+		cache:    c,
+		f:        f,
+		w:        bufio.NewWriterSize(f, 64<<10),
+		size:     size,
+		baseSize: size,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	c.SetJournal(a)
+	go a.syncLoop()
+	return a, nil
+}
+
+// replay applies every intact record in f to c and returns the length of the
+// intact prefix, truncating anything after it.
+func replay(f *os.File, c *cache.Cache) (int64, int, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	if info.Size() == 0 {
+		return 0, 0, nil
+	}
+
+	r := bufio.NewReaderSize(f, 64<<10)
+	magic := make([]byte, len(aofMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != aofMagic {
+		return 0, 0, errors.New("not an append-only file")
+	}
+
+	offset := int64(len(aofMagic))
+	replayed := 0
+	header := make([]byte, recordHeader)
+	for {
+		payload, err := readRecord(r, header)
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			err = applyRecord(c, payload)
+		}
+		if err != nil {
+			log.Printf("Truncating %d bytes after the last intact record of %s: %v", info.Size()-offset, f.Name(), err)
+			if err := f.Truncate(offset); err != nil {
+				return 0, 0, err
+			}
+			break
+		}
+		offset += int64(recordHeader + len(payload))
+		replayed++
+	}
+	return offset, replayed, nil
+}
+
+// readRecord reads one record, returning io.EOF only at a clean end of file.
+func readRecord(r io.Reader, header []byte) ([]byte, error) {
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errors.New("torn record header")
+		}
+		return nil, err
+	}
+	n := binary.LittleEndian.Uint32(header[0:])
+	if n == 0 || n > maxRecordSize {
+		return nil, fmt.Errorf("invalid record length %d", n)
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, errors.New("torn record")
+	}
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:]) {
+		return nil, errors.New("checksum mismatch")
+	}
+	return payload, nil
+}
+
+func applyRecord(c *cache.Cache, payload []byte) error {
+	switch payload[0] {
+	case recordSet:
+		item, err := cache.UnmarshalItem(payload[1:])
+		if err != nil {
+			return err
+		}
+		if !c.Load(item) {
+			// Expired since it was written
+			c.Unload(item.Key)
+		}
+	case recordDelete:
+		c.Unload(string(payload[1:]))
+	default:
+		return fmt.Errorf("unknown record kind %d", payload[0])
+	}
+	return nil
+}
+
+func setRecord(item cache.CacheItem) ([]byte, error) {
+	data, err := cache.MarshalItem(item)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{recordSet}, data...), nil
+}
+
+func writeRecord(w io.Writer, payload []byte) (int, error) {
+	var header [recordHeader]byte
+	binary.LittleEndian.PutUint32(header[0:], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(header[4:], crc32.ChecksumIEEE(payload))
+	if _, err := w.Write(header[:]); err != nil {
+		return 0, err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return 0, err
+	}
+	return recordHeader + len(payload), nil
+}
+
+// Stored implements cache.Journal.
+func (a *AOF) Stored(item cache.CacheItem) {
+	payload, err := setRecord(item)
+	if err != nil {
+		// Log a delete instead so an older value isn't restored on replay
+		log.Printf("Not persisting %s: %v", item.Key, err)
+		payload = append([]byte{recordDelete}, item.Key...)
+	}
+	a.append(payload)
+}
+
+// Removed implements cache.Journal.
+func (a *AOF) Removed(key string) {
+	a.append(append([]byte{recordDelete}, key...))
+}
+
+func (a *AOF) append(payload []byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.f == nil {
+		return
+	}
+	n, err := writeRecord(a.w, payload)
+	a.size += int64(n)
+	if err == nil && a.opts.Fsync == FsyncAlways {
+		if err = a.w.Flush(); err == nil {
+			err = a.f.Sync()
+		}
+	}
+	if err != nil && a.err == nil {
+		log.Printf("Failed to write to %s: %v", a.path, err)
+		a.err = err
+	}
+	if a.rewriting {
+		a.rewriteBuf = append(a.rewriteBuf, payload)
+	} else if a.opts.RewriteMinSize > 0 && a.size >= a.opts.RewriteMinSize && a.size >= 2*a.baseSize {
+		a.rewriting = true
+		go a.runRewrite()
+	}
+}
+
+// syncLoop flushes the buffered records once a second.
+func (a *AOF) syncLoop() {
+	defer close(a.done)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+			a.mu.Lock()
+			if a.f != nil {
+				a.flush(a.opts.Fsync != FsyncNever)
+			}
+			a.mu.Unlock()
+		}
+	}
+}
+
+// flush writes out buffered records and optionally syncs them. Callers must
+// hold a.mu.
+func (a *AOF) flush(sync bool) error {
+	err := a.w.Flush()
+	if err == nil && sync {
+		err = a.f.Sync()
+	}
+	if err != nil && a.err == nil {
+		log.Printf("Failed to flush %s: %v", a.path, err)
+		a.err = err
+	}
+	return err
+}
+
+// Sync flushes and syncs every record written so far. It reports the first
+// error the log has hit, if any.
+func (a *AOF) Sync() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.f == nil {
+		return ErrClosed
+	}
+	a.flush(true)
+	return a.err
+}
+
+// Size returns the current size of the file in bytes.
+func (a *AOF) Size() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.size
+}
+
+// Rewrite compacts the file down to one record per live item. Writes carry
+// on while it runs: they are logged to the old file as usual and appended to
+// the new one before it replaces the old.
+func (a *AOF) Rewrite() error {
+	a.mu.Lock()
+	if a.f == nil {
+		a.mu.Unlock()
+		return ErrClosed
+	}
+	if a.rewriting {
+		a.mu.Unlock()
+		return ErrRewriteInProgress
+	}
+	a.rewriting = true
+	a.mu.Unlock()
+	return a.rewrite()
+}
+
+func (a *AOF) runRewrite() {
+	if err := a.rewrite(); err != nil {
+		log.Printf("Background rewrite of %s failed: %v", a.path, err)
+	}
+}
+
+// rewrite does the work of Rewrite once a.rewriting has been set.
+func (a *AOF) rewrite() error {
+	defer func() {
+		a.mu.Lock()
+		a.rewriting = false
+		a.rewriteBuf = nil
+		a.mu.Unlock()
+	}()
+
+	// Records are full item states, so any write that lands in both the
+	// snapshot and rewriteBuf is simply applied twice on replay.
+	items := a.cache.Items()
+
+	tmp := a.path + ".rewrite"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriterSize(f, 64<<10)
+	size := int64(len(aofMagic))
+	w.WriteString(aofMagic)
+	for _, item := range items {
+		payload, err := setRecord(item)
+		if err != nil {
+			continue
+		}
+		n, err := writeRecord(w, payload)
+		if err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return err
+		}
+		size += int64(n)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.f == nil {
+		f.Close()
+		os.Remove(tmp)
+		return ErrClosed
+	}
+	for _, payload := range a.rewriteBuf {
+		n, _ := writeRecord(w, payload)
+		size += int64(n)
+	}
+	if err := w.Flush(); err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, a.path)
+	}
+	if err == nil {
+		err = syncDir(filepath.Dir(a.path))
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	a.flush(false)
+	a.f.Close()
+	a.f, a.w = f, bufio.NewWriterSize(f, 64<<10)
+	a.size, a.baseSize = size, size
+	log.Printf("Rewrote %s: %d items, %d bytes", a.path, len(items), size)
+	return nil
+}
+
+// syncDir makes a rename within dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Close stops logging changes, then flushes, syncs and closes the file.
+func (a *AOF) Close() error {
+	a.closeOnce.Do(func() {
+		a.cache.SetJournal(nil)
+		close(a.stop)
+		<-a.done
+	})
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.f == nil {
+		return ErrClosed
+	}
+	a.flush(true)
+	err := a.f.Close()
+	a.f = nil
+	if a.err != nil {
+		return a.err
+	}
+	return err
+}
@@ -0,0 +1,188 @@
+package persist
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/notlelouch/Distributed-Cache/pkg/cache"
+)
+
+func openAOF(t *testing.T, path string, opts AOFOptions) (*AOF, *cache.Cache) {
+	t.Helper()
+	c := cache.NewCache()
+	a, err := OpenAOF(path, c, opts)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", path, err)
+	}
+	return a, c
+}
+
+func TestAOFReplay(t *testing.T) {
+	for _, policy := range []FsyncPolicy{FsyncAlways, FsyncEverySecond, FsyncNever} {
+		t.Run(fmt.Sprint(policy), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cache.aof")
+			a, c := openAOF(t, path, AOFOptions{Fsync: policy})
+
+			item, _ := c.SetIf("greeting", "hello", time.Hour, cache.SetOptions{Tags: []string{"t"}}, cache.Precondition{})
+			c.Set("gone", "x", time.Hour)
+			c.Delete("gone")
+			c.Set("short", "x", 20*time.Millisecond)
+			c.Set("persistent", []byte{1, 2}, time.Minute)
+			c.Expire("persistent", 0)
+			c.Incr("hits", 3, 0)
+			c.Apply("list", cache.Op{Name: "rpush", Args: []string{"a", "b"}}, 0, cache.OpOrigin{})
+			if err := a.Close(); err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+
+			time.Sleep(30 * time.Millisecond)
+			a, restored := openAOF(t, path, AOFOptions{Fsync: policy})
+			defer a.Close()
+
+			got, found := restored.GetItem("greeting")
+			if !found || got.Value != "hello" || got.Version != item.Version || got.Expiration != item.Expiration || len(got.Tags) != 1 {
+				t.Errorf("Expected greeting to be restored exactly, got %+v", got)
+			}
+			for _, key := range []string{"gone", "short"} {
+				if _, found := restored.Get(key); found {
+					t.Errorf("Expected %s not to be restored", key)
+				}
+			}
+			if ttl, found := restored.TTL("persistent"); !found || ttl != 0 {
+				t.Errorf("Expected the TTL change to be replayed, got %v", ttl)
+			}
+			if n, _ := restored.Incr("hits", 0, 0); n != 3 {
+				t.Errorf("Expected counter 3, got %d", n)
+			}
+			if l, _ := restored.LRange("list", 0, -1); fmt.Sprint(l) != "[a b]" {
+				t.Errorf("Expected list to be restored, got %v", l)
+			}
+
+			// New writes get versions above the restored ones
+			next, _ := restored.SetIf("greeting", "again", 0, cache.SetOptions{}, cache.Precondition{})
+			if next.Version <= item.Version {
+				t.Errorf("Expected version after %d, got %d", item.Version, next.Version)
+			}
+		})
+	}
+}
+
+func TestAOFTruncatesTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	a, c := openAOF(t, path, AOFOptions{})
+	c.Set("a", "1", 0)
+	c.Set("b", "2", 0)
+	a.Close()
+	intact, _ := os.Stat(path)
+
+	for _, tail := range [][]byte{
+		{0x20, 0, 0},                      // Torn header
+		{0x20, 0, 0, 0, 1, 2, 3, 4, 1, 2}, // Torn payload
+		{2, 0, 0, 0, 0xde, 0xad, 0xbe, 0xef, recordDelete, 'a'}, // Bad checksum
+	} {
+		f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+		f.Write(tail)
+		f.Close()
+
+		a, restored := openAOF(t, path, AOFOptions{})
+		if v, _ := restored.Get("a"); v != "1" {
+			t.Errorf("Expected intact records to be replayed, got a=%v", v)
+		}
+		if _, found := restored.Get("b"); !found {
+			t.Errorf("Expected b to be replayed")
+		}
+		a.Close()
+		if info, _ := os.Stat(path); info.Size() != intact.Size() {
+			t.Errorf("Expected tail %x to be truncated to %d bytes, got %d", tail, intact.Size(), info.Size())
+		}
+	}
+
+	os.WriteFile(path, []byte("not an aof"), 0o644)
+	if _, err := OpenAOF(path, cache.NewCache(), AOFOptions{}); err == nil {
+		t.Errorf("Expected a foreign file to be rejected")
+	}
+}
+
+func TestAOFRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	a, c := openAOF(t, path, AOFOptions{RewriteMinSize: -1})
+	for i := 0; i < 1000; i++ {
+		c.Set(fmt.Sprintf("key-%d", i%10), fmt.Sprint(i), 0)
+	}
+	before := a.Size()
+
+	// Writes made during the rewrite must survive it
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			c.Set(fmt.Sprintf("during-%d", i), "x", 0)
+		}
+	}()
+	if err := a.Rewrite(); err != nil {
+		t.Fatalf("Rewrite failed: %v", err)
+	}
+	wg.Wait()
+	if a.Size() >= before {
+		t.Errorf("Expected rewrite to shrink the log from %d bytes, got %d", before, a.Size())
+	}
+	c.Set("after", "x", 0)
+	a.Close()
+
+	a, restored := openAOF(t, path, AOFOptions{})
+	defer a.Close()
+	if v, _ := restored.Get("key-9"); v != "999" {
+		t.Errorf("Expected latest value 999, got %v", v)
+	}
+	for _, key := range []string{"during-199", "after"} {
+		if _, found := restored.Get(key); !found {
+			t.Errorf("Expected %s to survive the rewrite", key)
+		}
+	}
+}
+
+// waitRewrite waits for any background rewrite of a to finish.
+func waitRewrite(a *AOF) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for a.rewriting {
+		a.mu.Unlock()
+		time.Sleep(time.Millisecond)
+		a.mu.Lock()
+	}
+}
+
+func TestAOFAutomaticRewrite(t *testing.T) {
+	// fill writes the same key over and over and returns the final log size
+	fill := func(opts AOFOptions) int64 {
+		a, c := openAOF(t, filepath.Join(t.TempDir(), "cache.aof"), opts)
+		defer a.Close()
+		for i := 0; i < 2000; i++ {
+			c.Set("hot", fmt.Sprint(i), 0)
+			waitRewrite(a)
+		}
+		return a.Size()
+	}
+
+	full := fill(AOFOptions{RewriteMinSize: -1})
+	compacted := fill(AOFOptions{RewriteMinSize: 4 << 10})
+	if compacted > 8<<10 || compacted > full/2 {
+		t.Errorf("Expected the log to be compacted automatically: %d bytes vs %d without rewrites", compacted, full)
+	}
+}
+
+func TestParseFsyncPolicy(t *testing.T) {
+	for s, want := range map[string]FsyncPolicy{"always": FsyncAlways, "everysec": FsyncEverySecond, "no": FsyncNever} {
+		if got, err := ParseFsyncPolicy(s); err != nil || got != want {
+			t.Errorf("%s: got %v %v", s, got, err)
+		}
+	}
+	if _, err := ParseFsyncPolicy("sometimes"); err == nil {
+		t.Errorf("Expected an unknown policy to be rejected")
+	}
+}
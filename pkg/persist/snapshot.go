@@ -0,0 +1,392 @@
+package persist
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/notlelouch/Distributed-Cache/pkg/cache"
+)
+
+// SnapshotOptions configures a Snapshotter.
+type SnapshotOptions struct {
+	// Interval between automatic snapshots. Zero takes them only on demand.
+	Interval time.Duration
+	// Retain is how many snapshots to keep; older ones are deleted after
+	// each new snapshot. Zero uses DefaultRetain; -1 keeps them all.
+	Retain int
+}
+
+// DefaultRetain is the default SnapshotOptions.Retain.
+const DefaultRetain = 5
+
+// RestoreOptions configures Snapshotter.Restore.
+type RestoreOptions struct {
+	// Rebase gives every item the TTL it had left when the snapshot was
+	// taken, counted from the restore. By default items keep their original
+	// deadlines, and those that expired since the snapshot are skipped.
+	Rebase bool
+}
+
+var ErrSnapshotNotFound = errors.New("persist: snapshot not found")
+
+// A snapshot file starts with snapshotMagic, the time it was taken in Unix
+// nanoseconds and the item count, both 8 bytes. The items follow as records
+// in the append-only file format, each holding one encoded item whose
+// expiration is relative to the time taken.
+const (
+	snapshotMagic  = "DSNP1\n"
+	snapshotHeader = len(snapshotMagic) + 16
+	snapshotPrefix = "snapshot-"
+	snapshotExt    = ".dsnp"
+	// snapshotTimeFormat sorts in time order.
+	snapshotTimeFormat = "20060102T150405.000000000Z"
+)
+
+// SnapshotInfo describes one snapshot file.
+type SnapshotInfo struct {
+	Name  string    `json:"name"`
+	Taken time.Time `json:"taken"`
+	Items int       `json:"items"`
+	Size  int64     `json:"size"`
+}
+
+// Snapshotter writes fuzzy snapshots of a cache to a directory and restores
+// them. A snapshot is not a point-in-time copy: it is read a page at a time
+// while writes carry on, so it holds every key that existed for the whole
+// copy, but writes made during the copy may or may not be in it. An item is
+// never torn, and each key appears at most once.
+type Snapshotter struct {
+	dir   string
+	opts  SnapshotOptions
+	cache *cache.Cache
+
+	mu sync.Mutex // Serializes snapshots and restores
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewSnapshotter creates dir if needed and, if opts.Interval is set, starts
+// taking snapshots of c in the background.
+func NewSnapshotter(dir string, c *cache.Cache, opts SnapshotOptions) (*Snapshotter, error) {
+	if opts.Retain == 0 {
+		opts.Retain = DefaultRetain
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &Snapshotter{
+		dir:   dir,
+		opts:  opts,
+		cache: c,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go s.loop()
+	return s, nil
+}
+
+func (s *Snapshotter) loop() {
+	defer close(s.done)
+	if s.opts.Interval <= 0 {
+		<-s.stop
+		return
+	}
+	ticker := time.NewTicker(s.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if _, err := s.Snapshot(); err != nil {
+				log.Printf("Periodic snapshot failed: %v", err)
+			}
+		}
+	}
+}
+
+// snapshotPage is how many items Snapshot copies each time it takes the
+// cache's read lock.
+const snapshotPage = 1000
+
+// Snapshot writes a fuzzy snapshot of the cache to a new file. Items are
+// copied a page at a time, so writers are only blocked for one page; values
+// are never modified in place, so encoding and writing them happens without
+// blocking writers at all. The snapshot is dated when the copy starts.
+func (s *Snapshotter) Snapshot() (SnapshotInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	taken := time.Now()
+	var payloads [][]byte
+	for cursor := ""; ; {
+		var items []cache.CacheItem
+		items, cursor = s.cache.ScanItems(cursor, snapshotPage)
+		payloads = encodeSnapshotItems(payloads, taken, items)
+		if cursor == "" {
+			break
+		}
+	}
+
+	name := snapshotPrefix + taken.UTC().Format(snapshotTimeFormat) + snapshotExt
+	path := filepath.Join(s.dir, name)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	count, size, err := writeSnapshot(f, taken, payloads)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err == nil {
+		err = syncDir(s.dir)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return SnapshotInfo{}, err
+	}
+
+	log.Printf("Wrote snapshot %s: %d items, %d bytes", name, count, size)
+	s.prune()
+	return SnapshotInfo{Name: name, Taken: taken.UTC(), Items: count, Size: size}, nil
+}
+
+// encodeSnapshotItems appends the snapshot records for items to payloads,
+// with expirations relative to taken. Items whose values cannot be persisted
+// are left out.
+func encodeSnapshotItems(payloads [][]byte, taken time.Time, items []cache.CacheItem) [][]byte {
+	for _, item := range items {
+		if item.Expiration != 0 {
+			item.Expiration -= taken.UnixNano()
+		}
+		if item.Stale != 0 {
+			item.Stale -= taken.UnixNano()
+		}
+		data, err := cache.MarshalItem(item)
+		if err != nil {
+			log.Printf("Not snapshotting %s: %v", item.Key, err)
+			continue
+		}
+		payloads = append(payloads, data)
+	}
+	return payloads
+}
+
+// writeSnapshot writes the encoded items in payloads to w and returns how
+// many were written and the size of the file.
+func writeSnapshot(w io.Writer, taken time.Time, payloads [][]byte) (int, int64, error) {
+	bw := bufio.NewWriterSize(w, 64<<10)
+	header := make([]byte, 0, snapshotHeader)
+	header = append(header, snapshotMagic...)
+	header = binary.LittleEndian.AppendUint64(header, uint64(taken.UnixNano()))
+	header = binary.LittleEndian.AppendUint64(header, uint64(len(payloads)))
+	bw.Write(header)
+
+	size := int64(len(header))
+	for _, payload := range payloads {
+		n, err := writeRecord(bw, payload)
+		if err != nil {
+			return 0, 0, err
+		}
+		size += int64(n)
+	}
+	return len(payloads), size, bw.Flush()
+}
+
+// prune deletes all but the newest opts.Retain snapshots. Callers must hold
+// s.mu.
+func (s *Snapshotter) prune() {
+	if s.opts.Retain < 0 {
+		return
+	}
+	names, err := s.names()
+	if err != nil {
+		log.Printf("Failed to list snapshots: %v", err)
+		return
+	}
+	for len(names) > s.opts.Retain {
+		if err := os.Remove(filepath.Join(s.dir, names[0])); err != nil {
+			log.Printf("Failed to delete snapshot %s: %v", names[0], err)
+		}
+		names = names[1:]
+	}
+}
+
+// names returns the snapshot file names in dir, oldest first.
+func (s *Snapshotter) names() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && validSnapshotName(e.Name()) {
+			names = append(names, e.Name())
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+func validSnapshotName(name string) bool {
+	return strings.HasPrefix(name, snapshotPrefix) && strings.HasSuffix(name, snapshotExt) &&
+		filepath.Base(name) == name
+}
+
+// List returns the snapshots in the directory, newest first.
+func (s *Snapshotter) List() ([]SnapshotInfo, error) {
+	names, err := s.names()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]SnapshotInfo, 0, len(names))
+	for i := len(names) - 1; i >= 0; i-- {
+		info, err := s.stat(names[i])
+		if err != nil {
+			log.Printf("Skipping snapshot %s: %v", names[i], err)
+			continue
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// stat reads the header of the named snapshot.
+func (s *Snapshotter) stat(name string) (SnapshotInfo, error) {
+	f, err := os.Open(filepath.Join(s.dir, name))
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	taken, count, err := readSnapshotHeader(f)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	return SnapshotInfo{Name: name, Taken: taken.UTC(), Items: count, Size: fi.Size()}, nil
+}
+
+func readSnapshotHeader(r io.Reader) (time.Time, int, error) {
+	header := make([]byte, snapshotHeader)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return time.Time{}, 0, errors.New("not a snapshot file")
+	}
+	taken := int64(binary.LittleEndian.Uint64(header[len(snapshotMagic):]))
+	count := binary.LittleEndian.Uint64(header[len(snapshotMagic)+8:])
+	if count > maxRecordSize {
+		return time.Time{}, 0, fmt.Errorf("invalid item count %d", count)
+	}
+	return time.Unix(0, taken), int(count), nil
+}
+
+// Latest returns the newest snapshot, or ErrSnapshotNotFound if there is
+// none.
+func (s *Snapshotter) Latest() (SnapshotInfo, error) {
+	infos, err := s.List()
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	if len(infos) == 0 {
+		return SnapshotInfo{}, ErrSnapshotNotFound
+	}
+	return infos[0], nil
+}
+
+// Restore replaces the contents of the cache with the named snapshot and
+// returns the number of items restored. The whole file is read and checked
+// before the cache is touched, so a damaged snapshot leaves it unchanged.
+func (s *Snapshotter) Restore(name string, opts RestoreOptions) (int, error) {
+	if !validSnapshotName(name) {
+		return 0, ErrSnapshotNotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(filepath.Join(s.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, ErrSnapshotNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	items, err := readSnapshot(f, opts, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("persist: reading snapshot %s: %w", name, err)
+	}
+	n := s.cache.Replace(items)
+	log.Printf("Restored %d of %d items from snapshot %s", n, len(items), name)
+	return n, nil
+}
+
+// readSnapshot decodes every item in r, turning relative expirations back
+// into deadlines based on the snapshot time or, when rebasing, on now.
+func readSnapshot(r io.Reader, opts RestoreOptions, now time.Time) ([]cache.CacheItem, error) {
+	br := bufio.NewReaderSize(r, 64<<10)
+	taken, count, err := readSnapshotHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	base := taken.UnixNano()
+	if opts.Rebase {
+		base = now.UnixNano()
+	}
+
+	// The count comes from the file, so it only sizes the first allocation
+	// up to a point
+	items := make([]cache.CacheItem, 0, min(count, snapshotPage))
+	header := make([]byte, recordHeader)
+	for range count {
+		payload, err := readRecord(br, header)
+		if err == io.EOF {
+			err = errors.New("truncated snapshot")
+		}
+		if err != nil {
+			return nil, err
+		}
+		item, err := cache.UnmarshalItem(payload)
+		if err != nil {
+			return nil, err
+		}
+		if item.Expiration != 0 {
+			item.Expiration += base
+		}
+		if item.Stale != 0 {
+			item.Stale += base
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// Close stops taking periodic snapshots, waiting for one in progress.
+func (s *Snapshotter) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		<-s.done
+	})
+	return nil
+}
@@ -0,0 +1,185 @@
+package persist
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/notlelouch/Distributed-Cache/pkg/cache"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	dir := t.TempDir()
+	c := cache.NewCache()
+	s, err := NewSnapshotter(dir, c, SnapshotOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create snapshotter: %v", err)
+	}
+	defer s.Close()
+
+	item, _ := c.SetIf("greeting", "hello", time.Hour, cache.SetOptions{Tags: []string{"t"}}, cache.Precondition{})
+	c.Set("short", "x", 50*time.Millisecond)
+	c.Incr("hits", 3, 0)
+	c.Apply("set", cache.Op{Name: "sadd", Args: []string{"a", "b"}}, 0, cache.OpOrigin{})
+	info, err := s.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if info.Items != 4 {
+		t.Errorf("Expected 4 items in the snapshot, got %d", info.Items)
+	}
+
+	// Changes after the snapshot are undone by restoring it
+	c.Set("greeting", "changed", 0)
+	c.Set("added", "x", 0)
+	c.Delete("hits")
+	time.Sleep(60 * time.Millisecond)
+
+	n, err := s.Restore(info.Name, RestoreOptions{})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("Expected 3 items restored without the expired one, got %d", n)
+	}
+	got, found := c.GetItem("greeting")
+	if !found || got.Value != "hello" || got.Expiration != item.Expiration || got.Version != item.Version {
+		t.Errorf("Expected greeting to be restored with its deadline, got %+v", got)
+	}
+	if _, found := c.Get("added"); found {
+		t.Error("Expected a key written after the snapshot to be removed")
+	}
+	if v, _ := c.Incr("hits", 0, 0); v != 3 {
+		t.Errorf("Expected counter 3, got %d", v)
+	}
+	if ok, _ := c.SIsMember("set", "b"); !ok {
+		t.Error("Expected the set to be restored")
+	}
+
+	// Rebasing keeps the TTL each item had left when the snapshot was taken
+	n, err = s.Restore(info.Name, RestoreOptions{Rebase: true})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if n != 4 {
+		t.Errorf("Expected 4 items restored, got %d", n)
+	}
+	if ttl, found := c.TTL("short"); !found || ttl <= 0 || ttl > 50*time.Millisecond {
+		t.Errorf("Expected short to be restored with its remaining TTL, got %v", ttl)
+	}
+
+	if _, err := s.Restore("../escape"+snapshotExt, RestoreOptions{}); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Errorf("Expected ErrSnapshotNotFound, got %v", err)
+	}
+}
+
+func TestSnapshotListAndRetain(t *testing.T) {
+	dir := t.TempDir()
+	c := cache.NewCache()
+	s, err := NewSnapshotter(dir, c, SnapshotOptions{Retain: 2})
+	if err != nil {
+		t.Fatalf("Failed to create snapshotter: %v", err)
+	}
+	defer s.Close()
+
+	var taken []SnapshotInfo
+	for i := range 3 {
+		c.Set("k", fmt.Sprint(i), 0)
+		info, err := s.Snapshot()
+		if err != nil {
+			t.Fatalf("Snapshot failed: %v", err)
+		}
+		taken = append(taken, info)
+	}
+
+	infos, err := s.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(infos) != 2 || infos[0].Name != taken[2].Name || infos[1].Name != taken[1].Name {
+		t.Fatalf("Expected the two newest snapshots, newest first, got %+v", infos)
+	}
+	if infos[0].Items != 1 || infos[0].Size != taken[2].Size || !infos[0].Taken.Equal(taken[2].Taken) {
+		t.Errorf("Expected listed info to match %+v, got %+v", taken[2], infos[0])
+	}
+	latest, err := s.Latest()
+	if err != nil || latest.Name != taken[2].Name {
+		t.Errorf("Expected latest %s, got %s (%v)", taken[2].Name, latest.Name, err)
+	}
+}
+
+func TestSnapshotRejectsDamagedFile(t *testing.T) {
+	dir := t.TempDir()
+	c := cache.NewCache()
+	s, _ := NewSnapshotter(dir, c, SnapshotOptions{})
+	defer s.Close()
+
+	c.Set("a", "1", 0)
+	c.Set("b", "2", 0)
+	info, err := s.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	path := filepath.Join(dir, info.Name)
+	if err := os.Truncate(path, info.Size-3); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Set("c", "3", 0)
+	if _, err := s.Restore(info.Name, RestoreOptions{}); err == nil {
+		t.Fatal("Expected restoring a truncated snapshot to fail")
+	}
+	if _, found := c.Get("c"); !found {
+		t.Error("Expected a failed restore to leave the cache unchanged")
+	}
+}
+
+func TestPeriodicSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	c := cache.NewCache()
+	c.Set("k", "v", 0)
+	s, err := NewSnapshotter(dir, c, SnapshotOptions{Interval: 20 * time.Millisecond, Retain: -1})
+	if err != nil {
+		t.Fatalf("Failed to create snapshotter: %v", err)
+	}
+	time.Sleep(110 * time.Millisecond)
+	s.Close()
+
+	infos, _ := s.List()
+	if len(infos) < 2 {
+		t.Errorf("Expected several periodic snapshots, got %d", len(infos))
+	}
+}
+
+func TestSnapshotSpansPages(t *testing.T) {
+	dir := t.TempDir()
+	c := cache.NewCache()
+	s, err := NewSnapshotter(dir, c, SnapshotOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create snapshotter: %v", err)
+	}
+	defer s.Close()
+
+	total := 2*snapshotPage + 7
+	for i := 0; i < total; i++ {
+		c.Set(fmt.Sprintf("key:%05d", i), fmt.Sprint(i), 0)
+	}
+	info, err := s.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if info.Items != total {
+		t.Errorf("Expected %d items in the snapshot, got %d", total, info.Items)
+	}
+
+	c.Delete("key:01500")
+	if n, err := s.Restore(info.Name, RestoreOptions{}); err != nil || n != total {
+		t.Fatalf("Restore = %d, %v, want %d items", n, err, total)
+	}
+	if v, found := c.Get("key:01500"); !found || v != "1500" {
+		t.Errorf("Expected key:01500 to be restored, got %v, %v", v, found)
+	}
+}
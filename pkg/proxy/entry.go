@@ -0,0 +1,287 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// entry is a stored response. An entry without a status is a Vary marker:
+// it only records the request headers the responses for a URL vary on, and
+// the responses themselves are stored under variant keys.
+type entry struct {
+	Status         int           `json:"status,omitempty"`
+	Header         http.Header   `json:"header,omitempty"`
+	Stored         int64         `json:"stored,omitempty"`   // When the response was received, in Unix nanoseconds
+	InitialAge     time.Duration `json:"age,omitempty"`      // Age the response already had when it was received
+	Lifetime       time.Duration `json:"lifetime,omitempty"` // How long the response is fresh for
+	NoCache        bool          `json:"no_cache,omitempty"` // The response must be revalidated on every use
+	MustRevalidate bool          `json:"must_revalidate,omitempty"`
+	Vary           []string      `json:"vary,omitempty"` // Canonical names of the request headers it varies on
+	Gen            string        `json:"gen,omitempty"`  // Generation of a marker's variants
+	Body           []byte        `json:"-"`
+}
+
+var errBadEntry = errors.New("proxy: malformed stored response")
+
+// Entries are stored as their JSON metadata, a newline and the raw body.
+func (e *entry) marshal() ([]byte, error) {
+	meta, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, 0, len(meta)+1+len(e.Body))
+	data = append(data, meta...)
+	data = append(data, '\n')
+	return append(data, e.Body...), nil
+}
+
+func unmarshalEntry(data []byte) (*entry, error) {
+	meta, body, ok := bytes.Cut(data, []byte{'\n'})
+	if !ok {
+		return nil, errBadEntry
+	}
+	e := &entry{}
+	if err := json.Unmarshal(meta, e); err != nil {
+		return nil, err
+	}
+	e.Body = body
+	return e, nil
+}
+
+func (e *entry) isMarker() bool { return e.Status == 0 }
+
+// age is how old the response is at now.
+func (e *entry) age(now time.Time) time.Duration {
+	return e.InitialAge + max(now.Sub(time.Unix(0, e.Stored)), 0)
+}
+
+// fresh reports whether the response can be served at now without asking
+// the upstream, given the request's Cache-Control.
+func (e *entry) fresh(now time.Time, reqCC directives) bool {
+	if e.NoCache || reqCC.has("no-cache") {
+		return false
+	}
+	age := e.age(now)
+	if maxAge, ok := reqCC.seconds("max-age"); ok && age > maxAge {
+		return false
+	}
+	return age < e.Lifetime
+}
+
+// hasValidators reports whether the response can be revalidated with a
+// conditional request.
+func (e *entry) hasValidators() bool {
+	return e.Header.Get("ETag") != "" || e.Header.Get("Last-Modified") != ""
+}
+
+// notModified reports whether the response matches the conditional headers
+// of r, so a 304 can be sent instead. If-None-Match takes precedence over
+// If-Modified-Since.
+func (e *entry) notModified(r *http.Request) bool {
+	if e.Status != http.StatusOK {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := strings.TrimPrefix(e.Header.Get("ETag"), "W/")
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || (etag != "" && strings.TrimPrefix(candidate, "W/") == etag) {
+				return true
+			}
+		}
+		return false
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(e.Header.Get("Last-Modified"))
+	return err == nil && !lastModified.After(ims)
+}
+
+// variantKey is the key the response to r is stored under, given the Vary
+// marker stored under key.
+func (e *entry) variantKey(key string, r *http.Request) string {
+	h := sha256.New()
+	for _, name := range e.Vary {
+		values := r.Header.Values(name)
+		for i, v := range values {
+			values[i] = strings.TrimSpace(v)
+		}
+		h.Write([]byte(name + ":" + strings.Join(values, ",") + "\n"))
+	}
+	return key + ":" + e.Gen + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+// newEntry builds the entry for a response to a GET, and reports whether a
+// shared cache may store it.
+func newEntry(r *http.Request, resp *http.Response, now time.Time) (*entry, bool) {
+	cc := parseCacheControl(resp.Header.Values("Cache-Control"))
+	e := &entry{
+		Status:         resp.StatusCode,
+		Header:         endToEnd(resp.Header),
+		Stored:         now.UnixNano(),
+		NoCache:        cc.has("no-cache"),
+		MustRevalidate: cc.has("must-revalidate") || cc.has("proxy-revalidate") || cc.has("s-maxage"),
+	}
+	e.Header.Del("Content-Length")
+	if e.Header.Get("Date") == "" {
+		e.Header.Set("Date", now.UTC().Format(http.TimeFormat))
+	}
+	e.refresh(resp.Header, now)
+
+	for _, v := range resp.Header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name == "*" {
+				return e, false
+			} else if name != "" {
+				e.Vary = append(e.Vary, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+
+	switch {
+	case !cacheableStatus[resp.StatusCode], cc.has("no-store"), cc.has("private"):
+		return e, false
+	case r.Header.Get("Authorization") != "" && !cc.has("public") && !e.MustRevalidate:
+		return e, false
+	case resp.Header.Get("Set-Cookie") != "":
+		return e, false
+	}
+	// Without validators a response that is never fresh would have to be
+	// fetched again every time anyway
+	if e.hasValidators() {
+		return e, true
+	}
+	return e, e.Lifetime > 0 && !e.NoCache
+}
+
+// refresh updates the response's age and freshness lifetime from header,
+// received at now.
+func (e *entry) refresh(header http.Header, now time.Time) {
+	e.Stored = now.UnixNano()
+	e.InitialAge = 0
+	if age, err := strconv.Atoi(header.Get("Age")); err == nil && age > 0 {
+		e.InitialAge = time.Duration(age) * time.Second
+	}
+
+	cc := parseCacheControl(e.Header.Values("Cache-Control"))
+	if lifetime, ok := cc.seconds("s-maxage"); ok {
+		e.Lifetime = lifetime
+	} else if lifetime, ok := cc.seconds("max-age"); ok {
+		e.Lifetime = lifetime
+	} else if expires, err := http.ParseTime(e.Header.Get("Expires")); err == nil {
+		date, err := http.ParseTime(e.Header.Get("Date"))
+		if err != nil {
+			date = now
+		}
+		e.Lifetime = max(expires.Sub(date), 0)
+	} else {
+		e.Lifetime = 0
+	}
+}
+
+// revalidated returns a copy of the entry updated with the headers of a 304
+// answered by the upstream at now.
+func (e *entry) revalidated(resp *http.Response, now time.Time) *entry {
+	updated := *e
+	updated.Header = e.Header.Clone()
+	for name, values := range endToEnd(resp.Header) {
+		if name != "Content-Length" {
+			updated.Header[name] = values
+		}
+	}
+	cc := parseCacheControl(updated.Header.Values("Cache-Control"))
+	updated.NoCache = cc.has("no-cache")
+	updated.MustRevalidate = cc.has("must-revalidate") || cc.has("proxy-revalidate") || cc.has("s-maxage")
+	updated.refresh(resp.Header, now)
+	return &updated
+}
+
+// ttl is how long the entry is kept in the cache: while it is fresh, and
+// then for keepStale more if it can be revalidated.
+func (e *entry) ttl(keepStale time.Duration) time.Duration {
+	ttl := max(e.Lifetime-e.InitialAge, 0)
+	if e.hasValidators() {
+		ttl += keepStale
+	}
+	return ttl
+}
+
+// cacheableStatus lists the status codes that may be stored.
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// directives holds the directives of Cache-Control headers by lower-case
+// name, with unquoted values.
+type directives map[string]string
+
+func parseCacheControl(values []string) directives {
+	cc := make(directives)
+	for _, v := range values {
+		for _, directive := range strings.Split(v, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name != "" {
+				cc[strings.ToLower(name)] = strings.Trim(value, `"`)
+			}
+		}
+	}
+	return cc
+}
+
+func (cc directives) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// seconds returns a directive holding a number of seconds as a duration.
+func (cc directives) seconds(name string) (time.Duration, bool) {
+	n, err := strconv.Atoi(cc[name])
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// hopByHop lists the headers that only apply to a single connection and
+// are never forwarded or stored.
+var hopByHop = []string{
+	"Connection", "Proxy-Connection", "Keep-Alive", "Proxy-Authenticate",
+	"Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// endToEnd returns a copy of header without its hop-by-hop headers,
+// including any named in Connection.
+func endToEnd(header http.Header) http.Header {
+	h := header.Clone()
+	if h == nil {
+		h = make(http.Header)
+	}
+	for _, v := range h.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			h.Del(strings.TrimSpace(name))
+		}
+	}
+	for _, name := range hopByHop {
+		h.Del(name)
+	}
+	return h
+}
@@ -0,0 +1,415 @@
+// Package proxy runs Disperse as a shared caching reverse proxy in front of
+// an upstream HTTP service. Responses are stored in the distributed cache,
+// so a response fetched through one node is served by every node.
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/notlelouch/Distributed-Cache/pkg/cache"
+	"github.com/notlelouch/Distributed-Cache/pkg/distributed"
+)
+
+// keyPrefix starts the cache key of every stored response.
+const keyPrefix = "proxy:"
+
+// cacheKey is the key the response to r is stored under. Request URIs hold
+// characters that can't appear in a key replicated over HTTP, so the key
+// is their hash.
+func cacheKey(r *http.Request) string {
+	sum := sha256.Sum256([]byte(r.URL.RequestURI()))
+	return keyPrefix + hex.EncodeToString(sum[:])
+}
+
+// Values of distributed.CacheStatusHeader on proxied responses.
+const (
+	statusHit         = "hit"         // Served fresh from the cache
+	statusMiss        = "miss"        // Fetched from the upstream
+	statusRevalidated = "revalidated" // Stale, and confirmed by the upstream with a 304
+	statusStale       = "stale"       // Stale, served because the upstream failed
+	statusBypass      = "bypass"      // Not looked up in the cache
+)
+
+// Defaults for Options fields left at zero.
+const (
+	DefaultKeepStale   = time.Hour
+	DefaultMaxBodySize = 8 << 20
+	// DefaultTimeout bounds a whole upstream request, body included, when
+	// Options.Client is not set.
+	DefaultTimeout = 30 * time.Second
+)
+
+// Options tunes a Server.
+type Options struct {
+	// KeepStale is how long a response with an ETag or Last-Modified is
+	// kept after it goes stale, so it can be revalidated with a conditional
+	// request instead of being fetched again.
+	KeepStale time.Duration
+	// MaxBodySize is the largest response body that is stored. Larger
+	// responses are passed through.
+	MaxBodySize int64
+	Client      *http.Client // Defaults to a client with DefaultTimeout
+}
+
+// Server is a caching reverse proxy for a single upstream.
+type Server struct {
+	dc       *distributed.DistributedCache
+	upstream *url.URL
+	opts     Options
+	server   *http.Server
+
+	mu      sync.Mutex
+	filling map[string]chan struct{} // Closed once the fill of a key is done
+}
+
+// NewServer creates a proxy that forwards to the upstream base URL and
+// caches its responses in dc. Responses are read and written on dc's own
+// node, so dc must hold every key: a sharded cluster returns
+// distributed.ErrShardedFrontend.
+func NewServer(dc *distributed.DistributedCache, upstream string, opts Options) (*Server, error) {
+	if dc.Sharded {
+		return nil, distributed.ErrShardedFrontend
+	}
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("proxy: upstream %q is not an http or https URL", upstream)
+	}
+	if opts.KeepStale <= 0 {
+		opts.KeepStale = DefaultKeepStale
+	}
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = DefaultMaxBodySize
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: DefaultTimeout}
+	}
+	s := &Server{
+		dc:       dc,
+		upstream: u,
+		opts:     opts,
+		filling:  make(map[string]chan struct{}),
+	}
+	s.server = &http.Server{Handler: s}
+	return s, nil
+}
+
+// ListenAndServe listens on addr and proxies requests until Close.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("Caching proxy for %s is running on: %s", s.upstream, l.Addr())
+	return s.Serve(l)
+}
+
+// Serve proxies requests accepted on l until Close is called.
+func (s *Server) Serve(l net.Listener) error {
+	if err := s.server.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Close stops the listener and closes all client connections.
+func (s *Server) Close() error {
+	return s.server.Close()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		s.serveCached(w, r)
+		return
+	}
+
+	resp, err := s.forward(r, r.Method, r.Body)
+	if err != nil {
+		s.upstreamFailed(w, r, err)
+		return
+	}
+	defer resp.Body.Close()
+	// A successful unsafe request may change the resource, so the stored
+	// response is dropped everywhere
+	if r.Method != http.MethodOptions && r.Method != http.MethodTrace && resp.StatusCode < 400 {
+		if err := s.dc.Delete(cacheKey(r), cache.Precondition{}); err != nil {
+			log.Printf("Failed to invalidate %s: %v", r.URL.RequestURI(), err)
+		}
+	}
+	pass(w, resp, nil, statusBypass)
+}
+
+// serveCached answers a GET or HEAD from the cache where it can.
+func (s *Server) serveCached(w http.ResponseWriter, r *http.Request) {
+	reqCC := parseCacheControl(r.Header.Values("Cache-Control"))
+	if len(reqCC) == 0 && r.Header.Get("Pragma") == "no-cache" {
+		reqCC["no-cache"] = ""
+	}
+	if reqCC.has("no-store") {
+		s.passThrough(w, r)
+		return
+	}
+
+	key := cacheKey(r)
+	if e, found := s.lookup(key, r); found {
+		if e.fresh(time.Now(), reqCC) {
+			s.serveEntry(w, r, e, statusHit)
+			return
+		}
+		s.fill(w, r, key, e)
+		return
+	}
+	if reqCC.has("only-if-cached") {
+		w.WriteHeader(http.StatusGatewayTimeout)
+		return
+	}
+	if r.Method == http.MethodHead {
+		s.passThrough(w, r)
+		return
+	}
+
+	// Concurrent misses on this node wait for the first one to fill the
+	// cache. The response they need may vary, so they look it up again.
+	s.mu.Lock()
+	done, waiting := s.filling[key]
+	if !waiting {
+		done = make(chan struct{})
+		s.filling[key] = done
+	}
+	s.mu.Unlock()
+	if waiting {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+			return
+		}
+		if e, found := s.lookup(key, r); found && e.fresh(time.Now(), reqCC) {
+			s.serveEntry(w, r, e, statusHit)
+			return
+		}
+	} else {
+		defer func() {
+			s.mu.Lock()
+			delete(s.filling, key)
+			s.mu.Unlock()
+			close(done)
+		}()
+	}
+	s.fill(w, r, key, nil)
+}
+
+// fill fetches the response to r from the upstream, stores it if it may be,
+// and answers r with it. If stale is set it is revalidated instead.
+func (s *Server) fill(w http.ResponseWriter, r *http.Request, key string, stale *entry) {
+	out, err := s.upstreamRequest(r, http.MethodGet, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The client's own conditions are answered from the stored response
+	out.Header.Del("If-None-Match")
+	out.Header.Del("If-Modified-Since")
+	if stale != nil {
+		if etag := stale.Header.Get("ETag"); etag != "" {
+			out.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := stale.Header.Get("Last-Modified"); lastModified != "" {
+			out.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	resp, err := s.opts.Client.Do(out)
+	if err == nil && resp.StatusCode >= 500 && stale != nil && !stale.MustRevalidate {
+		resp.Body.Close()
+		err = fmt.Errorf("upstream answered %s", resp.Status)
+	}
+	if err != nil {
+		if stale != nil && !stale.MustRevalidate {
+			log.Printf("Failed to revalidate %s, serving it stale: %v", r.URL.RequestURI(), err)
+			s.serveEntry(w, r, stale, statusStale)
+			return
+		}
+		s.upstreamFailed(w, r, err)
+		return
+	}
+	defer resp.Body.Close()
+	now := time.Now()
+
+	if stale != nil && resp.StatusCode == http.StatusNotModified {
+		e := stale.revalidated(resp, now)
+		s.store(key, r, e)
+		s.serveEntry(w, r, e, statusRevalidated)
+		return
+	}
+
+	e, cacheable := newEntry(r, resp, now)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, s.opts.MaxBodySize+1))
+	if err != nil {
+		s.upstreamFailed(w, r, err)
+		return
+	}
+	if !cacheable || int64(len(body)) > s.opts.MaxBodySize {
+		pass(w, resp, body, statusMiss)
+		return
+	}
+	e.Body = body
+	s.store(key, r, e)
+	s.serveEntry(w, r, e, statusMiss)
+}
+
+// lookup finds the stored response to r, following a Vary marker under key
+// to the variant matching r's headers.
+func (s *Server) lookup(key string, r *http.Request) (*entry, bool) {
+	e, found := s.get(key)
+	if found && e.isMarker() {
+		e, found = s.get(e.variantKey(key, r))
+	}
+	if !found || e.isMarker() {
+		return nil, false
+	}
+	return e, true
+}
+
+func (s *Server) get(key string) (*entry, bool) {
+	item, found := s.dc.Get(key)
+	if !found {
+		return nil, false
+	}
+	data, ok := item.Value.([]byte)
+	if !ok {
+		return nil, false
+	}
+	e, err := unmarshalEntry(data)
+	if err != nil {
+		log.Printf("Ignoring stored response %s: %v", key, err)
+		return nil, false
+	}
+	return e, true
+}
+
+// store saves e as the response to r across the cluster. A response that
+// varies is stored under its variant key, behind a marker under key that
+// names the headers it varies on.
+func (s *Server) store(key string, r *http.Request, e *entry) {
+	ttl := e.ttl(s.opts.KeepStale)
+	if ttl <= 0 {
+		return
+	}
+	if len(e.Vary) > 0 {
+		marker, found := s.get(key)
+		if !found || !marker.isMarker() || strings.Join(marker.Vary, ",") != strings.Join(e.Vary, ",") {
+			// A new generation, so variants stored before it are not used
+			marker = &entry{Vary: e.Vary, Gen: strconv.FormatInt(time.Now().UnixNano(), 36)}
+		}
+		s.set(key, marker, max(ttl, s.opts.KeepStale))
+		key = marker.variantKey(key, r)
+	}
+	s.set(key, e, ttl)
+}
+
+func (s *Server) set(key string, e *entry, ttl time.Duration) {
+	data, err := e.marshal()
+	if err == nil {
+		_, err = s.dc.Set(key, data, ttl, cache.SetOptions{}, cache.Precondition{})
+	}
+	if err != nil {
+		log.Printf("Failed to store response %s: %v", key, err)
+	}
+}
+
+// serveEntry answers r with a stored response, or with a 304 if it matches
+// the request's conditions.
+func (s *Server) serveEntry(w http.ResponseWriter, r *http.Request, e *entry, status string) {
+	h := w.Header()
+	for name, values := range e.Header {
+		h[name] = values
+	}
+	h.Set("Age", strconv.Itoa(int(e.age(time.Now()).Seconds())))
+	h.Set(distributed.CacheStatusHeader, status)
+	if e.notModified(r) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	h.Set("Content-Length", strconv.Itoa(len(e.Body)))
+	w.WriteHeader(e.Status)
+	if r.Method != http.MethodHead {
+		w.Write(e.Body)
+	}
+}
+
+// passThrough forwards r without using the cache.
+func (s *Server) passThrough(w http.ResponseWriter, r *http.Request) {
+	resp, err := s.forward(r, r.Method, nil)
+	if err != nil {
+		s.upstreamFailed(w, r, err)
+		return
+	}
+	defer resp.Body.Close()
+	pass(w, resp, nil, statusBypass)
+}
+
+func (s *Server) forward(r *http.Request, method string, body io.Reader) (*http.Response, error) {
+	out, err := s.upstreamRequest(r, method, body)
+	if err != nil {
+		return nil, err
+	}
+	return s.opts.Client.Do(out)
+}
+
+// upstreamRequest builds the request for r to the upstream.
+func (s *Server) upstreamRequest(r *http.Request, method string, body io.Reader) (*http.Request, error) {
+	target := *s.upstream
+	target.Path = strings.TrimSuffix(s.upstream.Path, "/") + r.URL.Path
+	target.RawPath = strings.TrimSuffix(s.upstream.EscapedPath(), "/") + r.URL.EscapedPath()
+	target.RawQuery = r.URL.RawQuery
+
+	out, err := http.NewRequestWithContext(r.Context(), method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	out.Header = endToEnd(r.Header)
+	out.ContentLength = r.ContentLength
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if prior := r.Header.Get("X-Forwarded-For"); prior != "" {
+			host = prior + ", " + host
+		}
+		out.Header.Set("X-Forwarded-For", host)
+	}
+	out.Header.Set("X-Forwarded-Host", r.Host)
+	return out, nil
+}
+
+func (s *Server) upstreamFailed(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("Upstream request for %s failed: %v", r.URL.RequestURI(), err)
+	http.Error(w, "Upstream unavailable", http.StatusBadGateway)
+}
+
+// pass copies an upstream response to w. head is the part of the body that
+// was already read.
+func pass(w http.ResponseWriter, resp *http.Response, head []byte, status string) {
+	h := w.Header()
+	for name, values := range endToEnd(resp.Header) {
+		h[name] = values
+	}
+	h.Set(distributed.CacheStatusHeader, status)
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(w, io.MultiReader(bytes.NewReader(head), resp.Body)); err != nil {
+		log.Printf("Failed to copy upstream response: %v", err)
+	}
+}
@@ -0,0 +1,273 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/notlelouch/Distributed-Cache/pkg/distributed"
+)
+
+// startProxy runs a proxy for upstream on a new node and returns its URL.
+func startProxy(t *testing.T, name string, memberlistPort int, upstream string) (*distributed.DistributedCache, string) {
+	t.Helper()
+
+	httpPort := memberlistPort + 1000
+	dc, err := distributed.NewDistributedCache(memberlistPort, httpPort, name)
+	if err != nil {
+		t.Fatalf("Failed to create distributed cache: %v", err)
+	}
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	dc.RegisterRoutes(app)
+	go app.Listen(fmt.Sprintf("127.0.0.1:%d", httpPort))
+
+	s, err := NewServer(dc, upstream, Options{})
+	if err != nil {
+		t.Fatalf("Failed to create proxy: %v", err)
+	}
+	front := httptest.NewServer(s)
+	t.Cleanup(func() {
+		front.Close()
+		app.Shutdown()
+		dc.List.Shutdown()
+	})
+	return dc, front.URL
+}
+
+// get requests url with the given header pairs and returns the response
+// with its body read.
+func get(t *testing.T, url string, header ...string) (*http.Response, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+func cacheStatus(resp *http.Response) string {
+	return resp.Header.Get(distributed.CacheStatusHeader)
+}
+
+func TestCachesAndRevalidates(t *testing.T) {
+	var requests, revalidations atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Cache-Control", "max-age=1")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			revalidations.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fmt.Fprint(w, "article "+r.URL.Path)
+	}))
+	defer upstream.Close()
+	_, front := startProxy(t, "proxy1", 7988, upstream.URL)
+
+	resp, body := get(t, front+"/articles/1")
+	if cacheStatus(resp) != "miss" || body != "article /articles/1" {
+		t.Fatalf("First GET = %q (%s), want a miss", body, cacheStatus(resp))
+	}
+	resp, body = get(t, front+"/articles/1")
+	if cacheStatus(resp) != "hit" || body != "article /articles/1" || requests.Load() != 1 {
+		t.Fatalf("Second GET = %q (%s) after %d upstream requests, want a hit", body, cacheStatus(resp), requests.Load())
+	}
+	if resp.Header.Get("Age") == "" || resp.Header.Get("ETag") != `"v1"` {
+		t.Errorf("Hit headers = %v, want Age and the stored ETag", resp.Header)
+	}
+
+	// The client's conditional request is answered by the cache
+	resp, _ = get(t, front+"/articles/1", "If-None-Match", `W/"v1"`)
+	if resp.StatusCode != http.StatusNotModified || requests.Load() != 1 {
+		t.Errorf("Conditional GET = %d after %d upstream requests, want a 304 from the cache", resp.StatusCode, requests.Load())
+	}
+
+	// Once stale, the response is revalidated rather than fetched again
+	time.Sleep(1100 * time.Millisecond)
+	resp, body = get(t, front+"/articles/1")
+	if cacheStatus(resp) != "revalidated" || body != "article /articles/1" || revalidations.Load() != 1 {
+		t.Fatalf("Stale GET = %q (%s) after %d revalidations, want it revalidated", body, cacheStatus(resp), revalidations.Load())
+	}
+	resp, _ = get(t, front+"/articles/1")
+	if cacheStatus(resp) != "hit" {
+		t.Errorf("GET after revalidation = %s, want a hit", cacheStatus(resp))
+	}
+
+	// no-cache in the request forces a revalidation
+	resp, _ = get(t, front+"/articles/1", "Cache-Control", "no-cache")
+	if cacheStatus(resp) != "revalidated" || revalidations.Load() != 2 {
+		t.Errorf("no-cache GET = %s, want it revalidated", cacheStatus(resp))
+	}
+}
+
+func TestStorageRules(t *testing.T) {
+	var requests atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch r.URL.Path {
+		case "/private":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		case "/no-store":
+			w.Header().Set("Cache-Control", "no-store")
+		case "/cookie":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Set-Cookie", "session=1")
+		case "/auth":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/public-auth":
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		}
+		fmt.Fprint(w, r.Method)
+	}))
+	defer upstream.Close()
+	_, front := startProxy(t, "proxy2", 7989, upstream.URL)
+
+	for _, tc := range []struct {
+		path   string
+		header []string
+		stored bool
+	}{
+		{"/private", nil, false},
+		{"/no-store", nil, false},
+		{"/cookie", nil, false},
+		{"/auth", []string{"Authorization", "Bearer x"}, false},
+		{"/public-auth", []string{"Authorization", "Bearer x"}, true},
+	} {
+		get(t, front+tc.path, tc.header...)
+		before := requests.Load()
+		resp, _ := get(t, front+tc.path, tc.header...)
+		if hit := requests.Load() == before; hit != tc.stored {
+			t.Errorf("Second GET %s was a hit: %v (%s), want %v", tc.path, hit, cacheStatus(resp), tc.stored)
+		}
+	}
+
+	resp, _ := get(t, front+"/uncached", "Cache-Control", "only-if-cached")
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("only-if-cached miss = %d, want 504", resp.StatusCode)
+	}
+}
+
+func TestVaryAndInvalidation(t *testing.T) {
+	var requests atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		fmt.Fprintf(w, "%s %s", r.Method, r.Header.Get("Accept-Language"))
+	}))
+	defer upstream.Close()
+	_, front := startProxy(t, "proxy3", 7900, upstream.URL)
+
+	for _, lang := range []string{"en", "fr", "en", "fr"} {
+		if _, body := get(t, front+"/greeting", "Accept-Language", lang); body != "GET "+lang {
+			t.Fatalf("GET in %s = %q", lang, body)
+		}
+	}
+	if requests.Load() != 2 {
+		t.Fatalf("Upstream saw %d requests, want one per language", requests.Load())
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, front+"/greeting", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp, _ = get(t, front+"/greeting", "Accept-Language", "en"); cacheStatus(resp) != "miss" {
+		t.Errorf("GET after POST = %s, want a miss", cacheStatus(resp))
+	}
+}
+
+func TestServesStaleWhenUpstreamFails(t *testing.T) {
+	var down atomic.Bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		cc := "max-age=0"
+		if r.URL.Path == "/strict" {
+			cc += ", must-revalidate"
+		}
+		w.Header().Set("Cache-Control", cc)
+		w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+		fmt.Fprint(w, "report")
+	}))
+	defer upstream.Close()
+	_, front := startProxy(t, "proxy4", 7901, upstream.URL)
+
+	get(t, front+"/report")
+	get(t, front+"/strict")
+	down.Store(true)
+
+	if resp, body := get(t, front+"/report"); cacheStatus(resp) != "stale" || body != "report" {
+		t.Errorf("GET with upstream down = %q (%s), want the stale response", body, cacheStatus(resp))
+	}
+	if resp, _ := get(t, front+"/strict"); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("must-revalidate GET with upstream down = %d, want the upstream's 503", resp.StatusCode)
+	}
+}
+
+func TestResponsesAreSharedAcrossNodes(t *testing.T) {
+	var requests atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, "shared")
+	}))
+	defer upstream.Close()
+	_, front1 := startProxy(t, "proxy5", 7902, upstream.URL)
+	dc2, front2 := startProxy(t, "proxy6", 7903, upstream.URL)
+	if err := dc2.JoinCluster("127.0.0.1:7902"); err != nil {
+		t.Fatalf("Failed to join cluster: %v", err)
+	}
+
+	// Concurrent misses on one node share one upstream request
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, body := get(t, front1+"/feed"); body != "shared" {
+				t.Errorf("GET /feed = %q", body)
+			}
+		}()
+	}
+	wg.Wait()
+	if requests.Load() != 1 {
+		t.Fatalf("Upstream saw %d requests for concurrent misses, want 1", requests.Load())
+	}
+
+	resp, body := get(t, front2+"/feed")
+	if cacheStatus(resp) != "hit" || body != "shared" || requests.Load() != 1 {
+		t.Errorf("GET on the other node = %q (%s), want a hit", body, cacheStatus(resp))
+	}
+}
+
+func TestRefusesShardedCluster(t *testing.T) {
+	dc, err := distributed.NewDistributedCache(7910, 8910, "proxy7")
+	if err != nil {
+		t.Fatalf("Failed to create distributed cache: %v", err)
+	}
+	defer dc.List.Shutdown()
+	dc.Sharded = true
+
+	if _, err := NewServer(dc, "http://127.0.0.1:1", Options{}); !errors.Is(err, distributed.ErrShardedFrontend) {
+		t.Errorf("NewServer on a sharded cluster = %v, want ErrShardedFrontend", err)
+	}
+}
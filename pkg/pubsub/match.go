@@ -0,0 +1,85 @@
+package pubsub
+
+// Match reports whether channel matches the glob pattern, with the same
+// rules as Redis PSUBSCRIBE: * matches any run of characters, ? matches one
+// character, [abc] and [a-z] match a class (negated with ^), and \ escapes
+// the next character.
+func Match(pattern, channel string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(channel); i++ {
+				if Match(pattern[1:], channel[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(channel) == 0 {
+				return false
+			}
+			channel = channel[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(channel) == 0 {
+				return false
+			}
+			end, ok := matchClass(pattern, channel[0])
+			if !ok {
+				return false
+			}
+			channel = channel[1:]
+			pattern = pattern[end:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(channel) == 0 || pattern[0] != channel[0] {
+				return false
+			}
+			channel = channel[1:]
+			pattern = pattern[1:]
+		}
+	}
+	return len(channel) == 0
+}
+
+// matchClass matches c against the [...] class at the start of pattern and
+// returns the length of the class. An unterminated class runs to the end of
+// the pattern.
+func matchClass(pattern string, c byte) (int, bool) {
+	i := 1
+	negate := i < len(pattern) && pattern[i] == '^'
+	if negate {
+		i++
+	}
+	matched := false
+	for ; i < len(pattern) && pattern[i] != ']'; i++ {
+		switch {
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			i++
+			matched = matched || pattern[i] == c
+		case i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']':
+			lo, hi := pattern[i], pattern[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || (c >= lo && c <= hi)
+			i += 2
+		default:
+			matched = matched || pattern[i] == c
+		}
+	}
+	if i < len(pattern) {
+		i++ // Closing bracket
+	}
+	return i, matched != negate
+}
@@ -0,0 +1,209 @@
+// Package pubsub is an in-process publish/subscribe broker with Redis-style
+// channel and pattern subscriptions. The distributed package forwards every
+// publish to the broker of each node in the cluster.
+package pubsub
+
+import "sync"
+
+// Message is one published payload as seen by a subscriber.
+type Message struct {
+	Channel string
+	Pattern string // The matching pattern, for pattern subscriptions
+	Payload []byte
+}
+
+// Broker routes published messages to subscriptions.
+type Broker struct {
+	mu       sync.RWMutex
+	channels map[string]map[*Subscription]struct{}
+	patterns map[string]map[*Subscription]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		channels: make(map[string]map[*Subscription]struct{}),
+		patterns: make(map[string]map[*Subscription]struct{}),
+	}
+}
+
+// Subscription receives the messages of its channels and patterns on C. A
+// subscription that falls more than its buffer behind is dropped: C is closed
+// and Lagged reports true.
+type Subscription struct {
+	C <-chan Message
+
+	c        chan Message
+	broker   *Broker
+	channels map[string]struct{}
+	patterns map[string]struct{}
+	lagged   bool
+	closed   bool
+}
+
+// Subscribe creates a subscription with room for buffer undelivered messages.
+// It listens to nothing until Subscribe or PSubscribe is called on it.
+func (b *Broker) Subscribe(buffer int) *Subscription {
+	ch := make(chan Message, buffer)
+	return &Subscription{
+		C:        ch,
+		c:        ch,
+		broker:   b,
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+	}
+}
+
+// Publish delivers payload to every subscriber of channel and of a pattern
+// matching it, and returns how many deliveries were made.
+func (b *Broker) Publish(channel string, payload []byte) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delivered := 0
+	for s := range b.channels[channel] {
+		if s.deliver(Message{Channel: channel, Payload: payload}) {
+			delivered++
+		}
+	}
+	for pattern, subs := range b.patterns {
+		if !Match(pattern, channel) {
+			continue
+		}
+		for s := range subs {
+			if s.deliver(Message{Channel: channel, Pattern: pattern, Payload: payload}) {
+				delivered++
+			}
+		}
+	}
+	return delivered
+}
+
+// deliver sends m without blocking. Callers must hold b.mu for writing.
+func (s *Subscription) deliver(m Message) bool {
+	if s.closed {
+		return false
+	}
+	select {
+	case s.c <- m:
+		return true
+	default:
+		s.lagged = true
+		s.close()
+		return false
+	}
+}
+
+// Subscribe adds channels to s and returns its total subscription count.
+func (s *Subscription) Subscribe(channels ...string) int {
+	return s.update(channels, s.channels, s.broker.channels, true)
+}
+
+// PSubscribe adds glob patterns to s and returns its total subscription count.
+func (s *Subscription) PSubscribe(patterns ...string) int {
+	return s.update(patterns, s.patterns, s.broker.patterns, true)
+}
+
+// Unsubscribe removes channels from s and returns its total subscription
+// count.
+func (s *Subscription) Unsubscribe(channels ...string) int {
+	return s.update(channels, s.channels, s.broker.channels, false)
+}
+
+// PUnsubscribe removes patterns from s and returns its total subscription
+// count.
+func (s *Subscription) PUnsubscribe(patterns ...string) int {
+	return s.update(patterns, s.patterns, s.broker.patterns, false)
+}
+
+func (s *Subscription) update(names []string, own map[string]struct{}, index map[string]map[*Subscription]struct{}, add bool) int {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+
+	if s.closed {
+		return 0
+	}
+	for _, name := range names {
+		if add {
+			own[name] = struct{}{}
+			if index[name] == nil {
+				index[name] = make(map[*Subscription]struct{})
+			}
+			index[name][s] = struct{}{}
+			continue
+		}
+		delete(own, name)
+		if subs := index[name]; subs != nil {
+			delete(subs, s)
+			if len(subs) == 0 {
+				delete(index, name)
+			}
+		}
+	}
+	return len(s.channels) + len(s.patterns)
+}
+
+// Channels returns the channels s is subscribed to.
+func (s *Subscription) Channels() []string {
+	s.broker.mu.RLock()
+	defer s.broker.mu.RUnlock()
+	return keys(s.channels)
+}
+
+// Patterns returns the patterns s is subscribed to.
+func (s *Subscription) Patterns() []string {
+	s.broker.mu.RLock()
+	defer s.broker.mu.RUnlock()
+	return keys(s.patterns)
+}
+
+// Count returns the number of channels and patterns s is subscribed to.
+func (s *Subscription) Count() int {
+	s.broker.mu.RLock()
+	defer s.broker.mu.RUnlock()
+	return len(s.channels) + len(s.patterns)
+}
+
+// Lagged reports whether s was dropped for not keeping up.
+func (s *Subscription) Lagged() bool {
+	s.broker.mu.RLock()
+	defer s.broker.mu.RUnlock()
+	return s.lagged
+}
+
+// Close removes every subscription of s and closes C.
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.close()
+}
+
+// close must be called with the broker lock held.
+func (s *Subscription) close() {
+	if s.closed {
+		return
+	}
+	for name := range s.channels {
+		delete(s.broker.channels[name], s)
+		if len(s.broker.channels[name]) == 0 {
+			delete(s.broker.channels, name)
+		}
+	}
+	for name := range s.patterns {
+		delete(s.broker.patterns[name], s)
+		if len(s.broker.patterns[name]) == 0 {
+			delete(s.broker.patterns, name)
+		}
+	}
+	clear(s.channels)
+	clear(s.patterns)
+	s.closed = true
+	close(s.c)
+}
+
+func keys(m map[string]struct{}) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
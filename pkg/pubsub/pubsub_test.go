@@ -0,0 +1,97 @@
+package pubsub
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, channel string
+		want             bool
+	}{
+		{"news.*", "news.sport", true},
+		{"news.*", "news", false},
+		{"*", "", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h*o", "ho", true},
+		{"a*b*c", "aXXbYYc", true},
+		{"a*b*c", "aXXbYY", false},
+		{`news\*`, "news*", true},
+		{`news\*`, "newsX", false},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.channel); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.channel, got, tt.want)
+		}
+	}
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	b := NewBroker()
+	s := b.Subscribe(10)
+	defer s.Close()
+
+	if n := s.Subscribe("news", "alerts"); n != 2 {
+		t.Errorf("Expected 2 subscriptions, got %d", n)
+	}
+	if n := s.PSubscribe("news.*"); n != 3 {
+		t.Errorf("Expected 3 subscriptions, got %d", n)
+	}
+
+	if n := b.Publish("news", []byte("a")); n != 1 {
+		t.Errorf("Expected 1 delivery, got %d", n)
+	}
+	if n := b.Publish("news.sport", []byte("b")); n != 1 {
+		t.Errorf("Expected 1 delivery, got %d", n)
+	}
+	if n := b.Publish("weather", []byte("c")); n != 0 {
+		t.Errorf("Expected no delivery, got %d", n)
+	}
+
+	m := <-s.C
+	if m.Channel != "news" || m.Pattern != "" || string(m.Payload) != "a" {
+		t.Errorf("Unexpected message %+v", m)
+	}
+	m = <-s.C
+	if m.Channel != "news.sport" || m.Pattern != "news.*" || string(m.Payload) != "b" {
+		t.Errorf("Unexpected message %+v", m)
+	}
+
+	s.Unsubscribe("news")
+	channels := s.Channels()
+	sort.Strings(channels)
+	if len(channels) != 1 || channels[0] != "alerts" {
+		t.Errorf("Expected only alerts to remain, got %v", channels)
+	}
+	if n := b.Publish("news", []byte("d")); n != 0 {
+		t.Errorf("Expected no delivery after unsubscribe, got %d", n)
+	}
+}
+
+func TestSlowSubscriberIsDropped(t *testing.T) {
+	b := NewBroker()
+	s := b.Subscribe(1)
+	s.Subscribe("c")
+
+	b.Publish("c", []byte("1"))
+	b.Publish("c", []byte("2"))
+
+	<-s.C
+	if _, ok := <-s.C; ok {
+		t.Errorf("Expected the lagging subscription to be closed")
+	}
+	if !s.Lagged() || s.Count() != 0 {
+		t.Errorf("Expected a lagged subscription without channels")
+	}
+	if len(b.channels) != 0 {
+		t.Errorf("Expected the broker to forget the dropped subscription")
+	}
+	s.Close()
+}
@@ -0,0 +1,135 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// maxBulkLen bounds a single bulk string, matching Redis' proto-max-bulk-len.
+const maxBulkLen = 512 << 20
+
+var errProtocol = errors.New("protocol error")
+
+// readCommand reads one command, either as a RESP array of bulk strings or as
+// an inline command (as typed into telnet).
+func readCommand(r *bufio.Reader) ([][]byte, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, nil
+	}
+	if line[0] != '*' {
+		return bytes.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < 0 || n > 1024*1024 {
+		return nil, errProtocol
+	}
+	// The count comes from the client, so the slice grows as arguments
+	// actually arrive
+	args := make([][]byte, 0, min(n, 16))
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, errProtocol
+		}
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 || size > maxBulkLen {
+			return nil, errProtocol
+		}
+		arg, err := readBulk(r, size)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// readBulk reads a bulk string of size bytes followed by CRLF. The buffer
+// grows as the data arrives, so announcing a large size costs nothing until
+// the bytes are actually sent.
+func readBulk(r *bufio.Reader, size int) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(size)+2); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes()[:size], nil
+}
+
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(line, "\r\n"), nil
+}
+
+// writer encodes replies in RESP2, or RESP3 once a client has sent HELLO 3.
+type writer struct {
+	*bufio.Writer
+	proto int
+}
+
+func (w *writer) simple(s string) {
+	fmt.Fprintf(w, "+%s\r\n", s)
+}
+
+func (w *writer) error(s string) {
+	fmt.Fprintf(w, "-%s\r\n", s)
+}
+
+func (w *writer) integer(n int64) {
+	fmt.Fprintf(w, ":%d\r\n", n)
+}
+
+func (w *writer) bulk(b []byte) {
+	fmt.Fprintf(w, "$%d\r\n", len(b))
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+func (w *writer) null() {
+	if w.proto == 3 {
+		w.WriteString("_\r\n")
+	} else {
+		w.WriteString("$-1\r\n")
+	}
+}
+
+func (w *writer) array(n int) {
+	fmt.Fprintf(w, "*%d\r\n", n)
+}
+
+// mapHeader starts a map of n pairs; RESP2 has no map type so it falls back
+// to a flat array of keys and values.
+func (w *writer) mapHeader(n int) {
+	if w.proto == 3 {
+		fmt.Fprintf(w, "%%%d\r\n", n)
+	} else {
+		w.array(2 * n)
+	}
+}
+
+// push starts an out-of-band message of n elements, such as a pub/sub
+// message; RESP2 has no push type so it is sent as a plain array.
+func (w *writer) push(n int) {
+	if w.proto == 3 {
+		fmt.Fprintf(w, ">%d\r\n", n)
+	} else {
+		w.array(n)
+	}
+}
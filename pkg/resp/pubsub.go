@@ -0,0 +1,116 @@
+package resp
+
+import (
+	"github.com/notlelouch/Distributed-Cache/pkg/distributed"
+	"github.com/notlelouch/Distributed-Cache/pkg/pubsub"
+)
+
+// subscribedCommands are the commands a RESP2 connection may still send
+// while it has subscriptions.
+var subscribedCommands = map[string]bool{
+	"SUBSCRIBE":    true,
+	"PSUBSCRIBE":   true,
+	"UNSUBSCRIBE":  true,
+	"PUNSUBSCRIBE": true,
+	"PING":         true,
+	"QUIT":         true,
+}
+
+func (s *Server) subscribe(c *client, args [][]byte) {
+	s.join(c, "subscribe", args[1:], (*pubsub.Subscription).Subscribe)
+}
+
+func (s *Server) psubscribe(c *client, args [][]byte) {
+	s.join(c, "psubscribe", args[1:], (*pubsub.Subscription).PSubscribe)
+}
+
+// unsubscribe without arguments leaves every channel.
+func (s *Server) unsubscribe(c *client, args [][]byte) {
+	s.leave(c, "unsubscribe", args[1:], (*pubsub.Subscription).Unsubscribe, (*pubsub.Subscription).Channels)
+}
+
+func (s *Server) punsubscribe(c *client, args [][]byte) {
+	s.leave(c, "punsubscribe", args[1:], (*pubsub.Subscription).PUnsubscribe, (*pubsub.Subscription).Patterns)
+}
+
+// publish replies with the number of subscribers on this node that received
+// the message; subscribers on other nodes receive it too.
+func (s *Server) publish(c *client, args [][]byte) {
+	c.w.integer(int64(s.dc.Publish(string(args[1]), args[2])))
+}
+
+// join adds each name and confirms it with its own reply, as Redis does.
+func (s *Server) join(c *client, kind string, names [][]byte, add func(*pubsub.Subscription, ...string) int) {
+	if c.sub == nil {
+		c.sub = s.dc.PubSub.Subscribe(distributed.SubscriberBuffer)
+		go s.forward(c, c.sub)
+	}
+	for _, name := range names {
+		count := add(c.sub, string(name))
+		c.w.push(3)
+		c.w.bulk([]byte(kind))
+		c.w.bulk(name)
+		c.w.integer(int64(count))
+	}
+}
+
+func (s *Server) leave(c *client, kind string, names [][]byte, remove func(*pubsub.Subscription, ...string) int, current func(*pubsub.Subscription) []string) {
+	list := make([]string, 0, len(names))
+	for _, name := range names {
+		list = append(list, string(name))
+	}
+	if len(list) == 0 && c.sub != nil {
+		list = current(c.sub)
+	}
+	if len(list) == 0 {
+		c.w.push(3)
+		c.w.bulk([]byte(kind))
+		c.w.null()
+		c.w.integer(int64(c.subscribed()))
+		return
+	}
+	for _, name := range list {
+		count := 0
+		if c.sub != nil {
+			count = remove(c.sub, name)
+		}
+		c.w.push(3)
+		c.w.bulk([]byte(kind))
+		c.w.bulk([]byte(name))
+		c.w.integer(int64(count))
+	}
+}
+
+// forward writes the messages of sub to the client until it is closed. A
+// client that falls too far behind is disconnected.
+func (s *Server) forward(c *client, sub *pubsub.Subscription) {
+	for msg := range sub.C {
+		c.mu.Lock()
+		if msg.Pattern != "" {
+			c.w.push(4)
+			c.w.bulk([]byte("pmessage"))
+			c.w.bulk([]byte(msg.Pattern))
+		} else {
+			c.w.push(3)
+			c.w.bulk([]byte("message"))
+		}
+		c.w.bulk([]byte(msg.Channel))
+		c.w.bulk(msg.Payload)
+		err := c.w.Flush()
+		c.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+	if sub.Lagged() {
+		c.conn.Close()
+	}
+}
+
+// subscribed returns the number of channels and patterns c is subscribed to.
+func (c *client) subscribed() int {
+	if c.sub == nil {
+		return 0
+	}
+	return c.sub.Count()
+}
@@ -0,0 +1,481 @@
+// Package resp serves the distributed cache over the Redis serialization
+// protocol (RESP2 and RESP3), so existing Redis client libraries can use it.
+package resp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/notlelouch/Distributed-Cache/pkg/cache"
+	"github.com/notlelouch/Distributed-Cache/pkg/distributed"
+	"github.com/notlelouch/Distributed-Cache/pkg/pubsub"
+)
+
+// Server accepts RESP connections and maps commands onto a DistributedCache.
+// Reads load from the origin and writes replicate the same way as through
+// the HTTP API.
+type Server struct {
+	dc *distributed.DistributedCache
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+}
+
+// client is the per-connection state. mu guards w, which the subscription
+// forwarder writes to as well as the command loop.
+type client struct {
+	conn net.Conn
+	mu   sync.Mutex
+	w    *writer
+	quit bool
+	sub  *pubsub.Subscription
+}
+
+type command struct {
+	handler func(s *Server, c *client, args [][]byte)
+	arity   int // Exact argument count including the name, or -min if variadic
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"PING":    {(*Server).ping, -1},
+		"ECHO":    {(*Server).echo, 2},
+		"HELLO":   {(*Server).hello, -1},
+		"SELECT":  {(*Server).selectDB, 2},
+		"CLIENT":  {(*Server).clientCmd, -2},
+		"COMMAND": {(*Server).commandCmd, -1},
+		"QUIT":    {(*Server).quit, 1},
+		"GET":     {(*Server).get, 2},
+		"SET":     {(*Server).set, -3},
+		"DEL":     {(*Server).del, -2},
+		"EXISTS":  {(*Server).exists, -2},
+		"EXPIRE":  {(*Server).expire, 3},
+		"TTL":     {(*Server).ttl, 2},
+		"PTTL":    {(*Server).pttl, 2},
+		"MGET":    {(*Server).mget, -2},
+		"MSET":    {(*Server).mset, -3},
+		"INCR":    {(*Server).incr, 2},
+		"DECR":    {(*Server).decr, 2},
+		"INCRBY":  {(*Server).incrBy, 3},
+		"DECRBY":  {(*Server).decrBy, 3},
+
+		"SUBSCRIBE":    {(*Server).subscribe, -2},
+		"PSUBSCRIBE":   {(*Server).psubscribe, -2},
+		"UNSUBSCRIBE":  {(*Server).unsubscribe, -1},
+		"PUNSUBSCRIBE": {(*Server).punsubscribe, -1},
+		"PUBLISH":      {(*Server).publish, 3},
+	}
+}
+
+func NewServer(dc *distributed.DistributedCache) *Server {
+	return &Server{
+		dc:    dc,
+		conns: make(map[net.Conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on addr and serves RESP connections until Close.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("RESP server is running on: %s", l.Addr())
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until Close is called. It refuses to run
+// against a sharded cluster with distributed.ErrShardedFrontend.
+func (s *Server) Serve(l net.Listener) error {
+	if s.dc.Sharded {
+		l.Close()
+		return distributed.ErrShardedFrontend
+	}
+	s.mu.Lock()
+	s.listener = l
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops the listener and closes all client connections.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return err
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	c := &client{conn: conn, w: &writer{Writer: bufio.NewWriter(conn), proto: 2}}
+	defer func() {
+		if c.sub != nil {
+			c.sub.Close()
+		}
+	}()
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			if err == errProtocol {
+				c.mu.Lock()
+				c.w.error("ERR Protocol error")
+				c.w.Flush()
+				c.mu.Unlock()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		c.mu.Lock()
+		s.dispatch(c, args)
+
+		// Flush once the pipeline has been drained rather than per reply
+		if c.quit || r.Buffered() == 0 {
+			if err := c.w.Flush(); err != nil || c.quit {
+				c.mu.Unlock()
+				return
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+func (s *Server) dispatch(c *client, args [][]byte) {
+	name := strings.ToUpper(string(args[0]))
+	cmd, ok := commands[name]
+	if !ok {
+		c.w.error("ERR unknown command '" + string(args[0]) + "'")
+		return
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		c.w.error("ERR wrong number of arguments for '" + strings.ToLower(name) + "' command")
+		return
+	}
+	// RESP2 has no push type, so a subscribed connection can only manage
+	// its subscriptions, as in Redis
+	if c.w.proto == 2 && !subscribedCommands[name] && c.subscribed() > 0 {
+		c.w.error("ERR Can't execute '" + strings.ToLower(name) + "': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context")
+		return
+	}
+	cmd.handler(s, c, args)
+}
+
+// ####################################################   Connection commands   ##############################################
+
+func (s *Server) ping(c *client, args [][]byte) {
+	if c.w.proto == 2 && c.subscribed() > 0 {
+		c.w.array(2)
+		c.w.bulk([]byte("pong"))
+		if len(args) > 1 {
+			c.w.bulk(args[1])
+		} else {
+			c.w.bulk(nil)
+		}
+		return
+	}
+	if len(args) > 1 {
+		c.w.bulk(args[1])
+		return
+	}
+	c.w.simple("PONG")
+}
+
+func (s *Server) echo(c *client, args [][]byte) {
+	c.w.bulk(args[1])
+}
+
+// hello negotiates the protocol version; RESP3 clients send HELLO 3 first.
+func (s *Server) hello(c *client, args [][]byte) {
+	if len(args) > 1 {
+		proto, err := strconv.Atoi(string(args[1]))
+		if err != nil || (proto != 2 && proto != 3) {
+			c.w.error("NOPROTO unsupported protocol version")
+			return
+		}
+		c.w.proto = proto
+	}
+
+	c.w.mapHeader(6)
+	c.w.bulk([]byte("server"))
+	c.w.bulk([]byte("disperse"))
+	c.w.bulk([]byte("version"))
+	c.w.bulk([]byte("7.0.0"))
+	c.w.bulk([]byte("proto"))
+	c.w.integer(int64(c.w.proto))
+	c.w.bulk([]byte("mode"))
+	c.w.bulk([]byte("standalone"))
+	c.w.bulk([]byte("role"))
+	c.w.bulk([]byte("master"))
+	c.w.bulk([]byte("modules"))
+	c.w.array(0)
+}
+
+func (s *Server) selectDB(c *client, args [][]byte) {
+	if string(args[1]) != "0" {
+		c.w.error("ERR DB index is out of range")
+		return
+	}
+	c.w.simple("OK")
+}
+
+// clientCmd accepts CLIENT SETNAME/SETINFO and friends, which client
+// libraries send on connect, without acting on them.
+func (s *Server) clientCmd(c *client, args [][]byte) {
+	c.w.simple("OK")
+}
+
+func (s *Server) commandCmd(c *client, args [][]byte) {
+	c.w.array(0)
+}
+
+func (s *Server) quit(c *client, args [][]byte) {
+	c.w.simple("OK")
+	c.quit = true
+}
+
+// ####################################################   Key commands   ##############################################
+
+func (s *Server) get(c *client, args [][]byte) {
+	s.writeValue(c, string(args[1]))
+}
+
+func (s *Server) writeValue(c *client, key string) {
+	item, found, err := s.fetch(key)
+	if err != nil {
+		c.w.error("ERR " + err.Error())
+		return
+	}
+	if !found {
+		c.w.null()
+		return
+	}
+	data, err := s.dc.Codec.Marshal(item.Value)
+	if err != nil {
+		c.w.error("ERR " + err.Error())
+		return
+	}
+	c.w.bulk(data)
+}
+
+// fetch reads key like an HTTP GET, loading it from the origin when one is
+// configured. A key the origin is known not to have is reported as missing.
+func (s *Server) fetch(key string) (cache.CacheItem, bool, error) {
+	item, found, err := s.dc.Fetch(context.Background(), key)
+	if errors.Is(err, distributed.ErrKnownMissing) {
+		return cache.CacheItem{}, false, nil
+	}
+	return item, found, err
+}
+
+// set implements SET key value [NX|XX] [EX seconds|PX milliseconds].
+func (s *Server) set(c *client, args [][]byte) {
+	var duration time.Duration
+	var cond cache.Precondition
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "NX":
+			cond.IfNoneMatch = true
+		case "XX":
+			cond.IfMatchAny = true
+		case "EX", "PX":
+			if i+1 >= len(args) || duration != 0 {
+				c.w.error("ERR syntax error")
+				return
+			}
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil || n <= 0 {
+				c.w.error("ERR invalid expire time in 'set' command")
+				return
+			}
+			unit := time.Second
+			if strings.EqualFold(string(args[i]), "PX") {
+				unit = time.Millisecond
+			}
+			duration = time.Duration(n) * unit
+			i++
+		default:
+			c.w.error("ERR syntax error")
+			return
+		}
+	}
+	if cond.IfNoneMatch && cond.IfMatchAny {
+		c.w.error("ERR syntax error")
+		return
+	}
+
+	_, err := s.dc.Set(string(args[1]), args[2], duration, cache.SetOptions{}, cond)
+	if err == cache.ErrPreconditionFailed {
+		c.w.null()
+		return
+	}
+	if err != nil {
+		c.w.error("ERR " + err.Error())
+		return
+	}
+	c.w.simple("OK")
+}
+
+func (s *Server) del(c *client, args [][]byte) {
+	var deleted int64
+	for _, key := range args[1:] {
+		if s.dc.Delete(string(key), cache.Precondition{IfMatchAny: true}) == nil {
+			deleted++
+		}
+	}
+	c.w.integer(deleted)
+}
+
+func (s *Server) exists(c *client, args [][]byte) {
+	var count int64
+	for _, key := range args[1:] {
+		_, found, err := s.fetch(string(key))
+		if err != nil {
+			c.w.error("ERR " + err.Error())
+			return
+		}
+		if found {
+			count++
+		}
+	}
+	c.w.integer(count)
+}
+
+func (s *Server) expire(c *client, args [][]byte) {
+	seconds, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		c.w.error("ERR value is not an integer or out of range")
+		return
+	}
+	key := string(args[1])
+
+	// A non-positive TTL deletes the key, as in Redis
+	if seconds <= 0 {
+		if s.dc.Delete(key, cache.Precondition{IfMatchAny: true}) == nil {
+			c.w.integer(1)
+		} else {
+			c.w.integer(0)
+		}
+		return
+	}
+	if s.dc.Expire(key, time.Duration(seconds)*time.Second) {
+		c.w.integer(1)
+	} else {
+		c.w.integer(0)
+	}
+}
+
+func (s *Server) ttl(c *client, args [][]byte) {
+	s.writeTTL(c, string(args[1]), time.Second)
+}
+
+func (s *Server) pttl(c *client, args [][]byte) {
+	s.writeTTL(c, string(args[1]), time.Millisecond)
+}
+
+// writeTTL replies -2 for a missing key, -1 for a key without expiration and
+// the remaining time in unit otherwise.
+func (s *Server) writeTTL(c *client, key string, unit time.Duration) {
+	remaining, found := s.dc.Cache.TTL(key)
+	switch {
+	case !found:
+		c.w.integer(-2)
+	case remaining == 0:
+		c.w.integer(-1)
+	default:
+		c.w.integer(int64((remaining + unit/2) / unit))
+	}
+}
+
+func (s *Server) mget(c *client, args [][]byte) {
+	c.w.array(len(args) - 1)
+	for _, key := range args[1:] {
+		s.writeValue(c, string(key))
+	}
+}
+
+func (s *Server) mset(c *client, args [][]byte) {
+	if len(args)%2 != 1 {
+		c.w.error("ERR wrong number of arguments for 'mset' command")
+		return
+	}
+	for i := 1; i < len(args); i += 2 {
+		if _, err := s.dc.Set(string(args[i]), args[i+1], 0, cache.SetOptions{}, cache.Precondition{}); err != nil {
+			c.w.error("ERR " + err.Error())
+			return
+		}
+	}
+	c.w.simple("OK")
+}
+
+func (s *Server) incr(c *client, args [][]byte) {
+	s.applyIncr(c, string(args[1]), 1)
+}
+
+func (s *Server) decr(c *client, args [][]byte) {
+	s.applyIncr(c, string(args[1]), -1)
+}
+
+func (s *Server) incrBy(c *client, args [][]byte) {
+	s.incrByArg(c, args, 1)
+}
+
+func (s *Server) decrBy(c *client, args [][]byte) {
+	s.incrByArg(c, args, -1)
+}
+
+func (s *Server) incrByArg(c *client, args [][]byte, sign int64) {
+	delta, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		c.w.error("ERR value is not an integer or out of range")
+		return
+	}
+	s.applyIncr(c, string(args[1]), sign*delta)
+}
+
+func (s *Server) applyIncr(c *client, key string, delta int64) {
+	value, err := s.dc.Incr(key, delta, 0)
+	if err != nil {
+		c.w.error("ERR value is not an integer or out of range")
+		return
+	}
+	c.w.integer(value)
+}
@@ -0,0 +1,344 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/notlelouch/Distributed-Cache/pkg/distributed"
+)
+
+// testConn speaks raw RESP to a server so the tests don't depend on a client
+// library.
+type testConn struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func startServer(t *testing.T, dc *distributed.DistributedCache) *testConn {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := NewServer(dc)
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	return &testConn{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *testConn) do(args ...string) string {
+	c.t.Helper()
+	fmt.Fprintf(c.conn, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.conn, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return c.reply()
+}
+
+// reply reads one reply and renders it compactly, e.g. "+OK", ":1", "$v",
+// "nil" or "[$a nil]".
+func (c *testConn) reply() string {
+	c.t.Helper()
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("Failed to read reply: %v", err)
+	}
+	line = strings.TrimRight(line, "\r\n")
+	switch line[0] {
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return "nil"
+		}
+		buf := make([]byte, n+2)
+		if _, err := c.r.Read(buf); err != nil {
+			c.t.Fatalf("Failed to read bulk: %v", err)
+		}
+		return "$" + string(buf[:n])
+	case '_':
+		return "nil"
+	case '*', '%', '>':
+		n, _ := strconv.Atoi(line[1:])
+		if line[0] == '%' {
+			n *= 2
+		}
+		parts := make([]string, n)
+		for i := range parts {
+			parts[i] = c.reply()
+		}
+		return "[" + strings.Join(parts, " ") + "]"
+	default:
+		return line
+	}
+}
+
+func TestRESPStringCommands(t *testing.T) {
+	dc, err := distributed.NewDistributedCache(7970, 8970, "resp1")
+	if err != nil {
+		t.Fatalf("Failed to create distributed cache: %v", err)
+	}
+	defer dc.List.Shutdown()
+	c := startServer(t, dc)
+
+	steps := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"PING"}, "+PONG"},
+		{[]string{"SET", "greeting", "hello"}, "+OK"},
+		{[]string{"GET", "greeting"}, "$hello"},
+		{[]string{"SET", "greeting", "again", "NX"}, "nil"},
+		{[]string{"SET", "missing", "x", "XX"}, "nil"},
+		{[]string{"SET", "session", "abc", "EX", "100"}, "+OK"},
+		{[]string{"TTL", "session"}, ":100"},
+		{[]string{"TTL", "greeting"}, ":-1"},
+		{[]string{"TTL", "missing"}, ":-2"},
+		{[]string{"EXPIRE", "greeting", "50"}, ":1"},
+		{[]string{"TTL", "greeting"}, ":50"},
+		{[]string{"MSET", "a", "1", "b", "2"}, "+OK"},
+		{[]string{"MGET", "a", "missing", "b"}, "[$1 nil $2]"},
+		{[]string{"INCR", "a"}, ":2"},
+		{[]string{"INCRBY", "counter", "10"}, ":10"},
+		{[]string{"DECR", "counter"}, ":9"},
+		{[]string{"GET", "counter"}, "$9"},
+		{[]string{"INCR", "greeting"}, "-ERR value is not an integer or out of range"},
+		{[]string{"EXISTS", "a", "b", "missing"}, ":2"},
+		{[]string{"DEL", "a", "b", "missing"}, ":2"},
+		{[]string{"EXISTS", "a"}, ":0"},
+		{[]string{"GET"}, "-ERR wrong number of arguments for 'get' command"},
+		{[]string{"FLUSHALL"}, "-ERR unknown command 'FLUSHALL'"},
+	}
+	for _, step := range steps {
+		if got := c.do(step.args...); got != step.want {
+			t.Errorf("%v: got %q, want %q", step.args, got, step.want)
+		}
+	}
+}
+
+func TestRESPRejectsBadLengths(t *testing.T) {
+	dc, _ := distributed.NewDistributedCache(7906, 8906, "resp6")
+	defer dc.List.Shutdown()
+	c := startServer(t, dc)
+	addr := c.conn.RemoteAddr().String()
+
+	for _, input := range []string{"*-1\r\n", "*-5\r\n", "*1\r\n$-3\r\n", "*1\r\n$-1\r\n"} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("Failed to connect after %q: %v", input, err)
+		}
+		fmt.Fprint(conn, input)
+		bad := &testConn{t: t, conn: conn, r: bufio.NewReader(conn)}
+		if got := bad.reply(); got != "-ERR Protocol error" {
+			t.Errorf("%q: got %q, want a protocol error", input, got)
+		}
+		conn.Close()
+	}
+
+	// The server is still up for everyone else
+	if got := c.do("PING"); got != "+PONG" {
+		t.Errorf("PING after bad input = %q, want +PONG", got)
+	}
+}
+
+func TestRESPPXExpiry(t *testing.T) {
+	dc, _ := distributed.NewDistributedCache(7971, 8971, "resp2")
+	defer dc.List.Shutdown()
+	c := startServer(t, dc)
+
+	if got := c.do("SET", "flash", "x", "PX", "50"); got != "+OK" {
+		t.Fatalf("SET PX failed: %s", got)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := c.do("GET", "flash"); got != "nil" {
+		t.Errorf("Expected flash to expire after 50ms, got %s", got)
+	}
+}
+
+func TestRESP3Hello(t *testing.T) {
+	dc, _ := distributed.NewDistributedCache(7972, 8972, "resp3")
+	defer dc.List.Shutdown()
+	c := startServer(t, dc)
+
+	if got := c.do("HELLO", "3"); !strings.Contains(got, "$proto :3") {
+		t.Errorf("Expected HELLO 3 to switch to RESP3, got %s", got)
+	}
+	if got := c.do("GET", "missing"); got != "nil" {
+		t.Errorf("Expected RESP3 null, got %s", got)
+	}
+	if got := c.do("HELLO", "4"); !strings.HasPrefix(got, "-NOPROTO") {
+		t.Errorf("Expected NOPROTO for unsupported version, got %s", got)
+	}
+}
+
+func TestRESPWritesAreReplicated(t *testing.T) {
+	var nodes []*distributed.DistributedCache
+	for i, name := range []string{"resp-a", "resp-b"} {
+		dc, err := distributed.NewDistributedCache(7973+i, 8973+i, name)
+		if err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+		app := fiber.New(fiber.Config{DisableStartupMessage: true})
+		dc.RegisterRoutes(app)
+		l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", 8973+i))
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		go app.Listener(l)
+		t.Cleanup(func() {
+			app.Shutdown()
+			dc.List.Shutdown()
+		})
+		nodes = append(nodes, dc)
+	}
+	if err := nodes[1].JoinCluster("127.0.0.1:7973"); err != nil {
+		t.Fatalf("Failed to join cluster: %v", err)
+	}
+
+	c := startServer(t, nodes[0])
+	c.do("SET", "shared", "value", "EX", "60")
+	c.do("INCR", "hits")
+	c.do("EXPIRE", "hits", "30")
+
+	if value, found := nodes[1].Cache.Get("shared"); !found || string(value.([]byte)) != "value" {
+		t.Errorf("Expected SET to be replicated, got %v, found: %v", value, found)
+	}
+	if ttl, found := nodes[1].Cache.TTL("hits"); !found || ttl <= 0 || ttl > 30*time.Second {
+		t.Errorf("Expected INCR and EXPIRE to be replicated, got ttl %v, found: %v", ttl, found)
+	}
+}
+
+func TestRESPPubSub(t *testing.T) {
+	dc, err := distributed.NewDistributedCache(7956, 8956, "resp-pubsub")
+	if err != nil {
+		t.Fatalf("Failed to create distributed cache: %v", err)
+	}
+	defer dc.List.Shutdown()
+	sub := startServer(t, dc)
+	pub := startServer(t, dc)
+
+	steps := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"SUBSCRIBE", "news", "sports"}, "[$subscribe $news :1]"},
+		{[]string{"GET", "x"}, "-ERR Can't execute 'get': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context"},
+		{[]string{"PSUBSCRIBE", "alerts.*"}, "[$psubscribe $alerts.* :3]"},
+		{[]string{"PING"}, "[$pong $]"},
+	}
+	for i, step := range steps {
+		if got := sub.do(step.args...); got != step.want {
+			t.Errorf("%v: got %q, want %q", step.args, got, step.want)
+		}
+		if i == 0 {
+			if got := sub.reply(); got != "[$subscribe $sports :2]" {
+				t.Errorf("Expected second subscribe confirmation, got %q", got)
+			}
+		}
+	}
+
+	if got := pub.do("PUBLISH", "news", "hello"); got != ":1" {
+		t.Errorf("Expected one receiver, got %s", got)
+	}
+	if got := sub.reply(); got != "[$message $news $hello]" {
+		t.Errorf("Unexpected message %q", got)
+	}
+	pub.do("PUBLISH", "alerts.disk", "full")
+	if got := sub.reply(); got != "[$pmessage $alerts.* $alerts.disk $full]" {
+		t.Errorf("Unexpected pattern message %q", got)
+	}
+
+	sub.do("UNSUBSCRIBE")
+	sub.reply()
+	if got := sub.do("PUNSUBSCRIBE", "alerts.*"); got != "[$punsubscribe $alerts.* :0]" {
+		t.Errorf("Unexpected punsubscribe reply %q", got)
+	}
+	if got := sub.do("PING"); got != "+PONG" {
+		t.Errorf("Expected normal commands after unsubscribing, got %q", got)
+	}
+
+	// RESP3 clients get messages as push replies and may keep issuing commands
+	sub.do("HELLO", "3")
+	sub.do("SUBSCRIBE", "news")
+	if got := sub.do("GET", "missing"); got != "nil" {
+		t.Errorf("Expected GET to work while subscribed over RESP3, got %q", got)
+	}
+	pub.do("PUBLISH", "news", "again")
+	if got := sub.reply(); got != "[$message $news $again]" {
+		t.Errorf("Unexpected push message %q", got)
+	}
+}
+
+func TestRESPRefusesShardedCluster(t *testing.T) {
+	dc, err := distributed.NewDistributedCache(7907, 8907, "resp7")
+	if err != nil {
+		t.Fatalf("Failed to create distributed cache: %v", err)
+	}
+	defer dc.List.Shutdown()
+	dc.Sharded = true
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer l.Close()
+	if err := NewServer(dc).Serve(l); !errors.Is(err, distributed.ErrShardedFrontend) {
+		t.Errorf("Serve on a sharded cluster = %v, want ErrShardedFrontend", err)
+	}
+}
+
+func TestRESPReadsThroughOrigin(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/loaded":
+			w.Write([]byte("from origin"))
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer origin.Close()
+
+	dc, err := distributed.NewDistributedCache(7916, 8916, "resp-origin")
+	if err != nil {
+		t.Fatalf("Failed to create distributed cache: %v", err)
+	}
+	defer dc.List.Shutdown()
+	dc.SetOrigin(distributed.OriginOptions{Loader: &distributed.HTTPOrigin{BaseURL: origin.URL}, NegativeTTL: time.Minute})
+	c := startServer(t, dc)
+
+	steps := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"GET", "loaded"}, "$from origin"},
+		{[]string{"GET", "ghost"}, "nil"},
+		{[]string{"EXISTS", "ghost", "other"}, ":0"},
+		{[]string{"MGET", "ghost", "loaded"}, "[nil $from origin]"},
+		{[]string{"EXISTS", "loaded", "ghost"}, ":1"},
+	}
+	for _, step := range steps {
+		if got := c.do(step.args...); got != step.want {
+			t.Errorf("%v: expected %q, got %q", step.args, step.want, got)
+		}
+	}
+	if got := c.do("GET", "broken"); !strings.HasPrefix(got, "-ERR") {
+		t.Errorf("Expected an origin failure to be an error reply, got %q", got)
+	}
+}
@@ -0,0 +1,275 @@
+// Package rpc serves the distributed cache over gRPC. The service is defined
+// in cachepb/cache.proto, from which typed clients can be generated for any
+// language.
+package rpc
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/notlelouch/Distributed-Cache/pkg/cache"
+	"github.com/notlelouch/Distributed-Cache/pkg/distributed"
+	"github.com/notlelouch/Distributed-Cache/pkg/rpc/cachepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// WatchBuffer is how many events a Watch stream may fall behind before it is
+// disconnected.
+var WatchBuffer = 1024
+
+// Server implements cachepb.CacheServer on top of a DistributedCache. Reads
+// and writes go through the same DistributedCache methods as the HTTP API,
+// so reads load from the origin and writes replicate and version items
+// identically.
+type Server struct {
+	cachepb.UnimplementedCacheServer
+
+	dc   *distributed.DistributedCache
+	grpc *grpc.Server
+}
+
+func NewServer(dc *distributed.DistributedCache, opts ...grpc.ServerOption) *Server {
+	s := &Server{
+		dc:   dc,
+		grpc: grpc.NewServer(opts...),
+	}
+	cachepb.RegisterCacheServer(s.grpc, s)
+	return s
+}
+
+// ListenAndServe listens on addr and serves gRPC requests until Close.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("gRPC server is running on: %s", l.Addr())
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until Close is called. It refuses to run
+// against a sharded cluster with distributed.ErrShardedFrontend.
+func (s *Server) Serve(l net.Listener) error {
+	if s.dc.Sharded {
+		l.Close()
+		return distributed.ErrShardedFrontend
+	}
+	return s.grpc.Serve(l)
+}
+
+// Close stops the server, cancelling in-flight RPCs and Watch streams.
+func (s *Server) Close() error {
+	s.grpc.Stop()
+	return nil
+}
+
+func (s *Server) Get(ctx context.Context, req *cachepb.GetRequest) (*cachepb.GetResponse, error) {
+	if req.Key == "" {
+		return nil, status.Error(codes.InvalidArgument, "key is required")
+	}
+	return s.get(ctx, req.Key)
+}
+
+func (s *Server) Put(ctx context.Context, req *cachepb.PutRequest) (*cachepb.PutResponse, error) {
+	item, err := s.put(req)
+	if err != nil {
+		return nil, err
+	}
+	return &cachepb.PutResponse{Item: item}, nil
+}
+
+func (s *Server) Delete(ctx context.Context, req *cachepb.DeleteRequest) (*cachepb.DeleteResponse, error) {
+	if err := s.delete(req); err != nil {
+		return nil, err
+	}
+	return &cachepb.DeleteResponse{}, nil
+}
+
+func (s *Server) BatchGet(ctx context.Context, req *cachepb.BatchGetRequest) (*cachepb.BatchGetResponse, error) {
+	resp := &cachepb.BatchGetResponse{Results: make([]*cachepb.GetResponse, len(req.Keys))}
+	for i, key := range req.Keys {
+		result, err := s.get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if !result.Found {
+			result.Item = &cachepb.Item{Key: key}
+		}
+		resp.Results[i] = result
+	}
+	return resp, nil
+}
+
+func (s *Server) BatchPut(ctx context.Context, req *cachepb.BatchPutRequest) (*cachepb.BatchPutResponse, error) {
+	resp := &cachepb.BatchPutResponse{}
+	for _, put := range req.Puts {
+		item, err := s.put(put)
+		resp.Statuses = append(resp.Statuses, keyStatus(put.Key, err))
+		if err == nil {
+			resp.Items = append(resp.Items, item)
+		}
+	}
+	return resp, nil
+}
+
+func (s *Server) BatchDelete(ctx context.Context, req *cachepb.BatchDeleteRequest) (*cachepb.BatchDeleteResponse, error) {
+	resp := &cachepb.BatchDeleteResponse{}
+	for _, del := range req.Deletes {
+		resp.Statuses = append(resp.Statuses, keyStatus(del.Key, s.delete(del)))
+	}
+	return resp, nil
+}
+
+// Watch streams every change to keys under req.Prefix, including writes
+// replicated from other nodes.
+func (s *Server) Watch(req *cachepb.WatchRequest, stream cachepb.Cache_WatchServer) error {
+	var w *cache.Watcher[string, interface{}]
+	if req.SinceSeq != 0 {
+		var err error
+		if w, err = s.dc.Cache.WatchFrom(req.SinceSeq, WatchBuffer); err != nil {
+			return status.Error(codes.OutOfRange, err.Error())
+		}
+	} else {
+		w = s.dc.Cache.Watch(WatchBuffer)
+	}
+	defer w.Close()
+
+	for {
+		select {
+		case ev, ok := <-w.C:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "watcher fell too far behind")
+			}
+			if !strings.HasPrefix(ev.Key, req.Prefix) {
+				continue
+			}
+			item, err := s.toItem(ev.Item)
+			if err != nil {
+				return err
+			}
+			out := &cachepb.WatchEvent{
+				Type: eventTypes[ev.Type],
+				Key:  ev.Key,
+				Item: item,
+				Seq:  ev.Seq,
+			}
+			if err := stream.Send(out); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+var eventTypes = map[cache.EventType]cachepb.WatchEvent_Type{
+	cache.EventSet:    cachepb.WatchEvent_SET,
+	cache.EventDelete: cachepb.WatchEvent_DELETE,
+	cache.EventExpire: cachepb.WatchEvent_EXPIRE,
+	cache.EventEvict:  cachepb.WatchEvent_EVICT,
+}
+
+func (s *Server) get(ctx context.Context, key string) (*cachepb.GetResponse, error) {
+	item, found, err := s.dc.Fetch(ctx, key)
+	if errors.Is(err, distributed.ErrKnownMissing) {
+		return &cachepb.GetResponse{}, nil
+	}
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, "failed to load from origin: "+err.Error())
+	}
+	if !found {
+		return &cachepb.GetResponse{}, nil
+	}
+	out, err := s.toItem(item)
+	if err != nil {
+		return nil, err
+	}
+	return &cachepb.GetResponse{Found: true, Item: out}, nil
+}
+
+func (s *Server) put(req *cachepb.PutRequest) (*cachepb.Item, error) {
+	if req.Key == "" {
+		return nil, status.Error(codes.InvalidArgument, "key is required")
+	}
+	if req.TtlMillis < 0 {
+		return nil, status.Error(codes.InvalidArgument, "ttl must not be negative")
+	}
+	opts := cache.SetOptions{ContentType: req.ContentType, Flags: req.Flags}
+	// Copy the value so the stored item does not share the request buffer
+	value := append([]byte{}, req.Value...)
+	duration := time.Duration(req.TtlMillis) * time.Millisecond
+
+	item, err := s.dc.Set(req.Key, value, duration, opts, precondition(req.Precondition))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return s.toItem(item)
+}
+
+func (s *Server) delete(req *cachepb.DeleteRequest) error {
+	if req.Key == "" {
+		return status.Error(codes.InvalidArgument, "key is required")
+	}
+	return toStatus(s.dc.Delete(req.Key, precondition(req.Precondition)))
+}
+
+// toItem converts a cache item, encoding its value with the node's codec just
+// like an HTTP GET does.
+func (s *Server) toItem(item cache.CacheItem) (*cachepb.Item, error) {
+	data, err := s.dc.Codec.Marshal(item.Value)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to encode value: %v", err)
+	}
+	return &cachepb.Item{
+		Key:         item.Key,
+		Value:       data,
+		ContentType: item.ContentType,
+		Version:     item.Version,
+		ExpiresAt:   item.Expiration,
+		Modified:    item.Modified,
+		Flags:       item.Flags,
+	}, nil
+}
+
+func precondition(p *cachepb.Precondition) cache.Precondition {
+	if p == nil {
+		return cache.Precondition{}
+	}
+	cond := cache.Precondition{
+		IfMatch:     p.IfMatch,
+		IfMatchAny:  p.IfMatchAny,
+		IfNoneMatch: p.IfNoneMatch,
+	}
+	if p.IfUnmodifiedSince != 0 {
+		cond.IfUnmodifiedSince = time.Unix(p.IfUnmodifiedSince, 0)
+	}
+	return cond
+}
+
+func toStatus(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, cache.ErrPreconditionFailed):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case status.Code(err) != codes.Unknown:
+		return err
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+func keyStatus(key string, err error) *cachepb.KeyStatus {
+	st := status.Convert(err)
+	return &cachepb.KeyStatus{
+		Key:     key,
+		Code:    uint32(st.Code()),
+		Message: st.Message(),
+	}
+}
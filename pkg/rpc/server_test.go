@@ -0,0 +1,244 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/notlelouch/Distributed-Cache/pkg/distributed"
+	"github.com/notlelouch/Distributed-Cache/pkg/rpc/cachepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// startNode starts a cache node that replicates over the binary transport
+// and serves gRPC on a random port.
+func startNode(t *testing.T, memberlistPort int) (*distributed.DistributedCache, cachepb.CacheClient) {
+	t.Helper()
+
+	dc, err := distributed.NewDistributedCache(memberlistPort, memberlistPort+1000, fmt.Sprintf("grpc%d", memberlistPort))
+	if err != nil {
+		t.Fatalf("Failed to create distributed cache: %v", err)
+	}
+	if err := dc.StartReplication(memberlistPort + 2000); err != nil {
+		t.Fatalf("Failed to start replication: %v", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := NewServer(dc)
+	go s.Serve(l)
+
+	conn, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		s.Close()
+		dc.StopReplication()
+		dc.List.Shutdown()
+	})
+	return dc, cachepb.NewCacheClient(conn)
+}
+
+func TestGetPutDelete(t *testing.T) {
+	_, client := startNode(t, 7930)
+	ctx := context.Background()
+
+	put, err := client.Put(ctx, &cachepb.PutRequest{Key: "greeting", Value: []byte("hello"), ContentType: "text/plain", TtlMillis: 60000})
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if put.Item.Version == 0 || put.Item.ExpiresAt == 0 {
+		t.Errorf("Expected a versioned, expiring item, got %+v", put.Item)
+	}
+
+	got, err := client.Get(ctx, &cachepb.GetRequest{Key: "greeting"})
+	if err != nil || !got.Found || string(got.Item.Value) != "hello" || got.Item.ContentType != "text/plain" {
+		t.Errorf("Unexpected Get result %+v, %v", got, err)
+	}
+
+	// A stale version must not be overwritten or deleted
+	_, err = client.Put(ctx, &cachepb.PutRequest{Key: "greeting", Value: []byte("x"), Precondition: &cachepb.Precondition{IfMatch: []uint64{put.Item.Version + 1}}})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition, got %v", err)
+	}
+	_, err = client.Delete(ctx, &cachepb.DeleteRequest{Key: "greeting", Precondition: &cachepb.Precondition{IfMatch: []uint64{put.Item.Version}}})
+	if err != nil {
+		t.Fatalf("Conditional delete failed: %v", err)
+	}
+
+	got, err = client.Get(ctx, &cachepb.GetRequest{Key: "greeting"})
+	if err != nil || got.Found {
+		t.Errorf("Expected key to be deleted, got %+v, %v", got, err)
+	}
+
+	if _, err := client.Get(ctx, &cachepb.GetRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument for empty key, got %v", err)
+	}
+}
+
+func TestBatchRPCs(t *testing.T) {
+	_, client := startNode(t, 7932)
+	ctx := context.Background()
+
+	puts, err := client.BatchPut(ctx, &cachepb.BatchPutRequest{Puts: []*cachepb.PutRequest{
+		{Key: "a", Value: []byte("1")},
+		{Key: "b", Value: []byte("2")},
+		{Key: "a", Value: []byte("3"), Precondition: &cachepb.Precondition{IfNoneMatch: true}},
+	}})
+	if err != nil {
+		t.Fatalf("BatchPut failed: %v", err)
+	}
+	want := []codes.Code{codes.OK, codes.OK, codes.FailedPrecondition}
+	for i, st := range puts.Statuses {
+		if st.Code != uint32(want[i]) {
+			t.Errorf("Expected put %d to end with %v, got %+v", i, want[i], st)
+		}
+	}
+	if len(puts.Items) != 2 {
+		t.Errorf("Expected 2 stored items, got %d", len(puts.Items))
+	}
+
+	gets, err := client.BatchGet(ctx, &cachepb.BatchGetRequest{Keys: []string{"a", "missing", "b"}})
+	if err != nil {
+		t.Fatalf("BatchGet failed: %v", err)
+	}
+	if !gets.Results[0].Found || string(gets.Results[0].Item.Value) != "1" ||
+		gets.Results[1].Found || gets.Results[1].Item.Key != "missing" ||
+		!gets.Results[2].Found || string(gets.Results[2].Item.Value) != "2" {
+		t.Errorf("Unexpected BatchGet results: %v", gets.Results)
+	}
+
+	dels, err := client.BatchDelete(ctx, &cachepb.BatchDeleteRequest{Deletes: []*cachepb.DeleteRequest{
+		{Key: "a"},
+		{Key: "b", Precondition: &cachepb.Precondition{IfMatch: []uint64{1}}},
+	}})
+	if err != nil {
+		t.Fatalf("BatchDelete failed: %v", err)
+	}
+	if dels.Statuses[0].Code != 0 || dels.Statuses[1].Code != uint32(codes.FailedPrecondition) {
+		t.Errorf("Unexpected BatchDelete statuses: %v", dels.Statuses)
+	}
+}
+
+func TestGetReadsThroughOrigin(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/loaded":
+			w.Write([]byte("from origin"))
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer origin.Close()
+
+	dc, client := startNode(t, 7917)
+	dc.SetOrigin(distributed.OriginOptions{Loader: &distributed.HTTPOrigin{BaseURL: origin.URL}, NegativeTTL: time.Minute})
+	ctx := context.Background()
+
+	got, err := client.Get(ctx, &cachepb.GetRequest{Key: "loaded"})
+	if err != nil || !got.Found || string(got.Item.Value) != "from origin" {
+		t.Errorf("Expected the miss to be loaded from the origin, got %v (%v)", got, err)
+	}
+	// A key the origin lacks is not found, also once it is in the negative
+	// cache
+	for i := 0; i < 2; i++ {
+		gets, err := client.BatchGet(ctx, &cachepb.BatchGetRequest{Keys: []string{"ghost"}})
+		if err != nil || gets.Results[0].Found {
+			t.Errorf("Expected ghost to be missing, got %v (%v)", gets, err)
+		}
+	}
+	if _, err := client.Get(ctx, &cachepb.GetRequest{Key: "broken"}); status.Code(err) != codes.Unavailable {
+		t.Errorf("Expected an origin failure to be Unavailable, got %v", err)
+	}
+}
+
+func TestWatchSeesReplicatedWrites(t *testing.T) {
+	dc1, client1 := startNode(t, 7934)
+	dc2, client2 := startNode(t, 7935)
+	if err := dc2.JoinCluster(dc1.List.LocalNode().Address()); err != nil {
+		t.Fatalf("Failed to join cluster: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client2.Watch(ctx, &cachepb.WatchRequest{Prefix: "user:"})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	// Wait for the watch to be registered before writing
+	time.Sleep(100 * time.Millisecond)
+
+	client1.Put(ctx, &cachepb.PutRequest{Key: "other", Value: []byte("ignored")})
+	client1.Put(ctx, &cachepb.PutRequest{Key: "user:1", Value: []byte("alice")})
+	client1.Delete(ctx, &cachepb.DeleteRequest{Key: "user:1"})
+
+	ev, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Recv failed: %v", err)
+	}
+	if ev.Type != cachepb.WatchEvent_SET || ev.Key != "user:1" || string(ev.Item.Value) != "alice" {
+		t.Errorf("Expected SET of user:1, got %v", ev)
+	}
+	ev, err = stream.Recv()
+	if err != nil {
+		t.Fatalf("Recv failed: %v", err)
+	}
+	if ev.Type != cachepb.WatchEvent_DELETE || ev.Key != "user:1" {
+		t.Errorf("Expected DELETE of user:1, got %v", ev)
+	}
+}
+
+func TestWatchResumesFromSeq(t *testing.T) {
+	dc, client := startNode(t, 7926)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client.Put(ctx, &cachepb.PutRequest{Key: "a", Value: []byte("1")})
+	client.Put(ctx, &cachepb.PutRequest{Key: "b", Value: []byte("2")})
+	dc.Cache.SetMaxItems(1)
+
+	stream, err := client.Watch(ctx, &cachepb.WatchRequest{SinceSeq: 1})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	for _, want := range []cachepb.WatchEvent_Type{cachepb.WatchEvent_SET, cachepb.WatchEvent_EVICT} {
+		ev, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv failed: %v", err)
+		}
+		if ev.Type != want {
+			t.Errorf("Expected %v, got %v", want, ev)
+		}
+	}
+}
+
+func TestGRPCRefusesShardedCluster(t *testing.T) {
+	dc, err := distributed.NewDistributedCache(7909, 8909, "grpc7909")
+	if err != nil {
+		t.Fatalf("Failed to create distributed cache: %v", err)
+	}
+	defer dc.List.Shutdown()
+	dc.Sharded = true
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer l.Close()
+	if err := NewServer(dc).Serve(l); !errors.Is(err, distributed.ErrShardedFrontend) {
+		t.Errorf("Serve on a sharded cluster = %v, want ErrShardedFrontend", err)
+	}
+}
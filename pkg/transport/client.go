@@ -0,0 +1,276 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DialTimeout bounds how long establishing a pooled connection may take.
+var DialTimeout = 2 * time.Second
+
+// conn is one multiplexed client connection.
+type conn struct {
+	netConn net.Conn
+	out     *frameWriter
+
+	mu      sync.Mutex
+	pending map[uint64]chan frame
+	nextID  uint64
+	err     error
+}
+
+func dial(addr string) (*conn, error) {
+	nc, err := net.DialTimeout("tcp", addr, DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{
+		netConn: nc,
+		out:     newFrameWriter(nc),
+		pending: make(map[uint64]chan frame),
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+func (c *conn) readLoop() {
+	r := bufio.NewReader(c.netConn)
+	for {
+		f, err := readFrame(r)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		c.mu.Lock()
+		ch, ok := c.pending[f.id]
+		delete(c.pending, f.id)
+		c.mu.Unlock()
+		if ok {
+			ch <- f
+		}
+	}
+}
+
+// fail closes the connection and wakes every pending call.
+func (c *conn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err == nil {
+		c.err = err
+	}
+	c.netConn.Close()
+	c.out.close()
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
+
+func (c *conn) broken() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err != nil
+}
+
+func (c *conn) call(ctx context.Context, body []byte) ([]byte, error) {
+	id, ch, err := c.send(body)
+	if err != nil {
+		return nil, err
+	}
+	return c.wait(ctx, id, ch)
+}
+
+// send writes a request without waiting for its response, so that requests
+// sent one after another reach the server in that order.
+func (c *conn) send(body []byte) (uint64, chan frame, error) {
+	ch := make(chan frame, 1)
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return 0, nil, ErrClosed
+	}
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	if err := c.out.send(frame{id: id, kind: kindRequest, body: body}); err != nil {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return 0, nil, err
+	}
+	return id, ch, nil
+}
+
+// wait waits for the response to the request sent with id.
+func (c *conn) wait(ctx context.Context, id uint64, ch chan frame) ([]byte, error) {
+	select {
+	case f, ok := <-ch:
+		if !ok {
+			return nil, ErrClosed
+		}
+		if f.kind == kindError {
+			return nil, RemoteError(f.body)
+		}
+		return f.body, nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// Pool keeps a fixed number of multiplexed connections per remote address
+// and spreads calls over them round-robin. Broken connections are redialed
+// on the next call.
+type Pool struct {
+	size int
+
+	mu      sync.Mutex
+	conns   map[string][]*conn
+	dialing map[slotKey]*pendingDial
+	next    atomic.Uint64
+}
+
+type slotKey struct {
+	addr string
+	slot int
+}
+
+// pendingDial is a connection being dialed without the pool lock held.
+// Calls that want the same slot wait for it instead of dialing again.
+type pendingDial struct {
+	done chan struct{}
+	err  error
+}
+
+// NewPool creates a pool holding up to connsPerAddr connections per address.
+func NewPool(connsPerAddr int) *Pool {
+	if connsPerAddr < 1 {
+		connsPerAddr = 1
+	}
+	return &Pool{
+		size:    connsPerAddr,
+		conns:   make(map[string][]*conn),
+		dialing: make(map[slotKey]*pendingDial),
+	}
+}
+
+// Call sends body to the server at addr and waits for its response.
+func (p *Pool) Call(ctx context.Context, addr string, body []byte) ([]byte, error) {
+	c, err := p.get(addr)
+	if err != nil {
+		return nil, err
+	}
+	return c.call(ctx, body)
+}
+
+// CallBatch sends every body to addr back to back on one connection, so
+// they share writes and are handled in order, and returns the responses. errs[i] is set for failed calls.
+func (p *Pool) CallBatch(ctx context.Context, addr string, bodies [][]byte) (responses [][]byte, errs []error) {
+	responses = make([][]byte, len(bodies))
+	errs = make([]error, len(bodies))
+
+	c, err := p.get(addr)
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return responses, errs
+	}
+
+	// Send everything before waiting so the server sees the calls in order
+	ids := make([]uint64, len(bodies))
+	chans := make([]chan frame, len(bodies))
+	for i, body := range bodies {
+		ids[i], chans[i], errs[i] = c.send(body)
+	}
+	for i := range bodies {
+		if errs[i] == nil {
+			responses[i], errs[i] = c.wait(ctx, ids[i], chans[i])
+		}
+	}
+	return responses, errs
+}
+
+func (p *Pool) get(addr string) (*conn, error) {
+	key := slotKey{addr, int(p.next.Add(1) % uint64(p.size))}
+
+	for {
+		p.mu.Lock()
+		conns := p.conns[addr]
+		if len(conns) < p.size {
+			conns = append(conns, make([]*conn, p.size-len(conns))...)
+			p.conns[addr] = conns
+		}
+		if c := conns[key.slot]; c != nil && !c.broken() {
+			p.mu.Unlock()
+			return c, nil
+		}
+		if pending, ok := p.dialing[key]; ok {
+			p.mu.Unlock()
+			<-pending.done
+			if pending.err != nil {
+				return nil, pending.err
+			}
+			continue
+		}
+		pending := &pendingDial{done: make(chan struct{})}
+		p.dialing[key] = pending
+		p.mu.Unlock()
+
+		c, err := dial(addr)
+
+		p.mu.Lock()
+		delete(p.dialing, key)
+		if err == nil {
+			if conns := p.conns[addr]; conns != nil {
+				conns[key.slot] = c
+			} else {
+				// The address was evicted or the pool closed while dialing
+				c.fail(ErrClosed)
+				c, err = nil, ErrClosed
+			}
+		}
+		pending.err = err
+		close(pending.done)
+		p.mu.Unlock()
+		return c, err
+	}
+}
+
+// Evict closes the pooled connections to addr, for a server that has left.
+func (p *Pool) Evict(addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, c := range p.conns[addr] {
+		if c != nil {
+			c.fail(ErrClosed)
+		}
+	}
+	delete(p.conns, addr)
+}
+
+// Close closes every pooled connection.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for addr, conns := range p.conns {
+		for _, c := range conns {
+			if c != nil {
+				c.fail(ErrClosed)
+			}
+		}
+		delete(p.conns, addr)
+	}
+}
@@ -0,0 +1,76 @@
+// Package transport is a compact binary protocol for node-to-node traffic.
+// Connections are persistent and pipelined: every frame carries a request ID,
+// so many calls can be in flight on one connection. The server handles the
+// requests on a connection one at a time, in the order they arrive, and
+// answers them in that order. Frames queued while a connection is busy are written
+// together, which batches small replication messages into few syscalls.
+//
+// Frame layout (big endian):
+//
+//	[4 body length][8 request id][1 kind][body]
+package transport
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	frameHeaderLen = 4 + 8 + 1
+	maxFrameLen    = 64 << 20
+)
+
+// Frame kinds
+const (
+	kindRequest  byte = 0
+	kindResponse byte = 1
+	kindError    byte = 2
+)
+
+var (
+	ErrFrameTooLarge = errors.New("transport: frame too large")
+	ErrClosed        = errors.New("transport: connection closed")
+)
+
+// RemoteError is returned by Call when the handler on the other node failed.
+type RemoteError string
+
+func (e RemoteError) Error() string { return "transport: remote error: " + string(e) }
+
+type frame struct {
+	id   uint64
+	kind byte
+	body []byte
+}
+
+func writeFrame(w *bufio.Writer, f frame) error {
+	var header [frameHeaderLen]byte
+	binary.BigEndian.PutUint32(header[0:], uint32(len(f.body)))
+	binary.BigEndian.PutUint64(header[4:], f.id)
+	header[12] = f.kind
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(f.body)
+	return err
+}
+
+func readFrame(r *bufio.Reader) (frame, error) {
+	var header [frameHeaderLen]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return frame{}, err
+	}
+	size := binary.BigEndian.Uint32(header[0:])
+	if size > maxFrameLen {
+		return frame{}, ErrFrameTooLarge
+	}
+	f := frame{
+		id:   binary.BigEndian.Uint64(header[4:]),
+		kind: header[12],
+		body: make([]byte, size),
+	}
+	_, err := io.ReadFull(r, f.body)
+	return f, err
+}
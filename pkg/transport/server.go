@@ -0,0 +1,156 @@
+package transport
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"net"
+	"sync"
+)
+
+// Handler processes one request body and returns the response body.
+type Handler func(body []byte) ([]byte, error)
+
+// Server accepts transport connections and runs handler for every request.
+// Requests on one connection are handled one at a time, in the order they
+// arrive, so writes replicated over a connection are applied in order.
+type Server struct {
+	handler Handler
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+}
+
+func NewServer(handler Handler) *Server {
+	return &Server{
+		handler: handler,
+		conns:   make(map[net.Conn]struct{}),
+	}
+}
+
+// Serve accepts connections on l until Close is called.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	s.listener = l
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops the listener and closes all connections.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return err
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	out := newFrameWriter(conn)
+	defer out.close()
+
+	r := bufio.NewReader(conn)
+	for {
+		req, err := readFrame(r)
+		if err != nil {
+			return
+		}
+		if req.kind != kindRequest {
+			log.Printf("transport: unexpected frame kind %d from %s", req.kind, conn.RemoteAddr())
+			return
+		}
+		// Requests are handled one at a time so writes to a key replicated
+		// over one connection are applied in the order they were sent
+		resp := frame{id: req.id, kind: kindResponse}
+		body, err := s.handler(req.body)
+		if err != nil {
+			resp.kind = kindError
+			body = []byte(err.Error())
+		}
+		resp.body = body
+		out.send(resp)
+	}
+}
+
+// frameWriter serializes frames from many goroutines onto one connection.
+// It only flushes once its queue is empty, so frames sent close together go
+// out in a single write.
+type frameWriter struct {
+	conn  net.Conn
+	queue chan frame
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newFrameWriter(conn net.Conn) *frameWriter {
+	fw := &frameWriter{
+		conn:  conn,
+		queue: make(chan frame, 256),
+		done:  make(chan struct{}),
+	}
+	go fw.run()
+	return fw
+}
+
+func (fw *frameWriter) send(f frame) error {
+	select {
+	case fw.queue <- f:
+		return nil
+	case <-fw.done:
+		return ErrClosed
+	}
+}
+
+func (fw *frameWriter) close() {
+	fw.once.Do(func() { close(fw.done) })
+}
+
+func (fw *frameWriter) run() {
+	w := bufio.NewWriterSize(fw.conn, 64<<10)
+	for {
+		select {
+		case f := <-fw.queue:
+			if err := writeFrame(w, f); err != nil {
+				fw.conn.Close()
+				fw.close()
+				return
+			}
+			if len(fw.queue) == 0 {
+				if err := w.Flush(); err != nil {
+					fw.conn.Close()
+					fw.close()
+					return
+				}
+			}
+		case <-fw.done:
+			return
+		}
+	}
+}
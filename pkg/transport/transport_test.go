@@ -0,0 +1,236 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func startEchoServer(t testing.TB) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := NewServer(func(body []byte) ([]byte, error) {
+		if string(body) == "fail" {
+			return nil, errors.New("handler failed")
+		}
+		if bytes.HasPrefix(body, []byte("slow")) {
+			time.Sleep(50 * time.Millisecond)
+		}
+		return append([]byte("echo:"), body...), nil
+	})
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return l.Addr().String()
+}
+
+func TestPoolCall(t *testing.T) {
+	addr := startEchoServer(t)
+	pool := NewPool(1)
+	defer pool.Close()
+
+	resp, err := pool.Call(context.Background(), addr, []byte("hello"))
+	if err != nil {
+		t.Fatalf("Call failed: %v", err)
+	}
+	if string(resp) != "echo:hello" {
+		t.Errorf("Expected echo:hello, got %q", resp)
+	}
+
+	_, err = pool.Call(context.Background(), addr, []byte("fail"))
+	var remote RemoteError
+	if !errors.As(err, &remote) || string(remote) != "handler failed" {
+		t.Errorf("Expected remote handler error, got %v", err)
+	}
+}
+
+func TestPoolMultiplexesConcurrentCalls(t *testing.T) {
+	addr := startEchoServer(t)
+	pool := NewPool(1)
+	defer pool.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := fmt.Sprintf("msg-%d", i)
+			resp, err := pool.Call(context.Background(), addr, []byte(body))
+			if err != nil || string(resp) != "echo:"+body {
+				t.Errorf("Call %d: got %q, %v", i, resp, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	pool.mu.Lock()
+	conns := len(pool.conns[addr])
+	pool.mu.Unlock()
+	if conns != 1 {
+		t.Errorf("Expected the calls to share one connection, got %d", conns)
+	}
+}
+
+func TestServerHandlesRequestsInOrder(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	var mu sync.Mutex
+	var seen []string
+	s := NewServer(func(body []byte) ([]byte, error) {
+		// A slow first request must not let the later ones overtake it
+		if string(body) == "0" {
+			time.Sleep(20 * time.Millisecond)
+		}
+		mu.Lock()
+		seen = append(seen, string(body))
+		mu.Unlock()
+		return body, nil
+	})
+	go s.Serve(l)
+	defer s.Close()
+	pool := NewPool(1)
+	defer pool.Close()
+
+	var bodies [][]byte
+	for i := 0; i < 20; i++ {
+		bodies = append(bodies, []byte(fmt.Sprint(i)))
+	}
+	_, errs := pool.CallBatch(context.Background(), l.Addr().String(), bodies)
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("Call %d failed: %v", i, err)
+		}
+	}
+	for i, body := range seen {
+		if body != fmt.Sprint(i) {
+			t.Fatalf("Requests were handled in order %v", seen)
+		}
+	}
+}
+
+func TestPoolEvict(t *testing.T) {
+	addr := startEchoServer(t)
+	pool := NewPool(2)
+	defer pool.Close()
+
+	if _, err := pool.Call(context.Background(), addr, []byte("a")); err != nil {
+		t.Fatalf("Call failed: %v", err)
+	}
+	pool.mu.Lock()
+	c := pool.conns[addr][1]
+	pool.mu.Unlock()
+
+	pool.Evict(addr)
+	if !c.broken() {
+		t.Errorf("Expected the evicted connection to be closed")
+	}
+	if _, err := pool.Call(context.Background(), addr, []byte("b")); err != nil {
+		t.Errorf("Call after Evict failed: %v", err)
+	}
+}
+
+func TestPoolCallBatch(t *testing.T) {
+	addr := startEchoServer(t)
+	pool := NewPool(2)
+	defer pool.Close()
+
+	bodies := [][]byte{[]byte("a"), []byte("fail"), []byte("c")}
+	responses, errs := pool.CallBatch(context.Background(), addr, bodies)
+	if string(responses[0]) != "echo:a" || string(responses[2]) != "echo:c" {
+		t.Errorf("Unexpected batch responses: %q", responses)
+	}
+	if errs[0] != nil || errs[1] == nil || errs[2] != nil {
+		t.Errorf("Expected only the second call to fail, got %v", errs)
+	}
+}
+
+func TestPoolRedialsAfterServerRestart(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	addr := l.Addr().String()
+	echo := func(body []byte) ([]byte, error) { return body, nil }
+
+	s := NewServer(echo)
+	go s.Serve(l)
+	pool := NewPool(1)
+	defer pool.Close()
+	if _, err := pool.Call(context.Background(), addr, []byte("one")); err != nil {
+		t.Fatalf("First call failed: %v", err)
+	}
+	s.Close()
+
+	l, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to listen again: %v", err)
+	}
+	s = NewServer(echo)
+	go s.Serve(l)
+	defer s.Close()
+
+	// The first call may still see the dead connection; a retry must redial
+	var resp []byte
+	for i := 0; i < 3; i++ {
+		if resp, err = pool.Call(context.Background(), addr, []byte("two")); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil || string(resp) != "two" {
+		t.Errorf("Expected pool to redial, got %q, %v", resp, err)
+	}
+}
+
+func TestCallTimeout(t *testing.T) {
+	addr := startEchoServer(t)
+	pool := NewPool(1)
+	defer pool.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := pool.Call(ctx, addr, []byte("slow")); err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+}
+
+func BenchmarkPoolCallParallel(b *testing.B) {
+	addr := startEchoServer(b)
+	pool := NewPool(2)
+	defer pool.Close()
+	body := make([]byte, 128)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := pool.Call(context.Background(), addr, body); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
+
+func TestFailedSendForgetsCall(t *testing.T) {
+	done := make(chan struct{})
+	close(done)
+	c := &conn{
+		out:     &frameWriter{queue: make(chan frame), done: done},
+		pending: make(map[uint64]chan frame),
+	}
+	if _, err := c.call(context.Background(), []byte("x")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Expected ErrClosed from a closed writer, got %v", err)
+	}
+	if len(c.pending) != 0 {
+		t.Errorf("Expected the failed call not to stay pending, got %d", len(c.pending))
+	}
+}